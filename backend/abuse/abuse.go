@@ -0,0 +1,231 @@
+package abuse
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Decision is the outcome of an abuse check
+type Decision string
+
+const (
+	DecisionAllow   Decision = "allow"
+	DecisionReview  Decision = "review"
+	DecisionCaptcha Decision = "captcha"
+	DecisionBlock   Decision = "block"
+)
+
+// Config holds the scoring weights, thresholds and lists used by Evaluate
+type Config struct {
+	ReviewThreshold  int
+	CaptchaThreshold int
+	BlockThreshold   int
+
+	Window      time.Duration
+	IPLimit     int
+	SubnetLimit int
+
+	HoneypotScore       int
+	IPVelocityScore     int
+	SubnetVelocityScore int
+	DisposableScore     int
+	BlockedDomainScore  int
+
+	DisposableDomains map[string]bool
+	BlockedDomains    map[string]bool
+
+	CaptchaSecret    string
+	CaptchaVerifyURL string
+}
+
+// Signal describes a single signup attempt
+type Signal struct {
+	IP             string
+	Email          string
+	HoneypotFilled bool
+}
+
+// Result is the score and decision for a signup attempt
+type Result struct {
+	Score    int
+	Reasons  []string
+	Decision Decision
+}
+
+var (
+	config = Config{
+		ReviewThreshold:  30,
+		CaptchaThreshold: 50,
+		BlockThreshold:   100,
+
+		Window:      time.Hour,
+		IPLimit:     3,
+		SubnetLimit: 10,
+
+		HoneypotScore:       100,
+		IPVelocityScore:     40,
+		SubnetVelocityScore: 30,
+		DisposableScore:     50,
+		BlockedDomainScore:  100,
+	}
+	mu       sync.Mutex
+	attempts = map[string][]time.Time{}
+	// lastSweep is when attempts was last cleared of keys with no attempt
+	// inside the velocity window
+	lastSweep time.Time
+)
+
+// Current returns the active configuration
+func Current() Config {
+	mu.Lock()
+	defer mu.Unlock()
+	return config
+}
+
+// SetConfig replaces the active configuration
+func SetConfig(c Config) {
+	mu.Lock()
+	defer mu.Unlock()
+	config = c
+}
+
+// Evaluate scores a signup attempt and records it for velocity tracking
+func Evaluate(s Signal) Result {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var res Result
+	add := func(score int, reason string) {
+		res.Score += score
+		res.Reasons = append(res.Reasons, reason)
+	}
+
+	if s.HoneypotFilled {
+		add(config.HoneypotScore, "honeypot")
+	}
+
+	now := time.Now()
+	if s.IP != "" {
+		if record("ip:"+s.IP, now) > config.IPLimit {
+			add(config.IPVelocityScore, "ip_velocity")
+		}
+		if subnet := subnetOf(s.IP); subnet != "" && record("net:"+subnet, now) > config.SubnetLimit {
+			add(config.SubnetVelocityScore, "subnet_velocity")
+		}
+	}
+
+	domain := ""
+	if at := strings.LastIndex(s.Email, "@"); at >= 0 {
+		domain = strings.ToLower(s.Email[at+1:])
+	}
+	if config.BlockedDomains[domain] {
+		add(config.BlockedDomainScore, "blocked_domain")
+	} else if config.DisposableDomains[domain] {
+		add(config.DisposableScore, "disposable_domain")
+	}
+
+	switch {
+	case res.Score >= config.BlockThreshold:
+		res.Decision = DecisionBlock
+	case res.Score >= config.CaptchaThreshold && config.CaptchaSecret != "":
+		res.Decision = DecisionCaptcha
+	case res.Score >= config.ReviewThreshold:
+		res.Decision = DecisionReview
+	default:
+		res.Decision = DecisionAllow
+	}
+
+	log.Printf("Signup abuse check: ip=%s domain=%s score=%d decision=%s reasons=%v",
+		s.IP, domain, res.Score, res.Decision, res.Reasons)
+	return res
+}
+
+// VerifyCaptcha checks a CAPTCHA response token with the configured provider
+func VerifyCaptcha(token, remoteIP string) bool {
+	mu.Lock()
+	secret, verifyURL := config.CaptchaSecret, config.CaptchaVerifyURL
+	mu.Unlock()
+
+	if secret == "" || token == "" {
+		return false
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.PostForm(verifyURL, url.Values{
+		"secret":   {secret},
+		"response": {token},
+		"remoteip": {remoteIP},
+	})
+	if err != nil {
+		log.Println("Error verifying CAPTCHA:", err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		log.Println("Error decoding CAPTCHA response:", err)
+		return false
+	}
+	return body.Success
+}
+
+// record adds an attempt for key and returns the number of attempts within
+// the velocity window. Callers must hold mu.
+func record(key string, now time.Time) int {
+	cutoff := now.Add(-config.Window)
+	if now.Sub(lastSweep) > config.Window {
+		sweep(cutoff)
+		lastSweep = now
+	}
+	kept := attempts[key][:0]
+	for _, t := range attempts[key] {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	kept = append(kept, now)
+	attempts[key] = kept
+	return len(kept)
+}
+
+// sweep drops the keys whose attempts all happened before cutoff, which
+// count the same as missing ones. Callers must hold mu.
+func sweep(cutoff time.Time) {
+	for key, times := range attempts {
+		if !times[len(times)-1].After(cutoff) {
+			delete(attempts, key)
+		}
+	}
+}
+
+// subnetOf returns the /24 (IPv4) or /64 (IPv6) network of an IP address
+func subnetOf(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return parsed.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// DomainSet builds a DisposableDomains or BlockedDomains set, ignoring case
+func DomainSet(domains []string) map[string]bool {
+	set := map[string]bool{}
+	for _, d := range domains {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			set[d] = true
+		}
+	}
+	return set
+}
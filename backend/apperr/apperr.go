@@ -0,0 +1,96 @@
+// Package apperr holds the errors handlers report to clients. Each has an
+// HTTP status, a stable machine-readable code and a human-readable detail;
+// middleware.Errors turns them into application/problem+json responses.
+package apperr
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Code identifies a kind of error. Codes are part of the API: clients may
+// branch on them, so they never change once published.
+type Code string
+
+const (
+	CodeInvalidRequest     Code = "invalid_request"
+	CodeValidation         Code = "validation_failed"
+	CodeInvalidID          Code = "invalid_id"
+	CodeUnauthorized       Code = "unauthorized"
+	CodeInvalidToken       Code = "invalid_token"
+	CodeInvalidCredentials Code = "invalid_credentials"
+	CodeForbidden          Code = "forbidden"
+	CodeInsufficientScope  Code = "insufficient_scope"
+	CodeNotFound           Code = "not_found"
+	CodeUserNotFound       Code = "user_not_found"
+	CodeConflict           Code = "conflict"
+	CodeEmailTaken         Code = "email_taken"
+	CodeUsernameTaken      Code = "username_taken"
+	// CodeVersionConflict means the resource changed since the client read it
+	CodeVersionConflict      Code = "version_conflict"
+	CodePreconditionRequired Code = "precondition_required"
+	CodeCaptchaRequired      Code = "captcha_required"
+	CodeAccountLocked        Code = "account_locked"
+	CodeAccountDeleted       Code = "account_deleted"
+	CodeAccountDisabled      Code = "account_disabled"
+	CodeSessionLimit         Code = "session_limit"
+	CodeLastLoginMethod      Code = "last_login_method"
+	CodePendingDeletion      Code = "account_pending_deletion"
+	CodeSignupDisabled       Code = "signup_disabled"
+	CodeSignupRejected       Code = "signup_rejected"
+	CodeRateLimited          Code = "rate_limited"
+	CodeRegionBlocked        Code = "region_blocked"
+	CodeNotConfigured        Code = "not_configured"
+	CodeUpstream             Code = "upstream_error"
+	CodeInternal             Code = "internal_error"
+)
+
+// Error is an error to report to the client
+type Error struct {
+	Status int
+	Code   Code
+	Detail string
+	// Extensions are extra members of the problem, e.g. retry_after
+	Extensions map[string]interface{}
+	// Err is the underlying cause; it is logged, never shown
+	Err error
+}
+
+// New returns an error reported with status, code and detail
+func New(status int, code Code, detail string) *Error {
+	return &Error{Status: status, Code: code, Detail: detail}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Detail + ": " + e.Err.Error()
+	}
+	return e.Detail
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// With adds an extension member to the problem and returns e
+func (e *Error) With(key string, value interface{}) *Error {
+	if e.Extensions == nil {
+		e.Extensions = make(map[string]interface{})
+	}
+	e.Extensions[key] = value
+	return e
+}
+
+// Wrap records err as the cause of e and returns e
+func (e *Error) Wrap(err error) *Error {
+	e.Err = err
+	return e
+}
+
+// From returns err as an *Error, reporting anything else as an internal
+// error without revealing it
+func From(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return New(http.StatusInternalServerError, CodeInternal, "Internal server error").Wrap(err)
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+const (
+	githubUserURL   = "https://api.github.com/user"
+	githubEmailsURL = "https://api.github.com/user/emails"
+)
+
+type githubProvider struct {
+	client OAuthClient
+}
+
+// OAuthConfig returns the client settings from GITHUB_OAUTH_*
+func (p githubProvider) OAuthConfig() *oauth2.Config {
+	return p.client.oauth2Config(endpoints.GitHub, "read:user", "user:email")
+}
+
+// FetchProfile reads the GitHub user and its primary verified email, which
+// the user endpoint omits when the address is private
+func (githubProvider) FetchProfile(ctx context.Context, client *http.Client) (OAuthProfile, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := githubGet(client, githubUserURL, &user); err != nil {
+		return OAuthProfile{}, err
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := githubGet(client, githubEmailsURL, &emails); err != nil {
+		return OAuthProfile{}, err
+	}
+
+	profile := OAuthProfile{Subject: strconv.FormatInt(user.ID, 10), Name: user.Name}
+	if profile.Name == "" {
+		profile.Name = user.Login
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			profile.Email = e.Email
+		}
+	}
+	if profile.Email == "" {
+		return OAuthProfile{}, errors.New("github account has no verified primary email")
+	}
+	return profile, nil
+}
+
+func githubGet(client *http.Client, url string, out interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
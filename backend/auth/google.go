@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+const googleUserInfoURL = "https://openidconnect.googleapis.com/v1/userinfo"
+
+type googleProvider struct {
+	client OAuthClient
+}
+
+// OAuthConfig returns the client settings from GOOGLE_OAUTH_*
+func (p googleProvider) OAuthConfig() *oauth2.Config {
+	return p.client.oauth2Config(endpoints.Google, "openid", "email", "profile")
+}
+
+// FetchProfile reads the OpenID Connect user info
+func (googleProvider) FetchProfile(ctx context.Context, client *http.Client) (OAuthProfile, error) {
+	resp, err := client.Get(googleUserInfoURL)
+	if err != nil {
+		return OAuthProfile{}, fmt.Errorf("fetching user info: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return OAuthProfile{}, fmt.Errorf("fetching user info: %s", resp.Status)
+	}
+
+	var info struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return OAuthProfile{}, fmt.Errorf("decoding user info: %w", err)
+	}
+	if info.Email == "" || !info.EmailVerified {
+		return OAuthProfile{}, errors.New("google account has no verified email")
+	}
+
+	return OAuthProfile{Subject: info.Sub, Email: info.Email, Name: info.Name}, nil
+}
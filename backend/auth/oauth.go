@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"goapi/database"
+	"golang.org/x/oauth2"
+)
+
+// oauthLinkTTL bounds how long a pending account link waits for its callback
+const oauthLinkTTL = 10 * time.Minute
+
+// OAuthProfile is the identity returned by a social login provider
+type OAuthProfile struct {
+	// Provider is the name the provider is registered under
+	Provider string
+	// Subject is the provider's stable user ID
+	Subject string
+	Email   string
+	Name    string
+}
+
+// OAuthProvider is a social login provider
+type OAuthProvider interface {
+	// OAuthConfig returns the client settings, or nil when the provider is not configured
+	OAuthConfig() *oauth2.Config
+	// FetchProfile reads the signed-in user's identity with an authorized
+	// client. Accounts without a verified email are rejected.
+	FetchProfile(ctx context.Context, client *http.Client) (OAuthProfile, error)
+}
+
+var oauthProviders = map[string]OAuthProvider{
+	"google": googleProvider{},
+	"github": githubProvider{},
+}
+
+// OAuthClient is the app registered with a provider, which is off until all
+// three are set
+type OAuthClient struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// oauth2Config returns the client settings for endpoint, or nil when the
+// client is incomplete
+func (c OAuthClient) oauth2Config(endpoint oauth2.Endpoint, scopes ...string) *oauth2.Config {
+	if c.ClientID == "" || c.ClientSecret == "" || c.RedirectURL == "" {
+		return nil
+	}
+	return &oauth2.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		RedirectURL:  c.RedirectURL,
+		Endpoint:     endpoint,
+		Scopes:       scopes,
+	}
+}
+
+// SetOAuthClients sets the apps registered with Google and GitHub. It is
+// called once, before the server starts.
+func SetOAuthClients(google, github OAuthClient) {
+	oauthProviders["google"] = googleProvider{google}
+	oauthProviders["github"] = githubProvider{github}
+}
+
+// LookupOAuthProvider returns the provider registered under name
+func LookupOAuthProvider(name string) (OAuthProvider, bool) {
+	p, ok := oauthProviders[name]
+	return p, ok
+}
+
+// ExchangeOAuthCode exchanges an authorization code and reads the profile
+func ExchangeOAuthCode(ctx context.Context, name string, p OAuthProvider, cfg *oauth2.Config, code string) (OAuthProfile, error) {
+	token, err := cfg.Exchange(ctx, code)
+	if err != nil {
+		return OAuthProfile{}, fmt.Errorf("exchanging code: %w", err)
+	}
+
+	profile, err := p.FetchProfile(ctx, cfg.Client(ctx, token))
+	if err != nil {
+		return OAuthProfile{}, err
+	}
+	profile.Provider = name
+	return profile, nil
+}
+
+var links = newPendingStore[int]("oauth_link", oauthLinkTTL)
+
+// RememberOAuthLink records that the sign-in started with state should link
+// the identity to userID instead of signing in
+func RememberOAuthLink(ctx context.Context, db database.Querier, state string, userID int) error {
+	return links.put(ctx, db, state, userID, userID)
+}
+
+// TakeOAuthLink returns and forgets the user waiting to link for state
+func TakeOAuthLink(ctx context.Context, db database.Querier, state string) (int, bool, error) {
+	return links.take(ctx, db, state)
+}
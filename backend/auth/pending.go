@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"goapi/database"
+)
+
+// pendingStore holds values for sign-in flows that span two requests, keyed
+// by a random token handed to the client. They are kept in the
+// pending_flows table, so the second request can reach any replica. Values
+// expire after ttl and can be taken only once.
+type pendingStore[T any] struct {
+	// kind keeps each flow's keys apart
+	kind string
+	ttl  time.Duration
+}
+
+func newPendingStore[T any](kind string, ttl time.Duration) *pendingStore[T] {
+	return &pendingStore[T]{kind: kind, ttl: ttl}
+}
+
+// put stores value under key, dropping the expired entries of its kind.
+// userID is the user the flow belongs to, or zero for none.
+func (s *pendingStore[T]) put(ctx context.Context, db database.Querier, key string, userID int, value T) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err = db.ExecContext(ctx, `
+		WITH expired AS (
+			DELETE FROM pending_flows WHERE kind = $1 AND expires_at < $4
+		)
+		INSERT INTO pending_flows (kind, key_hash, value, expires_at, user_id) VALUES ($1, $2, $3, $5, $6)
+	`, s.kind, hashPendingKey(key), encoded, now, now.Add(s.ttl), sql.NullInt64{Int64: int64(userID), Valid: userID != 0})
+	return err
+}
+
+// take returns and forgets the value stored under key
+func (s *pendingStore[T]) take(ctx context.Context, db database.Querier, key string) (T, bool, error) {
+	var zero T
+	var encoded []byte
+	var expiresAt time.Time
+	err := db.QueryRowContext(ctx, `
+		DELETE FROM pending_flows WHERE kind = $1 AND key_hash = $2
+		RETURNING value, expires_at
+	`, s.kind, hashPendingKey(key)).Scan(&encoded, &expiresAt)
+	if err == sql.ErrNoRows {
+		return zero, false, nil
+	} else if err != nil {
+		return zero, false, err
+	}
+	if time.Now().After(expiresAt) {
+		return zero, false, nil
+	}
+
+	var value T
+	if err := json.Unmarshal(encoded, &value); err != nil {
+		return zero, false, err
+	}
+	return value, true, nil
+}
+
+// hashPendingKey is how keys are stored, so the table doesn't hold tokens
+// that are still usable
+func hashPendingKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
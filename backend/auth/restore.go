@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"goapi/database"
+)
+
+// accountRestoreTTL bounds how long a restore token from a sign-in stays usable
+const accountRestoreTTL = 10 * time.Minute
+
+// AccountRestore is a verified sign-in to an account scheduled for
+// deletion, waiting for its owner to confirm they want to keep it
+type AccountRestore struct {
+	UserID int
+	// Method and Email are recorded in the login event once restored
+	Method string
+	Email  string
+}
+
+var restores = newPendingStore[AccountRestore]("account_restore", accountRestoreTTL)
+
+// RememberAccountRestore stores the sign-in that token restores
+func RememberAccountRestore(ctx context.Context, db database.Querier, token string, restore AccountRestore) error {
+	return restores.put(ctx, db, token, restore.UserID, restore)
+}
+
+// TakeAccountRestore returns and forgets the sign-in token restores
+func TakeAccountRestore(ctx context.Context, db database.Querier, token string) (AccountRestore, bool, error) {
+	return restores.take(ctx, db, token)
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+	"goapi/database"
+)
+
+// samlRequestTTL bounds how long a SAML login waits for the IdP's response
+const samlRequestTTL = 10 * time.Minute
+
+// Attributes commonly used by IdPs for the user's email and display name
+var (
+	samlEmailAttributes = []string{
+		"email",
+		"mail",
+		"urn:oid:0.9.2342.19200300.100.1.3",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+	}
+	samlNameAttributes = []string{
+		"name",
+		"displayName",
+		"cn",
+		"urn:oid:2.16.840.1.113730.3.1.241",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name",
+	}
+)
+
+// SAMLConfig is the service provider and the IdP it trusts
+type SAMLConfig struct {
+	// IDPMetadataURL or IDPMetadataFile locates the IdP metadata; SAML is
+	// off without either
+	IDPMetadataURL  string
+	IDPMetadataFile string
+	// RootURL is the absolute URL the API is served from
+	RootURL  string
+	EntityID string
+	// The SP key pair is only needed when the IdP encrypts assertions
+	SPCertFile string
+	SPKeyFile  string
+	// EmailAttribute and NameAttribute are tried before the common names
+	EmailAttribute string
+	NameAttribute  string
+}
+
+var (
+	samlMu     sync.Mutex
+	samlConfig SAMLConfig
+	samlSP     *saml.ServiceProvider
+)
+
+// SetSAMLConfig sets the service provider settings, dropping a provider
+// built from earlier ones
+func SetSAMLConfig(c SAMLConfig) {
+	samlMu.Lock()
+	defer samlMu.Unlock()
+	samlConfig, samlSP = c, nil
+}
+
+// SAMLServiceProvider builds the service provider, or returns nil when SAML
+// is not configured. The IdP metadata is loaded on first use and kept; a
+// failed load is retried on the next call.
+func SAMLServiceProvider(ctx context.Context) (*saml.ServiceProvider, error) {
+	samlMu.Lock()
+	defer samlMu.Unlock()
+	c := samlConfig
+	if c.IDPMetadataURL == "" && c.IDPMetadataFile == "" {
+		return nil, nil
+	}
+	if samlSP != nil {
+		return samlSP, nil
+	}
+
+	rootURL, err := url.Parse(strings.TrimSuffix(c.RootURL, "/"))
+	if err != nil || rootURL.Scheme == "" || rootURL.Host == "" {
+		return nil, errors.New("SAML_ROOT_URL must be the absolute URL the API is served from")
+	}
+
+	var idp *saml.EntityDescriptor
+	if c.IDPMetadataFile != "" {
+		data, err := os.ReadFile(c.IDPMetadataFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading IdP metadata: %w", err)
+		}
+		idp, err = samlsp.ParseMetadata(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing IdP metadata: %w", err)
+		}
+	} else {
+		u, err := url.Parse(c.IDPMetadataURL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing SAML_IDP_METADATA_URL: %w", err)
+		}
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		idp, err = samlsp.FetchMetadata(ctx, http.DefaultClient, *u)
+		if err != nil {
+			return nil, fmt.Errorf("fetching IdP metadata: %w", err)
+		}
+	}
+
+	sp := &saml.ServiceProvider{
+		EntityID:          c.EntityID,
+		MetadataURL:       *rootURL.JoinPath("/api/auth/saml/metadata"),
+		AcsURL:            *rootURL.JoinPath("/api/auth/saml/acs"),
+		IDPMetadata:       idp,
+		AuthnNameIDFormat: saml.PersistentNameIDFormat,
+	}
+
+	// A key pair is only needed when the IdP encrypts assertions
+	if c.SPCertFile != "" || c.SPKeyFile != "" {
+		pair, err := tls.LoadX509KeyPair(c.SPCertFile, c.SPKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading SP key pair: %w", err)
+		}
+		key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("SP key must be an RSA key")
+		}
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("parsing SP certificate: %w", err)
+		}
+		sp.Key = key
+		sp.Certificate = cert
+	}
+
+	samlSP = sp
+	return samlSP, nil
+}
+
+// SAMLProfile maps a verified assertion to a sign-in identity. The subject
+// is the NameID; the email comes from SAML_EMAIL_ATTRIBUTE or a common email
+// attribute, falling back to the NameID when it is an email address.
+func SAMLProfile(assertion *saml.Assertion) (OAuthProfile, error) {
+	profile := OAuthProfile{Provider: "saml"}
+	var nameIDFormat string
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		profile.Subject = assertion.Subject.NameID.Value
+		nameIDFormat = assertion.Subject.NameID.Format
+	}
+	if profile.Subject == "" {
+		return profile, errors.New("assertion has no NameID")
+	}
+
+	samlMu.Lock()
+	c := samlConfig
+	samlMu.Unlock()
+
+	profile.Email = samlAttribute(assertion, c.EmailAttribute, samlEmailAttributes)
+	if profile.Email == "" && (nameIDFormat == string(saml.EmailAddressNameIDFormat) || strings.Contains(profile.Subject, "@")) {
+		profile.Email = profile.Subject
+	}
+	if profile.Email == "" {
+		return profile, errors.New("assertion has no email attribute")
+	}
+
+	profile.Name = samlAttribute(assertion, c.NameAttribute, samlNameAttributes)
+	return profile, nil
+}
+
+var samlRequests = newPendingStore[string]("saml_request", samlRequestTTL)
+
+// RememberSAMLRequest records the AuthnRequest ID sent with relayState
+func RememberSAMLRequest(ctx context.Context, db database.Querier, relayState, requestID string) error {
+	return samlRequests.put(ctx, db, relayState, 0, requestID)
+}
+
+// TakeSAMLRequest returns and forgets the AuthnRequest ID for relayState
+func TakeSAMLRequest(ctx context.Context, db database.Querier, relayState string) (string, bool, error) {
+	return samlRequests.take(ctx, db, relayState)
+}
+
+// samlAttribute returns the first value of the configured attribute, or of
+// the first fallback present in the assertion
+func samlAttribute(assertion *saml.Assertion, configured string, fallbacks []string) string {
+	names := fallbacks
+	if configured != "" {
+		names = []string{configured}
+	}
+	for _, name := range names {
+		for _, statement := range assertion.AttributeStatements {
+			for _, attr := range statement.Attributes {
+				if (attr.Name == name || attr.FriendlyName == name) && len(attr.Values) > 0 {
+					return strings.TrimSpace(attr.Values[0].Value)
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Scopes carried by access tokens
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	// ScopeAdmin opens /api/admin and the admin-only user operations
+	ScopeAdmin = "admin"
+)
+
+// Scopes are all the scopes there are
+var Scopes = []string{ScopeUsersRead, ScopeUsersWrite, ScopeAdmin}
+
+// ParseScopes splits a space- or comma-separated scope list
+func ParseScopes(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool { return r == ' ' || r == ',' })
+}
+
+// DefaultScopes returns the scopes granted to every user, from
+// JWT_DEFAULT_SCOPES. Anything more is granted to accounts one by one, with
+// the scopes subcommand.
+func DefaultScopes() []string {
+	return current().Scopes
+}
+
+// GrantScopes narrows the scopes a user holds, the defaults plus those
+// granted to their account, to the requested ones. An empty request grants
+// all of them; asking for a scope that isn't held fails.
+func GrantScopes(account, requested []string) ([]string, error) {
+	var granted []string
+	for _, held := range [][]string{DefaultScopes(), account} {
+		for _, scope := range held {
+			if !HasScope(granted, scope) {
+				granted = append(granted, scope)
+			}
+		}
+	}
+	if len(requested) == 0 {
+		return granted, nil
+	}
+	var scopes []string
+	for _, scope := range requested {
+		if !HasScope(granted, scope) {
+			return nil, fmt.Errorf("scope %q is not available", scope)
+		}
+		if !HasScope(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// HasScope reports whether scopes contains scope
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
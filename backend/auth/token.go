@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Config holds the signing key, lifetime and default scopes of access tokens
+type Config struct {
+	Secret []byte
+	TTL    time.Duration
+	Scopes []string
+}
+
+// Claims identifies the session an access token was issued for
+type Claims struct {
+	UserID    int
+	SessionID int
+	Scopes    []string
+}
+
+// tokenClaims is the JWT payload; scope is a space-separated list as in OAuth
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Scope string `json:"scope,omitempty"`
+}
+
+// config has no secret until main sets one with SetConfig; until then, no
+// token can be issued or accepted
+var (
+	mu     sync.RWMutex
+	config = Config{TTL: 24 * time.Hour, Scopes: []string{ScopeUsersRead}}
+)
+
+// errNoSecret is returned for every token while JWT_SECRET is unset
+var errNoSecret = errors.New("JWT_SECRET is not set")
+
+// SetConfig replaces the active configuration
+func SetConfig(c Config) {
+	mu.Lock()
+	defer mu.Unlock()
+	config = c
+}
+
+// current returns the active configuration
+func current() Config {
+	mu.RLock()
+	defer mu.RUnlock()
+	return config
+}
+
+// TokenTTL returns how long newly issued access tokens are valid
+func TokenTTL() time.Duration {
+	return current().TTL
+}
+
+// IssueToken returns a signed access token for the user's session
+func IssueToken(claims Claims, expiresAt time.Time) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(claims.UserID),
+			ID:        strconv.Itoa(claims.SessionID),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		Scope: strings.Join(claims.Scopes, " "),
+	})
+	secret := current().Secret
+	if len(secret) == 0 {
+		return "", errNoSecret
+	}
+	return token.SignedString(secret)
+}
+
+// ParseToken validates an access token and returns the session it was issued
+// for. Tokens without a scope claim predate scopes and get the defaults.
+func ParseToken(tokenString string) (Claims, error) {
+	cfg := current()
+	if len(cfg.Secret) == 0 {
+		return Claims{}, errNoSecret
+	}
+
+	var tc tokenClaims
+	_, err := jwt.ParseWithClaims(tokenString, &tc, func(*jwt.Token) (interface{}, error) {
+		return cfg.Secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return Claims{}, err
+	}
+
+	var claims Claims
+	claims.UserID, err = strconv.Atoi(tc.Subject)
+	if err != nil {
+		return Claims{}, errors.New("token has an invalid subject")
+	}
+	claims.SessionID, err = strconv.Atoi(tc.ID)
+	if err != nil {
+		return Claims{}, errors.New("token has no session")
+	}
+	claims.Scopes = ParseScopes(tc.Scope)
+	if tc.Scope == "" {
+		claims.Scopes = cfg.Scopes
+	}
+	return claims, nil
+}
+
+// claimsKey is the context key of the caller's claims
+type claimsKey struct{}
+
+// NewContext returns a copy of ctx carrying the claims of the caller's
+// token, for code that isn't handed a gin context, such as gRPC methods
+func NewContext(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// FromContext returns the claims stored in ctx by NewContext
+func FromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(Claims)
+	return claims, ok
+}
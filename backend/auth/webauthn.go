@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"goapi/database"
+)
+
+// webAuthnCeremonyTTL bounds how long a registration or login waits for the
+// authenticator's response
+const webAuthnCeremonyTTL = 5 * time.Minute
+
+// WebAuthnConfig is the relying party passkeys are registered with
+type WebAuthnConfig struct {
+	// RPID is the domain passkeys are bound to; passkeys are off without it
+	RPID    string
+	RPName  string
+	Origins []string
+}
+
+var webAuthnConfig WebAuthnConfig
+
+// SetWebAuthnConfig sets the relying party. It is called once, before the
+// server starts.
+func SetWebAuthnConfig(c WebAuthnConfig) {
+	webAuthnConfig = c
+}
+
+// WebAuthn builds the relying party, or returns nil when passkeys are not
+// configured
+func WebAuthn() (*webauthn.WebAuthn, error) {
+	c := webAuthnConfig
+	if c.RPID == "" {
+		return nil, nil
+	}
+	name := c.RPName
+	if name == "" {
+		name = "User Management"
+	}
+
+	return webauthn.New(&webauthn.Config{
+		RPID:          c.RPID,
+		RPDisplayName: name,
+		RPOrigins:     c.Origins,
+	})
+}
+
+// WebAuthnCeremony is a registration or login waiting for its response
+type WebAuthnCeremony struct {
+	// UserID is the user registering a credential; zero for logins
+	UserID  int
+	Session webauthn.SessionData
+}
+
+var ceremonies = newPendingStore[WebAuthnCeremony]("webauthn_ceremony", webAuthnCeremonyTTL)
+
+// RememberWebAuthnCeremony stores the ceremony started under id
+func RememberWebAuthnCeremony(ctx context.Context, db database.Querier, id string, ceremony WebAuthnCeremony) error {
+	return ceremonies.put(ctx, db, id, ceremony.UserID, ceremony)
+}
+
+// TakeWebAuthnCeremony returns and forgets the ceremony started under id
+func TakeWebAuthnCeremony(ctx context.Context, db database.Querier, id string) (WebAuthnCeremony, bool, error) {
+	return ceremonies.take(ctx, db, id)
+}
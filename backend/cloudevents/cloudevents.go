@@ -0,0 +1,74 @@
+// Package cloudevents wraps published events in the CloudEvents 1.0
+// envelope, in its structured JSON mode, so webhook receivers and broker
+// consumers get the same standard format: the event name as type, the user
+// as subject, and a dataschema naming the version of the data's layout.
+package cloudevents
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// SpecVersion is the CloudEvents version of the envelope
+	SpecVersion = "1.0"
+	// ContentType is the media type of an envelope in structured mode
+	ContentType = "application/cloudevents+json"
+	// DataVersion goes up when an event's data changes in a way that breaks
+	// consumers; it is part of every event's dataschema
+	DataVersion = 1
+)
+
+// Event is an event in the CloudEvents envelope
+type Event struct {
+	SpecVersion string `json:"specversion"`
+	ID          string `json:"id"`
+	// Source identifies this service, see SetSource
+	Source string `json:"source"`
+	// Type is the event name, e.g. user.created
+	Type string `json:"type"`
+	// Subject is the ID of the user the event is about
+	Subject         string      `json:"subject,omitempty"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	DataSchema      string      `json:"dataschema"`
+	Data            interface{} `json:"data"`
+}
+
+var (
+	mu     sync.RWMutex
+	source = "/goapi"
+)
+
+// SetSource sets the source of the events built from now on, a URI
+// reference that tells this deployment's events apart from others'
+func SetSource(s string) {
+	mu.Lock()
+	defer mu.Unlock()
+	source = s
+}
+
+// New returns the envelope of the event of type eventType with id, about
+// subject, that happened at t
+func New(id, eventType, subject string, t time.Time, data interface{}) Event {
+	mu.RLock()
+	defer mu.RUnlock()
+	return Event{
+		SpecVersion:     SpecVersion,
+		ID:              id,
+		Source:          source,
+		Type:            eventType,
+		Subject:         subject,
+		Time:            t.UTC(),
+		DataContentType: "application/json",
+		DataSchema:      DataSchema(eventType),
+		Data:            data,
+	}
+}
+
+// DataSchema is the dataschema of eventType's data at DataVersion, e.g.
+// urn:goapi:events:user.created:v1
+func DataSchema(eventType string) string {
+	return "urn:goapi:events:" + eventType + ":v" + strconv.Itoa(DataVersion)
+}
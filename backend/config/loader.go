@@ -0,0 +1,171 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loader reads typed values from the environment, collecting the errors
+// so they can all be reported at once
+type loader struct {
+	errs []error
+}
+
+func (l *loader) fail(key, problem string) {
+	l.errs = append(l.errs, fmt.Errorf("%s %s", key, problem))
+}
+
+// string returns the value of key, or def when it is unset or empty
+func (l *loader) string(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+func (l *loader) int(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		l.fail(key, fmt.Sprintf("must be a whole number, got %q", value))
+		return def
+	}
+	return n
+}
+
+func (l *loader) float(key string, def float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		l.fail(key, fmt.Sprintf("must be a number, got %q", value))
+		return def
+	}
+	return f
+}
+
+func (l *loader) bool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		l.fail(key, fmt.Sprintf("must be true or false, got %q", value))
+		return def
+	}
+	return b
+}
+
+// list splits a comma-separated value, dropping blank entries
+func (l *loader) list(key, def string) []string {
+	var items []string
+	for _, item := range strings.Split(l.string(key, def), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (l *loader) duration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		l.fail(key, fmt.Sprintf("must be a duration like 30s or 5m, got %q", value))
+		return def
+	}
+	return d
+}
+
+// loadFile copies the settings in a .env or YAML file into the environment,
+// keeping any variable that is already set. YAML files hold the same keys as
+// the environment, e.g. "DATABASE_HOST: db".
+func loadFile(path string) error {
+	var values map[string]string
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		values, err = readYAML(path)
+	default:
+		values, err = readDotEnv(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	for key, value := range values {
+		if _, set := os.LookupEnv(key); !set {
+			os.Setenv(key, value)
+		}
+	}
+	return nil
+}
+
+func readYAML(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch v := value.(type) {
+		case nil:
+			values[key] = ""
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("%s must be a single value", key)
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+// readDotEnv parses KEY=value lines, skipping blanks and # comments. Values
+// may be quoted and lines may start with "export ".
+func readDotEnv(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected KEY=value", n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	return values, scanner.Err()
+}
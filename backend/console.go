@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"goapi/tui"
+)
+
+// runConsole starts the terminal admin console against a running instance
+func runConsole(args []string) {
+	fs := flag.NewFlagSet("console", flag.ExitOnError)
+	defaultURL := os.Getenv("API_URL")
+	if defaultURL == "" {
+		defaultURL = "http://localhost:8080"
+	}
+	apiURL := fs.String("api", defaultURL, "base URL of the running API")
+	token := fs.String("token", os.Getenv("API_TOKEN"), "access token from /api/auth/login")
+	fs.Parse(args)
+
+	if err := tui.Run(*apiURL, *token); err != nil {
+		log.Fatal("Error running console:", err)
+	}
+}
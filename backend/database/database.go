@@ -1,15 +1,49 @@
 package database
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"errors"
 
-var db *sql.DB
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
 
-// SetDB sets the database connection
-func SetDB(database *sql.DB) {
-	db = database
+// Querier is a *sql.DB or *sql.Tx
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
-// GetDB returns the database connection
-func GetDB() *sql.DB {
-	return db
-} 
\ No newline at end of file
+// Array scans a PostgreSQL array into dest, such as a *[]string; slices are
+// passed as array arguments as they are
+func Array(dest interface{}) sql.Scanner {
+	// A Map caches scan plans and is not safe to share between goroutines
+	return pgtype.NewMap().SQLScanner(dest)
+}
+
+// UniqueViolation reports whether err is a unique constraint violation, and
+// the constraint or index violated
+func UniqueViolation(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return "", false
+	}
+	return pgErr.ConstraintName, true
+}
+
+// Transact runs fn in a transaction on conn. The transaction is committed if
+// fn returns nil and rolled back if it returns an error or panics.
+func Transact(ctx context.Context, conn *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
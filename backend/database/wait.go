@@ -0,0 +1,42 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Backoff bounds for Wait
+const (
+	firstRetryDelay = 500 * time.Millisecond
+	maxRetryDelay   = 10 * time.Second
+)
+
+// Wait pings conn until the database answers or timeout has passed,
+// doubling the pause after each failed attempt up to 10s. It lets the
+// server start before Postgres does, as under docker compose. A timeout
+// of 0 tries once. The error is the last attempt's.
+func Wait(ctx context.Context, conn *sql.DB, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	delay := firstRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := conn.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return err
+		}
+		pause := min(delay, remaining)
+		log.Printf("Warning: database not reachable (attempt %d), retrying in %s: %v", attempt, pause.Round(time.Millisecond), err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(pause):
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
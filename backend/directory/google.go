@@ -0,0 +1,129 @@
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/oauth2/jwt"
+)
+
+const (
+	googleUsersURL   = "https://admin.googleapis.com/admin/directory/v1/users"
+	googleTokenURL   = "https://oauth2.googleapis.com/token"
+	googleUsersScope = "https://www.googleapis.com/auth/admin.directory.user.readonly"
+)
+
+// GoogleConfig holds the settings needed to read users from the Google Admin
+// SDK Directory API with a service account using domain-wide delegation
+type GoogleConfig struct {
+	// CredentialsFile is the path to the service account JSON key
+	CredentialsFile string
+	// AdminEmail is the Workspace admin the service account impersonates
+	AdminEmail string
+	// Customer is the Workspace customer ID, "my_customer" for the admin's own
+	Customer string
+}
+
+// User is a directory user mapped to the fields the local users table needs
+type User struct {
+	Email     string
+	Name      string
+	Suspended bool
+}
+
+// Configured reports whether the credentials and admin subject are set
+func (c GoogleConfig) Configured() bool {
+	return c.CredentialsFile != "" && c.AdminEmail != ""
+}
+
+// FetchGoogleUsers lists every user of the Workspace customer
+func FetchGoogleUsers(ctx context.Context, cfg GoogleConfig) ([]User, error) {
+	if !cfg.Configured() {
+		return nil, errors.New("google workspace import is not configured")
+	}
+
+	raw, err := os.ReadFile(cfg.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading credentials: %w", err)
+	}
+	var key struct {
+		ClientEmail  string `json:"client_email"`
+		PrivateKey   string `json:"private_key"`
+		PrivateKeyID string `json:"private_key_id"`
+		TokenURI     string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(raw, &key); err != nil {
+		return nil, fmt.Errorf("parsing credentials: %w", err)
+	}
+	tokenURL := key.TokenURI
+	if tokenURL == "" {
+		tokenURL = googleTokenURL
+	}
+
+	client := (&jwt.Config{
+		Email:        key.ClientEmail,
+		PrivateKey:   []byte(key.PrivateKey),
+		PrivateKeyID: key.PrivateKeyID,
+		Scopes:       []string{googleUsersScope},
+		TokenURL:     tokenURL,
+		Subject:      cfg.AdminEmail,
+	}).Client(ctx)
+
+	var users []User
+	pageToken := ""
+	for {
+		query := url.Values{
+			"customer":   {cfg.Customer},
+			"maxResults": {"500"},
+		}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, googleUsersURL+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("listing directory users: %w", err)
+		}
+
+		var page struct {
+			Users []struct {
+				PrimaryEmail string `json:"primaryEmail"`
+				Suspended    bool   `json:"suspended"`
+				Name         struct {
+					FullName string `json:"fullName"`
+				} `json:"name"`
+			} `json:"users"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("listing directory users: unexpected status %s", resp.Status)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decoding directory users: %w", err)
+		}
+
+		for _, u := range page.Users {
+			users = append(users, User{
+				Email:     u.PrimaryEmail,
+				Name:      u.Name.FullName,
+				Suspended: u.Suspended,
+			})
+		}
+
+		if page.NextPageToken == "" {
+			return users, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"goapi/auth"
+	"goapi/config"
+	"goapi/directory"
+	"goapi/middleware"
+	"goapi/migrations"
+)
+
+// doctor collects the results of the self-check
+type doctor struct {
+	failures int
+	warnings int
+}
+
+func (d *doctor) ok(format string, args ...interface{}) {
+	fmt.Printf("[OK]   "+format+"\n", args...)
+}
+
+func (d *doctor) warn(hint string, format string, args ...interface{}) {
+	d.warnings++
+	fmt.Printf("[WARN] "+format+"\n", args...)
+	fmt.Printf("       -> %s\n", hint)
+}
+
+func (d *doctor) fail(hint string, format string, args ...interface{}) {
+	d.failures++
+	fmt.Printf("[FAIL] "+format+"\n", args...)
+	fmt.Printf("       -> %s\n", hint)
+}
+
+// runDoctor validates configuration and database access and prints
+// actionable diagnostics. It returns the process exit code.
+func runDoctor(args []string) int {
+	d := &doctor{}
+
+	fmt.Println("Configuration")
+	cfg := d.checkConfig(args)
+
+	fmt.Println()
+	fmt.Println("Database")
+	d.checkDatabase(cfg.Database)
+
+	fmt.Println()
+	fmt.Printf("%d failure(s), %d warning(s)\n", d.failures, d.warnings)
+	if d.failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+// checkConfig reports invalid settings and returns the configuration, with
+// defaults in place of the invalid values
+func (d *doctor) checkConfig(args []string) *config.Config {
+	cfg, err := config.Load(args)
+	if err != nil {
+		for _, problem := range strings.Split(err.Error(), "\n") {
+			d.fail("fix the setting in the environment or the config file", "%s", problem)
+		}
+	}
+
+	if cfg.Database.URL == "" {
+		for _, key := range []string{"DATABASE_HOST", "DATABASE_PORT", "DATABASE_NAME", "DATABASE_USER", "DATABASE_PASSWORD"} {
+			if os.Getenv(key) == "" {
+				d.warn("set "+key+" or DATABASE_URL explicitly for non-local deployments", "%s is not set, using the built-in default", key)
+			}
+		}
+		if cfg.Database.Password == "password" {
+			d.warn("change DATABASE_PASSWORD from the development default", "DATABASE_PASSWORD is the default development password")
+		}
+	}
+
+	d.ok("PORT %d", cfg.Port)
+	if cfg.GRPCPort != 0 {
+		d.ok("GRPC_PORT %d", cfg.GRPCPort)
+	}
+
+	if networks, err := middleware.ParseCIDRs(cfg.AdminAllowedCIDRs); err != nil {
+		d.fail("fix the entry in ADMIN_ALLOWED_CIDRS (e.g. 10.0.0.0/8,127.0.0.1)", "ADMIN_ALLOWED_CIDRS: %v", err)
+	} else if len(networks) == 0 {
+		d.warn("set ADMIN_ALLOWED_CIDRS to restrict /api/admin to trusted networks", "admin endpoints are reachable from any address")
+	} else {
+		d.ok("admin endpoints restricted to %d network(s)", len(networks))
+	}
+
+	if path := cfg.GeoIPDBPath; path != "" {
+		if _, err := os.Stat(path); err != nil {
+			d.fail("download a GeoLite2-Country database or unset GEOIP_DB_PATH", "GeoIP database %s: %v", path, err)
+		} else {
+			d.ok("GeoIP database %s", path)
+		}
+	}
+
+	if ws := directory.GoogleConfig(cfg.GoogleWorkspace); ws.CredentialsFile != "" || ws.AdminEmail != "" {
+		if !ws.Configured() {
+			d.fail("set both GOOGLE_WORKSPACE_CREDENTIALS_FILE and GOOGLE_WORKSPACE_ADMIN_EMAIL", "Google Workspace import is only partially configured")
+		} else if _, err := os.Stat(ws.CredentialsFile); err != nil {
+			d.fail("check the path and file permissions of the service account key", "Google Workspace credentials %s: %v", ws.CredentialsFile, err)
+		} else {
+			d.ok("Google Workspace credentials %s", ws.CredentialsFile)
+		}
+	}
+
+	auth.SetSAMLConfig(auth.SAMLConfig(cfg.Auth.SAML))
+	if sp, err := auth.SAMLServiceProvider(context.Background()); err != nil {
+		d.fail("check SAML_ROOT_URL, the IdP metadata and the SP key pair", "SAML: %v", err)
+	} else if sp != nil {
+		d.ok("SAML identity provider %s", sp.IDPMetadata.EntityID)
+	}
+	return cfg
+}
+
+func (d *doctor) checkDatabase(cfg config.Database) {
+	conn, err := sql.Open("pgx", cfg.ConnString())
+	if err != nil {
+		d.fail("check DATABASE_URL or the DATABASE_* variables", "cannot open database connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := conn.PingContext(ctx); err != nil {
+		d.fail("make sure Postgres is running and reachable at "+cfg.Address(), "cannot connect to database: %v", err)
+		return
+	}
+	var user string
+	if err := conn.QueryRowContext(ctx, "SELECT current_user").Scan(&user); err != nil {
+		d.fail("check that the database user can run queries", "cannot query database: %v", err)
+		return
+	}
+	d.ok("connected to %s as %s", cfg.Address(), user)
+
+	var canCreate bool
+	if err := conn.QueryRowContext(ctx, "SELECT has_schema_privilege('public', 'CREATE')").Scan(&canCreate); err != nil {
+		d.fail("check that the database user can query its privileges", "cannot check schema privileges: %v", err)
+	} else if !canCreate {
+		d.warn("GRANT CREATE ON SCHEMA public to "+user+" so the schema can be created and upgraded at startup", "database user cannot create tables in schema public")
+	} else {
+		d.ok("database user can create tables")
+	}
+
+	var usersTable sql.NullString
+	if err := conn.QueryRowContext(ctx, "SELECT to_regclass('public.users')::text").Scan(&usersTable); err != nil {
+		d.fail("check database permissions", "cannot inspect schema: %v", err)
+		return
+	}
+	if !usersTable.Valid {
+		d.warn("run \"go run . migrate up\" or start the server once to create the schema", "users table does not exist yet")
+		return
+	}
+
+	var canWrite bool
+	if err := conn.QueryRowContext(ctx, "SELECT has_table_privilege('users', 'SELECT, INSERT, UPDATE, DELETE')").Scan(&canWrite); err != nil || !canWrite {
+		d.fail("GRANT SELECT, INSERT, UPDATE, DELETE ON users to "+user, "database user cannot read and write the users table")
+	} else {
+		d.ok("database user can read and write users")
+	}
+
+	// The server applies these at startup unless MIGRATE_ON_START is false
+	statuses, err := migrations.List(ctx, conn)
+	if err != nil {
+		d.fail("check database permissions", "cannot read schema migrations: %v", err)
+		return
+	}
+	pending := 0
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			pending++
+		}
+	}
+	if pending > 0 {
+		d.warn("run \"go run . migrate up\" or start the server to apply them", "%d schema migration(s) pending", pending)
+	} else {
+		d.ok("schema is up to date (%d migrations)", len(statuses))
+	}
+}
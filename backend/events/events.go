@@ -0,0 +1,218 @@
+// Package events publishes user events (user.created, user.updated and
+// user.deleted) to a message broker, NATS or Kafka, so other services can
+// react to them without polling the API. Enqueue writes each event to an
+// outbox table in the transaction of the change, and a background relay publishes
+// the outbox in order, removing what the broker accepted. Message bodies are
+// CloudEvents, the same as webhook payloads. Events are delivered at least
+// once: consumers should ignore IDs they have seen.
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"goapi/cloudevents"
+	"goapi/database"
+	"goapi/locks"
+)
+
+// Brokers that can be configured
+const (
+	BrokerNATS  = "nats"
+	BrokerKafka = "kafka"
+)
+
+// batchSize is how many outbox rows the relay claims at once
+const batchSize = 100
+
+// LockName is the lock held by the replica relaying the outbox
+const LockName = "events.relay"
+
+// Message is an event as handed to a broker
+type Message struct {
+	// Topic is the NATS subject or Kafka topic
+	Topic string
+	// Key orders a user's events on brokers that partition by key
+	Key string
+	// ID is the event's ID, for brokers that deduplicate
+	ID   string
+	Body []byte
+}
+
+// Publisher sends messages to a broker
+type Publisher interface {
+	// Publish returns once the broker has accepted msg
+	Publish(ctx context.Context, msg Message) error
+	// Ping checks that the broker can be reached
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// Config tunes the relay
+type Config struct {
+	// DB holds the outbox
+	DB *sql.DB
+	// TopicPrefix is put before the event name to make the topic, e.g.
+	// "goapi." publishes user.created to goapi.user.created
+	TopicPrefix string
+	// PollInterval is how often the outbox is looked at after a failure
+	PollInterval time.Duration
+	// Timeout bounds each publish
+	Timeout time.Duration
+}
+
+var (
+	// enabled is set by Start; without a broker nothing is queued
+	enabled bool
+	// wake tells the relay that Enqueue queued something
+	wake = make(chan struct{}, 1)
+)
+
+// Connect returns the Publisher for broker at url: a NATS server for nats,
+// a Kafka REST Proxy for kafka
+func Connect(broker, url string, timeout time.Duration) (Publisher, error) {
+	switch broker {
+	case BrokerNATS:
+		return connectNATS(url, timeout)
+	case BrokerKafka:
+		return newKafkaREST(url, timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown event broker %q", broker)
+	}
+}
+
+// Enqueue writes event with data to the outbox, to be published under key,
+// which is also the event's subject,
+// in tx, the transaction making the change, so the event is published if
+// and only if the change commits. Call Wake once tx has committed. It does
+// nothing until Start has been called.
+func Enqueue(ctx context.Context, tx *sql.Tx, event, key string, data interface{}) error {
+	if !enabled {
+		return nil
+	}
+	now := time.Now()
+	eventID := newEventID()
+	payload, err := json.Marshal(cloudevents.New(eventID, event, key, now, data))
+	if err != nil {
+		return fmt.Errorf("encoding event payload: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO event_outbox (event_id, event, event_key, payload, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, eventID, event, key, payload, now)
+	return err
+}
+
+// Wake tells the relay that events were committed to the outbox, so it
+// publishes them without waiting for the next poll
+func Wake() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start relays the outbox to pub from a background goroutine while this
+// replica holds the relay lock, as soon as events are enqueued and every
+// cfg.PollInterval, which also paces the retries while the broker is failing.
+// Other replicas only write to the outbox, so events go out in order.
+func Start(pub Publisher, cfg Config) {
+	enabled = true
+
+	locks.Run(cfg.DB, LockName, func(ctx context.Context) {
+		ticker := time.NewTicker(cfg.PollInterval)
+		defer ticker.Stop()
+		for {
+			for relay(pub, cfg) == batchSize {
+				// A full batch may have left more events behind
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-wake:
+			}
+		}
+	})
+}
+
+// outboxEvent is an outbox row being relayed
+type outboxEvent struct {
+	id      int64
+	eventID string
+	event   string
+	key     string
+	payload []byte
+}
+
+// relay publishes a batch of the oldest outbox rows and returns how many it
+// published. It stops at the first failure so events go out in the order
+// they happened. The rows stay locked until the batch is done, so a replica
+// that just took the relay lock over waits for one still finishing a batch.
+func relay(pub Publisher, cfg Config) int {
+	published := 0
+	err := database.Transact(context.Background(), cfg.DB, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`
+			SELECT id, event_id, event, event_key, payload FROM event_outbox
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE
+		`, batchSize)
+		if err != nil {
+			return err
+		}
+		var pending []outboxEvent
+		for rows.Next() {
+			var e outboxEvent
+			if err := rows.Scan(&e.id, &e.eventID, &e.event, &e.key, &e.payload); err != nil {
+				rows.Close()
+				return err
+			}
+			pending = append(pending, e)
+		}
+		rows.Close()
+
+		var done []int64
+		for _, e := range pending {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+			publishErr := pub.Publish(ctx, Message{Topic: cfg.TopicPrefix + e.event, Key: e.key, ID: e.eventID, Body: e.payload})
+			cancel()
+			if publishErr != nil {
+				log.Printf("Error publishing event %s: %v", e.eventID, publishErr)
+				if _, err := tx.Exec(`
+					UPDATE event_outbox SET attempts = attempts + 1, last_error = $2 WHERE id = $1
+				`, e.id, publishErr.Error()); err != nil {
+					return err
+				}
+				break
+			}
+			done = append(done, e.id)
+		}
+
+		if _, err := tx.Exec(`DELETE FROM event_outbox WHERE id = ANY($1)`, done); err != nil {
+			return err
+		}
+		// Anything short of a full batch waits for the next poll, so a
+		// failing broker isn't retried in a tight loop
+		published = len(done)
+		return nil
+	})
+	if err != nil {
+		log.Println("Error relaying events:", err)
+		return 0
+	}
+	return published
+}
+
+func newEventID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
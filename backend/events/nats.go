@@ -0,0 +1,60 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsPublisher publishes to NATS subjects. Core NATS keeps nothing for
+// subscribers that are away; capture the subjects in a JetStream stream,
+// which also drops redeliveries by their Nats-Msg-Id.
+type natsPublisher struct {
+	conn *nats.Conn
+}
+
+func connectNATS(url string, timeout time.Duration) (*natsPublisher, error) {
+	conn, err := nats.Connect(url,
+		nats.Name("goapi"),
+		nats.Timeout(timeout),
+		// Reconnect forever, failing publishes in the meantime rather than
+		// buffering them: the outbox holds them until NATS is back
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectBufSize(-1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &natsPublisher{conn: conn}, nil
+}
+
+// Publish sends msg and waits for the server to have it
+func (p *natsPublisher) Publish(ctx context.Context, msg Message) error {
+	if !p.conn.IsConnected() {
+		return errors.New("not connected to NATS")
+	}
+	err := p.conn.PublishMsg(&nats.Msg{
+		Subject: msg.Topic,
+		Header:  nats.Header{"Nats-Msg-Id": []string{msg.ID}},
+		Data:    msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+	return p.conn.FlushWithContext(ctx)
+}
+
+func (p *natsPublisher) Ping(ctx context.Context) error {
+	if !p.conn.IsConnected() {
+		return errors.New("not connected to NATS")
+	}
+	return p.conn.FlushWithContext(ctx)
+}
+
+func (p *natsPublisher) Close() error {
+	p.conn.Close()
+	return nil
+}
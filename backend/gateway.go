@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"goapi/middleware"
+	userv1 "goapi/proto/user/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// gatewayHandler serves the /api/v1 routes generated from the UserService
+// proto, and their OpenAPI document, by calling the gRPC server on port.
+// Calls go through the same interceptors as native gRPC ones, so the two
+// can't drift apart.
+func gatewayHandler(port int) gin.HandlerFunc {
+	conn, err := grpc.NewClient("localhost:"+strconv.Itoa(port),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(middleware.GatewayClientInterceptor()))
+	if err != nil {
+		log.Fatal("Error connecting the gateway to gRPC: ", err)
+	}
+
+	// Fields keep their proto names, like the rest of the API, and zero
+	// values are sent rather than left out
+	mux := runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
+			MarshalOptions:   protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true},
+			UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+		}),
+		runtime.WithErrorHandler(middleware.GatewayErrors),
+	)
+	if err := userv1.RegisterUserServiceHandler(context.Background(), mux, conn); err != nil {
+		log.Fatal("Error registering the gateway: ", err)
+	}
+	err = mux.HandlePath(http.MethodGet, "/api/v1/openapi.json", func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(userv1.OpenAPI)
+	})
+	if err != nil {
+		log.Fatal("Error registering the gateway: ", err)
+	}
+
+	return func(c *gin.Context) {
+		// The gateway forwards the remote address as x-forwarded-for, which
+		// GRPCPeerIP trusts from the gateway; give it the client IP gin worked
+		// out, which only comes from forwarding headers when the request
+		// came through TRUSTED_PROXIES, instead of what the client claims
+		c.Request.Header.Del("X-Forwarded-For")
+		c.Request.RemoteAddr = net.JoinHostPort(c.ClientIP(), "0")
+		mux.ServeHTTP(c.Writer, c.Request)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"goapi/auth"
+	"goapi/handlers"
+	"goapi/middleware"
+	userv1 "goapi/proto/user/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// grpcScopes maps the gRPC methods that need an access token to the scope
+// they need, as the REST routes do; Login is open
+var grpcScopes = map[string]string{
+	userv1.UserService_CreateUser_FullMethodName: auth.ScopeUsersWrite,
+	userv1.UserService_GetUser_FullMethodName:    auth.ScopeUsersRead,
+	userv1.UserService_ListUsers_FullMethodName:  auth.ScopeUsersRead,
+	userv1.UserService_UpdateUser_FullMethodName: auth.ScopeUsersWrite,
+	userv1.UserService_DeleteUser_FullMethodName: auth.ScopeUsersWrite,
+}
+
+// serveGRPC serves the gRPC UserService on port from a background goroutine,
+// backed by h and checking tokens' sessions in db within queryTimeout, with
+// the standard health service and reflection for tools like grpcurl.
+// checks, such as rate limits, run before tokens are checked.
+func serveGRPC(port int, h *handlers.Handler, db *sql.DB, queryTimeout time.Duration, adminNetworks []*net.IPNet, checks ...grpc.UnaryServerInterceptor) {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		log.Fatal("Error listening for gRPC: ", err)
+	}
+
+	// Calls are logged with their final status, and panics recovered, before
+	// errors are converted, checks run and tokens checked
+	interceptors := []grpc.UnaryServerInterceptor{
+		middleware.GRPCLogger(),
+		middleware.GRPCRecovery(),
+		middleware.GRPCErrors(),
+	}
+	interceptors = append(interceptors, checks...)
+	interceptors = append(interceptors, middleware.GRPCAuth(db, queryTimeout, grpcScopes))
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
+	userv1.RegisterUserServiceServer(server, handlers.NewUserGRPCServer(h, adminNetworks))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	reflection.Register(server)
+
+	go func() {
+		log.Fatal(server.Serve(listener))
+	}()
+	log.Printf("gRPC server listening on port %d", port)
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/auth"
+	"goapi/database"
+	"goapi/jobs"
+	"goapi/metrics"
+	"goapi/models"
+)
+
+var (
+	// deletionGracePeriod is how long an account deleted by its owner can
+	// still be restored by logging in
+	deletionGracePeriod = 30 * 24 * time.Hour
+	// anonymizePurged keeps purged accounts as anonymized rows rather than
+	// deleting them
+	anonymizePurged bool
+)
+
+// SetDeletionGracePeriod sets how long self-deleted accounts are kept before
+// they are purged, and whether purging anonymizes them instead of deleting
+// them
+func SetDeletionGracePeriod(d time.Duration, anonymize bool) {
+	deletionGracePeriod = d
+	anonymizePurged = anonymize
+}
+
+// restoreDeletedAccount cancels the scheduled deletion of user when they log
+// in asking for it. Sessions revoked by the deletion stay signed out.
+func (h *Handler) restoreDeletedAccount(ctx context.Context, cl client, user *models.User) error {
+	audit := userAudit{cl: cl, action: models.AuditUserUndelete}
+	err := database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		err := scanUser(tx.QueryRowContext(ctx, `
+			UPDATE users SET deleted_at = NULL, purge_at = NULL
+			WHERE id = $1
+			RETURNING `+userColumns, user.ID), user)
+		if err != nil {
+			return err
+		}
+		return audit.hook(ctx, tx, *user)
+	})
+	if err != nil {
+		return err
+	}
+	h.publishAudit(ctx, audit, *user)
+	return nil
+}
+
+// respondPendingDeletion answers a social or SAML sign-in, made with method
+// and email, to an account scheduled for deletion with 409 and its
+// purge_at. The identity provider has vouched for the user, so the response
+// also carries a restore_token that POST /auth/restore exchanges for the
+// restored account and an access token.
+func (h *Handler) respondPendingDeletion(c *gin.Context, pending *pendingDeletionError, method, email string) {
+	h.recordLoginEvent(c, pending.User.ID, email, method, models.LoginFailurePendingDeletion)
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error signing in"))
+		return
+	}
+	token := hex.EncodeToString(buf)
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err := auth.RememberAccountRestore(ctx, h.db, token, auth.AccountRestore{UserID: pending.User.ID, Method: method, Email: email})
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error signing in"))
+		return
+	}
+
+	c.Error(apperr.New(http.StatusConflict, apperr.CodePendingDeletion, "Account is scheduled for deletion; send restore_token to /auth/restore to keep it").
+		With("purge_at", pending.PurgeAt).
+		With("restore_token", token))
+}
+
+// @Summary Restore account after sign-in
+// @Description Keeps an account scheduled for deletion, using the restore_token returned with 409 by a social or SAML sign-in to it, and signs the user in. Tokens are single-use and expire after 10 minutes.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param request body models.RestoreAccountRequest true "Restore token"
+// @Success 200 {object} models.APIResponse{data=models.AuthResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 429 {object} models.Problem
+// @Router /auth/restore [post]
+func (h *Handler) RestoreAccountHandler(c *gin.Context) {
+	var req models.RestoreAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	restore, ok, err := auth.TakeAccountRestore(ctx, h.db, req.RestoreToken)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error restoring account"))
+		return
+	}
+	if !ok {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidToken, "Unknown or expired restore token"))
+		return
+	}
+
+	// The account may have been restored, or purged, since the sign-in
+	var user models.User
+	err = scanUser(h.db.QueryRowContext(ctx, `
+		SELECT `+userColumns+` FROM users
+		WHERE id = $1 AND (deleted_at IS NULL OR (purge_at > $2 AND anonymized_at IS NULL))
+	`, restore.UserID, time.Now()), &user)
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusForbidden, apperr.CodeAccountDeleted, "This account has been deleted"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error restoring account"))
+		return
+	}
+	if !user.IsActive {
+		h.recordLoginEvent(c, user.ID, restore.Email, restore.Method, models.LoginFailureAccountDisabled)
+		c.Error(disabledError())
+		return
+	}
+
+	if err := h.restoreDeletedAccount(ctx, clientOf(c), &user); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error restoring account"))
+		return
+	}
+	h.recordLoginEvent(c, user.ID, restore.Email, restore.Method, "")
+	metrics.Logins.Inc()
+	h.respondWithToken(c, http.StatusOK, user)
+}
+
+// purgeAccountsJob purges the accounts whose grace period is over
+func (h *Handler) purgeAccountsJob(ctx context.Context, job *jobs.Job) error {
+	purged, err := h.purgeDeletedAccounts(ctx)
+	if purged > 0 {
+		log.Printf("Purged %d deleted accounts", purged)
+	}
+	return err
+}
+
+// purgeDeletedAccounts removes or anonymizes every account whose purge time
+// has passed and returns how many were purged
+func (h *Handler) purgeDeletedAccounts(ctx context.Context) (int, error) {
+	ids, err := queryIDs(ctx, h.db, `
+		SELECT id FROM users
+		WHERE deleted_at IS NOT NULL AND purge_at <= $1
+	`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	purge := h.purgeUser
+	if anonymizePurged {
+		purge = h.anonymizePurgedUser
+	}
+	purged := 0
+	for _, id := range ids {
+		ok, err := h.purgeWithTimeout(ctx, purge, id)
+		if err != nil {
+			return purged, err
+		}
+		if ok {
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// purgeWithTimeout runs purge for id, bounding its database work by the
+// handler timeout
+func (h *Handler) purgeWithTimeout(ctx context.Context, purge func(context.Context, int) (bool, error), id int) (bool, error) {
+	ctx, cancel := h.withTimeout(ctx)
+	defer cancel()
+	return purge(ctx, id)
+}
+
+// purgeUser deletes a user whose purge time has passed, together with the
+// prior versions and audited changes that would outlive the row. It reports
+// false when the account was restored in the meantime.
+func (h *Handler) purgeUser(ctx context.Context, id int) (bool, error) {
+	purged := false
+	err := database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		now := time.Now()
+		result, err := tx.ExecContext(ctx, `
+			DELETE FROM users
+			WHERE id = $1 AND deleted_at IS NOT NULL AND purge_at <= $2
+		`, id, now)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+
+		for _, stmt := range []string{
+			// Sessions, identities, passkeys and login events cascade; these
+			// tables have no foreign key
+			`DELETE FROM users_history WHERE user_id = $1`,
+			`UPDATE audit_logs SET before = NULL, after = NULL WHERE user_id = $1`,
+			`UPDATE audit_logs SET actor_ip = '' WHERE actor_id = $1`,
+		} {
+			if _, err := tx.ExecContext(ctx, stmt, id); err != nil {
+				return err
+			}
+		}
+
+		// Nobody is signed in to attribute the purge to
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO audit_logs (action, user_id, created_at) VALUES ($1, $2, $3)
+		`, models.AuditUserPurge, id, now)
+		purged = err == nil
+		return err
+	})
+	return purged && err == nil, err
+}
+
+// anonymizePurgedUser is purgeUser keeping the row, anonymized, so references
+// to the user stay valid
+func (h *Handler) anonymizePurgedUser(ctx context.Context, id int) (bool, error) {
+	purged := false
+	err := database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		// Clearing purge_at takes the account off the purge list
+		result, err := tx.ExecContext(ctx, `
+			UPDATE users SET purge_at = NULL
+			WHERE id = $1 AND deleted_at IS NOT NULL AND purge_at <= $2
+		`, id, time.Now())
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+		purged = true
+		if err := anonymizeUserTx(ctx, tx, id); err != nil {
+			return err
+		}
+		return client{}.auditTx(ctx, tx, models.AuditUserAnonymize, id, nil, nil)
+	})
+	if purged && err == nil {
+		h.publishAuditEvent(ctx, models.AuditUserAnonymize, id, nil, nil)
+	}
+	return purged && err == nil, err
+}
@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/database"
+	"goapi/models"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+// recordAudit stores who performed action on the user with userID. before
+// and after are the state around the change, nil when there is none (e.g.
+// before a create); when both are given only the fields that differ are kept.
+// The actor is the authenticated caller, if any. User lifecycle changes are
+// also published to webhooks, the event broker and WebSocket clients.
+//
+// recordAudit is for changes that have already committed, so a failing
+// audit write is logged rather than undoing them, and the entry is written
+// even if the client has gone. Changes made in a transaction record their
+// entry in it with auditTx.
+func (h *Handler) recordAudit(c *gin.Context, action string, userID int, before, after interface{}) {
+	h.audit(c.Request.Context(), clientOf(c), action, userID, before, after)
+}
+
+// audit is recordAudit for a call under ctx with cl as the actor
+func (h *Handler) audit(ctx context.Context, cl client, action string, userID int, before, after interface{}) {
+	ctx, cancel := h.withTimeout(context.WithoutCancel(ctx))
+	defer cancel()
+	err := database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		return cl.auditTx(ctx, tx, action, userID, before, after)
+	})
+	if err != nil {
+		log.Printf("Error recording audit entry %s for user %d: %v", action, userID, err)
+	}
+	h.publishAuditEvent(ctx, action, userID, before, after)
+}
+
+// auditTx writes cl's audit entry, and the change's event for the broker,
+// in tx, the transaction making the change, so they commit or fail
+// together. The change is published with publishAuditEvent once tx has
+// committed.
+func (cl client) auditTx(ctx context.Context, tx *sql.Tx, action string, userID int, before, after interface{}) error {
+	if err := insertAudit(ctx, tx, cl.userID, cl.ip, action, userID, before, after); err != nil {
+		return err
+	}
+	return enqueueAuditEvent(ctx, tx, action, userID, before, after)
+}
+
+func insertAudit(ctx context.Context, tx *sql.Tx, actorID int, actorIP, action string, userID int, before, after interface{}) error {
+	beforeJSON, afterJSON := auditDiff(before, after)
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO audit_logs (actor_id, actor_ip, action, user_id, before, after, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, sql.NullInt64{Int64: int64(actorID), Valid: actorID != 0}, actorIP, action,
+		sql.NullInt64{Int64: int64(userID), Valid: userID != 0}, beforeJSON, afterJSON, time.Now())
+	return err
+}
+
+// userAudit audits a change to a user made through the UserService: hook
+// writes the entry in the change's transaction and publishAudit announces
+// the change once it has committed. Deletes record the user as they were,
+// other changes the user as stored, after before, which is nil for creates.
+// Entries hold the admin-only fields; events, queued for the broker or
+// published, don't.
+type userAudit struct {
+	cl     client
+	action string
+	before *models.User
+}
+
+// states returns the user before and after the change, as view shows them
+func (a userAudit) states(user models.User, view func(*models.User) models.UserResponse) (interface{}, interface{}) {
+	if a.action == models.AuditUserDelete || a.action == models.AuditUserHardDelete {
+		return view(&user), nil
+	}
+	if a.before == nil {
+		return nil, view(&user)
+	}
+	return view(a.before), view(&user)
+}
+
+func (a userAudit) hook(ctx context.Context, tx *sql.Tx, user models.User) error {
+	before, after := a.states(user, (*models.User).ToAdminUserResponse)
+	if err := insertAudit(ctx, tx, a.cl.userID, a.cl.ip, a.action, user.ID, before, after); err != nil {
+		return err
+	}
+	before, after = a.states(user, (*models.User).ToUserResponse)
+	return enqueueAuditEvent(ctx, tx, a.action, user.ID, before, after)
+}
+
+// publishAudit announces a change audited with a once it has committed
+func (h *Handler) publishAudit(ctx context.Context, a userAudit, user models.User) {
+	before, after := a.states(user, (*models.User).ToUserResponse)
+	h.publishAuditEvent(ctx, a.action, user.ID, before, after)
+}
+
+// auditDiff encodes before and after as JSON objects, dropping the fields
+// they have in common when both are present
+func auditDiff(before, after interface{}) (interface{}, interface{}) {
+	b, a := toJSONObject(before), toJSONObject(after)
+	if b != nil && a != nil {
+		for key, value := range b {
+			if other, ok := a[key]; ok && reflect.DeepEqual(value, other) {
+				delete(b, key)
+				delete(a, key)
+			}
+		}
+	}
+	return encodeJSONObject(b), encodeJSONObject(a)
+}
+
+func toJSONObject(v interface{}) map[string]interface{} {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var m map[string]interface{}
+	if json.Unmarshal(data, &m) != nil {
+		return nil
+	}
+	return m
+}
+
+// encodeJSONObject returns the JSON for m, or nil to store SQL NULL
+func encodeJSONObject(m map[string]interface{}) interface{} {
+	if m == nil {
+		return nil
+	}
+	data, _ := json.Marshal(m)
+	return data
+}
+
+// @Summary Query the audit log
+// @Description Lists audited changes, newest first. Filters combine. from and to take RFC 3339 timestamps or YYYY-MM-DD dates; to is exclusive, except that a date includes that whole day.
+// @Tags Admin
+// @Produce json
+// @Param user_id query int false "User the change was made to"
+// @Param actor_id query int false "User who made the change"
+// @Param action query string false "Action, e.g. user.update"
+// @Param from query string false "Earliest time"
+// @Param to query string false "Latest time"
+// @Param limit query int false "Number of entries to return (max 500)" default(50)
+// @Success 200 {object} models.APIResponse{data=[]models.AuditLogResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/audit [get]
+func (h *Handler) ListAuditLogsHandler(c *gin.Context) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	for _, filter := range []struct{ param, column string }{
+		{"user_id", "user_id"},
+		{"actor_id", "actor_id"},
+	} {
+		if value := c.Query(filter.param); value != "" {
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Invalid "+filter.param))
+				return
+			}
+			where(filter.column+" = $%d", id)
+		}
+	}
+	if action := c.Query("action"); action != "" {
+		where("action = $%d", action)
+	}
+	for _, bound := range []struct {
+		param, condition string
+		endOfDay         bool
+	}{
+		{"from", "created_at >= $%d", false},
+		{"to", "created_at < $%d", true},
+	} {
+		if value := c.Query(bound.param); value != "" {
+			t, err := parseTimeFilter(value, bound.endOfDay)
+			if err != nil {
+				c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Invalid "+bound.param+": use RFC 3339 or YYYY-MM-DD"))
+				return
+			}
+			where(bound.condition, t)
+		}
+	}
+
+	limit := defaultAuditLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxAuditLimit)))
+			return
+		}
+		limit = n
+	}
+
+	query := "SELECT id, actor_id, actor_ip, action, user_id, before, after, created_at FROM audit_logs"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return
+	}
+	defer rows.Close()
+
+	entries := []models.AuditLogResponse{}
+	for rows.Next() {
+		var (
+			e               models.AuditLogResponse
+			actorID, userID sql.NullInt64
+			before, after   []byte
+		)
+		if err := rows.Scan(&e.ID, &actorID, &e.ActorIP, &e.Action, &userID, &before, &after, &e.CreatedAt); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+			return
+		}
+		if actorID.Valid {
+			id := int(actorID.Int64)
+			e.ActorID = &id
+		}
+		if userID.Valid {
+			id := int(userID.Int64)
+			e.UserID = &id
+		}
+		e.Before, e.After = before, after
+		entries = append(entries, e)
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    entries,
+	})
+}
+
+// historyIgnoredFields change on every write, so they are left out of a
+// user's timeline
+var historyIgnoredFields = map[string]bool{"id": true, "version": true, "updated_at": true}
+
+// @Summary Get user change history
+// @Description Lists the audited changes made to a user, newest first, as field-level changes with who made them and when. Erased or purged users keep the entries but not the values.
+// @Tags Users
+// @Produce json
+// @Param id path int true "User ID"
+// @Param limit query int false "Number of entries to return (max 500)" default(50)
+// @Success 200 {object} models.APIResponse{data=[]models.UserHistoryEntry}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/{id}/history [get]
+func (h *Handler) GetUserHistoryHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID"))
+		return
+	}
+
+	limit := defaultAuditLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxAuditLimit)))
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT audit_logs.id, audit_logs.action, audit_logs.actor_id, actors.name,
+			audit_logs.before, audit_logs.after, audit_logs.created_at
+		FROM audit_logs
+		LEFT JOIN users actors ON actors.id = audit_logs.actor_id
+		WHERE audit_logs.user_id = $1
+		ORDER BY audit_logs.created_at DESC, audit_logs.id DESC
+		LIMIT $2
+	`, id, limit)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving user history"))
+		return
+	}
+	defer rows.Close()
+
+	entries := []models.UserHistoryEntry{}
+	for rows.Next() {
+		var (
+			e             models.UserHistoryEntry
+			actorID       sql.NullInt64
+			actorName     sql.NullString
+			before, after []byte
+		)
+		if err := rows.Scan(&e.ID, &e.Action, &actorID, &actorName, &before, &after, &e.CreatedAt); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving user history"))
+			return
+		}
+		if actorID.Valid {
+			id := int(actorID.Int64)
+			e.ActorID = &id
+		}
+		if actorName.Valid {
+			e.ActorName = &actorName.String
+		}
+		e.Changes = fieldChanges(before, after)
+		entries = append(entries, e)
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    entries,
+	})
+}
+
+// fieldChanges lists the fields of the stored before and after objects,
+// sorted by name
+func fieldChanges(before, after []byte) []models.FieldChange {
+	var b, a map[string]interface{}
+	json.Unmarshal(before, &b)
+	json.Unmarshal(after, &a)
+
+	fields := make(map[string]bool)
+	for field := range b {
+		fields[field] = true
+	}
+	for field := range a {
+		fields[field] = true
+	}
+
+	changes := []models.FieldChange{}
+	for field := range fields {
+		if !historyIgnoredFields[field] {
+			changes = append(changes, models.FieldChange{Field: field, From: b[field], To: a[field]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
@@ -1,140 +1,296 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
+	"goapi/abuse"
+	"goapi/apperr"
+	"goapi/auth"
 	"goapi/database"
+	"goapi/hashing"
+	"goapi/lockout"
+	"goapi/mailer"
+	"goapi/metrics"
 	"goapi/models"
+	"goapi/services"
+	"goapi/utils"
+	"goapi/validation"
 )
 
 // @Summary User login
-// @Description Authenticates a user with email and password
+// @Description Authenticates a user with email and password. The token carries the scopes the account holds (JWT_DEFAULT_SCOPES plus any granted to it) unless scope asks for fewer; asking for one it doesn't hold is a 403. An account its owner deleted gets a 409 with the purge time until it is purged; logging in with restore set brings it back. With SESSION_MAX_PER_USER set, logging in while holding that many sessions is a 409 unless SESSION_LIMIT_POLICY evicts the oldest.
 // @Tags Authentication
 // @Accept json
 // @Produce json
 // @Param credentials body models.LoginRequest true "Login credentials"
-// @Success 200 {object} models.APIResponse
-// @Failure 400 {object} models.APIResponse
-// @Failure 401 {object} models.APIResponse
+// @Success 200 {object} models.APIResponse{data=models.AuthResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 423 {object} models.Problem
+// @Failure 429 {object} models.Problem
 // @Router /auth/login [post]
-func LoginHandler(c *gin.Context) {
+func (h *Handler) LoginHandler(c *gin.Context) {
 	var req models.LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Message: "Invalid request data: " + err.Error(),
-		})
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
 		return
 	}
 
-	// Find user by email
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	resp, err := h.passwordLogin(ctx, clientOf(c), req)
+	if err != nil {
+		respondWithLoginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    resp,
+	})
+}
+
+// passwordLogin checks req's email and password for cl, applying the
+// lockouts, and starts a session. It serves both the REST and the gRPC
+// login.
+func (h *Handler) passwordLogin(ctx context.Context, cl client, req models.LoginRequest) (models.AuthResponse, *apperr.Error) {
+	scopes := auth.ParseScopes(req.Scope)
+	for _, scope := range scopes {
+		if !auth.HasScope(auth.Scopes, scope) {
+			return models.AuthResponse{}, apperr.New(http.StatusBadRequest, apperr.CodeValidation, fmt.Sprintf("Invalid request data: unknown scope %q", scope))
+		}
+	}
+
+	// Refuse clients that keep guessing before touching the database
+	if retryAfter := lockout.IPLockedFor(cl.ip); retryAfter > 0 {
+		h.loginEvent(ctx, cl, 0, req.Email, models.LoginMethodPassword, models.LoginFailureIPLocked)
+		return models.AuthResponse{}, lockedError(retryAfter)
+	}
+
+	// Find user by email; accounts their owners deleted can still log in
+	// until they are purged. The exact address also matches, and wins, so
+	// users whose key is stale after EMAIL_DEDUP_STRIP_ALIASES changed can
+	// still sign in.
 	var user models.User
-	err := database.GetDB().QueryRow(`
-		SELECT id, name, email, password, age, is_active, created_at, updated_at
-		FROM users WHERE email = $1
-	`, req.Email).Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.Age, &user.IsActive, &user.CreatedAt, &user.UpdatedAt)
+	var purgeAt *time.Time
+	err := h.db.QueryRowContext(ctx, `
+		SELECT `+userColumns+`, password, purge_at
+		FROM users WHERE (email_normalized = $1 OR LOWER(email) = $3)
+			AND (deleted_at IS NULL OR (purge_at > $2 AND anonymized_at IS NULL))
+		ORDER BY LOWER(email) = $3 DESC
+		LIMIT 1
+	`, utils.CanonicalEmail(req.Email), time.Now(), strings.ToLower(strings.TrimSpace(req.Email))).Scan(append(userFields(&user), &user.Password, &purgeAt)...)
 
 	if err == sql.ErrNoRows {
-		c.JSON(http.StatusUnauthorized, models.APIResponse{
-			Success: false,
-			Message: "Invalid credentials",
-		})
-		return
+		metrics.FailedLogins.Inc()
+		h.loginEvent(ctx, cl, 0, req.Email, models.LoginMethodPassword, models.LoginFailureUnknownEmail)
+		if retryAfter := lockout.RecordIPFailure(cl.ip); retryAfter > 0 {
+			return models.AuthResponse{}, lockedError(retryAfter)
+		}
+		return models.AuthResponse{}, apperr.New(http.StatusUnauthorized, apperr.CodeInvalidCredentials, "Invalid credentials")
 	} else if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Message: "Database error",
-		})
-		return
+		return models.AuthResponse{}, apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error")
 	}
 
-	// Check password
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
+	retryAfter, err := h.accountLockedFor(ctx, user.ID)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, models.APIResponse{
-			Success: false,
-			Message: "Invalid credentials",
-		})
-		return
+		return models.AuthResponse{}, apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error")
+	}
+	if retryAfter > 0 {
+		h.loginEvent(ctx, cl, user.ID, req.Email, models.LoginMethodPassword, models.LoginFailureAccountLocked)
+		return models.AuthResponse{}, lockedError(retryAfter)
 	}
 
-	c.JSON(http.StatusOK, models.APIResponse{
-		Success: true,
-		Data:    user.ToUserResponse(),
-	})
+	// Check password
+	if !hashing.Verify(user.Password, req.Password) {
+		metrics.FailedLogins.Inc()
+		h.loginEvent(ctx, cl, user.ID, req.Email, models.LoginMethodPassword, models.LoginFailureInvalidPassword)
+		ipRetryAfter := lockout.RecordIPFailure(cl.ip)
+		accountRetryAfter, _ := h.recordAccountFailure(ctx, user.ID)
+		if retryAfter := max(ipRetryAfter, accountRetryAfter); retryAfter > 0 {
+			return models.AuthResponse{}, lockedError(retryAfter)
+		}
+		return models.AuthResponse{}, apperr.New(http.StatusUnauthorized, apperr.CodeInvalidCredentials, "Invalid credentials")
+	}
+
+	h.clearAccountFailures(ctx, user.ID)
+
+	if !user.IsActive {
+		h.loginEvent(ctx, cl, user.ID, req.Email, models.LoginMethodPassword, models.LoginFailureAccountDisabled)
+		return models.AuthResponse{}, disabledError()
+	}
+
+	if purgeAt != nil {
+		if !req.Restore {
+			h.loginEvent(ctx, cl, user.ID, req.Email, models.LoginMethodPassword, models.LoginFailurePendingDeletion)
+			return models.AuthResponse{}, apperr.New(http.StatusConflict, apperr.CodePendingDeletion, "Account is scheduled for deletion; log in with restore to keep it").
+				With("purge_at", *purgeAt)
+		}
+		if err := h.restoreDeletedAccount(ctx, cl, &user); err != nil {
+			return models.AuthResponse{}, apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error restoring account")
+		}
+	}
+
+	if err := h.checkSessionLimit(ctx, cl, user.ID, req.Email, models.LoginMethodPassword); err != nil {
+		return models.AuthResponse{}, err
+	}
+
+	h.rehashPassword(ctx, user, req.Password)
+	h.loginEvent(ctx, cl, user.ID, req.Email, models.LoginMethodPassword, "")
+	metrics.Logins.Inc()
+	return h.issueToken(ctx, cl, user, scopes)
 }
 
 // @Summary User registration
-// @Description Registers a new user
+// @Description Registers a new user and queues a welcome email. With invitation_token, the user signs up with the invited address and joins the organization, even when signup is otherwise disabled.
 // @Tags Authentication
 // @Accept json
 // @Produce json
 // @Param user body models.SignupRequest true "User registration data"
-// @Success 201 {object} models.APIResponse
-// @Failure 400 {object} models.APIResponse
-// @Failure 409 {object} models.APIResponse
+// @Success 201 {object} models.APIResponse{data=models.AuthResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 428 {object} models.Problem
+// @Failure 429 {object} models.Problem
 // @Router /auth/signup [post]
-func SignupHandler(c *gin.Context) {
+func (h *Handler) Signup(c *gin.Context) {
 	var req models.SignupRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Message: "Invalid request data: " + err.Error(),
-		})
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
 		return
 	}
 
-	// Check if user already exists
-	var existingID int
-	err := database.GetDB().QueryRow("SELECT id FROM users WHERE email = $1", req.Email).Scan(&existingID)
-	if err == nil {
-		c.JSON(http.StatusConflict, models.APIResponse{
-			Success: false,
-			Message: "User with email " + req.Email + " already exists",
-		})
+	var invite *invitation
+	if req.InvitationToken != "" {
+		inv, ok := h.findInvitation(c, req.InvitationToken)
+		if !ok || !invitedEmail(c, inv, req.Email) {
+			return
+		}
+		invite = &inv
+	} else if !validation.SignupEnabled() {
+		c.Error(apperr.New(http.StatusForbidden, apperr.CodeSignupDisabled, "Signup is disabled"))
+		return
+	}
+	newUser := services.NewUser{
+		Name:     req.Name,
+		Email:    req.Email,
+		Username: req.Username,
+		Password: req.Password,
+		Age:      req.Age,
+		IsActive: true,
+	}
+	if err := newUser.Validate(); err != nil {
+		respondWithUserError(c, err, "Error creating user")
+		return
+	}
+
+	// Score the attempt for abuse before touching the database
+	check := abuse.Evaluate(abuse.Signal{
+		IP:             c.ClientIP(),
+		Email:          newUser.Email,
+		HoneypotFilled: req.Website != "",
+	})
+	if check.Decision == abuse.DecisionBlock {
+		c.Error(apperr.New(http.StatusForbidden, apperr.CodeSignupRejected, "Signup rejected"))
 		return
-	} else if err != sql.ErrNoRows {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Message: "Database error",
-		})
+	}
+	if check.Decision == abuse.DecisionCaptcha && !abuse.VerifyCaptcha(req.CaptchaToken, c.ClientIP()) {
+		c.Error(apperr.New(http.StatusPreconditionRequired, apperr.CodeCaptchaRequired, "CAPTCHA verification required"))
 		return
 	}
+	newUser.Flagged = check.Decision == abuse.DecisionReview
 
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	audit := userAudit{cl: clientOf(c), action: models.AuditUserCreate}
+	user, err := h.users.Create(ctx, newUser, audit.hook)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Message: "Error processing password",
-		})
+		respondWithUserError(c, err, "Error creating user")
 		return
 	}
 
-	// Create user using the same logic as createUserHandler
-	var user models.User
-	now := time.Now()
-	err = database.GetDB().QueryRow(`
-		INSERT INTO users (name, email, password, age, is_active, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id, name, email, age, is_active, created_at, updated_at
-	`, req.Name, req.Email, string(hashedPassword), req.Age, true, now, now).
-		Scan(&user.ID, &user.Name, &user.Email, &user.Age, &user.IsActive, &user.CreatedAt, &user.UpdatedAt)
+	metrics.Signups.Inc()
+	h.publishAudit(ctx, audit, user)
+	if invite != nil {
+		h.joinInvitedOrg(c, *invite, user.ID)
+	}
+	h.queueWelcomeEmail(ctx, user)
+	h.respondWithToken(c, http.StatusCreated, user)
+}
 
+// queueWelcomeEmail queues the welcome email of a user who just signed up.
+// Failing to is only logged; the signup stands.
+func (h *Handler) queueWelcomeEmail(ctx context.Context, user models.User) {
+	msg, err := mailer.Render(mailer.TemplateWelcome, user.Email, gin.H{
+		"Name":   user.Name,
+		"Email":  user.Email,
+		"AppURL": appURL,
+	})
+	if err == nil {
+		err = mailer.Enqueue(ctx, h.db, msg)
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Message: "Error creating user",
-		})
-		return
+		log.Println("Error queueing welcome email:", err)
 	}
+}
 
-	c.JSON(http.StatusCreated, models.APIResponse{
+// respondWithToken starts a session for user and writes its access token,
+// carrying every scope they hold, with the user
+func (h *Handler) respondWithToken(c *gin.Context, status int, user models.User) {
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	resp, err := h.issueToken(ctx, clientOf(c), user, nil)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(status, models.APIResponse{
 		Success: true,
-		Data:    user.ToUserResponse(),
+		Data:    resp,
 	})
-} 
\ No newline at end of file
+}
+
+// issueToken starts a session for user signed in from cl and returns its
+// access token, with the user. The token carries the scopes user holds,
+// limited to the requested ones when there are any.
+func (h *Handler) issueToken(ctx context.Context, cl client, user models.User, requested []string) (models.AuthResponse, *apperr.Error) {
+	var account []string
+	err := h.db.QueryRowContext(ctx, `SELECT scopes FROM users WHERE id = $1`, user.ID).Scan(database.Array(&account))
+	if err != nil {
+		return models.AuthResponse{}, apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error")
+	}
+	scopes, err := auth.GrantScopes(account, requested)
+	if err != nil {
+		return models.AuthResponse{}, apperr.New(http.StatusForbidden, apperr.CodeForbidden, err.Error())
+	}
+
+	expiresAt := time.Now().Add(auth.TokenTTL())
+	sessionID, err := h.createSession(ctx, cl, user.ID, expiresAt)
+	if err == errSessionLimit {
+		return models.AuthResponse{}, sessionLimitError()
+	} else if err != nil {
+		return models.AuthResponse{}, apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error creating session")
+	}
+
+	token, err := auth.IssueToken(auth.Claims{UserID: user.ID, SessionID: sessionID, Scopes: scopes}, expiresAt)
+	if err != nil {
+		return models.AuthResponse{}, apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error issuing token")
+	}
+
+	return models.AuthResponse{
+		User:      user.ToUserResponse(),
+		Token:     token,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}, nil
+}
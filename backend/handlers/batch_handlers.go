@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/models"
+)
+
+// maxBatchIDs caps how many users can be fetched in one batch request
+const maxBatchIDs = 100
+
+// @Summary Look up users by ID
+// @Description Retrieves several users in one request. Users are returned in request order and IDs that don't exist are reported in missing.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body models.LookupUsersRequest true "User IDs"
+// @Param fields query string false "Comma-separated fields to return, e.g. id,name,email; all by default"
+// @Success 200 {object} models.APIResponse{data=models.BatchUsersResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/lookup [post]
+func (h *Handler) LookupUsers(c *gin.Context) {
+	var req models.LookupUsersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return
+	}
+
+	fields, ok := parseUserFields(c)
+	if !ok {
+		return
+	}
+
+	h.respondWithUsersByIDs(c, req.IDs, fields)
+}
+
+// getUsersByIDs serves GET /users?ids=1,2,3
+func (h *Handler) getUsersByIDs(c *gin.Context, rawIDs string, fields userFieldSet) {
+	var ids []int
+	for _, raw := range strings.Split(rawIDs, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID: "+raw))
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	h.respondWithUsersByIDs(c, ids, fields)
+}
+
+// respondWithUsersByIDs loads the given users in a single query and writes
+// them in request order, along with the IDs that weren't found
+func (h *Handler) respondWithUsersByIDs(c *gin.Context, ids []int, fields userFieldSet) {
+	// Drop duplicates while keeping the first occurrence's position
+	seen := make(map[int]bool, len(ids))
+	unique := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	if len(unique) > maxBatchIDs {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "At most "+strconv.Itoa(maxBatchIDs)+" IDs can be requested at once"))
+		return
+	}
+
+	// Rows are matched up with the request by id
+	rows, err := h.users.GetMany(c.Request.Context(), unique, fields.columns("id"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving users"))
+		return
+	}
+
+	found := make(map[int]models.User, len(rows))
+	for _, user := range rows {
+		found[user.ID] = user
+	}
+
+	users := []models.User{}
+	missing := []int{}
+	for _, id := range unique {
+		if user, ok := found[id]; ok {
+			users = append(users, user)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: models.BatchUsersResponse{
+			Users:   fields.responses(c, users),
+			Missing: missing,
+		},
+	})
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/database"
+	"goapi/models"
+)
+
+// unblocked is a condition that neither the user in column nor the user in
+// param, a column or placeholder, has blocked the other
+func unblocked(column, param string) string {
+	return `NOT EXISTS (
+		SELECT 1 FROM user_blocks
+		WHERE (blocker_id = ` + column + ` AND blocked_id = ` + param + `)
+			OR (blocker_id = ` + param + ` AND blocked_id = ` + column + `)
+	)`
+}
+
+// @Summary Block user
+// @Description Blocks a user. Follows between the two are removed and neither can follow the other, see the other in follower listings or, when signed in, view the other's public profile. Blocking someone again changes nothing.
+// @Tags Relationships
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/me/blocks/{id} [post]
+func (h *Handler) BlockUserHandler(c *gin.Context) {
+	id, ok := h.existingUserParam(c)
+	if !ok {
+		return
+	}
+	userID := c.GetInt("userID")
+	if id == userID {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "You can't block yourself"))
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	if err := h.blockUser(ctx, userID, id); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error blocking user"))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Blocked user " + strconv.Itoa(id),
+	})
+}
+
+// blockUser records the block and ends follows in both directions
+func (h *Handler) blockUser(ctx context.Context, blocker, blocked int) error {
+	return database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO user_blocks (blocker_id, blocked_id, created_at) VALUES ($1, $2, $3)
+			ON CONFLICT (blocker_id, blocked_id) DO NOTHING
+		`, blocker, blocked, time.Now())
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM user_relationships
+			WHERE (follower_id = $1 AND followee_id = $2) OR (follower_id = $2 AND followee_id = $1)
+		`, blocker, blocked)
+		return err
+	})
+}
+
+// @Summary Unblock user
+// @Description Removes a block. Follows it removed aren't restored.
+// @Tags Relationships
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/me/blocks/{id} [delete]
+func (h *Handler) UnblockUserHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID"))
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	result, err := h.db.ExecContext(ctx, `
+		DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2
+	`, c.GetInt("userID"), id)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error unblocking user"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "You haven't blocked user "+strconv.Itoa(id)))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Unblocked user " + strconv.Itoa(id),
+	})
+}
+
+// @Summary List blocked users
+// @Description Lists the users the caller has blocked, most recent first
+// @Tags Relationships
+// @Produce json
+// @Param page query int false "Page number, starting at 1" default(1)
+// @Param page_size query int false "Users per page, at most 100" default(20)
+// @Success 200 {object} models.APIResponse{data=[]models.BlockedUser,pagination=models.Pagination}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/me/blocks [get]
+func (h *Handler) ListBlocksHandler(c *gin.Context) {
+	page, pageSize, ok := parsePage(c)
+	if !ok {
+		return
+	}
+	userID := c.GetInt("userID")
+
+	from := `
+		FROM user_blocks b JOIN users u ON u.id = b.blocked_id
+		WHERE b.blocker_id = $1 AND u.deleted_at IS NULL`
+
+	var total int
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	if err := h.db.QueryRowContext(ctx, "SELECT COUNT(*)"+from, userID).Scan(&total); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error counting users"))
+		return
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT u.id, u.name, u.username, u.email, u.age, u.show_email, u.show_age, b.created_at`+from+`
+		ORDER BY b.created_at DESC, u.id DESC
+		LIMIT $2 OFFSET $3
+	`, userID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving users"))
+		return
+	}
+	defer rows.Close()
+
+	users := []models.BlockedUser{}
+	for rows.Next() {
+		var user models.User
+		var blockedAt time.Time
+		if err := rows.Scan(&user.ID, &user.Name, &user.Username, &user.Email, &user.Age, &user.ShowEmail, &user.ShowAge, &blockedAt); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error scanning user"))
+			return
+		}
+		users = append(users, models.BlockedUser{PublicUserResponse: user.ToPublicUserResponse(), BlockedAt: blockedAt})
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:    true,
+		Data:       users,
+		Pagination: newPagination(page, pageSize, total),
+	})
+}
@@ -0,0 +1,673 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/database"
+	"goapi/jobs"
+	"goapi/mailer"
+	"goapi/models"
+	"goapi/repository"
+)
+
+// campaignBatch is how many users a campaigns.send job takes at a time, both
+// when finding the segment and when sending
+const campaignBatch = 500
+
+// Every campaign email ends with a link to stop them
+const (
+	campaignTextFooter = "\n\n--\nTo stop getting these emails, open {{.UnsubscribeURL}}\n"
+	campaignHTMLFooter = `<p style="margin-top: 32px; font-size: 12px; color: #71717a;">` +
+		`Don't want these emails? <a href="{{.UnsubscribeURL}}" style="color: #71717a;">Unsubscribe</a>.</p>`
+)
+
+// campaignData is what a campaign's templates are filled in with
+type campaignData struct {
+	Name           string
+	Email          string
+	Username       string
+	AppURL         string
+	UnsubscribeURL string
+}
+
+// sendCampaignRequest is the payload of a campaigns.send job
+type sendCampaignRequest struct {
+	CampaignID int64 `json:"campaign_id"`
+}
+
+// parseCampaign parses a campaign's templates with the unsubscribe footer
+func parseCampaign(subject, text, html string) (*mailer.Template, error) {
+	if html != "" {
+		html += campaignHTMLFooter
+	}
+	return mailer.Parse(subject, text+campaignTextFooter, html)
+}
+
+// @Summary Send an email campaign
+// @Description Queues a campaigns.send job emailing every user that matches the filters, which are those of GET /users. The subject and bodies are Go templates filled in with the recipient's Name, Email and Username, and AppURL; each email gets an unsubscribe link. Users who opted out of campaign emails are skipped. Follow the campaign's progress on /admin/campaigns/{id}.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param campaign body models.CampaignRequest true "Campaign email"
+// @Param q query string false "Names and emails containing or resembling this, tolerating typos"
+// @Param is_active query bool false "Only active or only inactive users"
+// @Param age_min query int false "Minimum age, inclusive"
+// @Param age_max query int false "Maximum age, inclusive"
+// @Param created_after query string false "Created at or after, RFC 3339 or YYYY-MM-DD"
+// @Param created_before query string false "Created before, RFC 3339 or YYYY-MM-DD"
+// @Param inactive_since query string false "Not seen since, e.g. 90d, 12h, RFC 3339 or YYYY-MM-DD; users never seen count from their creation"
+// @Param tag query string false "Tag the users carry, e.g. beta; repeat to require several"
+// @Param metadata.key query string false "Metadata value, e.g. metadata.department=eng; repeat with other keys, values compare as text"
+// @Success 202 {object} models.APIResponse{data=models.Campaign}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/campaigns [post]
+func (h *Handler) CreateCampaignHandler(c *gin.Context) {
+	var req models.CampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return
+	}
+	filter, ok := parseUserFilters(c)
+	if !ok {
+		return
+	}
+
+	// Templates that don't parse, or refer to fields there aren't, fail now
+	// rather than for every recipient
+	tmpl, err := parseCampaign(req.Subject, req.Text, req.HTML)
+	if err == nil {
+		_, err = tmpl.Render("user@example.com", campaignData{
+			Name: "Ada Lovelace", Email: "user@example.com", Username: "ada", AppURL: appURL, UnsubscribeURL: appURL,
+		})
+	}
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid template: "+err.Error()))
+		return
+	}
+	segment, err := json.Marshal(filter)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error creating campaign"))
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	var id int64
+	err = database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO campaigns (subject, text_body, html_body, filter, created_by, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id
+		`, req.Subject, req.Text, req.HTML, segment, c.GetInt("userID"), time.Now()).Scan(&id)
+		if err != nil {
+			return err
+		}
+		jobID, err := jobs.EnqueueTx(ctx, tx, JobSendCampaign, sendCampaignRequest{CampaignID: id}, jobs.Options{})
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE campaigns SET job_id = $2 WHERE id = $1`, id, jobID)
+		return err
+	})
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error creating campaign"))
+		return
+	}
+	h.recordAudit(c, models.AuditCampaignCreate, 0, nil, gin.H{"campaign_id": id, "subject": req.Subject, "segment": filter})
+
+	campaign, err := h.loadCampaign(ctx, id)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving campaign"))
+		return
+	}
+	c.Header("Location", "/api/admin/campaigns/"+strconv.FormatInt(id, 10))
+	c.JSON(http.StatusAccepted, models.APIResponse{
+		Success: true,
+		Data:    campaign,
+		Message: "Campaign queued",
+	})
+}
+
+// @Summary List email campaigns
+// @Description Returns the campaigns newest first, with how many of their emails are pending, sent, failed or skipped.
+// @Tags Admin
+// @Produce json
+// @Param page query int false "Page number" default(1)
+// @Param page_size query int false "Campaigns per page" default(20) maximum(100)
+// @Success 200 {object} models.APIResponse{data=[]models.Campaign,pagination=models.Pagination}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/campaigns [get]
+func (h *Handler) ListCampaignsHandler(c *gin.Context) {
+	page, pageSize, ok := parsePage(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	var total int
+	if err := h.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM campaigns`).Scan(&total); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving campaigns"))
+		return
+	}
+	rows, err := h.db.QueryContext(ctx, campaignQuery+` ORDER BY c.id DESC LIMIT $1 OFFSET $2`, pageSize, (page-1)*pageSize)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving campaigns"))
+		return
+	}
+	defer rows.Close()
+
+	campaigns := []models.Campaign{}
+	for rows.Next() {
+		campaign, err := scanCampaign(rows)
+		if err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving campaigns"))
+			return
+		}
+		campaigns = append(campaigns, campaign)
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving campaigns"))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:    true,
+		Data:       campaigns,
+		Pagination: newPagination(page, pageSize, total),
+	})
+}
+
+// @Summary Get an email campaign
+// @Description Returns a campaign with its job's status and how many of its emails are pending, sent, failed or skipped. The total is known once every user in the segment has been found.
+// @Tags Admin
+// @Produce json
+// @Param id path int true "Campaign ID"
+// @Success 200 {object} models.APIResponse{data=models.Campaign}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/campaigns/{id} [get]
+func (h *Handler) GetCampaignHandler(c *gin.Context) {
+	id, ok := campaignID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	campaign, err := h.loadCampaign(ctx, id)
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "Campaign not found"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving campaign"))
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    campaign,
+	})
+}
+
+// @Summary List the recipients of an email campaign
+// @Description Returns a page of a campaign's recipients by user ID, with why their email failed; filter by status to see the failures.
+// @Tags Admin
+// @Produce json
+// @Param id path int true "Campaign ID"
+// @Param status query string false "Only recipients in this status" Enums(pending, sent, failed, skipped)
+// @Param page query int false "Page number" default(1)
+// @Param page_size query int false "Recipients per page" default(20) maximum(100)
+// @Success 200 {object} models.APIResponse{data=[]models.CampaignRecipient,pagination=models.Pagination}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/campaigns/{id}/recipients [get]
+func (h *Handler) ListCampaignRecipientsHandler(c *gin.Context) {
+	id, ok := campaignID(c)
+	if !ok {
+		return
+	}
+	status := c.Query("status")
+	switch status {
+	case "", models.CampaignRecipientPending, models.CampaignRecipientSent, models.CampaignRecipientFailed, models.CampaignRecipientSkipped:
+	default:
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "status must be pending, sent, failed or skipped"))
+		return
+	}
+	page, pageSize, ok := parsePage(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	var total int
+	var exists bool
+	err := h.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM campaigns WHERE id = $1),
+			(SELECT COUNT(*) FROM campaign_recipients WHERE campaign_id = $1 AND ($2 = '' OR status = $2))
+	`, id, status).Scan(&exists, &total)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving recipients"))
+		return
+	}
+	if !exists {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "Campaign not found"))
+		return
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT user_id, email, status, error, sent_at
+		FROM campaign_recipients
+		WHERE campaign_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY user_id
+		LIMIT $3 OFFSET $4
+	`, id, status, pageSize, (page-1)*pageSize)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving recipients"))
+		return
+	}
+	defer rows.Close()
+
+	recipients := []models.CampaignRecipient{}
+	for rows.Next() {
+		var r models.CampaignRecipient
+		if err := rows.Scan(&r.UserID, &r.Email, &r.Status, &r.Error, &r.SentAt); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving recipients"))
+			return
+		}
+		recipients = append(recipients, r)
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving recipients"))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:    true,
+		Data:       recipients,
+		Pagination: newPagination(page, pageSize, total),
+	})
+}
+
+// @Summary Get my email preferences
+// @Description Returns whether the caller gets campaign emails. Account emails, such as password resets, are always sent.
+// @Tags Users
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=models.EmailPreferences}
+// @Failure 401 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/me/email-preferences [get]
+func (h *Handler) GetEmailPreferencesHandler(c *gin.Context) {
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	var optedOut bool
+	err := h.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM email_opt_outs WHERE user_id = $1)
+	`, c.GetInt("userID")).Scan(&optedOut)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving email preferences"))
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    models.EmailPreferences{Campaigns: !optedOut},
+	})
+}
+
+// @Summary Set my email preferences
+// @Description Sets whether the caller gets campaign emails. Emails of campaigns already being sent stop too.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param preferences body models.EmailPreferences true "Email preferences"
+// @Success 200 {object} models.APIResponse{data=models.EmailPreferences}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/me/email-preferences [put]
+func (h *Handler) SetEmailPreferencesHandler(c *gin.Context) {
+	var req models.EmailPreferences
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	var err error
+	if req.Campaigns {
+		_, err = h.db.ExecContext(ctx, `DELETE FROM email_opt_outs WHERE user_id = $1`, c.GetInt("userID"))
+	} else {
+		err = optOut(ctx, h.db, c.GetInt("userID"))
+	}
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error saving email preferences"))
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    req,
+		Message: "Email preferences saved",
+	})
+}
+
+// @Summary Unsubscribe from campaign emails
+// @Description Opts the recipient of a campaign email out of campaign emails, with the token of its unsubscribe link. No sign-in is needed.
+// @Tags Public
+// @Accept json
+// @Produce json
+// @Param request body models.UnsubscribeRequest true "Unsubscribe token"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 429 {object} models.Problem
+// @Router /email/unsubscribe [post]
+func (h *Handler) UnsubscribeHandler(c *gin.Context) {
+	var req models.UnsubscribeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	var userID int
+	err := h.db.QueryRowContext(ctx, `
+		SELECT user_id FROM campaign_recipients WHERE token_hash = $1
+	`, hashInvitationToken(req.Token)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "Unsubscribe link not found"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return
+	}
+	if err := optOut(ctx, h.db, userID); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error saving email preferences"))
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "You won't get campaign emails anymore",
+	})
+}
+
+// optOut stops campaign emails to userID
+func optOut(ctx context.Context, q database.Querier, userID int) error {
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO email_opt_outs (user_id, created_at) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO NOTHING
+	`, userID, time.Now())
+	return err
+}
+
+// campaignID parses the campaign ID of the path, writing a 400 when it isn't
+// one
+func campaignID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid campaign ID"))
+		return 0, false
+	}
+	return id, true
+}
+
+// campaignQuery selects campaigns with their job's status and their
+// recipients counted by status
+const campaignQuery = `
+	SELECT c.id, c.subject, c.filter, c.job_id, c.created_by, c.created_at, c.resolved_at,
+		COALESCE(j.status, ''), COALESCE(j.last_error, ''),
+		(SELECT COUNT(*) FILTER (WHERE r.status = 'pending') FROM campaign_recipients r WHERE r.campaign_id = c.id),
+		(SELECT COUNT(*) FILTER (WHERE r.status = 'sent') FROM campaign_recipients r WHERE r.campaign_id = c.id),
+		(SELECT COUNT(*) FILTER (WHERE r.status = 'failed') FROM campaign_recipients r WHERE r.campaign_id = c.id),
+		(SELECT COUNT(*) FILTER (WHERE r.status = 'skipped') FROM campaign_recipients r WHERE r.campaign_id = c.id)
+	FROM campaigns c
+	LEFT JOIN jobs j ON j.id = c.job_id`
+
+// scanCampaign scans a row of campaignQuery
+func scanCampaign(row interface{ Scan(...interface{}) error }) (models.Campaign, error) {
+	var campaign models.Campaign
+	var segment []byte
+	err := row.Scan(&campaign.ID, &campaign.Subject, &segment, &campaign.JobID, &campaign.CreatedBy, &campaign.CreatedAt,
+		&campaign.ResolvedAt, &campaign.Status, &campaign.Error,
+		&campaign.Pending, &campaign.Sent, &campaign.Failed, &campaign.Skipped)
+	if err != nil {
+		return campaign, err
+	}
+	campaign.Segment = segment
+	campaign.Total = campaign.Pending + campaign.Sent + campaign.Failed + campaign.Skipped
+	if campaign.Status != jobs.StatusFailed {
+		campaign.Error = ""
+	}
+	return campaign, nil
+}
+
+// loadCampaign returns the campaign with id and its progress
+func (h *Handler) loadCampaign(ctx context.Context, id int64) (models.Campaign, error) {
+	return scanCampaign(h.db.QueryRowContext(ctx, campaignQuery+` WHERE c.id = $1`, id))
+}
+
+// sendCampaignJob sends a campaign. It first records a recipient for every
+// user in the segment, skipping those who opted out, then sends to the
+// pending recipients a batch at a time, so a retried job carries on where
+// the last attempt stopped. Emails the mail backend rejects are marked
+// failed; other errors fail the attempt to be retried later.
+func (h *Handler) sendCampaignJob(ctx context.Context, job *jobs.Job) error {
+	var req sendCampaignRequest
+	if err := json.Unmarshal(job.Payload, &req); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	var subject, text, html string
+	var segment []byte
+	var resolved sql.NullTime
+	err := h.db.QueryRowContext(ctx, `
+		SELECT subject, text_body, html_body, filter, resolved_at FROM campaigns WHERE id = $1
+	`, req.CampaignID).Scan(&subject, &text, &html, &segment, &resolved)
+	if err == sql.ErrNoRows {
+		return jobs.Permanent(fmt.Errorf("campaign %d no longer exists", req.CampaignID))
+	} else if err != nil {
+		return err
+	}
+	tmpl, err := parseCampaign(subject, text, html)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+
+	if !resolved.Valid {
+		var filter repository.UserFilter
+		if err := json.Unmarshal(segment, &filter); err != nil {
+			return jobs.Permanent(err)
+		}
+		if err := h.resolveCampaign(ctx, req.CampaignID, filter); err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	sent, failed := 0, 0
+	for {
+		n, f, err := h.sendCampaignBatch(ctx, req.CampaignID, tmpl)
+		sent += n - f
+		failed += f
+		if err != nil {
+			return err
+		}
+		if n < campaignBatch {
+			break
+		}
+	}
+	log.Printf("Sent campaign %d in %s: %d sent, %d failed", req.CampaignID, time.Since(start).Round(time.Second), sent, failed)
+	return nil
+}
+
+// resolveCampaign records a recipient for every user matching filter, a
+// page at a time, then marks the campaign resolved. Users already recorded
+// by an earlier attempt are left as they are.
+func (h *Handler) resolveCampaign(ctx context.Context, id int64, filter repository.UserFilter) error {
+	filter.Before = nil
+	for {
+		// Newest first, as the keyset pages of filter.Before go
+		users, err := h.users.List(ctx, repository.ListQuery{
+			Columns: []string{"id", "email", "created_at"},
+			Filter:  filter,
+			Sort:    []repository.SortKey{{Column: "created_at", Descending: true}},
+			Limit:   campaignBatch,
+		})
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			break
+		}
+
+		ids := make([]int64, len(users))
+		for i, user := range users {
+			ids[i] = int64(user.ID)
+		}
+		_, err = h.db.ExecContext(ctx, `
+			INSERT INTO campaign_recipients (campaign_id, user_id, email, status)
+			SELECT $1, u.id, u.email,
+				CASE WHEN EXISTS (SELECT 1 FROM email_opt_outs o WHERE o.user_id = u.id) THEN 'skipped' ELSE 'pending' END
+			FROM users u
+			WHERE u.id = ANY($2)
+			ON CONFLICT (campaign_id, user_id) DO NOTHING
+		`, id, ids)
+		if err != nil {
+			return err
+		}
+
+		last := users[len(users)-1]
+		filter.Before = &repository.Position{CreatedAt: last.CreatedAt, ID: last.ID}
+		if len(users) < campaignBatch {
+			break
+		}
+	}
+	_, err := h.db.ExecContext(ctx, `UPDATE campaigns SET resolved_at = $2 WHERE id = $1`, id, time.Now())
+	return err
+}
+
+// campaignRecipient is a pending recipient of a campaign being sent
+type campaignRecipient struct {
+	UserID   int
+	Email    string
+	Name     string
+	Username sql.NullString
+	OptedOut bool
+}
+
+// sendCampaignBatch sends up to campaignBatch pending emails of campaign id,
+// returning how many it handled and how many of those failed
+func (h *Handler) sendCampaignBatch(ctx context.Context, id int64, tmpl *mailer.Template) (handled, failed int, err error) {
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT r.user_id, r.email, u.name, u.username,
+			EXISTS (SELECT 1 FROM email_opt_outs o WHERE o.user_id = r.user_id)
+		FROM campaign_recipients r JOIN users u ON u.id = r.user_id
+		WHERE r.campaign_id = $1 AND r.status = 'pending'
+		ORDER BY r.user_id
+		LIMIT $2
+	`, id, campaignBatch)
+	if err != nil {
+		return 0, 0, err
+	}
+	var recipients []campaignRecipient
+	for rows.Next() {
+		var r campaignRecipient
+		if err := rows.Scan(&r.UserID, &r.Email, &r.Name, &r.Username, &r.OptedOut); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		recipients = append(recipients, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, r := range recipients {
+		if err := ctx.Err(); err != nil {
+			return handled, failed, err
+		}
+		// Users who opted out since the segment was found
+		if r.OptedOut {
+			if err := markCampaignRecipient(ctx, h.db, id, r.UserID, models.CampaignRecipientSkipped, ""); err != nil {
+				return handled, failed, err
+			}
+			handled++
+			continue
+		}
+
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return handled, failed, err
+		}
+		token := hex.EncodeToString(buf)
+		_, err := h.db.ExecContext(ctx, `
+			UPDATE campaign_recipients SET token_hash = $3 WHERE campaign_id = $1 AND user_id = $2
+		`, id, r.UserID, hashInvitationToken(token))
+		if err != nil {
+			return handled, failed, err
+		}
+
+		msg, err := tmpl.Render(r.Email, campaignData{
+			Name:           r.Name,
+			Email:          r.Email,
+			Username:       r.Username.String,
+			AppURL:         appURL,
+			UnsubscribeURL: appURL + "/unsubscribe?token=" + token,
+		})
+		status, reason := models.CampaignRecipientSent, ""
+		if err != nil {
+			// The template was tried out when the campaign was created, so
+			// this is about the recipient's own data
+			status, reason = models.CampaignRecipientFailed, err.Error()
+		} else if err := mailer.Send(ctx, msg); errors.Is(err, mailer.ErrRejected) {
+			status, reason = models.CampaignRecipientFailed, err.Error()
+		} else if err != nil {
+			return handled, failed, err
+		}
+		if status == models.CampaignRecipientFailed {
+			failed++
+		}
+		if err := markCampaignRecipient(ctx, h.db, id, r.UserID, status, reason); err != nil {
+			return handled, failed, err
+		}
+		handled++
+	}
+	return handled, failed, nil
+}
+
+// markCampaignRecipient sets the status of a recipient of campaign id, with
+// why their email failed
+func markCampaignRecipient(ctx context.Context, q database.Querier, id int64, userID int, status, reason string) error {
+	_, err := q.ExecContext(ctx, `
+		UPDATE campaign_recipients
+		SET status = $3, error = $4, sent_at = CASE WHEN $3 = 'sent' THEN $5::timestamp END
+		WHERE campaign_id = $1 AND user_id = $2
+	`, id, userID, status, reason, time.Now())
+	return err
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// client is who a call came from, as recorded with sessions, login events
+// and audit entries. REST handlers take it from the request; gRPC methods
+// from the peer and metadata.
+type client struct {
+	// userID is the authenticated caller, 0 for anonymous calls
+	userID    int
+	ip        string
+	userAgent string
+	// country is set when geo-blocking resolved it for the request
+	country string
+}
+
+// clientOf returns the client of a REST request
+func clientOf(c *gin.Context) client {
+	return client{
+		userID:    c.GetInt("userID"),
+		ip:        c.ClientIP(),
+		userAgent: c.Request.UserAgent(),
+		country:   c.GetString("country"),
+	}
+}
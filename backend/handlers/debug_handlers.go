@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"expvar"
+	"net/http/pprof"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PprofHandler serves the net/http/pprof profiles under /debug/pprof, e.g.
+// go tool pprof http://host/debug/pprof/profile?seconds=30 for CPU and
+// /debug/pprof/heap for memory. It is mounted as /debug/pprof/*profile.
+func PprofHandler(c *gin.Context) {
+	switch c.Param("profile") {
+	case "/cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "/profile":
+		pprof.Profile(c.Writer, c.Request)
+	case "/symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "/trace":
+		pprof.Trace(c.Writer, c.Request)
+	default:
+		// The index page and the named profiles: heap, goroutine, allocs, ...
+		pprof.Index(c.Writer, c.Request)
+	}
+}
+
+// ExpvarHandler serves the published expvar variables as JSON, including
+// the runtime's memstats and cmdline
+func ExpvarHandler(c *gin.Context) {
+	expvar.Handler().ServeHTTP(c.Writer, c.Request)
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strconv"
+	"time"
+
+	"goapi/database"
+	"goapi/jobs"
+	"goapi/utils"
+)
+
+// emailKeysSetting records whether the stored email deduplication keys,
+// email_normalized, were computed with EMAIL_DEDUP_STRIP_ALIASES on
+const emailKeysSetting = "email_normalized.strip_aliases"
+
+// emailKeysBatch is how many rows renormalizeEmailsJob reads at a time
+const emailKeysBatch = 500
+
+// QueueEmailRenormalization queues the job recomputing the email
+// deduplication keys of users and invitations when they were computed with
+// a different EMAIL_DEDUP_STRIP_ALIASES than the current one. Until it has
+// run, logins still find users by their exact address.
+func (h *Handler) QueueEmailRenormalization(ctx context.Context) error {
+	stored := "false" // rows backfilled by the first migration are lowercased only
+	err := h.db.QueryRowContext(ctx, `SELECT value FROM settings WHERE key = $1`, emailKeysSetting).Scan(&stored)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if stored == strconv.FormatBool(utils.StripEmailAliases()) {
+		return nil
+	}
+	log.Printf("EMAIL_DEDUP_STRIP_ALIASES changed to %t, recomputing stored email keys", utils.StripEmailAliases())
+	_, err = jobs.Enqueue(ctx, h.db, JobRenormalizeEmails, nil, jobs.Options{UniqueKey: JobRenormalizeEmails})
+	return err
+}
+
+// renormalizeEmailsJob recomputes email_normalized for every user and
+// invitation, then records the setting it was computed with. A user whose
+// new key another user already has keeps the old one, and is logged: the
+// two accounts are the same mailbox and need merging by hand.
+func (h *Handler) renormalizeEmailsJob(ctx context.Context, job *jobs.Job) error {
+	strip := utils.StripEmailAliases()
+	for _, table := range []string{"users", "invitations"} {
+		changed, err := h.renormalizeEmails(ctx, table)
+		if err != nil {
+			return err
+		}
+		if changed > 0 {
+			log.Printf("Recomputed the email key of %d %s", changed, table)
+		}
+	}
+
+	_, err := h.db.ExecContext(ctx, `
+		INSERT INTO settings (key, value, updated_at) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at
+	`, emailKeysSetting, strconv.FormatBool(strip), time.Now())
+	return err
+}
+
+// renormalizeEmails updates the rows of table whose email_normalized isn't
+// the current key for their email and returns how many it changed
+func (h *Handler) renormalizeEmails(ctx context.Context, table string) (int, error) {
+	type row struct {
+		id              int
+		email, key, old string
+	}
+	changed, after := 0, 0
+	for {
+		rows, err := h.db.QueryContext(ctx, `
+			SELECT id, email, email_normalized FROM `+table+`
+			WHERE id > $1 ORDER BY id LIMIT $2
+		`, after, emailKeysBatch)
+		if err != nil {
+			return changed, err
+		}
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.email, &r.old); err != nil {
+				rows.Close()
+				return changed, err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return changed, err
+		}
+
+		for _, r := range batch {
+			after = r.id
+			r.key = utils.CanonicalEmail(r.email)
+			if r.key == r.old {
+				continue
+			}
+			_, err := h.db.ExecContext(ctx, `UPDATE `+table+` SET email_normalized = $1 WHERE id = $2`, r.key, r.id)
+			if _, ok := database.UniqueViolation(err); ok {
+				log.Printf("Warning: %s %d keeps email key %q: another account already has %q", table, r.id, r.old, r.key)
+				continue
+			} else if err != nil {
+				return changed, err
+			}
+			changed++
+		}
+		if len(batch) < emailKeysBatch {
+			return changed, nil
+		}
+	}
+}
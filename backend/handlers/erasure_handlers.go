@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/database"
+	"goapi/models"
+)
+
+// @Summary Anonymize user
+// @Description Irreversibly erases a user's personal data (GDPR right to erasure) while keeping the row, so references to the user stay valid. Name, email, username, age, metadata, password and activity times are scrubbed, prior versions are dropped, sign-in identities, passkeys, sessions, admin notes, pending sign-in flows and undelivered webhook events about the user are removed, the user's login events and audit entries lose their IPs, devices and recorded changes, and delivered webhook payloads lose their data. The account ends up deactivated and deleted. Allowed on one's own account; for anyone else it needs the users:write and admin scopes and ADMIN_ALLOWED_CIDRS.
+// @Tags Users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/{id}/anonymize [post]
+func (h *Handler) AnonymizeUserHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID"))
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	var anonymized bool
+	err = h.db.QueryRowContext(ctx, "SELECT anonymized_at IS NOT NULL FROM users WHERE id = $1", id).Scan(&anonymized)
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeUserNotFound, "User with ID "+strconv.Itoa(id)+" not found"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return
+	}
+	if anonymized {
+		c.Error(apperr.New(http.StatusConflict, apperr.CodeConflict, "User is already anonymized"))
+		return
+	}
+
+	if err := h.anonymizeUser(ctx, clientOf(c), id); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error anonymizing user"))
+		return
+	}
+
+	h.publishAuditEvent(ctx, models.AuditUserAnonymize, id, nil, nil)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "User anonymized successfully",
+	})
+}
+
+// anonymizeUser scrubs the personal data of a user and everything recorded
+// about them, in one transaction with cl's audit entry. Only the fact of
+// the erasure is kept.
+func (h *Handler) anonymizeUser(ctx context.Context, cl client, id int) error {
+	return database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		if err := anonymizeUserTx(ctx, tx, id); err != nil {
+			return err
+		}
+		return cl.auditTx(ctx, tx, models.AuditUserAnonymize, id, nil, nil)
+	})
+}
+
+// anonymizeUserTx is anonymizeUser within tx
+func anonymizeUserTx(ctx context.Context, tx *sql.Tx, id int) error {
+	// The address is unique per user and can never receive mail
+	email := "deleted-" + strconv.Itoa(id) + "@anonymized.invalid"
+	now := time.Now()
+	_, err := tx.ExecContext(ctx, `
+		UPDATE users
+		SET name = 'Deleted user', email = $1, email_normalized = $1, username = NULL, password = '', has_password = FALSE,
+			age = NULL, is_active = FALSE, show_email = FALSE, show_age = FALSE, metadata = '{}',
+			last_login_at = NULL, last_login_ip = NULL, last_seen_at = NULL,
+			deleted_at = COALESCE(deleted_at, $2), anonymized_at = $2, updated_at = $2
+		WHERE id = $3
+	`, email, now, id)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range []string{
+		// Also drops the version the update above just recorded
+		`DELETE FROM users_history WHERE user_id = $1`,
+		`DELETE FROM sessions WHERE user_id = $1`,
+		`DELETE FROM user_identities WHERE user_id = $1`,
+		`DELETE FROM webauthn_credentials WHERE user_id = $1`,
+		`DELETE FROM admin_notes WHERE user_id = $1`,
+		`UPDATE login_events SET email = '', ip = '', user_agent = '', device = '', country = '' WHERE user_id = $1`,
+		`UPDATE audit_logs SET before = NULL, after = NULL WHERE user_id = $1`,
+		`UPDATE audit_logs SET actor_ip = '' WHERE actor_id = $1`,
+		`DELETE FROM pending_flows WHERE user_id = $1`,
+		`DELETE FROM campaign_recipients WHERE user_id = $1`,
+		// Events about the user carry them as the subject; undelivered
+		// ones are dropped, delivered ones keep only the envelope
+		`DELETE FROM webhook_deliveries WHERE payload->>'subject' = $1::int::text AND status = 'pending'`,
+		`UPDATE webhook_deliveries SET payload = payload - 'data' WHERE payload->>'subject' = $1::int::text`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
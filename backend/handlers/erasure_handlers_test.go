@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingDriver is a database/sql driver that accepts every statement and
+// remembers it, for checking what a transaction would run
+type recordingDriver struct {
+	mu         sync.Mutex
+	statements []string
+}
+
+func (d *recordingDriver) Open(name string) (driver.Conn, error) { return recordingConn{d}, nil }
+
+type recordingConn struct{ d *recordingDriver }
+
+func (c recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("recordingDriver: prepared statements are not supported")
+}
+func (c recordingConn) Close() error              { return nil }
+func (c recordingConn) Begin() (driver.Tx, error) { return recordingTx{}, nil }
+
+func (c recordingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	c.d.statements = append(c.d.statements, strings.Join(strings.Fields(query), " "))
+	return driver.RowsAffected(1), nil
+}
+
+type recordingTx struct{}
+
+func (recordingTx) Commit() error   { return nil }
+func (recordingTx) Rollback() error { return nil }
+
+func TestAnonymizeUserScrubsEverythingAboutTheUser(t *testing.T) {
+	d := &recordingDriver{}
+	sql.Register("erasure-recorder", d)
+	db, err := sql.Open("erasure-recorder", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := anonymizeUserTx(context.Background(), tx, 42); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"UPDATE users SET name = 'Deleted user'",
+		"DELETE FROM sessions WHERE user_id = $1",
+		"DELETE FROM user_identities WHERE user_id = $1",
+		"DELETE FROM webauthn_credentials WHERE user_id = $1",
+		"DELETE FROM pending_flows WHERE user_id = $1",
+		"DELETE FROM campaign_recipients WHERE user_id = $1",
+		"DELETE FROM webhook_deliveries WHERE payload->>'subject' = $1::int::text",
+		"UPDATE webhook_deliveries SET payload = payload - 'data' WHERE payload->>'subject' = $1::int::text",
+	} {
+		found := false
+		for _, statement := range d.statements {
+			if strings.HasPrefix(statement, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("no statement starting with %q in %q", want, d.statements)
+		}
+	}
+}
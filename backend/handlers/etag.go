@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/models"
+)
+
+// userETag returns the entity tag of a user's current version. The version
+// doesn't change when the user signs in or is seen, but responses show
+// those times, so the latest of them is appended when known: "7" or
+// "7-1700000000000".
+func userETag(user models.User) string {
+	tag := strconv.Itoa(user.Version)
+	if activity := latestActivity(user); activity != nil {
+		tag += "-" + strconv.FormatInt(activity.UnixMilli(), 10)
+	}
+	return `"` + tag + `"`
+}
+
+// etagVersion returns the user version an entity tag names
+func etagVersion(tag string) (int, bool) {
+	tag, ok := strings.CutPrefix(tag, `"`)
+	if !ok {
+		return 0, false
+	}
+	tag, ok = strings.CutSuffix(tag, `"`)
+	if !ok {
+		return 0, false
+	}
+	tag, _, _ = strings.Cut(tag, "-")
+	version, err := strconv.Atoi(tag)
+	return version, err == nil
+}
+
+// latestActivity returns the later of the user's last sign-in and last
+// request, nil if neither is known
+func latestActivity(user models.User) *time.Time {
+	latest := user.LastLoginAt
+	if user.LastSeenAt != nil && (latest == nil || user.LastSeenAt.After(*latest)) {
+		latest = user.LastSeenAt
+	}
+	return latest
+}
+
+// ifMatchUser reports whether the If-Match header, if any, names the user's
+// current version, writing 412 when it doesn't. Only the version counts, so
+// tags from before the user's latest sign-in still match. Weak tags never
+// match.
+func ifMatchUser(c *gin.Context, user models.User) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag == "*" {
+			return true
+		}
+		if version, ok := etagVersion(tag); ok && version == user.Version {
+			return true
+		}
+	}
+	c.Header("ETag", userETag(user))
+	c.Error(apperr.New(http.StatusPreconditionFailed, apperr.CodeVersionConflict, "User has changed since it was read; reload it and try again"))
+	return false
+}
+
+// notModifiedUser sets the user's ETag and Last-Modified headers and reports
+// whether the client's copy is still current, writing 304 when it is. As in
+// RFC 9110, If-None-Match (compared weakly) takes precedence over
+// If-Modified-Since. Responses are marked for revalidation on every use, and
+// only by the client, as they depend on the caller's token.
+func notModifiedUser(c *gin.Context, user models.User) bool {
+	etag := userETag(user)
+	lastModified := user.UpdatedAt
+	if activity := latestActivity(user); activity != nil && activity.After(lastModified) {
+		lastModified = *activity
+	}
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	c.Header("Cache-Control", "private, no-cache")
+
+	notModified := false
+	if header := c.GetHeader("If-None-Match"); header != "" {
+		for _, tag := range strings.Split(header, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				notModified = true
+				break
+			}
+		}
+	} else if since, err := http.ParseTime(c.GetHeader("If-Modified-Since")); err == nil {
+		// HTTP dates have whole seconds
+		notModified = !lastModified.Truncate(time.Second).After(since)
+	}
+
+	if notModified {
+		c.Status(http.StatusNotModified)
+	}
+	return notModified
+}
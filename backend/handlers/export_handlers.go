@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/jobs"
+	"goapi/models"
+)
+
+// exportCSVColumns are the columns of a CSV user export
+var exportCSVColumns = []string{"id", "name", "email", "username", "age", "is_active", "show_email", "show_age", "created_at"}
+
+// exportRequest is the payload of a users.export job
+type exportRequest struct {
+	Format string `json:"format"`
+}
+
+// @Summary Export users
+// @Description Queues a job exporting every user that isn't deleted, as CSV (id, name, email, username, age, is_active, show_email, show_age and created_at) or JSON Lines (one user per line, as the API returns them). Follow the job on /admin/jobs/{id} and download the file from /admin/jobs/{id}/result once it has succeeded.
+// @Tags Admin
+// @Produce json
+// @Param format query string false "File format" Enums(csv, jsonl) default(csv)
+// @Success 202 {object} models.APIResponse{data=models.Job}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/exports/users [post]
+func (h *Handler) ExportUsersHandler(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "jsonl" {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "format must be csv or jsonl"))
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	id, err := jobs.Enqueue(ctx, h.db, JobExportUsers, exportRequest{Format: format}, jobs.Options{})
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error queueing export"))
+		return
+	}
+	h.recordAudit(c, models.AuditUserExport, 0, nil, gin.H{"job_id": id, "format": format})
+
+	job, err := h.loadJob(ctx, id)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving job"))
+		return
+	}
+	c.JSON(http.StatusAccepted, models.APIResponse{
+		Success: true,
+		Data:    job,
+		Message: "Export queued",
+	})
+}
+
+// exportUsersJob writes the users that aren't deleted to the job's result
+func (h *Handler) exportUsersJob(ctx context.Context, job *jobs.Job) error {
+	var req exportRequest
+	if err := json.Unmarshal(job.Payload, &req); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	rows, err := h.db.QueryContext(ctx, `SELECT `+userColumns+` FROM users WHERE deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var buf bytes.Buffer
+	var write func(user models.User) error
+	var w *csv.Writer
+	switch req.Format {
+	case "csv":
+		w = csv.NewWriter(&buf)
+		if err := w.Write(exportCSVColumns); err != nil {
+			return err
+		}
+		write = func(user models.User) error {
+			record := []string{strconv.Itoa(user.ID), user.Name, user.Email, "", "",
+				strconv.FormatBool(user.IsActive), strconv.FormatBool(user.ShowEmail), strconv.FormatBool(user.ShowAge),
+				user.CreatedAt.Format(time.RFC3339)}
+			if user.Username != nil {
+				record[3] = *user.Username
+			}
+			if user.Age != nil {
+				record[4] = strconv.Itoa(*user.Age)
+			}
+			return w.Write(record)
+		}
+		job.ResultType = "text/csv"
+	case "jsonl":
+		enc := json.NewEncoder(&buf)
+		write = func(user models.User) error {
+			return enc.Encode(user.ToAdminUserResponse())
+		}
+		job.ResultType = "application/x-ndjson"
+	default:
+		return jobs.Permanent(fmt.Errorf("unknown export format %q", req.Format))
+	}
+
+	for rows.Next() {
+		var user models.User
+		if err := scanUser(rows, &user); err != nil {
+			return err
+		}
+		if err := write(user); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if w != nil {
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+	}
+	job.Result = buf.Bytes()
+	return nil
+}
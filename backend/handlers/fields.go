@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/models"
+	"goapi/repository"
+)
+
+// userFieldSet is the set of user fields a request selected with ?fields=,
+// nil when it wants all of them
+type userFieldSet []string
+
+// parseUserFields reads ?fields=id,name,email. Only user columns are
+// accepted; otherwise it writes a 400 response and returns false.
+func parseUserFields(c *gin.Context) (userFieldSet, bool) {
+	raw := c.Query("fields")
+	if raw == "" {
+		return nil, true
+	}
+
+	var fields userFieldSet
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		if !repository.IsUserColumn(field) {
+			c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Invalid field: "+field+"; use a comma-separated list of "+userColumns))
+			return nil, false
+		}
+		if !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	return fields, true
+}
+
+// columns returns the columns to select: the selected fields plus the ones
+// the handler itself needs, such as id to match rows up
+func (f userFieldSet) columns(required ...string) []string {
+	if f == nil {
+		return strings.Split(userColumns, ", ")
+	}
+	columns := append([]string(nil), f...)
+	for _, column := range required {
+		if !f.has(column) {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+func (f userFieldSet) has(field string) bool {
+	for _, selected := range f {
+		if selected == field {
+			return true
+		}
+	}
+	return false
+}
+
+// response converts user for output, keeping only the selected fields
+func (f userFieldSet) response(c *gin.Context, user models.User) interface{} {
+	if f == nil {
+		return userResponse(c, user)
+	}
+	all := toJSONObject(userResponse(c, user))
+	selected := make(map[string]interface{}, len(f))
+	for _, field := range f {
+		// Unset optional fields are left out, as in full responses
+		if value, ok := all[field]; ok {
+			selected[field] = value
+		}
+	}
+	return selected
+}
+
+// responses converts users for a listing, never returning null
+func (f userFieldSet) responses(c *gin.Context, users []models.User) interface{} {
+	if f == nil {
+		return toUserResponses(c, users)
+	}
+	responses := make([]interface{}, len(users))
+	for i, user := range users {
+		responses[i] = f.response(c, user)
+	}
+	return responses
+}
@@ -0,0 +1,401 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/models"
+	"goapi/utils"
+)
+
+const (
+	// maxImportFileSize caps the uploaded file
+	maxImportFileSize = 10 << 20
+	// maxImportRows caps the number of records in one file
+	maxImportRows = 10000
+)
+
+// Ways to handle a record whose email already belongs to a user
+const (
+	onDuplicateSkip   = "skip"
+	onDuplicateUpdate = "update"
+	onDuplicateFail   = "fail"
+)
+
+// importCSVColumns lists the accepted CSV header names
+var importCSVColumns = map[string]bool{
+	"name":       true,
+	"email":      true,
+	"username":   true,
+	"password":   true,
+	"age":        true,
+	"is_active":  true,
+	"show_email": true,
+	"show_age":   true,
+}
+
+// importRecord is one parsed record of an import file
+type importRecord struct {
+	line int
+	req  models.CreateUserRequest
+	err  error
+}
+
+// @Summary Import users from a file
+// @Description Imports users from an uploaded CSV file (header row with name, email, password and optionally username, age, is_active, show_email, show_age) or JSON Lines file (same fields as user creation, one user per line). The format comes from format, else the file extension. Each record is validated on its own; on_duplicate decides what happens when its email already belongs to a user: skip it, update the user's name, username, age, status, visibility and metadata (not the password), or report it as failed. The report lists the outcome per record.
+// @Tags Users
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "CSV or JSON Lines file, up to 10 MB and 10000 records"
+// @Param format query string false "File format" Enums(csv, jsonl)
+// @Param on_duplicate query string false "Duplicate email handling" Enums(skip, update, fail) default(skip)
+// @Param dry_run query bool false "Only report what would change" default(false)
+// @Success 200 {object} models.APIResponse{data=models.ImportReport}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/import [post]
+func (h *Handler) ImportUsers(c *gin.Context) {
+	onDuplicate := c.DefaultQuery("on_duplicate", onDuplicateSkip)
+	if onDuplicate != onDuplicateSkip && onDuplicate != onDuplicateUpdate && onDuplicate != onDuplicateFail {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "on_duplicate must be skip, update or fail"))
+		return
+	}
+	dryRun := c.Query("dry_run") == "true"
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportFileSize+1<<20)
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Upload the users as the multipart field \"file\""))
+		return
+	}
+	if header.Size > maxImportFileSize {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "File is larger than 10 MB"))
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Error reading file"))
+		return
+	}
+	defer file.Close()
+
+	records, err := readImportFile(file, importFormat(c.Query("format"), header.Filename))
+	if err == nil && len(records) > maxImportRows {
+		err = fmt.Errorf("more than %d records", maxImportRows)
+	}
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Invalid import file: "+err.Error()))
+		return
+	}
+
+	report := models.ImportReport{DryRun: dryRun, Items: []models.ImportItem{}}
+	// Canonical emails and usernames taken earlier in this file, which a dry
+	// run can't look up; only emails contain "@"
+	created := map[string]bool{}
+	for _, record := range records {
+		report.Add(h.importRecordUser(c.Request.Context(), clientOf(c), record, onDuplicate, dryRun, created))
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    report,
+	})
+}
+
+// importFormat returns format, or without it the format the extension of
+// filename names, "" when it names none
+func importFormat(format, filename string) string {
+	if format != "" {
+		return format
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return "csv"
+	case ".jsonl", ".ndjson":
+		return "jsonl"
+	}
+	return ""
+}
+
+// readImportFile parses an import file in format, csv or jsonl
+func readImportFile(r io.Reader, format string) ([]importRecord, error) {
+	switch format {
+	case "csv":
+		return readImportCSV(r)
+	case "jsonl":
+		return readImportJSONLines(r)
+	}
+	return nil, errors.New("unknown format; use a .csv or .jsonl file or set format")
+}
+
+// readImportCSV parses a CSV file with a header row. Records with a bad
+// value or the wrong number of fields carry an error; malformed CSV fails the
+// whole file.
+func readImportCSV(r io.Reader) ([]importRecord, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("file is empty")
+	} else if err != nil {
+		return nil, err
+	}
+	columns := make([]string, len(header))
+	seen := map[string]bool{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !importCSVColumns[name] {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate column %q", name)
+		}
+		seen[name] = true
+		columns[i] = name
+	}
+	for _, name := range []string{"name", "email", "password"} {
+		if !seen[name] {
+			return nil, fmt.Errorf("missing column %q", name)
+		}
+	}
+
+	var records []importRecord
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) && parseErr.Err == csv.ErrFieldCount {
+			records = append(records, importRecord{line: parseErr.StartLine, err: errors.New("wrong number of fields")})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		record := importRecord{line: line}
+		for i, value := range fields {
+			if record.err = setImportField(&record.req, columns[i], strings.TrimSpace(value)); record.err != nil {
+				break
+			}
+		}
+		records = append(records, record)
+	}
+}
+
+// setImportField sets one CSV value on req; empty optional values stay unset
+func setImportField(req *models.CreateUserRequest, column, value string) error {
+	switch column {
+	case "name":
+		req.Name = value
+	case "email":
+		req.Email = value
+	case "username":
+		if value != "" {
+			req.Username = &value
+		}
+	case "password":
+		req.Password = value
+	case "age":
+		if value == "" {
+			return nil
+		}
+		age, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("age must be a number")
+		}
+		req.Age = &age
+	default:
+		if value == "" {
+			return nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New(column + " must be true or false")
+		}
+		switch column {
+		case "is_active":
+			req.IsActive = &b
+		case "show_email":
+			req.ShowEmail = &b
+		case "show_age":
+			req.ShowAge = &b
+		}
+	}
+	return nil
+}
+
+// readImportJSONLines parses one JSON user object per line, skipping blank lines
+func readImportJSONLines(r io.Reader) ([]importRecord, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), streamImportMaxLine)
+
+	var records []importRecord
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		record := importRecord{line: line}
+		if err := json.Unmarshal([]byte(text), &record.req); err != nil {
+			record.err = errors.New("invalid JSON: " + err.Error())
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// importRecordUser validates a record and creates its user, or handles the
+// existing user with the same email as onDuplicate says, audited as done by
+// cl. With dryRun it only reports what would happen. Writes go through the
+// UserService, so the unique indexes and the user's version decide conflicts
+// with concurrent changes.
+func (h *Handler) importRecordUser(ctx context.Context, cl client, record importRecord, onDuplicate string, dryRun bool, created map[string]bool) models.ImportItem {
+	req := record.req
+	item := models.ImportItem{Line: record.line, Email: utils.NormalizeEmail(req.Email)}
+	fail := func(message string) models.ImportItem {
+		item.Action = models.ImportActionFailed
+		item.Error = message
+		return item
+	}
+
+	if record.err != nil {
+		return fail("Invalid user data: " + record.err.Error())
+	}
+	if err := checkImportedUser(&req); err != nil {
+		return fail("Invalid user data: " + err.Error())
+	}
+	canonical := utils.CanonicalEmail(req.Email)
+	// Each record gets the whole timeout, however large the file is
+	ctx, cancel := h.withTimeout(ctx)
+	defer cancel()
+	// usernameError explains why a dry run can't give the record's username
+	// to the user with exceptID, or returns "" if it can
+	usernameError := func(exceptID int) string {
+		if req.Username == nil {
+			return ""
+		}
+		taken, err := h.usernameTaken(ctx, *req.Username, exceptID)
+		if err != nil {
+			return "Database error"
+		}
+		if taken || created[*req.Username] {
+			return "Username " + *req.Username + " is already taken"
+		}
+		return ""
+	}
+	claim := func() {
+		created[canonical] = true
+		if req.Username != nil {
+			created[*req.Username] = true
+		}
+	}
+
+	var existing models.User
+	var deleted bool
+	err := h.db.QueryRowContext(ctx, `
+		SELECT `+userColumns+`, deleted_at IS NOT NULL FROM users WHERE email_normalized = $1
+	`, canonical).Scan(append(userFields(&existing), &deleted)...)
+	if err == sql.ErrNoRows && !created[canonical] {
+		item.Action = models.ImportActionCreate
+		if dryRun {
+			if message := usernameError(0); message != "" {
+				return fail(message)
+			}
+			claim()
+			return item
+		}
+		audit := userAudit{cl: cl, action: models.AuditUserImport}
+		user, err := h.users.Create(ctx, newUserFrom(req), audit.hook)
+		if err != nil {
+			return fail(userError(err, "Error creating user").Detail)
+		}
+		claim()
+		h.publishAudit(ctx, audit, user)
+		return item
+	} else if err != nil && err != sql.ErrNoRows {
+		return fail("Database error")
+	}
+
+	switch {
+	case onDuplicate == onDuplicateSkip:
+		item.Action = models.ImportActionSkipped
+		return item
+	case onDuplicate == onDuplicateFail:
+		return fail("User with email " + req.Email + " already exists")
+	case deleted:
+		return fail("User is deleted; restore it to import")
+	case err == sql.ErrNoRows:
+		// Created earlier in this dry run
+		item.Action = models.ImportActionUpdate
+		return item
+	}
+
+	change := func(user *models.User) error {
+		user.Name = req.Name
+		if req.Username != nil {
+			user.Username = req.Username
+		}
+		if req.Age != nil {
+			user.Age = req.Age
+		}
+		if req.IsActive != nil {
+			user.IsActive = *req.IsActive
+		}
+		if req.ShowEmail != nil {
+			user.ShowEmail = *req.ShowEmail
+		}
+		if req.ShowAge != nil {
+			user.ShowAge = *req.ShowAge
+		}
+		if req.Metadata != nil {
+			user.Metadata = req.Metadata
+		}
+		return nil
+	}
+	updated := existing
+	change(&updated)
+	if reflect.DeepEqual(existing.ToUserResponse(), updated.ToUserResponse()) {
+		item.Action = models.ImportActionUnchanged
+		return item
+	}
+
+	item.Action = models.ImportActionUpdate
+	if dryRun {
+		if req.Username != nil && !reflect.DeepEqual(req.Username, existing.Username) {
+			if message := usernameError(existing.ID); message != "" {
+				return fail(message)
+			}
+		}
+		claim()
+		return item
+	}
+	audit := userAudit{cl: cl, action: models.AuditUserImport, before: &existing}
+	updated, err = h.users.Update(ctx, existing, change, audit.hook)
+	if err != nil {
+		return fail(userError(err, "Error updating user").Detail)
+	}
+	claim()
+	h.publishAudit(ctx, audit, updated)
+	return item
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/repository"
+	"goapi/validation"
+)
+
+// parseUserFilters reads the user listing filters and the q search,
+// writing 400 for invalid values
+func parseUserFilters(c *gin.Context) (repository.UserFilter, bool) {
+	var f repository.UserFilter
+	invalid := func(message string) (repository.UserFilter, bool) {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, message))
+		return repository.UserFilter{}, false
+	}
+
+	if value := c.Query("is_active"); value != "" {
+		active, err := strconv.ParseBool(value)
+		if err != nil {
+			return invalid("is_active must be true or false")
+		}
+		f.Active = &active
+	}
+	for _, bound := range []struct {
+		param string
+		age   **int
+	}{
+		{"age_min", &f.MinAge},
+		{"age_max", &f.MaxAge},
+	} {
+		if value := c.Query(bound.param); value != "" {
+			age, err := strconv.Atoi(value)
+			if err != nil || age < 0 {
+				return invalid(bound.param + " must be a non-negative number")
+			}
+			*bound.age = &age
+		}
+	}
+	for param, values := range c.Request.URL.Query() {
+		key, ok := strings.CutPrefix(param, "metadata.")
+		if !ok {
+			continue
+		}
+		if err := validation.CheckMetadataKey(key); err != nil {
+			return invalid("Invalid filter: " + err.Error())
+		}
+		if f.Metadata == nil {
+			f.Metadata = map[string][]string{}
+		}
+		f.Metadata[key] = append(f.Metadata[key], values...)
+	}
+	for _, bound := range []struct {
+		param string
+		time  **time.Time
+	}{
+		{"created_after", &f.CreatedAfter},
+		{"created_before", &f.CreatedBefore},
+	} {
+		if value := c.Query(bound.param); value != "" {
+			t, err := parseTimeFilter(value, false)
+			if err != nil {
+				return invalid("Invalid " + bound.param + ": use RFC 3339 or YYYY-MM-DD")
+			}
+			*bound.time = &t
+		}
+	}
+	for _, value := range c.QueryArray("tag") {
+		tag := validation.NormalizeTag(value)
+		if err := validation.CheckTag(tag); err != nil {
+			return invalid("Invalid tag: " + err.Error())
+		}
+		f.Tags = append(f.Tags, tag)
+	}
+	if value := c.Query("inactive_since"); value != "" {
+		cutoff, err := parseSinceFilter(value)
+		if err != nil {
+			return invalid("Invalid inactive_since: use a number of days like 90d, a duration like 12h, RFC 3339 or YYYY-MM-DD")
+		}
+		f.InactiveSince = &cutoff
+	}
+	q := strings.TrimSpace(c.Query("q"))
+	if len(q) > maxSearchLength {
+		return invalid("q must be at most " + strconv.Itoa(maxSearchLength) + " characters")
+	}
+	f.Search = q
+	return f, true
+}
+
+// parseSinceFilter parses a time in the past given as a number of days ago
+// ("90d"), a duration ago ("12h") or a timestamp as for parseTimeFilter
+func parseSinceFilter(value string) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return time.Now().Add(-d), nil
+	}
+	return parseTimeFilter(value, false)
+}
+
+// parseTimeFilter parses an RFC 3339 timestamp or a date. With endOfDay a date
+// means the start of the following day, so an exclusive upper bound includes
+// the whole day.
+func parseTimeFilter(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return t, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// maxSearchLength bounds the user search term
+const maxSearchLength = 100
+
+// parseUserSort turns a sort parameter such as "-created_at,name" into sort
+// keys, writing 400 for unknown columns. A leading "-" sorts descending.
+// Without sort there are no keys, and the repository's default order applies.
+func parseUserSort(c *gin.Context) ([]repository.SortKey, bool) {
+	value := c.Query("sort")
+	if value == "" {
+		return nil, true
+	}
+
+	var keys []repository.SortKey
+	for _, field := range strings.Split(value, ",") {
+		key := repository.SortKey{Column: strings.TrimSpace(field)}
+		if column, ok := strings.CutPrefix(key.Column, "-"); ok {
+			key = repository.SortKey{Column: column, Descending: true}
+		}
+		if !repository.SortColumns[key.Column] {
+			c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Cannot sort by "+strconv.Quote(key.Column)))
+			return nil, false
+		}
+		keys = append(keys, key)
+	}
+	return keys, true
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/database"
+	"goapi/models"
+	"goapi/utils"
+)
+
+// @Summary Get user versions
+// @Description Retrieves every prior state of a user, newest first
+// @Tags Users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/{id}/versions [get]
+func (h *Handler) GetUserVersionsHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID"))
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT version, operation, changed_at, data
+		FROM users_history
+		WHERE user_id = $1
+		ORDER BY version DESC
+	`, id)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving user versions"))
+		return
+	}
+	defer rows.Close()
+
+	versions := []models.UserVersion{}
+	for rows.Next() {
+		var version models.UserVersion
+		var data []byte
+		if err := rows.Scan(&version.Version, &version.Operation, &version.ChangedAt, &data); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error scanning user version"))
+			return
+		}
+		if err := json.Unmarshal(data, &version.Data); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error decoding user version"))
+			return
+		}
+		versions = append(versions, version)
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    versions,
+	})
+}
+
+// @Summary Restore user version
+// @Description Restores a user's profile fields to a prior version. The state being replaced is itself recorded as a new version.
+// @Tags Users
+// @Produce json
+// @Param id path int true "User ID"
+// @Param version path int true "Version number"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/{id}/versions/{version}/restore [post]
+func (h *Handler) RestoreUserVersionHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID"))
+		return
+	}
+	versionNumber, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Invalid version"))
+		return
+	}
+
+	// Load the snapshot to restore
+	var data []byte
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err = h.db.QueryRowContext(ctx, `
+		SELECT data FROM users_history WHERE user_id = $1 AND version = $2
+	`, id, versionNumber).Scan(&data)
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "Version "+strconv.Itoa(versionNumber)+" of user "+strconv.Itoa(id)+" not found"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving user version"))
+		return
+	}
+
+	var snapshot models.UserSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error decoding user version"))
+		return
+	}
+
+	var current models.User
+	err = scanUser(h.db.QueryRowContext(ctx, `
+		SELECT `+userColumns+` FROM users WHERE id = $1 AND deleted_at IS NULL
+	`, id), &current)
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeUserNotFound, "User with ID "+strconv.Itoa(id)+" not found"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving user"))
+		return
+	}
+
+	h.restoreSnapshot(c, current, snapshot, models.AuditUserRestore)
+}
+
+// restoreSnapshot writes a prior state over the current user and responds
+// with the result. The write is refused with 409 if the user changed after
+// current was read.
+func (h *Handler) restoreSnapshot(c *gin.Context, current models.User, snapshot models.UserSnapshot, action string) {
+	id := current.ID
+
+	// The snapshot's email may have been taken by someone else since
+	var existingID int
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err := h.db.QueryRowContext(ctx, "SELECT id FROM users WHERE email_normalized = $1 AND id <> $2", utils.CanonicalEmail(snapshot.Email), id).Scan(&existingID)
+	if err == nil {
+		c.Error(apperr.New(http.StatusConflict, apperr.CodeEmailTaken, "Email "+snapshot.Email+" is already taken"))
+		return
+	} else if err != sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return
+	}
+	// So may its username
+	if !h.checkUsername(c, snapshot.Username, id) {
+		return
+	}
+
+	var user models.User
+	audit := userAudit{cl: clientOf(c), action: action, before: &current}
+	err = database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		err := scanUser(tx.QueryRowContext(ctx, `
+			UPDATE users
+			SET name = $1, email = $2, email_normalized = $3, username = $4, age = $5, is_active = $6, show_email = $7, show_age = $8, metadata = $9, updated_at = $10
+			WHERE id = $11 AND deleted_at IS NULL AND version = $12
+			RETURNING `+userColumns,
+			snapshot.Name, snapshot.Email, utils.CanonicalEmail(snapshot.Email), snapshot.Username, snapshot.Age, snapshot.IsActive, snapshot.ShowEmail, snapshot.ShowAge, snapshot.Metadata, time.Now(), id, current.Version), &user)
+		if err != nil {
+			return err
+		}
+		return audit.hook(ctx, tx, user)
+	})
+
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusConflict, apperr.CodeVersionConflict, "User was changed by another request; reload it and try again"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error restoring user"))
+		return
+	}
+
+	h.publishAudit(ctx, audit, user)
+	c.Header("ETag", userETag(user))
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    userResponse(c, user),
+	})
+}
+
+// @Summary Revert user's last change
+// @Description Restores a user to the version before their latest change, as recorded in the user's history; the revert is itself recorded, so reverting again redoes the change. If-Match with the ETag the revert is based on is required, so a change made since can't be undone by accident. Needs the users:write and admin scopes and ADMIN_ALLOWED_CIDRS.
+// @Tags Users
+// @Produce json
+// @Param id path int true "User ID"
+// @Param If-Match header string true "ETag of the user as last read"
+// @Success 200 {object} models.APIResponse{data=models.UserResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Failure 428 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/{id}/revert [post]
+func (h *Handler) RevertUserHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID"))
+		return
+	}
+	if c.GetHeader("If-Match") == "" {
+		c.Error(apperr.New(http.StatusPreconditionRequired, apperr.CodePreconditionRequired, "If-Match with the user's ETag is required to revert it"))
+		return
+	}
+
+	var current models.User
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err = scanUser(h.db.QueryRowContext(ctx, `
+		SELECT `+userColumns+` FROM users WHERE id = $1 AND deleted_at IS NULL
+	`, id), &current)
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeUserNotFound, "User with ID "+strconv.Itoa(id)+" not found"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving user"))
+		return
+	}
+	if !ifMatchUser(c, current) {
+		return
+	}
+
+	// Snapshots hold the state a change replaced, including its version
+	var data []byte
+	err = h.db.QueryRowContext(ctx, `
+		SELECT data FROM users_history
+		WHERE user_id = $1 AND operation = 'UPDATE' AND (data->>'version')::int < $2
+		ORDER BY version DESC
+		LIMIT 1
+	`, id, current.Version).Scan(&data)
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "User "+strconv.Itoa(id)+" has no earlier version to revert to"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving user version"))
+		return
+	}
+
+	var snapshot models.UserSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error decoding user version"))
+		return
+	}
+
+	h.restoreSnapshot(c, current, snapshot, models.AuditUserRevert)
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/database"
+	"goapi/hashing"
+	"goapi/models"
+	"goapi/validation"
+)
+
+// passwordMethod names the password in the identity routes, next to the
+// providers and identity IDs
+const passwordMethod = "password"
+
+// errLastLoginMethod is returned when removing the only way a user has left
+// to sign in
+var errLastLoginMethod = errors.New("last login method")
+
+// errHasPassword is returned when setting a password on an account that
+// already has one
+var errHasPassword = errors.New("account already has a password")
+
+// lastLoginMethodError is the error for unlinking the caller's last way to
+// sign in
+func lastLoginMethodError() *apperr.Error {
+	return apperr.New(http.StatusConflict, apperr.CodeLastLoginMethod,
+		"This is your only way to sign in; link another identity, a passkey or a password first")
+}
+
+// @Summary List my sign-in methods
+// @Description Returns the ways the caller can sign in: whether the account has a password, its linked Google, GitHub and SAML identities, and how many passkeys it has.
+// @Tags Users
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=models.LoginMethods}
+// @Failure 401 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/me/identities [get]
+func (h *Handler) ListMyIdentitiesHandler(c *gin.Context) {
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	methods, err := loginMethods(ctx, h.db, c.GetInt("userID"), false)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving sign-in methods"))
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    methods,
+	})
+}
+
+// @Summary Link a sign-in method
+// @Description With google or github, starts linking that provider's account to the caller's, returning the provider URL to open as /auth/oauth/{provider}/link does; an account already linked to another user is refused with 409 by the callback. With password, sets a password, body {"password"}, on an account created by a social or SAML sign-in; an account that has one changes it with PUT /users/me/password instead (409).
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param provider path string true "Sign-in method" Enums(google, github, password)
+// @Param password body models.SetPasswordRequest false "The password, for password"
+// @Success 200 {object} models.APIResponse{data=models.OAuthLinkResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 503 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/me/identities/{provider} [post]
+func (h *Handler) LinkMyIdentityHandler(c *gin.Context) {
+	if c.Param("provider") != passwordMethod {
+		h.OAuthLinkHandler(c)
+		return
+	}
+
+	var req models.SetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return
+	}
+	if err := validation.CheckPassword(req.Password); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return
+	}
+	hashedPassword, err := hashing.Hash(req.Password)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error processing password"))
+		return
+	}
+
+	userID := c.GetInt("userID")
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err = database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		methods, err := loginMethods(ctx, tx, userID, true)
+		if err != nil {
+			return err
+		}
+		if methods.Password {
+			return errHasPassword
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE users SET password = $1, has_password = TRUE, updated_at = $2 WHERE id = $3
+		`, hashedPassword, time.Now(), userID)
+		if err != nil {
+			return err
+		}
+		return clientOf(c).auditTx(ctx, tx, models.AuditIdentityLink, userID, nil, gin.H{"provider": passwordMethod})
+	})
+	if err == errHasPassword {
+		c.Error(apperr.New(http.StatusConflict, apperr.CodeConflict, "The account already has a password; change it with PUT /api/users/me/password"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error setting password"))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Password set",
+	})
+}
+
+// @Summary Unlink a sign-in method
+// @Description Removes one of the caller's linked identities by ID, or with password the account's password, so it can no longer be used to sign in. The account's last way to sign in, counting passkeys, can't be removed (409 last_login_method).
+// @Tags Users
+// @Produce json
+// @Param id path string true "Identity ID, or password"
+// @Success 200 {object} models.APIResponse{data=models.LoginMethods}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/me/identities/{id} [delete]
+func (h *Handler) UnlinkMyIdentityHandler(c *gin.Context) {
+	identityID := 0
+	if c.Param("id") != passwordMethod {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid identity ID"))
+			return
+		}
+		identityID = id
+	}
+
+	// A password is replaced by an unusable random one, as accounts created
+	// by a social sign-in get
+	var passwordHash string
+	if identityID == 0 {
+		var err error
+		if passwordHash, err = randomPasswordHash(); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error processing password"))
+			return
+		}
+	}
+
+	userID := c.GetInt("userID")
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	var methods models.LoginMethods
+	err := database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		var err error
+		methods, err = loginMethods(ctx, tx, userID, true)
+		if err != nil {
+			return err
+		}
+		remaining := len(methods.Identities) + methods.Passkeys
+		if methods.Password {
+			remaining++
+		}
+
+		var provider string
+		if identityID == 0 {
+			if !methods.Password {
+				return sql.ErrNoRows
+			}
+			provider = passwordMethod
+		} else {
+			for _, identity := range methods.Identities {
+				if identity.ID == identityID {
+					provider = identity.Provider
+				}
+			}
+			if provider == "" {
+				return sql.ErrNoRows
+			}
+		}
+		if remaining <= 1 {
+			return errLastLoginMethod
+		}
+
+		if identityID == 0 {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE users SET password = $1, has_password = FALSE, updated_at = $2 WHERE id = $3
+			`, passwordHash, time.Now(), userID)
+		} else {
+			_, err = tx.ExecContext(ctx, `DELETE FROM user_identities WHERE id = $1 AND user_id = $2`, identityID, userID)
+		}
+		if err != nil {
+			return err
+		}
+		before := gin.H{"provider": provider}
+		if identityID != 0 {
+			before["identity_id"] = identityID
+		}
+		if err := clientOf(c).auditTx(ctx, tx, models.AuditIdentityUnlink, userID, before, nil); err != nil {
+			return err
+		}
+		methods, err = loginMethods(ctx, tx, userID, false)
+		return err
+	})
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "Sign-in method not found"))
+		return
+	} else if err == errLastLoginMethod {
+		c.Error(lastLoginMethodError())
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error unlinking sign-in method"))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    methods,
+		Message: "Sign-in method removed",
+	})
+}
+
+// loginMethods returns the ways userID can sign in. With lock, the user's row
+// is locked so that concurrent unlinks can't together remove every method.
+func loginMethods(ctx context.Context, q database.Querier, userID int, lock bool) (models.LoginMethods, error) {
+	methods := models.LoginMethods{Identities: []models.Identity{}}
+	query := `SELECT has_password, (SELECT COUNT(*) FROM webauthn_credentials WHERE user_id = $1) FROM users WHERE id = $1`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+	if err := q.QueryRowContext(ctx, query, userID).Scan(&methods.Password, &methods.Passkeys); err != nil {
+		return methods, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, provider, COALESCE(email, ''), created_at FROM user_identities WHERE user_id = $1 ORDER BY id
+	`, userID)
+	if err != nil {
+		return methods, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var identity models.Identity
+		if err := rows.Scan(&identity.ID, &identity.Provider, &identity.Email, &identity.CreatedAt); err != nil {
+			return methods, err
+		}
+		methods.Identities = append(methods.Identities, identity)
+	}
+	return methods, rows.Err()
+}
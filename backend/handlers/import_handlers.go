@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/database"
+	"goapi/directory"
+	"goapi/hashing"
+	"goapi/models"
+	"goapi/utils"
+)
+
+// googleWorkspace is the directory GoogleWorkspaceImportHandler reads
+var googleWorkspace directory.GoogleConfig
+
+// SetGoogleWorkspaceConfig sets the directory users are imported from
+func SetGoogleWorkspaceConfig(cfg directory.GoogleConfig) {
+	googleWorkspace = cfg
+}
+
+// @Summary Import users from Google Workspace
+// @Description Pulls users from the Google Admin SDK Directory API and upserts them by email. Suspended directory users are imported as inactive. Runs as a dry run unless dry_run=false.
+// @Tags Admin
+// @Produce json
+// @Param dry_run query bool false "Only report what would change (default true)"
+// @Success 200 {object} models.APIResponse{data=models.ImportReport}
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 502 {object} models.Problem
+// @Failure 503 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/imports/google-workspace [post]
+func (h *Handler) GoogleWorkspaceImportHandler(c *gin.Context) {
+	dryRun := c.DefaultQuery("dry_run", "true") != "false"
+
+	cfg := googleWorkspace
+	if !cfg.Configured() {
+		c.Error(apperr.New(http.StatusServiceUnavailable, apperr.CodeNotConfigured, "Google Workspace import is not configured"))
+		return
+	}
+
+	directoryUsers, err := directory.FetchGoogleUsers(c.Request.Context(), cfg)
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadGateway, apperr.CodeUpstream, "Error fetching users from Google Workspace: "+err.Error()))
+		return
+	}
+
+	report := models.ImportReport{DryRun: dryRun, Items: []models.ImportItem{}}
+	for _, u := range directoryUsers {
+		report.Add(h.importDirectoryUser(c, u, dryRun))
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    report,
+	})
+}
+
+// importDirectoryUser creates or updates the local user matching a directory
+// user, or only reports what would happen when dryRun is set. The lookup,
+// the write and its audit entry share a transaction, with the matching user
+// locked until it commits.
+func (h *Handler) importDirectoryUser(c *gin.Context, u directory.User, dryRun bool) models.ImportItem {
+	email := utils.NormalizeEmail(u.Email)
+	item := models.ImportItem{Email: email}
+
+	name := strings.TrimSpace(u.Name)
+	if len(name) < 2 {
+		name = email[:strings.Index(email+"@", "@")]
+	}
+	isActive := !u.Suspended
+
+	// Each user gets the whole timeout, however large the directory is
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	var id int
+	var before, after interface{}
+	err := database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		var currentName string
+		var currentActive, deleted bool
+		err := tx.QueryRowContext(ctx,
+			"SELECT id, name, is_active, deleted_at IS NOT NULL FROM users WHERE email_normalized = $1 FOR UPDATE", utils.CanonicalEmail(email),
+		).Scan(&id, &currentName, &currentActive, &deleted)
+
+		switch {
+		case err == sql.ErrNoRows:
+			item.Action = models.ImportActionCreate
+			if dryRun {
+				return nil
+			}
+			// Imported users get an unusable random password until they reset it
+			passwordHash, err := randomPasswordHash()
+			if err == nil {
+				now := time.Now()
+				err = tx.QueryRowContext(ctx, `
+					INSERT INTO users (name, email, email_normalized, password, is_active, created_at, updated_at)
+					VALUES ($1, $2, $3, $4, $5, $6, $7)
+					RETURNING id
+				`, name, email, utils.CanonicalEmail(email), passwordHash, isActive, now, now).Scan(&id)
+			}
+			if err != nil {
+				item.Error = "Error creating user"
+				return err
+			}
+			after = gin.H{"name": name, "email": email, "is_active": isActive}
+		case err != nil:
+			item.Error = "Database error"
+			return err
+		case deleted:
+			item.Action = models.ImportActionFailed
+			item.Error = "User is deleted; restore it to import"
+			return nil
+		case currentName == name && currentActive == isActive:
+			item.Action = models.ImportActionUnchanged
+			return nil
+		default:
+			item.Action = models.ImportActionUpdate
+			if dryRun {
+				return nil
+			}
+			_, err = tx.ExecContext(ctx, `
+				UPDATE users SET name = $1, is_active = $2, updated_at = $3 WHERE id = $4
+			`, name, isActive, time.Now(), id)
+			if err != nil {
+				item.Error = "Error updating user"
+				return err
+			}
+			before = gin.H{"name": currentName, "is_active": currentActive}
+			after = gin.H{"name": name, "is_active": isActive}
+		}
+		return clientOf(c).auditTx(ctx, tx, models.AuditUserImport, id, before, after)
+	})
+	if err != nil {
+		item.Action = models.ImportActionFailed
+		if item.Error == "" {
+			item.Error = "Error recording import"
+		}
+		return item
+	}
+	if after != nil {
+		h.publishAuditEvent(ctx, models.AuditUserImport, id, before, after)
+	}
+	return item
+}
+
+// randomPasswordHash returns the hash of a random password nobody knows
+func randomPasswordHash() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hashing.Hash(hex.EncodeToString(buf))
+}
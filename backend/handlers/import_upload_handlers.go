@@ -0,0 +1,516 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/database"
+	"goapi/jobs"
+	"goapi/models"
+)
+
+// maxImportChunkSize caps one chunk of an import upload
+const maxImportChunkSize = 16 << 20
+
+var (
+	// maxImportUploadSize caps a file uploaded in chunks
+	maxImportUploadSize int64 = 256 << 20
+	// importUploadTTL is how long an upload is kept after it last changed
+	importUploadTTL = 24 * time.Hour
+)
+
+// SetImportUploadConfig sets the largest file that can be uploaded in chunks
+// and how long uploads are kept after they last changed
+func SetImportUploadConfig(maxSize int64, ttl time.Duration) {
+	maxImportUploadSize = maxSize
+	importUploadTTL = ttl
+}
+
+// importUploadRequest is the payload of a users.import job
+type importUploadRequest struct {
+	UploadID int64 `json:"upload_id"`
+	// UserID and IP are the uploader's, for the audit log
+	UserID int    `json:"user_id"`
+	IP     string `json:"ip"`
+}
+
+// @Summary Start a chunked import upload
+// @Description Starts uploading a CSV or JSON Lines import file (the formats of /users/import) in chunks, for files too large to send in one request. Send the chunks in order with PATCH /users/import/uploads/{id}; after a dropped connection, HEAD or GET the upload for the offset to resume from. Once the last chunk arrives the file is checked against checksum and parsed, and its users are imported by a background job as on_duplicate and dry_run say; GET the upload for the job's status and report. Uploads are removed IMPORT_UPLOAD_TTL after they last changed.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param upload body models.CreateImportUploadRequest true "The file to upload"
+// @Success 201 {object} models.APIResponse{data=models.ImportUpload}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/import/uploads [post]
+func (h *Handler) CreateImportUploadHandler(c *gin.Context) {
+	var req models.CreateImportUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return
+	}
+	if req.Size > maxImportUploadSize {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, fmt.Sprintf("File is larger than %d MB", maxImportUploadSize>>20)))
+		return
+	}
+	format := importFormat(req.Format, req.Filename)
+	if format == "" {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Unknown format; use a .csv or .jsonl file or set format"))
+		return
+	}
+	if req.OnDuplicate == "" {
+		req.OnDuplicate = onDuplicateSkip
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	var id int64
+	now := time.Now()
+	err := h.db.QueryRowContext(ctx, `
+		INSERT INTO import_uploads (user_id, filename, format, size, checksum, on_duplicate, dry_run, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		RETURNING id
+	`, c.GetInt("userID"), req.Filename, format, req.Size, req.Checksum, req.OnDuplicate, req.DryRun, now).Scan(&id)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error starting upload"))
+		return
+	}
+
+	upload, err := h.loadImportUpload(ctx, id, c.GetInt("userID"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving upload"))
+		return
+	}
+	c.Header("Location", "/api/users/import/uploads/"+strconv.FormatInt(id, 10))
+	c.Header("Upload-Offset", "0")
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data:    upload,
+		Message: "Upload started",
+	})
+}
+
+// @Summary Get a chunked import upload
+// @Description Returns one of the caller's uploads: how much of it has arrived, also as the Upload-Offset header (HEAD returns only the headers), and once complete its import's status and, after it succeeded, report.
+// @Tags Users
+// @Produce json
+// @Param id path int true "Upload ID"
+// @Success 200 {object} models.APIResponse{data=models.ImportUpload}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/import/uploads/{id} [get]
+func (h *Handler) GetImportUploadHandler(c *gin.Context) {
+	id, ok := importUploadID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	upload, err := h.loadImportUpload(ctx, id, c.GetInt("userID"))
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "Upload not found"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving upload"))
+		return
+	}
+	if upload.Status == jobs.StatusSucceeded && c.Request.Method != http.MethodHead {
+		var result []byte
+		err := h.db.QueryRowContext(ctx, `SELECT result FROM jobs WHERE id = $1`, *upload.JobID).Scan(&result)
+		if err == nil {
+			err = json.Unmarshal(result, &upload.Report)
+		}
+		if err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving import report"))
+			return
+		}
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    upload,
+	})
+}
+
+// @Summary Upload a chunk of an import file
+// @Description Appends the body, up to 16 MB, to one of the caller's uploads. Upload-Offset must be where the chunk starts, which is how much has arrived so far; any other offset is a 409 carrying the expected one, so a client unsure whether its last chunk arrived can resume from there. The last chunk completes the file: it is checked against the checksum and parsed, and an invalid file is a 422 and can't be resumed. A valid one is queued for import and the upload is returned with 202.
+// @Tags Users
+// @Accept application/offset+octet-stream
+// @Produce json
+// @Param id path int true "Upload ID"
+// @Param Upload-Offset header int true "Offset of the chunk in the file"
+// @Success 200 {object} models.APIResponse{data=models.ImportUpload}
+// @Success 202 {object} models.APIResponse{data=models.ImportUpload}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 422 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/import/uploads/{id} [patch]
+func (h *Handler) UploadImportChunkHandler(c *gin.Context) {
+	id, ok := importUploadID(c)
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Upload-Offset must be the chunk's offset in the file"))
+		return
+	}
+	chunk, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportChunkSize))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Chunk is larger than 16 MB or could not be read"))
+		return
+	}
+	if len(chunk) == 0 {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Chunk is empty"))
+		return
+	}
+
+	// Checking the completed file reads all of it, so it isn't held to the
+	// database timeout
+	ctx := c.Request.Context()
+	userID := c.GetInt("userID")
+	var upload models.ImportUpload
+	var checksum string
+	// invalid is why the completed file can't be imported
+	var invalid error
+	err = database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		queryCtx, cancel := h.withTimeout(ctx)
+		defer cancel()
+		err := tx.QueryRowContext(queryCtx, `
+			SELECT size, received, checksum, format, error FROM import_uploads
+			WHERE id = $1 AND user_id = $2
+			FOR UPDATE
+		`, id, userID).Scan(&upload.Size, &upload.Offset, &checksum, &upload.Format, &upload.Error)
+		if err == sql.ErrNoRows {
+			return apperr.New(http.StatusNotFound, apperr.CodeNotFound, "Upload not found")
+		} else if err != nil {
+			return err
+		}
+		if upload.Error != "" || upload.Offset == upload.Size {
+			return apperr.New(http.StatusConflict, apperr.CodeConflict, "Upload is already complete")
+		}
+		if offset != upload.Offset {
+			return apperr.New(http.StatusConflict, apperr.CodeConflict, "Upload-Offset doesn't match the bytes received").
+				With("offset", upload.Offset)
+		}
+		if offset+int64(len(chunk)) > upload.Size {
+			return apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Chunk goes past the end of the file")
+		}
+
+		if _, err := tx.ExecContext(queryCtx, `
+			INSERT INTO import_upload_chunks (upload_id, position, data) VALUES ($1, $2, $3)
+		`, id, offset, chunk); err != nil {
+			return err
+		}
+		upload.Offset += int64(len(chunk))
+		if _, err := tx.ExecContext(queryCtx, `
+			UPDATE import_uploads SET received = $2, updated_at = $3 WHERE id = $1
+		`, id, upload.Offset, time.Now()); err != nil {
+			return err
+		}
+		if upload.Offset < upload.Size {
+			return nil
+		}
+
+		// The file is complete: only a valid one is imported
+		invalid, err = checkImportUpload(ctx, tx, id, upload, checksum)
+		if err != nil {
+			return err
+		}
+		if invalid != nil {
+			return rejectImportUpload(queryCtx, tx, id, invalid.Error())
+		}
+		jobID, err := jobs.EnqueueTx(queryCtx, tx, JobImportUsers, importUploadRequest{UploadID: id, UserID: userID, IP: c.ClientIP()}, jobs.Options{})
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(queryCtx, `UPDATE import_uploads SET job_id = $2 WHERE id = $1`, id, jobID)
+		return err
+	})
+	var appErr *apperr.Error
+	if errors.As(err, &appErr) {
+		if offset, ok := appErr.Extensions["offset"].(int64); ok {
+			c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+		}
+		c.Error(appErr)
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error storing chunk"))
+		return
+	}
+	if invalid != nil {
+		c.Error(apperr.New(http.StatusUnprocessableEntity, apperr.CodeValidation, "Invalid import file: "+invalid.Error()))
+		return
+	}
+
+	queryCtx, cancel := h.withTimeout(ctx)
+	defer cancel()
+	upload, err = h.loadImportUpload(queryCtx, id, userID)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving upload"))
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.JobID != nil {
+		c.JSON(http.StatusAccepted, models.APIResponse{
+			Success: true,
+			Data:    upload,
+			Message: "Upload complete, import queued",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    upload,
+	})
+}
+
+// @Summary Cancel a chunked import upload
+// @Description Removes one of the caller's uploads and what has arrived of it. An upload whose import is queued or running can't be removed.
+// @Tags Users
+// @Produce json
+// @Param id path int true "Upload ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/import/uploads/{id} [delete]
+func (h *Handler) DeleteImportUploadHandler(c *gin.Context) {
+	id, ok := importUploadID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	upload, err := h.loadImportUpload(ctx, id, c.GetInt("userID"))
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "Upload not found"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error removing upload"))
+		return
+	}
+	result, err := h.db.ExecContext(ctx, `
+		DELETE FROM import_uploads
+		WHERE id = $1 AND (job_id IS NULL OR job_id NOT IN (SELECT id FROM jobs WHERE status IN ('pending', 'running')))
+	`, upload.ID)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error removing upload"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.Error(apperr.New(http.StatusConflict, apperr.CodeConflict, "Upload is being imported"))
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Upload removed",
+	})
+}
+
+// importUploadID parses the upload ID of the path, writing a 400 when it
+// isn't one
+func importUploadID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid upload ID"))
+		return 0, false
+	}
+	return id, true
+}
+
+// loadImportUpload returns the upload with id that userID started, with its
+// import's status
+func (h *Handler) loadImportUpload(ctx context.Context, id int64, userID int) (models.ImportUpload, error) {
+	var upload models.ImportUpload
+	var jobStatus, jobError sql.NullString
+	err := h.db.QueryRowContext(ctx, `
+		SELECT u.id, u.filename, u.format, u.size, u.received, u.on_duplicate, u.dry_run, u.job_id, u.error,
+			u.created_at, u.updated_at, j.status, j.last_error
+		FROM import_uploads u
+		LEFT JOIN jobs j ON j.id = u.job_id
+		WHERE u.id = $1 AND u.user_id = $2
+	`, id, userID).Scan(&upload.ID, &upload.Filename, &upload.Format, &upload.Size, &upload.Offset, &upload.OnDuplicate,
+		&upload.DryRun, &upload.JobID, &upload.Error, &upload.CreatedAt, &upload.UpdatedAt, &jobStatus, &jobError)
+	if err != nil {
+		return upload, err
+	}
+	switch {
+	case upload.Error != "":
+		upload.Status = models.ImportUploadInvalid
+	case upload.JobID == nil:
+		upload.Status = models.ImportUploadReceiving
+	case !jobStatus.Valid:
+		upload.Status = models.ImportUploadExpired
+	default:
+		upload.Status = jobStatus.String
+		if upload.Status == jobs.StatusFailed {
+			upload.Error = jobError.String
+		}
+	}
+	return upload, nil
+}
+
+// checkImportUpload reads the complete upload id through q, checking it
+// against checksum when there is one and parsing it. It returns why the file
+// can't be imported, or err when it couldn't be read.
+func checkImportUpload(ctx context.Context, q database.Querier, id int64, upload models.ImportUpload, checksum string) (invalid, err error) {
+	chunks := &uploadReader{ctx: ctx, q: q, id: id, size: upload.Size}
+	digest := sha256.New()
+	r := io.TeeReader(chunks, digest)
+	_, invalid = readImportFile(r, upload.Format)
+	if invalid == nil {
+		// The parsers may stop short of the end, e.g. at trailing blank
+		// lines, and the checksum covers all of it
+		_, invalid = io.Copy(io.Discard, r)
+	}
+	if chunks.err != nil {
+		return nil, chunks.err
+	}
+	if invalid == nil && checksum != "" && hex.EncodeToString(digest.Sum(nil)) != checksum {
+		invalid = errors.New("checksum doesn't match; start a new upload")
+	}
+	return invalid, nil
+}
+
+// rejectImportUpload records why upload id can't be imported and drops its
+// chunks, so it can't be resumed
+func rejectImportUpload(ctx context.Context, tx *sql.Tx, id int64, reason string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM import_upload_chunks WHERE upload_id = $1`, id); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `UPDATE import_uploads SET error = $2 WHERE id = $1`, id, reason)
+	return err
+}
+
+// uploadReader reads the chunks of an upload of size bytes in order, one
+// query per chunk, so a large file is never held in memory whole. err is
+// set when a chunk couldn't be read, as opposed to the file being invalid.
+type uploadReader struct {
+	ctx      context.Context
+	q        database.Querier
+	id       int64
+	size     int64
+	position int64
+	chunk    []byte
+	err      error
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.position >= r.size {
+			return 0, io.EOF
+		}
+		err := r.q.QueryRowContext(r.ctx, `
+			SELECT data FROM import_upload_chunks WHERE upload_id = $1 AND position = $2
+		`, r.id, r.position).Scan(&r.chunk)
+		if err == sql.ErrNoRows || (err == nil && len(r.chunk) == 0) {
+			err = fmt.Errorf("chunk at byte %d is missing", r.position)
+		}
+		if err != nil {
+			r.err = err
+			return 0, err
+		}
+		r.position += int64(len(r.chunk))
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// importUploadJob imports the users of a complete upload, storing the report
+// as the job's result, and drops the upload's chunks once it is done
+func (h *Handler) importUploadJob(ctx context.Context, job *jobs.Job) error {
+	var req importUploadRequest
+	if err := json.Unmarshal(job.Payload, &req); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	var upload models.ImportUpload
+	err := h.db.QueryRowContext(ctx, `
+		SELECT size, format, on_duplicate, dry_run FROM import_uploads WHERE id = $1
+	`, req.UploadID).Scan(&upload.Size, &upload.Format, &upload.OnDuplicate, &upload.DryRun)
+	if err == sql.ErrNoRows {
+		return jobs.Permanent(fmt.Errorf("upload %d no longer exists", req.UploadID))
+	} else if err != nil {
+		return err
+	}
+
+	// The file was parsed when it was completed, so failing now means its
+	// chunks can't be read
+	records, err := readImportFile(&uploadReader{ctx: ctx, q: h.db, id: req.UploadID, size: upload.Size}, upload.Format)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	cl := client{userID: req.UserID, ip: req.IP}
+	report := models.ImportReport{DryRun: upload.DryRun, Items: []models.ImportItem{}}
+	created := map[string]bool{}
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report.Add(h.importRecordUser(ctx, cl, record, upload.OnDuplicate, upload.DryRun, created))
+	}
+	log.Printf("Imported upload %d in %s: %d created, %d updated, %d failed",
+		req.UploadID, time.Since(start).Round(time.Second), report.Created, report.Updated, report.Failed)
+
+	job.Result, err = json.Marshal(report)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	job.ResultType = "application/json"
+
+	if _, err := h.db.ExecContext(ctx, `DELETE FROM import_upload_chunks WHERE upload_id = $1`, req.UploadID); err != nil {
+		log.Printf("Error removing the chunks of upload %d: %v", req.UploadID, err)
+	}
+	h.db.ExecContext(ctx, `UPDATE import_uploads SET updated_at = $2 WHERE id = $1`, req.UploadID, time.Now())
+	return nil
+}
+
+// expireImportUploadsJob removes the uploads unchanged for importUploadTTL,
+// except those whose import is queued or running
+func (h *Handler) expireImportUploadsJob(ctx context.Context, job *jobs.Job) error {
+	result, err := h.db.ExecContext(ctx, `
+		DELETE FROM import_uploads
+		WHERE updated_at <= $1
+			AND (job_id IS NULL OR job_id NOT IN (SELECT id FROM jobs WHERE status IN ('pending', 'running')))
+	`, time.Now().Add(-importUploadTTL))
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Removed %d expired import uploads", n)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/database"
+	"goapi/models"
+	"goapi/repository"
+)
+
+// @Summary Deactivate inactive users
+// @Description Sets is_active to false for active users who haven't signed in or made an authenticated request in the given number of days, and signs them out; users never seen count from their creation. Returns the affected IDs.
+// @Tags Admin
+// @Produce json
+// @Param days query int true "Days without activity"
+// @Param dry_run query bool false "Only list the users that would be deactivated" default(false)
+// @Success 200 {object} models.APIResponse{data=models.DeactivationReport}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/users/deactivate-inactive [post]
+func (h *Handler) DeactivateInactiveUsersHandler(c *gin.Context) {
+	days, err := strconv.Atoi(c.Query("days"))
+	if err != nil || days < 1 {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "days must be a positive number"))
+		return
+	}
+	dryRun := c.Query("dry_run") == "true"
+
+	report := models.DeactivationReport{DryRun: dryRun, Days: days}
+	cutoff := time.Now().AddDate(0, 0, -days)
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	if dryRun {
+		report.IDs, err = h.inactiveUserIDs(ctx, cutoff)
+	} else {
+		report.IDs, err = h.deactivateInactiveUsers(ctx, clientOf(c), cutoff)
+	}
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error deactivating users"))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    report,
+		Message: strconv.Itoa(len(report.IDs)) + " inactive users",
+	})
+}
+
+// inactiveUserIDs lists the active users whose last activity is before cutoff
+func (h *Handler) inactiveUserIDs(ctx context.Context, cutoff time.Time) ([]int, error) {
+	return queryIDs(ctx, h.db, `
+		SELECT id FROM users
+		WHERE deleted_at IS NULL AND is_active AND `+repository.LastActivity+` < $1
+		ORDER BY id
+	`, cutoff)
+}
+
+// deactivateInactiveUsers deactivates the users inactiveUserIDs would list,
+// revoking their sessions, and returns their IDs. Each deactivation is
+// audited as made by cl in the same transaction.
+func (h *Handler) deactivateInactiveUsers(ctx context.Context, cl client, cutoff time.Time) ([]int, error) {
+	var ids []int
+	err := database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		var err error
+		ids, err = queryIDs(ctx, tx, `
+		WITH deactivated AS (
+			UPDATE users SET is_active = FALSE, updated_at = $2
+			WHERE deleted_at IS NULL AND is_active AND `+repository.LastActivity+` < $1
+			RETURNING id
+		), revoked AS (
+			UPDATE sessions SET revoked_at = $2
+			WHERE user_id IN (SELECT id FROM deactivated) AND revoked_at IS NULL
+		)
+		SELECT id FROM deactivated ORDER BY id
+	`, cutoff, time.Now())
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := cl.auditTx(ctx, tx, models.AuditUserDeactivate, id, deactivatedBefore, deactivatedAfter); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		h.publishAuditEvent(ctx, models.AuditUserDeactivate, id, deactivatedBefore, deactivatedAfter)
+	}
+	return ids, nil
+}
+
+// deactivatedBefore and deactivatedAfter are the audited states of a
+// deactivation
+var (
+	deactivatedBefore = gin.H{"is_active": true}
+	deactivatedAfter  = gin.H{"is_active": false}
+)
+
+// queryIDs runs a query on q returning one ID per row, never returning nil
+func queryIDs(ctx context.Context, q database.Querier, query string, args ...interface{}) ([]int, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,404 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/database"
+	"goapi/mailer"
+	"goapi/models"
+	"goapi/utils"
+)
+
+// invitationTTL is how long an invitation link stays valid
+var invitationTTL = 7 * 24 * time.Hour
+
+// appURL is the base URL of the app that emails link to
+var appURL = "http://localhost:3000"
+
+// invitationURL is where invitation links point; the token is appended
+var invitationURL = appURL + "/invite?token="
+
+// SetInvitationConfig sets how long invitations last and the base URL of the
+// app their links, and those of other emails, open
+func SetInvitationConfig(ttl time.Duration, url string) {
+	invitationTTL = ttl
+	appURL = strings.TrimRight(url, "/")
+	invitationURL = appURL + "/invite?token="
+}
+
+// invitation is a pending invitation looked up by its token
+type invitation struct {
+	ID        int
+	OrgID     int
+	OrgName   string
+	Email     string
+	Role      string
+	ExpiresAt time.Time
+}
+
+// hashInvitationToken returns the form tokens are stored in, so a leaked
+// table can't be used to accept invitations
+func hashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// findInvitation looks up an unexpired invitation by its token, writing 404
+// when there is none
+func (h *Handler) findInvitation(c *gin.Context, token string) (invitation, bool) {
+	var inv invitation
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err := h.db.QueryRowContext(ctx, `
+		SELECT i.id, i.org_id, o.name, i.email, i.role, i.expires_at
+		FROM invitations i JOIN organizations o ON o.id = i.org_id
+		WHERE i.token_hash = $1 AND i.expires_at > $2
+	`, hashInvitationToken(token), time.Now()).Scan(&inv.ID, &inv.OrgID, &inv.OrgName, &inv.Email, &inv.Role, &inv.ExpiresAt)
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "Invitation not found or expired"))
+		return inv, false
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return inv, false
+	}
+	return inv, true
+}
+
+// acceptInvitation adds the user to the invitation's organization and uses
+// the invitation up. A user who is already a member keeps their role.
+func (h *Handler) acceptInvitation(c *gin.Context, inv invitation, userID int) error {
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	return database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO memberships (org_id, user_id, role, created_at) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (org_id, user_id) DO NOTHING
+		`, inv.OrgID, userID, inv.Role, time.Now())
+		if err != nil {
+			return err
+		}
+		joined, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM invitations WHERE id = $1", inv.ID); err != nil || joined == 0 {
+			return err
+		}
+		return clientOf(c).auditTx(ctx, tx, models.AuditOrgMemberAdd, userID, nil, gin.H{"org_id": inv.OrgID, "role": inv.Role, "invitation_id": inv.ID})
+	})
+}
+
+// @Summary Invite to organization
+// @Description Emails an invitation to join the organization. The link lets the recipient sign up, or accept with an existing account registered to the same address. The email is sent by a background job, retried while the mail server fails. Inviting an address again replaces its pending invitation. Needs the admin or owner role; only owners can invite admins and owners.
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Param invitation body models.InvitationRequest true "Invitation"
+// @Success 201 {object} models.APIResponse{data=models.InvitationResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Security BearerAuth
+// @Router /orgs/{id}/invitations [post]
+func (h *Handler) CreateInvitationHandler(c *gin.Context) {
+	orgID, role, ok := h.orgAccess(c, models.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	var req models.InvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return
+	}
+	if !canGrantOrgRole(c, role, req.Role) {
+		return
+	}
+	req.Email = utils.NormalizeEmail(req.Email)
+	canonical := utils.CanonicalEmail(req.Email)
+
+	var member bool
+	var orgName, inviterName string
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err := h.db.QueryRowContext(ctx, `
+		SELECT o.name, u.name, EXISTS (
+			SELECT 1 FROM memberships m JOIN users mu ON mu.id = m.user_id
+			WHERE m.org_id = o.id AND mu.email_normalized = $3
+		)
+		FROM organizations o, users u
+		WHERE o.id = $1 AND u.id = $2
+	`, orgID, c.GetInt("userID"), canonical).Scan(&orgName, &inviterName, &member)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return
+	}
+	if member {
+		c.Error(apperr.New(http.StatusConflict, apperr.CodeConflict, req.Email+" is already a member of this organization"))
+		return
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error creating invitation"))
+		return
+	}
+	token := hex.EncodeToString(buf)
+
+	inv, err := h.createInvitation(ctx, orgID, req, canonical, hashInvitationToken(token), c.GetInt("userID"), func(tx *sql.Tx, expiresAt time.Time) error {
+		msg, err := mailer.Render(mailer.TemplateInvitation, req.Email, gin.H{
+			"InviterName": inviterName,
+			"OrgName":     orgName,
+			"Role":        req.Role,
+			"URL":         invitationURL + token,
+			"ExpiresAt":   expiresAt,
+		})
+		if err != nil {
+			return err
+		}
+		return mailer.EnqueueTx(ctx, tx, msg)
+	})
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error sending invitation"))
+		return
+	}
+
+	h.recordAudit(c, models.AuditOrgInvite, 0, nil, gin.H{"org_id": orgID, "email": inv.Email, "role": inv.Role})
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data:    inv,
+		Message: "Invitation sent to " + req.Email,
+	})
+}
+
+// createInvitation stores an invitation, replacing any pending one for the
+// same address, together with whatever send queues in the same transaction
+// to email it
+func (h *Handler) createInvitation(ctx context.Context, orgID int, req models.InvitationRequest, canonical, tokenHash string, invitedBy int, send func(tx *sql.Tx, expiresAt time.Time) error) (models.InvitationResponse, error) {
+	inv := models.InvitationResponse{OrgID: orgID, Email: req.Email, Role: req.Role, InvitedBy: &invitedBy}
+
+	err := database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM invitations WHERE org_id = $1 AND email_normalized = $2", orgID, canonical)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO invitations (org_id, email, email_normalized, role, token_hash, invited_by, created_at, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING id, created_at, expires_at
+		`, orgID, req.Email, canonical, req.Role, tokenHash, invitedBy, now, now.Add(invitationTTL)).Scan(&inv.ID, &inv.CreatedAt, &inv.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		return send(tx, inv.ExpiresAt)
+	})
+	return inv, err
+}
+
+// @Summary List pending invitations
+// @Description Lists the organization's invitations that haven't been accepted, revoked or expired. Needs the admin or owner role.
+// @Tags Organizations
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Success 200 {object} models.APIResponse{data=[]models.InvitationResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /orgs/{id}/invitations [get]
+func (h *Handler) ListInvitationsHandler(c *gin.Context) {
+	orgID, _, ok := h.orgAccess(c, models.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT id, org_id, email, role, invited_by, created_at, expires_at
+		FROM invitations
+		WHERE org_id = $1 AND expires_at > $2
+		ORDER BY created_at DESC, id DESC
+	`, orgID, time.Now())
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving invitations"))
+		return
+	}
+	defer rows.Close()
+
+	invitations := []models.InvitationResponse{}
+	for rows.Next() {
+		var inv models.InvitationResponse
+		if err := rows.Scan(&inv.ID, &inv.OrgID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.CreatedAt, &inv.ExpiresAt); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving invitations"))
+			return
+		}
+		invitations = append(invitations, inv)
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    invitations,
+	})
+}
+
+// @Summary Revoke invitation
+// @Description Revokes a pending invitation so its link stops working. Needs the admin or owner role; only owners can revoke invitations for admins and owners.
+// @Tags Organizations
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Param invitationId path int true "Invitation ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /orgs/{id}/invitations/{invitationId} [delete]
+func (h *Handler) RevokeInvitationHandler(c *gin.Context) {
+	orgID, role, ok := h.orgAccess(c, models.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	invitationID, err := strconv.Atoi(c.Param("invitationId"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid invitation ID"))
+		return
+	}
+
+	var email, invitedRole string
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err = h.db.QueryRowContext(ctx, `
+		SELECT email, role FROM invitations WHERE id = $1 AND org_id = $2
+	`, invitationID, orgID).Scan(&email, &invitedRole)
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "Invitation with ID "+strconv.Itoa(invitationID)+" not found"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return
+	}
+	if !canGrantOrgRole(c, role, invitedRole) {
+		return
+	}
+
+	if _, err := h.db.ExecContext(ctx, "DELETE FROM invitations WHERE id = $1", invitationID); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error revoking invitation"))
+		return
+	}
+
+	h.recordAudit(c, models.AuditOrgInviteRevoke, 0, gin.H{"org_id": orgID, "email": email, "role": invitedRole}, nil)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Invitation revoked successfully",
+	})
+}
+
+// @Summary Get invitation
+// @Description Shows what an invitation link is for, so the app can offer to sign up or to sign in and accept. existing_account tells whether the invited address already has an account.
+// @Tags Invitations
+// @Produce json
+// @Param token path string true "Invitation token"
+// @Success 200 {object} models.APIResponse{data=models.InvitationPreview}
+// @Failure 404 {object} models.Problem
+// @Router /invitations/{token} [get]
+func (h *Handler) GetInvitationHandler(c *gin.Context) {
+	inv, ok := h.findInvitation(c, c.Param("token"))
+	if !ok {
+		return
+	}
+
+	var exists bool
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err := h.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM users WHERE email_normalized = $1 AND deleted_at IS NULL)
+	`, utils.CanonicalEmail(inv.Email)).Scan(&exists)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: models.InvitationPreview{
+			OrgID:           inv.OrgID,
+			OrgName:         inv.OrgName,
+			Email:           inv.Email,
+			Role:            inv.Role,
+			ExpiresAt:       inv.ExpiresAt,
+			ExistingAccount: exists,
+		},
+	})
+}
+
+// @Summary Accept invitation
+// @Description Joins the caller to the organization of an invitation sent to their email address. New users accept by signing up with invitation_token instead.
+// @Tags Invitations
+// @Produce json
+// @Param token path string true "Invitation token"
+// @Success 200 {object} models.APIResponse{data=models.OrgResponse}
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /invitations/{token}/accept [post]
+func (h *Handler) AcceptInvitationHandler(c *gin.Context) {
+	inv, ok := h.findInvitation(c, c.Param("token"))
+	if !ok {
+		return
+	}
+
+	var email string
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err := h.db.QueryRowContext(ctx, "SELECT email FROM users WHERE id = $1", c.GetInt("userID")).Scan(&email)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return
+	}
+	if !invitedEmail(c, inv, email) {
+		return
+	}
+
+	if err := h.acceptInvitation(c, inv, c.GetInt("userID")); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error accepting invitation"))
+		return
+	}
+	h.respondWithOrg(c, inv.OrgID, "Invitation accepted")
+}
+
+// invitedEmail checks that email is the address the invitation was sent to,
+// so a forwarded link can't be used by someone else; it writes 403 when not
+func invitedEmail(c *gin.Context, inv invitation, email string) bool {
+	if utils.CanonicalEmail(email) != utils.CanonicalEmail(inv.Email) {
+		c.Error(apperr.New(http.StatusForbidden, apperr.CodeForbidden, "This invitation was sent to a different email address"))
+		return false
+	}
+	return true
+}
+
+// joinInvitedOrg accepts the invitation for a user who just signed up with
+// it. Signup has already succeeded, so a failure is only logged; the
+// invitation stays pending and can still be accepted.
+func (h *Handler) joinInvitedOrg(c *gin.Context, inv invitation, userID int) {
+	if err := h.acceptInvitation(c, inv, userID); err != nil {
+		log.Println("Error accepting invitation at signup:", err)
+	}
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/jobs"
+	"goapi/models"
+)
+
+const (
+	defaultJobsLimit = 50
+	maxJobsLimit     = 500
+)
+
+// jobColumns are the columns scanJob reads
+const jobColumns = `id, type, payload, status, attempts, max_attempts,
+	CASE WHEN status = 'pending' THEN run_at END, last_error, result IS NOT NULL,
+	created_at, started_at, finished_at`
+
+// resultExtensions names downloaded job results by their content type
+var resultExtensions = map[string]string{
+	"text/csv":             ".csv",
+	"application/x-ndjson": ".jsonl",
+	"application/json":     ".json",
+}
+
+// scanJob reads a row of jobColumns
+func scanJob(row rowScanner, job *models.Job) error {
+	var payload []byte
+	err := row.Scan(&job.ID, &job.Type, &payload, &job.Status, &job.Attempts, &job.MaxAttempts,
+		&job.RunAt, &job.LastError, &job.HasResult, &job.CreatedAt, &job.StartedAt, &job.FinishedAt)
+	job.Payload = payload
+	return err
+}
+
+// loadJob loads a job by ID
+func (h *Handler) loadJob(ctx context.Context, id int64) (models.Job, error) {
+	var job models.Job
+	err := scanJob(h.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id), &job)
+	return job, err
+}
+
+// getJob loads the job in the id parameter, writing 400 or 404 when there
+// is none
+func (h *Handler) getJob(c *gin.Context) (models.Job, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid job ID"))
+		return models.Job{}, false
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	job, err := h.loadJob(ctx, id)
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "Job with ID "+strconv.FormatInt(id, 10)+" not found"))
+		return job, false
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving job"))
+		return job, false
+	}
+	return job, true
+}
+
+// @Summary List background jobs
+// @Description Lists background jobs, newest first: emails, user exports and scheduled maintenance such as account purges, with their status, attempts and last error. Failed attempts are retried with exponential backoff until the job type's attempts run out; finished jobs are kept for JOB_RETENTION.
+// @Tags Admin
+// @Produce json
+// @Param status query string false "Only jobs with this status" Enums(pending, running, succeeded, failed)
+// @Param type query string false "Only jobs of this type, e.g. users.export"
+// @Param limit query int false "Number of jobs to return (max 500)" default(50)
+// @Success 200 {object} models.APIResponse{data=[]models.Job}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/jobs [get]
+func (h *Handler) ListJobsHandler(c *gin.Context) {
+	status := c.Query("status")
+	if status != "" && status != jobs.StatusPending && status != jobs.StatusRunning && status != jobs.StatusSucceeded && status != jobs.StatusFailed {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "status must be pending, running, succeeded or failed"))
+		return
+	}
+	limit := defaultJobsLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxJobsLimit {
+			c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxJobsLimit)))
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT `+jobColumns+` FROM jobs
+		WHERE ($1::text = '' OR status = $1) AND ($2::text = '' OR type = $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`, status, c.Query("type"), limit)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving jobs"))
+		return
+	}
+	defer rows.Close()
+
+	list := []models.Job{}
+	for rows.Next() {
+		var job models.Job
+		if err := scanJob(rows, &job); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving jobs"))
+			return
+		}
+		list = append(list, job)
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    list,
+	})
+}
+
+// @Summary Get background job
+// @Description Returns a background job's status, attempts, last error and whether it has a result to download
+// @Tags Admin
+// @Produce json
+// @Param id path int true "Job ID"
+// @Success 200 {object} models.APIResponse{data=models.Job}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/jobs/{id} [get]
+func (h *Handler) GetJobHandler(c *gin.Context) {
+	job, ok := h.getJob(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    job,
+	})
+}
+
+// @Summary Download background job result
+// @Description Downloads the file a succeeded job produced, such as a user export
+// @Tags Admin
+// @Produce octet-stream
+// @Param id path int true "Job ID"
+// @Success 200 {file} file
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/jobs/{id}/result [get]
+func (h *Handler) GetJobResultHandler(c *gin.Context) {
+	job, ok := h.getJob(c)
+	if !ok {
+		return
+	}
+	if !job.HasResult {
+		c.Error(apperr.New(http.StatusConflict, apperr.CodeConflict, "Job "+strconv.FormatInt(job.ID, 10)+" is "+job.Status+" and has no result"))
+		return
+	}
+
+	var result []byte
+	var resultType sql.NullString
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err := h.db.QueryRowContext(ctx, `SELECT result, result_type FROM jobs WHERE id = $1`, job.ID).Scan(&result, &resultType)
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "Job with ID "+strconv.FormatInt(job.ID, 10)+" not found"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving job result"))
+		return
+	}
+
+	contentType := "application/octet-stream"
+	if resultType.Valid {
+		contentType = resultType.String
+	}
+	filename := "job-" + strconv.FormatInt(job.ID, 10) + resultExtensions[contentType]
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, contentType, result)
+}
+
+// @Summary Retry background job
+// @Description Queues a failed job to run again, with a fresh set of attempts
+// @Tags Admin
+// @Produce json
+// @Param id path int true "Job ID"
+// @Success 200 {object} models.APIResponse{data=models.Job}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/jobs/{id}/retry [post]
+func (h *Handler) RetryJobHandler(c *gin.Context) {
+	job, ok := h.getJob(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	retried, err := jobs.Retry(ctx, h.db, job.ID)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrying job"))
+		return
+	}
+	if !retried {
+		c.Error(apperr.New(http.StatusConflict, apperr.CodeConflict, "Only failed jobs can be retried; job "+strconv.FormatInt(job.ID, 10)+" is "+job.Status))
+		return
+	}
+
+	if job, err = h.loadJob(ctx, job.ID); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving job"))
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    job,
+		Message: "Job queued to run again",
+	})
+}
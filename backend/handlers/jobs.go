@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"time"
+
+	"goapi/jobs"
+)
+
+// Background job types
+const (
+	JobExportUsers       = "users.export"
+	JobPurgeAccounts     = "users.purge"
+	JobRenormalizeEmails = "users.renormalize_emails"
+	JobImportUsers       = "users.import"
+	JobSendCampaign      = "campaigns.send"
+
+	// Scheduled maintenance
+	JobExpireInvitations  = "invitations.expire"
+	JobPurgeSessions      = "sessions.purge"
+	JobPurgeAuditLogs     = "audit.purge"
+	JobDeactivateInactive = "users.deactivate_inactive"
+	JobRefreshStats       = "stats.refresh"
+	JobExpireUploads      = "imports.expire_uploads"
+)
+
+// RegisterJobs registers the background jobs the handlers queue, with how
+// often each is retried. Emails are the mailer's jobs.
+func (h *Handler) RegisterJobs() {
+	jobs.Register(JobExportUsers, jobs.Policy{MaxAttempts: 3, Timeout: 10 * time.Minute}, h.exportUsersJob)
+	jobs.Register(JobPurgeAccounts, jobs.Policy{MaxAttempts: 3}, h.purgeAccountsJob)
+	jobs.Register(JobRenormalizeEmails, jobs.Policy{MaxAttempts: 5, Timeout: time.Hour}, h.renormalizeEmailsJob)
+	jobs.Register(JobImportUsers, jobs.Policy{MaxAttempts: 3, Timeout: 6 * time.Hour}, h.importUploadJob)
+	jobs.Register(JobSendCampaign, jobs.Policy{MaxAttempts: 5, Backoff: time.Minute, Timeout: 6 * time.Hour}, h.sendCampaignJob)
+	jobs.Register(JobExpireInvitations, jobs.Policy{MaxAttempts: 3}, h.expireInvitationsJob)
+	jobs.Register(JobPurgeSessions, jobs.Policy{MaxAttempts: 3}, h.purgeSessionsJob)
+	jobs.Register(JobPurgeAuditLogs, jobs.Policy{MaxAttempts: 3}, h.purgeAuditLogsJob)
+	jobs.Register(JobDeactivateInactive, jobs.Policy{MaxAttempts: 3}, h.deactivateInactiveJob)
+	jobs.Register(JobRefreshStats, jobs.Policy{MaxAttempts: 3, Timeout: 30 * time.Minute}, h.refreshStatsJob)
+	jobs.Register(JobExpireUploads, jobs.Policy{MaxAttempts: 3}, h.expireImportUploadsJob)
+}
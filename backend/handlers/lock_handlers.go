@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/locks"
+	"goapi/models"
+)
+
+// @Summary List background locks
+// @Description Lists the locks that keep singleton background work on one replica at a time, scheduler (queueing maintenance jobs) and events.relay (publishing the event outbox), with the replica that last took each and whether it still holds it. A lock nobody holds is taken over by another replica within a few seconds.
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=[]models.Lock}
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/locks [get]
+func (h *Handler) ListLocksHandler(c *gin.Context) {
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	holders, err := locks.Holders(ctx, h.db)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving locks"))
+		return
+	}
+
+	list := make([]models.Lock, len(holders))
+	for i, holder := range holders {
+		list[i] = models.Lock{Name: holder.Name, Holder: holder.Holder, AcquiredAt: holder.AcquiredAt, Held: holder.Held}
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    list,
+	})
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/lockout"
+	"goapi/models"
+)
+
+// accountLockedFor returns how much longer the account is locked out, or zero
+func (h *Handler) accountLockedFor(ctx context.Context, userID int) (time.Duration, error) {
+	var lockedUntil sql.NullTime
+	err := h.db.QueryRowContext(ctx,
+		"SELECT locked_until FROM account_lockouts WHERE user_id = $1", userID,
+	).Scan(&lockedUntil)
+	if err == sql.ErrNoRows || (err == nil && !lockedUntil.Valid) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if remaining := time.Until(lockedUntil.Time); remaining > 0 {
+		return remaining, nil
+	}
+	return 0, nil
+}
+
+// recordAccountFailure counts a failed login for the account and returns how
+// long it is now locked out, or zero when it is still under the limit
+func (h *Handler) recordAccountFailure(ctx context.Context, userID int) (time.Duration, error) {
+	cfg := lockout.Current()
+	now := time.Now()
+
+	// Start a new window when the previous one has expired
+	var count int
+	err := h.db.QueryRowContext(ctx, `
+		INSERT INTO account_lockouts (user_id, failed_count, window_started_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET
+			failed_count = CASE WHEN account_lockouts.window_started_at < $3 THEN 1 ELSE account_lockouts.failed_count + 1 END,
+			window_started_at = CASE WHEN account_lockouts.window_started_at < $3 THEN $2 ELSE account_lockouts.window_started_at END
+		RETURNING failed_count
+	`, userID, now, now.Add(-cfg.Window)).Scan(&count)
+	if err != nil || count < cfg.MaxFailures {
+		return 0, err
+	}
+
+	_, err = h.db.ExecContext(ctx, `
+		UPDATE account_lockouts SET locked_until = $1, failed_count = 0, window_started_at = $2
+		WHERE user_id = $3
+	`, now.Add(cfg.Duration), now, userID)
+	return cfg.Duration, err
+}
+
+// clearAccountFailures forgets failed logins after a successful one
+func (h *Handler) clearAccountFailures(ctx context.Context, userID int) {
+	h.db.ExecContext(ctx, "DELETE FROM account_lockouts WHERE user_id = $1", userID)
+}
+
+// lockedError is the 423 for a lockout ending after retryAfter, which it
+// carries as retry_after in whole seconds
+func lockedError(retryAfter time.Duration) *apperr.Error {
+	return apperr.New(http.StatusLocked, apperr.CodeAccountLocked, "Too many failed login attempts, try again later").
+		With("retry_after", int(math.Ceil(retryAfter.Seconds())))
+}
+
+// disabledError is the 403 for signing in to a deactivated account
+func disabledError() *apperr.Error {
+	return apperr.New(http.StatusForbidden, apperr.CodeAccountDisabled, "This account has been deactivated")
+}
+
+// respondWithLoginError records err for the response, adding a Retry-After
+// header in whole seconds to lockouts
+func respondWithLoginError(c *gin.Context, err *apperr.Error) {
+	if seconds, ok := err.Extensions["retry_after"].(int); ok {
+		c.Header("Retry-After", strconv.Itoa(seconds))
+	}
+	c.Error(err)
+}
+
+// @Summary Unlock a user account
+// @Description Clears failed login attempts and any lockout for the user
+// @Tags Admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/users/{id}/unlock [post]
+func (h *Handler) UnlockUserHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID"))
+		return
+	}
+
+	var exists bool
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	if err := h.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL)", id).Scan(&exists); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return
+	}
+	if !exists {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeUserNotFound, "User not found"))
+		return
+	}
+
+	if _, err := h.db.ExecContext(ctx, "DELETE FROM account_lockouts WHERE user_id = $1", id); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error unlocking user"))
+		return
+	}
+	h.recordAudit(c, models.AuditUserUnlock, id, nil, nil)
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "User unlocked",
+	})
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/models"
+	"goapi/utils"
+	"goapi/webhooks"
+)
+
+const (
+	defaultLoginEventsLimit = 20
+	maxLoginEventsLimit     = 100
+)
+
+// recordLoginEvent stores a sign-in attempt. userID is zero when no account
+// matched, and failure is empty for successful attempts. The country is known
+// when geo-blocking resolved it for the request. Successful attempts also
+// set the user's last login and are published to webhooks. Errors are
+// ignored so the audit trail never blocks a sign-in.
+func (h *Handler) recordLoginEvent(c *gin.Context, userID int, email, method, failure string) {
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	h.loginEvent(ctx, clientOf(c), userID, email, method, failure)
+}
+
+// loginEvent is recordLoginEvent for an attempt made by cl under ctx
+func (h *Handler) loginEvent(ctx context.Context, cl client, userID int, email, method, failure string) {
+	now := time.Now()
+	h.db.ExecContext(ctx, `
+		INSERT INTO login_events (user_id, email, success, method, failure_reason, ip, user_agent, device, country, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, sql.NullInt64{Int64: int64(userID), Valid: userID != 0}, utils.NormalizeEmail(email), failure == "", method,
+		failure, cl.ip, cl.userAgent, describeDevice(cl.userAgent), cl.country, now)
+	if failure == "" && userID != 0 {
+		h.db.ExecContext(ctx, "UPDATE users SET last_login_at = $1, last_seen_at = $1, last_login_ip = NULLIF($3, '') WHERE id = $2", now, userID, cl.ip)
+		webhooks.Publish(ctx, h.db, webhooks.EventUserLogin, strconv.Itoa(userID), gin.H{
+			"user_id": userID,
+			"method":  method,
+			"ip":      cl.ip,
+			"country": cl.country,
+		})
+	}
+}
+
+// @Summary List my recent logins
+// @Description Lists sign-in attempts on the caller's account, newest first, including failed ones.
+// @Tags Sessions
+// @Produce json
+// @Param limit query int false "Number of attempts to return (max 100)" default(20)
+// @Success 200 {object} models.APIResponse{data=[]models.LoginEventResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/me/logins [get]
+func (h *Handler) ListMyLoginsHandler(c *gin.Context) {
+	limit := defaultLoginEventsLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxLoginEventsLimit {
+			c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxLoginEventsLimit)))
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT id, success, method, failure_reason, ip, user_agent, device, country, created_at
+		FROM login_events
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`, c.GetInt("userID"), limit)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return
+	}
+	defer rows.Close()
+
+	events := []models.LoginEventResponse{}
+	for rows.Next() {
+		var e models.LoginEventResponse
+		if err := rows.Scan(&e.ID, &e.Success, &e.Method, &e.FailureReason, &e.IP, &e.UserAgent, &e.Device, &e.Country, &e.CreatedAt); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+			return
+		}
+		events = append(events, e)
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    events,
+	})
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"goapi/jobs"
+)
+
+var (
+	// sessionRetention is how long ended sessions are kept, 0 for ever
+	sessionRetention = 30 * 24 * time.Hour
+	// auditRetention is how long audit entries are kept, 0 for ever
+	auditRetention time.Duration
+	// inactiveDays is how long users may go unseen before they are
+	// deactivated, 0 to keep them active
+	inactiveDays int
+)
+
+// SetMaintenanceConfig sets what the scheduled maintenance jobs keep
+func SetMaintenanceConfig(sessions, auditLogs time.Duration, deactivateInactiveDays int) {
+	sessionRetention = sessions
+	auditRetention = auditLogs
+	inactiveDays = deactivateInactiveDays
+}
+
+// expireInvitationsJob removes the invitations whose link has expired
+func (h *Handler) expireInvitationsJob(ctx context.Context, job *jobs.Job) error {
+	result, err := h.db.ExecContext(ctx, `DELETE FROM invitations WHERE expires_at <= $1`, time.Now())
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Removed %d expired invitations", n)
+	}
+	return nil
+}
+
+// purgeSessionsJob removes the sessions that expired or were revoked more
+// than sessionRetention ago
+func (h *Handler) purgeSessionsJob(ctx context.Context, job *jobs.Job) error {
+	if sessionRetention <= 0 {
+		return nil
+	}
+	result, err := h.db.ExecContext(ctx, `
+		DELETE FROM sessions WHERE LEAST(revoked_at, expires_at) < $1
+	`, time.Now().Add(-sessionRetention))
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Removed %d ended sessions", n)
+	}
+	return nil
+}
+
+// purgeAuditLogsJob removes the audit entries older than auditRetention
+func (h *Handler) purgeAuditLogsJob(ctx context.Context, job *jobs.Job) error {
+	if auditRetention <= 0 {
+		return nil
+	}
+	result, err := h.db.ExecContext(ctx, `
+		DELETE FROM audit_logs WHERE created_at < $1
+	`, time.Now().Add(-auditRetention))
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Removed %d old audit entries", n)
+	}
+	return nil
+}
+
+// deactivateInactiveJob deactivates the users not seen in inactiveDays
+func (h *Handler) deactivateInactiveJob(ctx context.Context, job *jobs.Job) error {
+	if inactiveDays <= 0 {
+		return nil
+	}
+	ids, err := h.deactivateInactiveUsers(ctx, client{}, time.Now().AddDate(0, 0, -inactiveDays))
+	if err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		log.Printf("Deactivated %d inactive users", len(ids))
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/metrics"
+	"goapi/models"
+	"goapi/services"
+)
+
+// @Summary Get my profile
+// @Description Retrieves the caller's own user record, identified by the access token
+// @Tags Users
+// @Produce json
+// @Param fields query string false "Comma-separated fields to return, e.g. id,name,email; all by default"
+// @Param If-None-Match header string false "ETag from an earlier read; 304 if the user is unchanged"
+// @Param If-Modified-Since header string false "Last-Modified from an earlier read; 304 if the user is unchanged"
+// @Success 200 {object} models.APIResponse{data=models.UserResponse}
+// @Success 304 "The user is unchanged"
+// @Header 200 {string} ETag "User version and latest activity, for If-Match and If-None-Match"
+// @Header 200 {string} Last-Modified "When the user last changed, signed in or was seen"
+// @Failure 401 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/me [get]
+func (h *Handler) GetMe(c *gin.Context) {
+	h.getUser(c, c.GetInt("userID"))
+}
+
+// @Summary Replace my profile
+// @Description Replaces the caller's own name, email, age, profile visibility and metadata; omitted optional fields are reset to their defaults. is_active can't be changed on one's own account.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param If-Match header string false "ETag from an earlier read"
+// @Param user body models.ReplaceUserRequest true "User data"
+// @Success 200 {object} models.APIResponse{data=models.UserResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/me [put]
+func (h *Handler) ReplaceMe(c *gin.Context) {
+	var req models.ReplaceUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return
+	}
+	if req.IsActive != nil {
+		respondOwnIsActive(c)
+		return
+	}
+
+	h.updateUser(c, c.GetInt("userID"), func(user *models.User) error {
+		replaceUserFields(user, req)
+		return nil
+	})
+}
+
+// @Summary Update my profile
+// @Description Applies a JSON Merge Patch (RFC 7396) to the caller's own account, as for PATCH /users/{id}. is_active can't be changed on one's own account.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param If-Match header string false "ETag from an earlier read"
+// @Param user body models.UpdateUserRequest true "Merge patch"
+// @Success 200 {object} models.APIResponse{data=models.UserResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/me [patch]
+func (h *Handler) UpdateMe(c *gin.Context) {
+	patch, ok := bindUserMergePatch(c)
+	if !ok {
+		return
+	}
+	if patch.has("is_active") {
+		respondOwnIsActive(c)
+		return
+	}
+
+	h.updateUser(c, c.GetInt("userID"), patch.apply)
+}
+
+// respondOwnIsActive rejects a change to the caller's own is_active
+func respondOwnIsActive(c *gin.Context) {
+	c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "is_active can't be changed on your own account"))
+}
+
+// @Summary Delete my account
+// @Description Schedules the caller's own account for deletion and signs out all of its sessions. The account is hidden right away and purged for good once the grace period (ACCOUNT_DELETION_GRACE_PERIOD, 30 days by default) is over; until then, logging in with "restore": true brings it back.
+// @Tags Users
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=models.AccountDeletionResponse}
+// @Failure 401 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/me [delete]
+func (h *Handler) DeleteMe(c *gin.Context) {
+	id := c.GetInt("userID")
+
+	purgeAt := time.Now().Add(deletionGracePeriod)
+	audit := userAudit{cl: clientOf(c), action: models.AuditUserDelete}
+	user, err := h.users.ScheduleDeletion(c.Request.Context(), id, purgeAt, audit.hook)
+	if errors.Is(err, services.ErrNotFound) {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeUserNotFound, "User with ID "+strconv.Itoa(id)+" not found"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error deleting user"))
+		return
+	}
+
+	h.publishAudit(c.Request.Context(), audit, user)
+	metrics.Deletions.Inc()
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    models.AccountDeletionResponse{PurgeAt: purgeAt},
+		Message: "Account scheduled for deletion",
+	})
+}
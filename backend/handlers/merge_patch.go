@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"goapi/apperr"
+	"goapi/models"
+)
+
+// userMergePatch is a JSON Merge Patch (RFC 7396) of a user
+type userMergePatch struct {
+	req models.UpdateUserRequest
+	// members holds every member of the patch, so a null can be told apart
+	// from an omitted field
+	members map[string]json.RawMessage
+}
+
+// bindUserMergePatch reads the request body as a merge patch of a user,
+// writing 400 if it isn't a JSON object or has invalid values. Unknown
+// members are ignored.
+func bindUserMergePatch(c *gin.Context) (*userMergePatch, bool) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return nil, false
+	}
+	patch, appErr := parseUserMergePatch(body)
+	if appErr != nil {
+		c.Error(appErr)
+		return nil, false
+	}
+	return patch, true
+}
+
+// parseUserMergePatch reads body as a merge patch of a user, returning a 400
+// if it isn't a JSON object or has invalid values
+func parseUserMergePatch(body []byte) (*userMergePatch, *apperr.Error) {
+	invalid := func(message string) (*userMergePatch, *apperr.Error) {
+		return nil, apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+message)
+	}
+
+	patch := &userMergePatch{}
+	if json.Unmarshal(body, &patch.members) != nil || patch.members == nil {
+		return invalid("the patch must be a JSON object")
+	}
+	if err := json.Unmarshal(body, &patch.req); err != nil {
+		return invalid(err.Error())
+	}
+	if err := binding.Validator.ValidateStruct(&patch.req); err != nil {
+		return invalid(err.Error())
+	}
+	return patch, nil
+}
+
+// has reports whether the patch sets member, possibly to null
+func (p *userMergePatch) has(member string) bool {
+	_, ok := p.members[member]
+	return ok
+}
+
+// apply changes user as the patch says. Only username, age and metadata can
+// be null.
+func (p *userMergePatch) apply(user *models.User) error {
+	for _, member := range []string{"name", "email", "is_active", "show_email", "show_age"} {
+		if string(p.members[member]) == "null" {
+			return errors.New(member + " can't be null")
+		}
+	}
+
+	req := p.req
+	if req.Name != nil {
+		user.Name = *req.Name
+	}
+	if req.Email != nil {
+		user.Email = *req.Email
+	}
+	if p.has("username") {
+		user.Username = req.Username
+	}
+	if p.has("age") {
+		user.Age = req.Age
+	}
+	if req.IsActive != nil {
+		user.IsActive = *req.IsActive
+	}
+	if req.ShowEmail != nil {
+		user.ShowEmail = *req.ShowEmail
+	}
+	if req.ShowAge != nil {
+		user.ShowAge = *req.ShowAge
+	}
+	if p.has("metadata") {
+		user.Metadata = mergePatchObject(user.Metadata, req.Metadata)
+	}
+	return nil
+}
+
+// mergePatchObject returns target with patch merged in as RFC 7396 describes:
+// null members are removed, objects are merged recursively and anything else
+// replaces the old value. target is left untouched.
+func mergePatchObject(target, patch map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(target)+len(patch))
+	for key, value := range target {
+		merged[key] = value
+	}
+	for key, value := range patch {
+		switch value := value.(type) {
+		case nil:
+			delete(merged, key)
+		case map[string]interface{}:
+			old, _ := merged[key].(map[string]interface{})
+			merged[key] = mergePatchObject(old, value)
+		default:
+			merged[key] = value
+		}
+	}
+	return merged
+}
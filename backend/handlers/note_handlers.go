@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/models"
+)
+
+// @Summary List admin notes
+// @Description Lists the notes admins have left on a user, newest first, with their authors
+// @Tags Admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.APIResponse{data=[]models.AdminNote}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/users/{id}/notes [get]
+func (h *Handler) ListAdminNotesHandler(c *gin.Context) {
+	id, ok := h.existingUserParam(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT n.id, n.user_id, n.author_id, authors.name, n.body, n.created_at
+		FROM admin_notes n
+		LEFT JOIN users authors ON authors.id = n.author_id
+		WHERE n.user_id = $1
+		ORDER BY n.created_at DESC, n.id DESC
+	`, id)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving notes"))
+		return
+	}
+	defer rows.Close()
+
+	notes := []models.AdminNote{}
+	for rows.Next() {
+		var note models.AdminNote
+		if err := rows.Scan(&note.ID, &note.UserID, &note.AuthorID, &note.AuthorName, &note.Body, &note.CreatedAt); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving notes"))
+			return
+		}
+		notes = append(notes, note)
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    notes,
+	})
+}
+
+// @Summary Add admin note
+// @Description Adds a note to a user record, authored by the caller. Notes are at most 5000 characters.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param note body models.AdminNoteRequest true "Note"
+// @Success 201 {object} models.APIResponse{data=models.AdminNote}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/users/{id}/notes [post]
+func (h *Handler) CreateAdminNoteHandler(c *gin.Context) {
+	id, ok := h.existingUserParam(c)
+	if !ok {
+		return
+	}
+	var req models.AdminNoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return
+	}
+	req.Body = strings.TrimSpace(req.Body)
+	if req.Body == "" {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: body must not be blank"))
+		return
+	}
+
+	authorID := c.GetInt("userID")
+	note := models.AdminNote{UserID: id, AuthorID: &authorID, Body: req.Body}
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err := h.db.QueryRowContext(ctx, `
+		WITH n AS (
+			INSERT INTO admin_notes (user_id, author_id, body, created_at) VALUES ($1, $2, $3, $4)
+			RETURNING id, author_id, created_at
+		)
+		SELECT n.id, n.created_at, authors.name
+		FROM n JOIN users authors ON authors.id = n.author_id
+	`, id, authorID, req.Body, time.Now()).Scan(&note.ID, &note.CreatedAt, &note.AuthorName)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error adding note"))
+		return
+	}
+
+	// The note itself stays out of the audit log, which outlives erasure
+	h.recordAudit(c, models.AuditUserNoteAdd, id, nil, gin.H{"note_id": note.ID})
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data:    note,
+		Message: "Note added successfully",
+	})
+}
+
+// @Summary Delete admin note
+// @Description Deletes a note from a user record
+// @Tags Admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Param noteId path int true "Note ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/users/{id}/notes/{noteId} [delete]
+func (h *Handler) DeleteAdminNoteHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID"))
+		return
+	}
+	noteID, err := strconv.Atoi(c.Param("noteId"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid note ID"))
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err = h.db.QueryRowContext(ctx, `
+		DELETE FROM admin_notes WHERE id = $1 AND user_id = $2 RETURNING id
+	`, noteID, id).Scan(&noteID)
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "Note with ID "+c.Param("noteId")+" not found"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error deleting note"))
+		return
+	}
+
+	h.recordAudit(c, models.AuditUserNoteDelete, id, gin.H{"note_id": noteID}, nil)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Note deleted successfully",
+	})
+}
@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/auth"
+	"goapi/database"
+	"goapi/metrics"
+	"goapi/models"
+	"goapi/utils"
+	"goapi/validation"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// oauthStateCookie carries the CSRF state between the redirect and the callback
+	oauthStateCookie = "oauth_state"
+	oauthCookiePath  = "/api/auth/oauth"
+)
+
+// errIdentityTaken is returned when linking an identity that belongs to another user
+var errIdentityTaken = errors.New("identity is linked to another user")
+
+// errAccountDeleted is returned when signing in to a soft-deleted user
+var errAccountDeleted = errors.New("account is deleted")
+
+// pendingDeletionError is returned when signing in to an account its owner
+// deleted, which can still be restored until PurgeAt
+type pendingDeletionError struct {
+	User    models.User
+	PurgeAt time.Time
+}
+
+func (e *pendingDeletionError) Error() string { return "account is scheduled for deletion" }
+
+// deletedAccountError is the error for signing in to user, soft-deleted,
+// whose deletion can be undone until purgeAt, if set
+func deletedAccountError(user models.User, purgeAt *time.Time) error {
+	if purgeAt != nil && purgeAt.After(time.Now()) {
+		return &pendingDeletionError{User: user, PurgeAt: *purgeAt}
+	}
+	return errAccountDeleted
+}
+
+// errSignupDisabled is returned when signing in would create an account
+// while signup is disabled
+var errSignupDisabled = errors.New("signup is disabled")
+
+// @Summary Start social sign-in
+// @Description Redirects to the provider's consent screen. The provider redirects back to the callback route.
+// @Tags Authentication
+// @Param provider path string true "Provider" Enums(google, github)
+// @Success 302
+// @Failure 404 {object} models.Problem
+// @Failure 503 {object} models.Problem
+// @Router /auth/oauth/{provider} [get]
+func OAuthLoginHandler(c *gin.Context) {
+	cfg, ok := oauthConfig(c)
+	if !ok {
+		return
+	}
+	state, ok := startOAuth(c)
+	if !ok {
+		return
+	}
+	c.Redirect(http.StatusFound, cfg.AuthCodeURL(state))
+}
+
+// @Summary Link a social identity
+// @Description Starts sign-in with the provider for the authenticated user. Open the returned URL in the same browser; the callback links the identity to the caller's account instead of signing in.
+// @Tags Authentication
+// @Produce json
+// @Param provider path string true "Provider" Enums(google, github)
+// @Success 200 {object} models.APIResponse{data=models.OAuthLinkResponse}
+// @Failure 401 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 503 {object} models.Problem
+// @Security BearerAuth
+// @Router /auth/oauth/{provider}/link [post]
+func (h *Handler) OAuthLinkHandler(c *gin.Context) {
+	cfg, ok := oauthConfig(c)
+	if !ok {
+		return
+	}
+	state, ok := startOAuth(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	if err := auth.RememberOAuthLink(ctx, h.db, state, c.GetInt("userID")); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error starting sign-in"))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    models.OAuthLinkResponse{URL: cfg.AuthCodeURL(state)},
+	})
+}
+
+// @Summary Complete social sign-in
+// @Description Exchanges the authorization code and returns an access token. The user is found by linked identity, then by verified email (linking the identity), and is created when neither matches.
+// @Tags Authentication
+// @Produce json
+// @Param provider path string true "Provider" Enums(google, github)
+// @Param code query string true "Authorization code"
+// @Param state query string true "State from the sign-in redirect"
+// @Success 200 {object} models.APIResponse{data=models.AuthResponse}
+// @Success 201 {object} models.APIResponse{data=models.AuthResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 502 {object} models.Problem
+// @Failure 503 {object} models.Problem
+// @Router /auth/oauth/{provider}/callback [get]
+func (h *Handler) OAuthCallbackHandler(c *gin.Context) {
+	cfg, ok := oauthConfig(c)
+	if !ok {
+		return
+	}
+
+	state, err := c.Cookie(oauthStateCookie)
+	c.SetCookie(oauthStateCookie, "", -1, oauthCookiePath, "", c.Request.TLS != nil, true)
+	if err != nil || state == "" || state != c.Query("state") {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Invalid OAuth state"))
+		return
+	}
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	linkUserID, linking, err := auth.TakeOAuthLink(ctx, h.db, state)
+	cancel()
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error completing sign-in"))
+		return
+	}
+
+	if c.Query("error") != "" || c.Query("code") == "" {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Sign-in was not completed"))
+		return
+	}
+
+	name := c.Param("provider")
+	provider, _ := auth.LookupOAuthProvider(name)
+	profile, err := auth.ExchangeOAuthCode(c.Request.Context(), name, provider, cfg, c.Query("code"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadGateway, apperr.CodeUpstream, "Error signing in with "+name+": "+err.Error()))
+		return
+	}
+
+	// The exchange with the provider isn't held to the database timeout
+	ctx, cancel = h.withTimeout(c.Request.Context())
+	defer cancel()
+	if linking {
+		user, err := h.linkOAuthIdentity(ctx, linkUserID, profile)
+		if err == errIdentityTaken {
+			c.Error(apperr.New(http.StatusConflict, apperr.CodeConflict, "This "+name+" account is already linked to another user"))
+			return
+		} else if err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error linking account"))
+			return
+		}
+		h.recordAudit(c, models.AuditIdentityLink, user.ID, nil, gin.H{"provider": profile.Provider})
+		h.respondWithToken(c, http.StatusOK, user)
+		return
+	}
+
+	user, created, err := h.findOrCreateOAuthUser(ctx, clientOf(c), profile)
+	var pending *pendingDeletionError
+	if errors.As(err, &pending) {
+		h.respondPendingDeletion(c, pending, models.LoginMethodOAuth+name, profile.Email)
+		return
+	} else if err == errAccountDeleted {
+		c.Error(apperr.New(http.StatusForbidden, apperr.CodeAccountDeleted, "This account has been deleted"))
+		return
+	} else if err == errSignupDisabled {
+		c.Error(apperr.New(http.StatusForbidden, apperr.CodeSignupDisabled, "Signup is disabled"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error signing in"))
+		return
+	}
+
+	if !user.IsActive {
+		h.recordLoginEvent(c, user.ID, profile.Email, models.LoginMethodOAuth+name, models.LoginFailureAccountDisabled)
+		c.Error(disabledError())
+		return
+	}
+
+	if h.refuseOverSessionLimit(c, user.ID, profile.Email, models.LoginMethodOAuth+name) {
+		return
+	}
+	h.recordLoginEvent(c, user.ID, profile.Email, models.LoginMethodOAuth+name, "")
+	if created {
+		h.publishAuditEvent(ctx, models.AuditUserCreate, user.ID, nil, user.ToUserResponse())
+		metrics.Signups.Inc()
+		h.respondWithToken(c, http.StatusCreated, user)
+		return
+	}
+	metrics.Logins.Inc()
+	h.respondWithToken(c, http.StatusOK, user)
+}
+
+// oauthConfig resolves the :provider parameter, writing 404 for unknown
+// providers and 503 for ones that aren't configured
+func oauthConfig(c *gin.Context) (*oauth2.Config, bool) {
+	provider, ok := auth.LookupOAuthProvider(c.Param("provider"))
+	if !ok {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "Unknown sign-in provider"))
+		return nil, false
+	}
+	cfg := provider.OAuthConfig()
+	if cfg == nil {
+		c.Error(apperr.New(http.StatusServiceUnavailable, apperr.CodeNotConfigured, "Sign-in with "+c.Param("provider")+" is not configured"))
+		return nil, false
+	}
+	return cfg, true
+}
+
+// startOAuth generates the CSRF state and stores it in a short-lived cookie
+func startOAuth(c *gin.Context) (string, bool) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error starting sign-in"))
+		return "", false
+	}
+	state := hex.EncodeToString(buf)
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthStateCookie, state, int((10 * time.Minute).Seconds()), oauthCookiePath, "", c.Request.TLS != nil, true)
+	return state, true
+}
+
+// findOrCreateOAuthUser returns the user linked to the profile's identity,
+// falling back to the user with the same email (and linking the identity).
+// When neither exists a user with an unusable password is created, audited
+// as created by cl, unless signup is disabled (errSignupDisabled). A match
+// that is soft-deleted gives a *pendingDeletionError while its owner can
+// still restore it, errAccountDeleted otherwise.
+func (h *Handler) findOrCreateOAuthUser(ctx context.Context, cl client, profile auth.OAuthProfile) (models.User, bool, error) {
+	var user models.User
+	var deleted bool
+	var purgeAt *time.Time
+	err := h.db.QueryRowContext(ctx, `
+		SELECT `+userColumns+`, deleted_at IS NOT NULL, CASE WHEN anonymized_at IS NULL THEN purge_at END FROM users
+		WHERE id = (SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2)
+	`, profile.Provider, profile.Subject).Scan(append(userFields(&user), &deleted, &purgeAt)...)
+	if err == nil && deleted {
+		return user, false, deletedAccountError(user, purgeAt)
+	} else if err != sql.ErrNoRows {
+		return user, false, err
+	}
+
+	email := utils.NormalizeEmail(profile.Email)
+	err = h.db.QueryRowContext(ctx, `
+		SELECT `+userColumns+`, deleted_at IS NOT NULL, CASE WHEN anonymized_at IS NULL THEN purge_at END FROM users
+		WHERE email_normalized = $1
+	`, utils.CanonicalEmail(email)).Scan(append(userFields(&user), &deleted, &purgeAt)...)
+	if err == nil && deleted {
+		return user, false, deletedAccountError(user, purgeAt)
+	} else if err == nil {
+		user, err = h.linkOAuthIdentity(ctx, user.ID, profile)
+		return user, false, err
+	} else if err != sql.ErrNoRows {
+		return user, false, err
+	}
+
+	if !validation.SignupEnabled() {
+		return user, false, errSignupDisabled
+	}
+
+	name := strings.TrimSpace(profile.Name)
+	if validation.CheckName(name) != nil {
+		name = email[:strings.Index(email+"@", "@")]
+	}
+
+	passwordHash, err := randomPasswordHash()
+	if err != nil {
+		return user, false, err
+	}
+
+	err = database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		now := time.Now()
+		err := scanUser(tx.QueryRowContext(ctx, `
+			INSERT INTO users (name, email, email_normalized, password, has_password, is_active, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, FALSE, $5, $6, $7)
+			RETURNING `+userColumns,
+			name, email, utils.CanonicalEmail(email), passwordHash, true, now, now), &user)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO user_identities (user_id, provider, subject, email, created_at)
+			VALUES ($1, $2, $3, $4, $5)
+		`, user.ID, profile.Provider, profile.Subject, email, now)
+		if err != nil {
+			return err
+		}
+		return userAudit{cl: cl, action: models.AuditUserCreate}.hook(ctx, tx, user)
+	})
+	return user, err == nil, err
+}
+
+// linkOAuthIdentity attaches the profile's identity to userID and returns the
+// user. Linking an identity that is already attached to that user is a no-op.
+func (h *Handler) linkOAuthIdentity(ctx context.Context, userID int, profile auth.OAuthProfile) (models.User, error) {
+	var user models.User
+
+	var ownerID int
+	err := h.db.QueryRowContext(ctx, `
+		INSERT INTO user_identities (user_id, provider, subject, email, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (provider, subject) DO UPDATE SET provider = EXCLUDED.provider
+		RETURNING user_id
+	`, userID, profile.Provider, profile.Subject, utils.NormalizeEmail(profile.Email), time.Now()).Scan(&ownerID)
+	if err != nil {
+		return user, err
+	}
+	if ownerID != userID {
+		return user, errIdentityTaken
+	}
+
+	err = scanUser(h.db.QueryRowContext(ctx, `
+		SELECT `+userColumns+` FROM users WHERE id = $1
+	`, userID), &user)
+	return user, err
+}
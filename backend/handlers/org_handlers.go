@@ -0,0 +1,543 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/database"
+	"goapi/models"
+)
+
+// orgColumns selects an organization with its member count and the role of
+// the user bound to $2, from organizations o joined with memberships m
+const orgColumns = `o.id, o.name,
+	(SELECT COUNT(*) FROM memberships mc JOIN users u ON u.id = mc.user_id WHERE mc.org_id = o.id AND u.deleted_at IS NULL),
+	m.role, o.created_at, o.updated_at`
+
+func scanOrg(row rowScanner, org *models.OrgResponse) error {
+	return row.Scan(&org.ID, &org.Name, &org.MemberCount, &org.Role, &org.CreatedAt, &org.UpdatedAt)
+}
+
+// orgAccess checks the caller's role in the organization named by :id.
+// Non-members get 404 so organizations can't be probed; members below min
+// get 403.
+func (h *Handler) orgAccess(c *gin.Context, min string) (int, string, bool) {
+	orgID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid organization ID"))
+		return 0, "", false
+	}
+
+	var role string
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err = h.db.QueryRowContext(ctx, `
+		SELECT role FROM memberships WHERE org_id = $1 AND user_id = $2
+	`, orgID, c.GetInt("userID")).Scan(&role)
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "Organization with ID "+strconv.Itoa(orgID)+" not found"))
+		return 0, "", false
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return 0, "", false
+	}
+	if models.OrgRoleRank[role] < models.OrgRoleRank[min] {
+		c.Error(apperr.New(http.StatusForbidden, apperr.CodeForbidden, "This needs the "+min+" role in the organization"))
+		return 0, "", false
+	}
+	return orgID, role, true
+}
+
+// bindOrgRequest binds and trims an organization name, writing 400 when it
+// is missing
+func bindOrgRequest(c *gin.Context) (models.OrgRequest, bool) {
+	var req models.OrgRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return req, false
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: name must not be blank"))
+		return req, false
+	}
+	return req, true
+}
+
+// @Summary Create organization
+// @Description Creates an organization with the caller as its owner
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Param org body models.OrgRequest true "Organization"
+// @Success 201 {object} models.APIResponse{data=models.OrgResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Security BearerAuth
+// @Router /orgs [post]
+func (h *Handler) CreateOrgHandler(c *gin.Context) {
+	req, ok := bindOrgRequest(c)
+	if !ok {
+		return
+	}
+	userID := c.GetInt("userID")
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	org, err := h.createOrg(ctx, clientOf(c), req.Name, userID)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error creating organization"))
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data:    org,
+		Message: "Organization created successfully",
+	})
+}
+
+// createOrg inserts an organization and its first owner in one transaction,
+// with cl's audit entry
+func (h *Handler) createOrg(ctx context.Context, cl client, name string, ownerID int) (models.OrgResponse, error) {
+	org := models.OrgResponse{Name: name, MemberCount: 1, Role: models.OrgRoleOwner}
+
+	err := database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		now := time.Now()
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO organizations (name, created_at, updated_at) VALUES ($1, $2, $2)
+			RETURNING id, created_at, updated_at
+		`, name, now).Scan(&org.ID, &org.CreatedAt, &org.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO memberships (org_id, user_id, role, created_at) VALUES ($1, $2, $3, $4)
+		`, org.ID, ownerID, models.OrgRoleOwner, now)
+		if err != nil {
+			return err
+		}
+		return cl.auditTx(ctx, tx, models.AuditOrgCreate, ownerID, nil, gin.H{"org_id": org.ID, "name": org.Name, "role": org.Role})
+	})
+	return org, err
+}
+
+// @Summary List my organizations
+// @Description Lists the organizations the caller belongs to, with the caller's role in each
+// @Tags Organizations
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=[]models.OrgResponse}
+// @Failure 401 {object} models.Problem
+// @Security BearerAuth
+// @Router /orgs [get]
+func (h *Handler) ListOrgsHandler(c *gin.Context) {
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT `+orgColumns+`
+		FROM organizations o JOIN memberships m ON m.org_id = o.id AND m.user_id = $1
+		ORDER BY o.name, o.id
+	`, c.GetInt("userID"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving organizations"))
+		return
+	}
+	defer rows.Close()
+
+	orgs := []models.OrgResponse{}
+	for rows.Next() {
+		var org models.OrgResponse
+		if err := scanOrg(rows, &org); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving organizations"))
+			return
+		}
+		orgs = append(orgs, org)
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    orgs,
+	})
+}
+
+// @Summary Get organization
+// @Description Retrieves an organization the caller belongs to
+// @Tags Organizations
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Success 200 {object} models.APIResponse{data=models.OrgResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /orgs/{id} [get]
+func (h *Handler) GetOrgHandler(c *gin.Context) {
+	orgID, _, ok := h.orgAccess(c, models.OrgRoleMember)
+	if !ok {
+		return
+	}
+	h.respondWithOrg(c, orgID, "")
+}
+
+// respondWithOrg writes the organization as seen by the caller
+func (h *Handler) respondWithOrg(c *gin.Context, orgID int, message string) {
+	var org models.OrgResponse
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err := scanOrg(h.db.QueryRowContext(ctx, `
+		SELECT `+orgColumns+`
+		FROM organizations o JOIN memberships m ON m.org_id = o.id AND m.user_id = $2
+		WHERE o.id = $1
+	`, orgID, c.GetInt("userID")), &org)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving organization"))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    org,
+		Message: message,
+	})
+}
+
+// @Summary Rename organization
+// @Description Renames an organization. Needs the admin or owner role in it.
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Param org body models.OrgRequest true "Organization"
+// @Success 200 {object} models.APIResponse{data=models.OrgResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /orgs/{id} [put]
+func (h *Handler) UpdateOrgHandler(c *gin.Context) {
+	orgID, _, ok := h.orgAccess(c, models.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	req, ok := bindOrgRequest(c)
+	if !ok {
+		return
+	}
+
+	var oldName string
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err := h.db.QueryRowContext(ctx, "SELECT name FROM organizations WHERE id = $1", orgID).Scan(&oldName)
+	if err == nil {
+		_, err = h.db.ExecContext(ctx, "UPDATE organizations SET name = $1, updated_at = $2 WHERE id = $3", req.Name, time.Now(), orgID)
+	}
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error updating organization"))
+		return
+	}
+
+	// org_id goes on the after side only, so the diff doesn't drop it
+	h.recordAudit(c, models.AuditOrgUpdate, 0, gin.H{"name": oldName}, gin.H{"org_id": orgID, "name": req.Name})
+	h.respondWithOrg(c, orgID, "Organization updated successfully")
+}
+
+// @Summary Delete organization
+// @Description Deletes an organization and all of its memberships. Needs the owner role in it.
+// @Tags Organizations
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /orgs/{id} [delete]
+func (h *Handler) DeleteOrgHandler(c *gin.Context) {
+	orgID, _, ok := h.orgAccess(c, models.OrgRoleOwner)
+	if !ok {
+		return
+	}
+
+	var name string
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err := h.db.QueryRowContext(ctx, "DELETE FROM organizations WHERE id = $1 RETURNING name", orgID).Scan(&name)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error deleting organization"))
+		return
+	}
+
+	h.recordAudit(c, models.AuditOrgDelete, 0, gin.H{"org_id": orgID, "name": name}, nil)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Organization deleted successfully",
+	})
+}
+
+// @Summary List organization members
+// @Description Lists the members of an organization the caller belongs to, owners first
+// @Tags Organizations
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Success 200 {object} models.APIResponse{data=[]models.MemberResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /orgs/{id}/members [get]
+func (h *Handler) ListOrgMembersHandler(c *gin.Context) {
+	orgID, _, ok := h.orgAccess(c, models.OrgRoleMember)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT u.id, u.name, u.email, u.username, m.role, m.created_at
+		FROM memberships m JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = $1 AND u.deleted_at IS NULL
+		ORDER BY CASE m.role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 ELSE 2 END, u.name, u.id
+	`, orgID)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving members"))
+		return
+	}
+	defer rows.Close()
+
+	members := []models.MemberResponse{}
+	for rows.Next() {
+		var m models.MemberResponse
+		if err := rows.Scan(&m.UserID, &m.Name, &m.Email, &m.Username, &m.Role, &m.JoinedAt); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving members"))
+			return
+		}
+		members = append(members, m)
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    members,
+	})
+}
+
+// @Summary Add organization member
+// @Description Adds a user to an organization. Admins can add members; adding an admin or owner needs the owner role.
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Param member body models.AddMemberRequest true "Member"
+// @Success 201 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Security BearerAuth
+// @Router /orgs/{id}/members [post]
+func (h *Handler) AddOrgMemberHandler(c *gin.Context) {
+	orgID, role, ok := h.orgAccess(c, models.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	var req models.AddMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return
+	}
+	if !canGrantOrgRole(c, role, req.Role) {
+		return
+	}
+
+	var exists bool
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	if err := h.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL)", req.UserID).Scan(&exists); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return
+	}
+	if !exists {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeUserNotFound, "User with ID "+strconv.Itoa(req.UserID)+" not found"))
+		return
+	}
+
+	result, err := h.db.ExecContext(ctx, `
+		INSERT INTO memberships (org_id, user_id, role, created_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (org_id, user_id) DO NOTHING
+	`, orgID, req.UserID, req.Role, time.Now())
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error adding member"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.Error(apperr.New(http.StatusConflict, apperr.CodeConflict, "User is already a member of this organization"))
+		return
+	}
+
+	h.recordAudit(c, models.AuditOrgMemberAdd, req.UserID, nil, gin.H{"org_id": orgID, "role": req.Role})
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Message: "Member added successfully",
+	})
+}
+
+// @Summary Change member role
+// @Description Changes a member's role. Needs the owner role; the last owner can't be demoted.
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Param userId path int true "User ID"
+// @Param member body models.UpdateMemberRequest true "Role"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Security BearerAuth
+// @Router /orgs/{id}/members/{userId} [patch]
+func (h *Handler) UpdateOrgMemberHandler(c *gin.Context) {
+	orgID, _, ok := h.orgAccess(c, models.OrgRoleOwner)
+	if !ok {
+		return
+	}
+	userID, current, ok := h.orgMember(c, orgID)
+	if !ok {
+		return
+	}
+	var req models.UpdateMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return
+	}
+	if current == models.OrgRoleOwner && req.Role != models.OrgRoleOwner && !h.keepsAnOwner(c, orgID) {
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	_, err := h.db.ExecContext(ctx, "UPDATE memberships SET role = $1 WHERE org_id = $2 AND user_id = $3", req.Role, orgID, userID)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error updating member"))
+		return
+	}
+
+	h.recordAudit(c, models.AuditOrgMemberUpdate, userID, gin.H{"role": current}, gin.H{"org_id": orgID, "role": req.Role})
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Member updated successfully",
+	})
+}
+
+// @Summary Remove organization member
+// @Description Removes a member from an organization. Anyone can leave; admins can remove members, and removing an admin or owner needs the owner role. The last owner can't be removed.
+// @Tags Organizations
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Param userId path int true "User ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Security BearerAuth
+// @Router /orgs/{id}/members/{userId} [delete]
+func (h *Handler) RemoveOrgMemberHandler(c *gin.Context) {
+	orgID, role, ok := h.orgAccess(c, models.OrgRoleMember)
+	if !ok {
+		return
+	}
+	userID, current, ok := h.orgMember(c, orgID)
+	if !ok {
+		return
+	}
+	if userID != c.GetInt("userID") {
+		if models.OrgRoleRank[role] < models.OrgRoleRank[models.OrgRoleAdmin] {
+			c.Error(apperr.New(http.StatusForbidden, apperr.CodeForbidden, "This needs the admin role in the organization"))
+			return
+		}
+		if !canGrantOrgRole(c, role, current) {
+			return
+		}
+	}
+	if current == models.OrgRoleOwner && !h.keepsAnOwner(c, orgID) {
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	_, err := h.db.ExecContext(ctx, "DELETE FROM memberships WHERE org_id = $1 AND user_id = $2", orgID, userID)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error removing member"))
+		return
+	}
+
+	h.recordAudit(c, models.AuditOrgMemberRemove, userID, gin.H{"org_id": orgID, "role": current}, nil)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Member removed successfully",
+	})
+}
+
+// orgMember loads the role of the member named by :userId, writing 404 if
+// they aren't in the organization
+func (h *Handler) orgMember(c *gin.Context, orgID int) (int, string, bool) {
+	userID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID"))
+		return 0, "", false
+	}
+
+	var role string
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err = h.db.QueryRowContext(ctx, "SELECT role FROM memberships WHERE org_id = $1 AND user_id = $2", orgID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "User "+strconv.Itoa(userID)+" is not a member of this organization"))
+		return 0, "", false
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return 0, "", false
+	}
+	return userID, role, true
+}
+
+// canGrantOrgRole reports whether a caller with role may add, or remove,
+// someone with target; only owners manage admins and owners. It writes 403
+// when not.
+func canGrantOrgRole(c *gin.Context, role, target string) bool {
+	if target != models.OrgRoleMember && role != models.OrgRoleOwner {
+		c.Error(apperr.New(http.StatusForbidden, apperr.CodeForbidden, "Only owners can manage admins and owners"))
+		return false
+	}
+	return true
+}
+
+// keepsAnOwner reports whether the organization has another owner besides
+// the one about to be demoted or removed, writing 409 when it doesn't
+func (h *Handler) keepsAnOwner(c *gin.Context, orgID int) bool {
+	var owners int
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err := h.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM memberships WHERE org_id = $1 AND role = $2", orgID, models.OrgRoleOwner).Scan(&owners)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return false
+	}
+	if owners < 2 {
+		c.Error(apperr.New(http.StatusConflict, apperr.CodeConflict, "An organization needs at least one owner; make someone else owner first or delete the organization"))
+		return false
+	}
+	return true
+}
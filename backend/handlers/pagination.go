@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/models"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// parsePage reads the page and page_size query parameters, writing 400 for
+// invalid values
+func parsePage(c *gin.Context) (page, pageSize int, ok bool) {
+	page = 1
+	if value := c.Query("page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "page must be a positive number"))
+			return 0, 0, false
+		}
+		page = n
+	}
+	pageSize, ok = parsePageSize(c)
+	return page, pageSize, ok
+}
+
+// parsePageSize reads the page_size query parameter, writing 400 for invalid
+// values
+func parsePageSize(c *gin.Context) (int, bool) {
+	value := c.Query("page_size")
+	if value == "" {
+		return defaultPageSize, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > maxPageSize {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "page_size must be between 1 and "+strconv.Itoa(maxPageSize)))
+		return 0, false
+	}
+	return n, true
+}
+
+// newPagination describes one page of a listing of total items
+func newPagination(page, pageSize, total int) *models.Pagination {
+	p := &models.Pagination{
+		Page:       page,
+		PageSize:   pageSize,
+		Total:      total,
+		TotalPages: (total + pageSize - 1) / pageSize,
+	}
+	if page < p.TotalPages {
+		next := page + 1
+		p.Next = &next
+	}
+	if page > 1 {
+		prev := min(page-1, max(p.TotalPages, 1))
+		p.Prev = &prev
+	}
+	return p
+}
+
+// encodeCursor makes an opaque token for the keyset position after the row
+// with the given creation time and ID
+func encodeCursor(createdAt time.Time, id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d,%d", createdAt.UnixMicro(), id)))
+}
+
+// decodeCursor reverses encodeCursor. Timestamps are stored without a zone
+// and read back as UTC, so the cursor's time is UTC as well.
+func decodeCursor(cursor string) (time.Time, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	var micros int64
+	var id int
+	if n, err := fmt.Sscanf(string(raw), "%d,%d", &micros, &id); err != nil || n != 2 {
+		return time.Time{}, 0, errors.New("malformed cursor")
+	}
+	return time.UnixMicro(micros).UTC(), id, nil
+}
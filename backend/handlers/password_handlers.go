@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/database"
+	"goapi/hashing"
+	"goapi/models"
+	"goapi/validation"
+)
+
+// @Summary Change my password
+// @Description Changes the caller's password after checking the current one. Every other session of the caller is signed out.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param passwords body models.ChangePasswordRequest true "Current and new password"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/me/password [put]
+func (h *Handler) ChangeMyPasswordHandler(c *gin.Context) {
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return
+	}
+	if err := validation.CheckPassword(req.NewPassword); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return
+	}
+
+	userID := c.GetInt("userID")
+
+	var currentHash string
+	var hasPassword bool
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	if err := h.db.QueryRowContext(ctx, "SELECT password, has_password FROM users WHERE id = $1 AND deleted_at IS NULL", userID).Scan(&currentHash, &hasPassword); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return
+	}
+	if !hasPassword {
+		c.Error(apperr.New(http.StatusConflict, apperr.CodeConflict, "The account has no password; set one with POST /api/users/me/identities/password"))
+		return
+	}
+	if !hashing.Verify(currentHash, req.CurrentPassword) {
+		c.Error(apperr.New(http.StatusForbidden, apperr.CodeInvalidCredentials, "Current password is incorrect"))
+		return
+	}
+
+	hashedPassword, err := hashing.Hash(req.NewPassword)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error processing password"))
+		return
+	}
+
+	err = database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		now := time.Now()
+		_, err := tx.ExecContext(ctx, "UPDATE users SET password = $1, updated_at = $2 WHERE id = $3", hashedPassword, now, userID)
+		if err != nil {
+			return err
+		}
+		// Anyone holding another session may know the old password
+		_, err = tx.ExecContext(ctx, `
+			UPDATE sessions SET revoked_at = $1
+			WHERE user_id = $2 AND id <> $3 AND revoked_at IS NULL
+		`, now, userID, c.GetInt("sessionID"))
+		if err != nil {
+			return err
+		}
+		return clientOf(c).auditTx(ctx, tx, models.AuditUserPasswordChange, userID, nil, nil)
+	})
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error changing password"))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Password changed",
+	})
+}
+
+// rehashPassword upgrades a stored hash made with another algorithm or weaker
+// parameters than configured. It runs after a successful login, the only time
+// the plain password is known, and leaves updated_at alone since the user
+// didn't change anything. Failures are ignored; the old hash keeps working.
+func (h *Handler) rehashPassword(ctx context.Context, user models.User, password string) {
+	if !hashing.NeedsRehash(user.Password) {
+		return
+	}
+	hashedPassword, err := hashing.Hash(password)
+	if err != nil {
+		return
+	}
+	h.db.ExecContext(ctx, "UPDATE users SET password = $1 WHERE id = $2 AND password = $3", hashedPassword, user.ID, user.Password)
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/models"
+)
+
+// @Summary Get public user profile
+// @Description Retrieves the public profile of a user, containing only the fields the user has made visible. Signed-in callers get 404 for users they have blocked or who have blocked them.
+// @Tags Public
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Router /public/users/{id} [get]
+func (h *Handler) GetPublicUserHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID"))
+		return
+	}
+
+	var user models.User
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err = scanUser(h.db.QueryRowContext(ctx, `
+		SELECT `+userColumns+`
+		FROM users WHERE id = $1 AND is_active = TRUE AND deleted_at IS NULL
+			AND `+unblocked("id", "$2")+`
+	`, id, c.GetInt("userID")), &user)
+
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeUserNotFound, "User with ID "+strconv.Itoa(id)+" not found"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving user"))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    user.ToPublicUserResponse(),
+	})
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"goapi/apperr"
+	"goapi/models"
+	"goapi/realtime"
+)
+
+// WebSocketHandler serves GET /ws, upgrading the authenticated caller's
+// request to a WebSocket that receives user events until it is closed
+func WebSocketHandler(c *gin.Context) {
+	if !websocket.IsWebSocketUpgrade(c.Request) {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Expected a WebSocket upgrade request"))
+		return
+	}
+	// The upgrader answers failed handshakes itself
+	scopes, _ := c.Get("scopes")
+	granted, _ := scopes.([]string)
+	realtime.Serve(c.Writer, c.Request, c.GetInt("userID"), c.GetInt("sessionID"), readsOnly(granted))
+}
+
+// @Summary List online users
+// @Description Lists the users with an open WebSocket connection to any server, by ID, with how many connections they have and since when
+// @Tags Users
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=[]models.OnlineUser}
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/online [get]
+func (h *Handler) ListOnlineUsersHandler(c *gin.Context) {
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	presence, err := realtime.Online(ctx, h.db)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving online users").Wrap(err))
+		return
+	}
+	ids := make([]int64, len(presence))
+	for i, p := range presence {
+		ids[i] = int64(p.UserID)
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT id, name, username FROM users WHERE id = ANY($1) AND deleted_at IS NULL
+	`, ids)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving online users"))
+		return
+	}
+	defer rows.Close()
+
+	byID := make(map[int]models.OnlineUser, len(presence))
+	for rows.Next() {
+		var user models.OnlineUser
+		if err := rows.Scan(&user.ID, &user.Name, &user.Username); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving online users"))
+			return
+		}
+		byID[user.ID] = user
+	}
+
+	// Users deleted while connected are left out
+	online := make([]models.OnlineUser, 0, len(presence))
+	for _, p := range presence {
+		if user, ok := byID[p.UserID]; ok {
+			user.Connections = p.Connections
+			user.OnlineSince = p.Since
+			online = append(online, user)
+		}
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    online,
+	})
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/models"
+)
+
+// @Summary Follow user
+// @Description Makes the caller follow a user. Following someone again changes nothing.
+// @Tags Relationships
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/me/following/{id} [post]
+func (h *Handler) FollowUserHandler(c *gin.Context) {
+	id, ok := h.followableUserParam(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	_, err := h.db.ExecContext(ctx, `
+		INSERT INTO user_relationships (follower_id, followee_id, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (follower_id, followee_id) DO NOTHING
+	`, c.GetInt("userID"), id, time.Now())
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error following user"))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Following user " + strconv.Itoa(id),
+	})
+}
+
+// followableUserParam parses the :id of a user the caller can follow: an
+// active user other than themselves with no block between them. It writes
+// 400, 404 or 409 otherwise; users who blocked the caller look missing.
+func (h *Handler) followableUserParam(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID"))
+		return 0, false
+	}
+	if id == c.GetInt("userID") {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "You can't follow yourself"))
+		return 0, false
+	}
+
+	var exists, blocked, blockedBy bool
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err = h.db.QueryRowContext(ctx, `
+		SELECT
+			EXISTS (SELECT 1 FROM users WHERE id = $1 AND is_active = TRUE AND deleted_at IS NULL),
+			EXISTS (SELECT 1 FROM user_blocks WHERE blocker_id = $2 AND blocked_id = $1),
+			EXISTS (SELECT 1 FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2)
+	`, id, c.GetInt("userID")).Scan(&exists, &blocked, &blockedBy)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return 0, false
+	}
+	if blocked {
+		c.Error(apperr.New(http.StatusConflict, apperr.CodeConflict, "Unblock user "+strconv.Itoa(id)+" before following them"))
+		return 0, false
+	}
+	if !exists || blockedBy {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeUserNotFound, "User with ID "+strconv.Itoa(id)+" not found"))
+		return 0, false
+	}
+	return id, true
+}
+
+// @Summary Unfollow user
+// @Description Stops the caller following a user
+// @Tags Relationships
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/me/following/{id} [delete]
+func (h *Handler) UnfollowUserHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID"))
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	result, err := h.db.ExecContext(ctx, `
+		DELETE FROM user_relationships WHERE follower_id = $1 AND followee_id = $2
+	`, c.GetInt("userID"), id)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error unfollowing user"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "You don't follow user "+strconv.Itoa(id)))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Unfollowed user " + strconv.Itoa(id),
+	})
+}
+
+// @Summary List followers
+// @Description Lists the users following a user, most recent first, showing their public profiles. Inactive and deleted users, and users with a block between them and the caller, are left out.
+// @Tags Relationships
+// @Produce json
+// @Param id path int true "User ID"
+// @Param page query int false "Page number, starting at 1" default(1)
+// @Param page_size query int false "Users per page, at most 100" default(20)
+// @Success 200 {object} models.APIResponse{data=[]models.RelatedUser,pagination=models.Pagination}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/{id}/followers [get]
+func (h *Handler) ListFollowersHandler(c *gin.Context) {
+	h.listRelationships(c, "followee_id", "follower_id")
+}
+
+// @Summary List followed users
+// @Description Lists the users a user follows, most recently followed first, showing their public profiles. Inactive and deleted users, and users with a block between them and the caller, are left out.
+// @Tags Relationships
+// @Produce json
+// @Param id path int true "User ID"
+// @Param page query int false "Page number, starting at 1" default(1)
+// @Param page_size query int false "Users per page, at most 100" default(20)
+// @Success 200 {object} models.APIResponse{data=[]models.RelatedUser,pagination=models.Pagination}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/{id}/following [get]
+func (h *Handler) ListFollowingHandler(c *gin.Context) {
+	h.listRelationships(c, "follower_id", "followee_id")
+}
+
+// listRelationships pages through the relationships whose column of equals
+// the :id user, listing the users in column other. Users with a block
+// between them and the caller are hidden, the :id user included.
+func (h *Handler) listRelationships(c *gin.Context, of, other string) {
+	id, ok := h.existingUserParam(c)
+	if !ok {
+		return
+	}
+	page, pageSize, ok := parsePage(c)
+	if !ok {
+		return
+	}
+	userID := c.GetInt("userID")
+
+	var visible bool
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	if err := h.db.QueryRowContext(ctx, "SELECT "+unblocked("$1", "$2"), id, userID).Scan(&visible); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return
+	}
+	if !visible {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeUserNotFound, "User with ID "+strconv.Itoa(id)+" not found"))
+		return
+	}
+
+	from := `
+		FROM user_relationships r JOIN users u ON u.id = r.` + other + `
+		WHERE r.` + of + ` = $1 AND u.is_active = TRUE AND u.deleted_at IS NULL
+			AND ` + unblocked("u.id", "$2")
+
+	var total int
+	if err := h.db.QueryRowContext(ctx, "SELECT COUNT(*)"+from, id, userID).Scan(&total); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error counting users"))
+		return
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT u.id, u.name, u.username, u.email, u.age, u.show_email, u.show_age, r.created_at`+from+`
+		ORDER BY r.created_at DESC, u.id DESC
+		LIMIT $3 OFFSET $4
+	`, id, userID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving users"))
+		return
+	}
+	defer rows.Close()
+
+	users := []models.RelatedUser{}
+	for rows.Next() {
+		var user models.User
+		var followedAt time.Time
+		if err := rows.Scan(&user.ID, &user.Name, &user.Username, &user.Email, &user.Age, &user.ShowEmail, &user.ShowAge, &followedAt); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error scanning user"))
+			return
+		}
+		users = append(users, models.RelatedUser{PublicUserResponse: user.ToPublicUserResponse(), FollowedAt: followedAt})
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:    true,
+		Data:       users,
+		Pagination: newPagination(page, pageSize, total),
+	})
+}
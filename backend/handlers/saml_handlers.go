@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"net/http"
+
+	"github.com/crewjam/saml"
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/auth"
+	"goapi/metrics"
+	"goapi/models"
+)
+
+// @Summary Start SAML sign-in
+// @Description Redirects to the identity provider with a SAML AuthnRequest. The IdP posts its response to the ACS route.
+// @Tags Authentication
+// @Success 302
+// @Failure 503 {object} models.Problem
+// @Router /auth/saml/login [get]
+func (h *Handler) SAMLLoginHandler(c *gin.Context) {
+	sp, ok := samlServiceProvider(c)
+	if !ok {
+		return
+	}
+
+	req, err := sp.MakeAuthenticationRequest(sp.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error starting sign-in"))
+		return
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error starting sign-in"))
+		return
+	}
+	relayState := hex.EncodeToString(buf)
+
+	redirect, err := req.Redirect(relayState, sp)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error starting sign-in"))
+		return
+	}
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	if err := auth.RememberSAMLRequest(ctx, h.db, relayState, req.ID); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error starting sign-in"))
+		return
+	}
+	c.Redirect(http.StatusFound, redirect.String())
+}
+
+// @Summary SAML assertion consumer service
+// @Description Receives the IdP's SAML response (HTTP-POST binding), verifies it and returns an access token. The user is found by NameID, then by email (linking the identity), and is created when neither matches. Only responses to a login started here are accepted.
+// @Tags Authentication
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param SAMLResponse formData string true "Base64-encoded SAML response"
+// @Param RelayState formData string true "Relay state from the sign-in redirect"
+// @Success 200 {object} models.APIResponse{data=models.AuthResponse}
+// @Success 201 {object} models.APIResponse{data=models.AuthResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 503 {object} models.Problem
+// @Router /auth/saml/acs [post]
+func (h *Handler) SAMLACSHandler(c *gin.Context) {
+	sp, ok := samlServiceProvider(c)
+	if !ok {
+		return
+	}
+
+	if err := c.Request.ParseForm(); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Invalid SAML response"))
+		return
+	}
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	requestID, ok, err := auth.TakeSAMLRequest(ctx, h.db, c.Request.PostForm.Get("RelayState"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error completing sign-in"))
+		return
+	}
+	if !ok {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Unknown or expired SAML sign-in"))
+		return
+	}
+
+	assertion, err := sp.ParseResponse(c.Request, []string{requestID})
+	if err != nil {
+		metrics.FailedLogins.Inc()
+		h.recordLoginEvent(c, 0, "", models.LoginMethodSAML, models.LoginFailureInvalidAssertion)
+		c.Error(apperr.New(http.StatusUnauthorized, apperr.CodeUnauthorized, "Invalid SAML response"))
+		return
+	}
+
+	profile, err := auth.SAMLProfile(assertion)
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Cannot sign in: "+err.Error()))
+		return
+	}
+
+	user, created, err := h.findOrCreateOAuthUser(ctx, clientOf(c), profile)
+	var pending *pendingDeletionError
+	if errors.As(err, &pending) {
+		h.respondPendingDeletion(c, pending, models.LoginMethodSAML, profile.Email)
+		return
+	} else if err == errIdentityTaken {
+		c.Error(apperr.New(http.StatusConflict, apperr.CodeConflict, "This SAML identity is linked to another user"))
+		return
+	} else if err == errAccountDeleted {
+		c.Error(apperr.New(http.StatusForbidden, apperr.CodeAccountDeleted, "This account has been deleted"))
+		return
+	} else if err == errSignupDisabled {
+		c.Error(apperr.New(http.StatusForbidden, apperr.CodeSignupDisabled, "Signup is disabled"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error signing in"))
+		return
+	}
+
+	if !user.IsActive {
+		h.recordLoginEvent(c, user.ID, profile.Email, models.LoginMethodSAML, models.LoginFailureAccountDisabled)
+		c.Error(disabledError())
+		return
+	}
+
+	if h.refuseOverSessionLimit(c, user.ID, profile.Email, models.LoginMethodSAML) {
+		return
+	}
+	h.recordLoginEvent(c, user.ID, profile.Email, models.LoginMethodSAML, "")
+	if created {
+		h.publishAuditEvent(ctx, models.AuditUserCreate, user.ID, nil, user.ToUserResponse())
+		metrics.Signups.Inc()
+		h.respondWithToken(c, http.StatusCreated, user)
+		return
+	}
+	metrics.Logins.Inc()
+	h.respondWithToken(c, http.StatusOK, user)
+}
+
+// @Summary SAML service provider metadata
+// @Description Returns the SP metadata XML to register with the identity provider
+// @Tags Authentication
+// @Produce xml
+// @Success 200 {string} string "SP metadata"
+// @Failure 503 {object} models.Problem
+// @Router /auth/saml/metadata [get]
+func SAMLMetadataHandler(c *gin.Context) {
+	sp, ok := samlServiceProvider(c)
+	if !ok {
+		return
+	}
+
+	data, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error generating metadata"))
+		return
+	}
+	c.Data(http.StatusOK, "application/samlmetadata+xml", data)
+}
+
+// samlServiceProvider returns the configured service provider, writing 503
+// when SAML is not configured or the IdP metadata can't be loaded
+func samlServiceProvider(c *gin.Context) (*saml.ServiceProvider, bool) {
+	sp, err := auth.SAMLServiceProvider(c.Request.Context())
+	if err != nil || sp == nil {
+		message := "SAML sign-in is not configured"
+		if err != nil {
+			message = "SAML sign-in is unavailable: " + err.Error()
+		}
+		c.Error(apperr.New(http.StatusServiceUnavailable, apperr.CodeNotConfigured, message))
+		return nil, false
+	}
+	return sp, true
+}
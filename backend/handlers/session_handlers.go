@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/database"
+	"goapi/models"
+	"goapi/utils"
+)
+
+var (
+	// maxSessions is how many active sessions a user may hold, 0 for any
+	// number
+	maxSessions int
+	// evictOldestSession makes room for a sign-in beyond maxSessions by
+	// revoking the oldest sessions instead of refusing it
+	evictOldestSession bool
+)
+
+// errSessionLimit means a user already holds maxSessions active sessions
+var errSessionLimit = errors.New("session limit reached")
+
+// SetSessionLimit sets how many active sessions a user may hold, 0 for any
+// number, and whether signing in beyond that evicts the oldest ones
+func SetSessionLimit(max int, evictOldest bool) {
+	maxSessions = max
+	evictOldestSession = evictOldest
+}
+
+// sessionLimitError is the 409 for signing in with every allowed session in
+// use
+func sessionLimitError() *apperr.Error {
+	return apperr.New(http.StatusConflict, apperr.CodeSessionLimit, "Signed in on too many devices; sign out of one first").
+		With("max_sessions", maxSessions)
+}
+
+// createSession records a new sign-in from cl and returns its ID. With a
+// session limit it fails with errSessionLimit once the user holds as many
+// sessions as allowed, or evicts the oldest ones to make room.
+func (h *Handler) createSession(ctx context.Context, cl client, userID int, expiresAt time.Time) (int, error) {
+	now := time.Now()
+
+	var id int
+	err := database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		if maxSessions > 0 {
+			if err := makeRoomForSession(ctx, tx, userID, now); err != nil {
+				return err
+			}
+		}
+		return tx.QueryRowContext(ctx, `
+			INSERT INTO sessions (user_id, ip, user_agent, device, created_at, last_seen_at, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id
+		`, userID, cl.ip, cl.userAgent, describeDevice(cl.userAgent), now, now, expiresAt).Scan(&id)
+	})
+	return id, err
+}
+
+// makeRoomForSession keeps userID below maxSessions active sessions in tx,
+// revoking the oldest ones when evictOldestSession is set and failing with
+// errSessionLimit otherwise. Each eviction is recorded in the user's login
+// history with the evicted session's device. The user's row stays locked
+// until tx ends, so concurrent sign-ins can't both take the last session.
+func makeRoomForSession(ctx context.Context, tx *sql.Tx, userID int, now time.Time) error {
+	var email string
+	if err := tx.QueryRowContext(ctx, `SELECT email FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&email); err != nil {
+		return err
+	}
+
+	type session struct {
+		id                    int
+		ip, userAgent, device string
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, COALESCE(ip, ''), COALESCE(user_agent, ''), COALESCE(device, '')
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY created_at, id
+	`, userID, now)
+	if err != nil {
+		return err
+	}
+	var active []session
+	for rows.Next() {
+		var s session
+		if err := rows.Scan(&s.id, &s.ip, &s.userAgent, &s.device); err != nil {
+			rows.Close()
+			return err
+		}
+		active = append(active, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	excess := len(active) - maxSessions + 1
+	if excess <= 0 {
+		return nil
+	}
+	if !evictOldestSession {
+		return errSessionLimit
+	}
+	for _, s := range active[:excess] {
+		if _, err := tx.ExecContext(ctx, `UPDATE sessions SET revoked_at = $1 WHERE id = $2`, now, s.id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO login_events (user_id, email, success, method, failure_reason, ip, user_agent, device, created_at)
+			VALUES ($1, $2, FALSE, $3, $4, $5, $6, $7, $8)
+		`, userID, utils.NormalizeEmail(email), models.LoginMethodSession, models.LoginFailureSessionEvicted,
+			s.ip, s.userAgent, s.device, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refuseOverSessionLimit writes the 409 for a sign-in of userID that
+// would go beyond the session limit, recording the attempt, and reports
+// whether it did. Sign-ins are only refused this way when the oldest
+// sessions aren't evicted instead.
+func (h *Handler) refuseOverSessionLimit(c *gin.Context, userID int, email, method string) bool {
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	if err := h.checkSessionLimit(ctx, clientOf(c), userID, email, method); err != nil {
+		c.Error(err)
+		return true
+	}
+	return false
+}
+
+// checkSessionLimit is refuseOverSessionLimit for a sign-in made by cl under
+// ctx, returning the error
+func (h *Handler) checkSessionLimit(ctx context.Context, cl client, userID int, email, method string) *apperr.Error {
+	if maxSessions == 0 || evictOldestSession {
+		return nil
+	}
+	var active int
+	err := h.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+	`, userID, time.Now()).Scan(&active)
+	if err != nil {
+		return apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error")
+	}
+	if active < maxSessions {
+		return nil
+	}
+	h.loginEvent(ctx, cl, userID, email, method, models.LoginFailureSessionLimit)
+	return sessionLimitError()
+}
+
+// describeDevice turns a user agent into a short label such as "Firefox on Linux"
+func describeDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"edg/", "Edge"},
+		{"opr/", "Opera"},
+		{"firefox/", "Firefox"},
+		{"chrome/", "Chrome"},
+		{"safari/", "Safari"},
+		{"curl/", "curl"},
+		{"go-http-client", "Go client"},
+		{"grpc-", "gRPC client"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	for _, o := range []struct{ token, name string }{
+		{"android", "Android"},
+		{"iphone", "iOS"},
+		{"ipad", "iPadOS"},
+		{"windows", "Windows"},
+		{"mac os", "macOS"},
+		{"linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			return browser + " on " + o.name
+		}
+	}
+	return browser
+}
+
+// @Summary List my sessions
+// @Description Lists the caller's active sessions, newest activity first. The session making the request is marked current.
+// @Tags Sessions
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=[]models.SessionResponse}
+// @Failure 401 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/me/sessions [get]
+func (h *Handler) ListMySessionsHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	currentID := c.GetInt("sessionID")
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT id, device, ip, user_agent, created_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY last_seen_at DESC
+	`, userID, time.Now())
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return
+	}
+	defer rows.Close()
+
+	sessions := []models.SessionResponse{}
+	for rows.Next() {
+		var s models.SessionResponse
+		if err := rows.Scan(&s.ID, &s.Device, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error scanning session data"))
+			return
+		}
+		s.Current = s.ID == currentID
+		sessions = append(sessions, s)
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    sessions,
+	})
+}
+
+// @Summary Revoke one of my sessions
+// @Description Signs out one of the caller's sessions; its token stops working immediately
+// @Tags Sessions
+// @Produce json
+// @Param id path int true "Session ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/me/sessions/{id} [delete]
+func (h *Handler) RevokeMySessionHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid session ID"))
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	result, err := h.db.ExecContext(ctx, `
+		UPDATE sessions SET revoked_at = $1
+		WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL
+	`, time.Now(), id, c.GetInt("userID"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "Session not found"))
+		return
+	}
+	h.recordAudit(c, models.AuditSessionRevoke, c.GetInt("userID"), gin.H{"session_id": id}, nil)
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Session revoked",
+	})
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/jobs"
+	"goapi/models"
+)
+
+// @Summary User statistics
+// @Description Counts users, active and inactive ones, their average age and the signups per day over the last 30 days, including days without any. Deleted users aren't counted. The figures come from a summary refreshed every STATS_REFRESH_INTERVAL or through /admin/stats/refresh, as of refreshed_at.
+// @Tags Users
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=models.UserStats}
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/stats [get]
+func (h *Handler) GetUserStatsHandler(c *gin.Context) {
+	var stats models.UserStats
+	var averageAge sql.NullFloat64
+	var refreshedAt sql.NullTime
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err := h.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(signups), 0), COALESCE(SUM(active), 0),
+			ROUND(SUM(age_sum)::numeric / NULLIF(SUM(age_count), 0), 1), MAX(refreshed_at)
+		FROM user_stats_daily
+	`).Scan(&stats.Total, &stats.Active, &averageAge, &refreshedAt)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving user statistics"))
+		return
+	}
+	stats.Inactive = stats.Total - stats.Active
+	if averageAge.Valid {
+		stats.AverageAge = &averageAge.Float64
+	}
+	if refreshedAt.Valid {
+		stats.RefreshedAt = &refreshedAt.Time
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT to_char(g.day, 'YYYY-MM-DD'), COALESCE(s.signups, 0)
+		FROM generate_series((CURRENT_DATE - ($1::int - 1))::timestamp, CURRENT_DATE::timestamp, INTERVAL '1 day') AS g(day)
+		LEFT JOIN user_stats_daily s ON s.day = g.day::date
+		ORDER BY g.day
+	`, models.StatsSignupDays)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving user statistics"))
+		return
+	}
+	defer rows.Close()
+
+	stats.SignupsPerDay = make([]models.DailySignups, 0, models.StatsSignupDays)
+	for rows.Next() {
+		var day models.DailySignups
+		if err := rows.Scan(&day.Date, &day.Count); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving user statistics"))
+			return
+		}
+		stats.SignupsPerDay = append(stats.SignupsPerDay, day)
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    stats,
+	})
+}
+
+// @Summary Refresh user statistics
+// @Description Queues a job refreshing the summary behind /users/stats, or returns the one already queued or running. Follow it on /admin/jobs/{id}.
+// @Tags Admin
+// @Produce json
+// @Success 202 {object} models.APIResponse{data=models.Job}
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/stats/refresh [post]
+func (h *Handler) RefreshStatsHandler(c *gin.Context) {
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	id, err := jobs.Enqueue(ctx, h.db, JobRefreshStats, struct{}{}, jobs.Options{UniqueKey: JobRefreshStats})
+	if err == nil && id == 0 {
+		// The scheduler or another admin got there first
+		err = h.db.QueryRowContext(ctx, `
+			SELECT id FROM jobs WHERE unique_key = $1 AND status IN ('pending', 'running')
+		`, JobRefreshStats).Scan(&id)
+	}
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error queueing statistics refresh"))
+		return
+	}
+
+	job, err := h.loadJob(ctx, id)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving job"))
+		return
+	}
+	c.JSON(http.StatusAccepted, models.APIResponse{
+		Success: true,
+		Data:    job,
+		Message: "Statistics refresh queued",
+	})
+}
+
+// refreshStatsJob recomputes the summary behind the user statistics,
+// without blocking reads of the old one meanwhile
+func (h *Handler) refreshStatsJob(ctx context.Context, job *jobs.Job) error {
+	start := time.Now()
+	if _, err := h.db.ExecContext(ctx, `REFRESH MATERIALIZED VIEW CONCURRENTLY user_stats_daily`); err != nil {
+		return err
+	}
+	log.Printf("Refreshed user statistics in %s", time.Since(start).Round(time.Millisecond))
+	return nil
+}
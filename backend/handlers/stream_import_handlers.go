@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"goapi/apperr"
+	"goapi/database"
+	"goapi/hashing"
+	"goapi/models"
+	"goapi/utils"
+	"goapi/validation"
+)
+
+const (
+	// streamImportBatchSize is the number of users inserted per statement
+	streamImportBatchSize = 200
+	// streamImportMaxLine caps the size of a single NDJSON record
+	streamImportMaxLine = 1 << 20
+)
+
+// streamImportRow is a validated line waiting to be inserted
+type streamImportRow struct {
+	line      int
+	req       models.CreateUserRequest
+	canonical string
+	password  string
+}
+
+// streamImport holds the state of one NDJSON import request
+type streamImport struct {
+	h       *Handler
+	c       *gin.Context
+	enc     *json.Encoder
+	batch   []streamImportRow
+	summary models.StreamImportSummary
+}
+
+// @Summary Stream-import users from NDJSON
+// @Description Reads newline-delimited JSON user records (same fields as user creation) and inserts them in batches while the body is still arriving. The response is NDJSON with one result per input line, followed by a final {"summary": {...}} line.
+// @Tags Users
+// @Accept application/x-ndjson
+// @Produce application/x-ndjson
+// @Param users body models.CreateUserRequest true "One user per line"
+// @Success 200 {object} models.StreamImportResult
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/import/stream [post]
+func (h *Handler) StreamImportUsersHandler(c *gin.Context) {
+	// Results are written while the request body is still being read
+	if err := http.NewResponseController(c.Writer).EnableFullDuplex(); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Streaming is not supported by this server"))
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+
+	s := &streamImport{h: h, c: c, enc: json.NewEncoder(c.Writer)}
+
+	scanner := bufio.NewScanner(c.Request.Body)
+	scanner.Buffer(make([]byte, 64*1024), streamImportMaxLine)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		s.add(line, text)
+		if len(s.batch) >= streamImportBatchSize {
+			s.flush()
+		}
+	}
+	s.flush()
+
+	if err := scanner.Err(); err != nil {
+		s.write(models.StreamImportResult{Line: line + 1, Action: models.ImportActionFailed, Error: "Error reading request body: " + err.Error()})
+	}
+
+	s.enc.Encode(gin.H{"summary": s.summary})
+}
+
+// add validates a line and queues it for the next batch
+func (s *streamImport) add(line int, text string) {
+	var req models.CreateUserRequest
+	if err := json.Unmarshal([]byte(text), &req); err != nil {
+		s.write(models.StreamImportResult{Line: line, Action: models.ImportActionFailed, Error: "Invalid JSON: " + err.Error()})
+		return
+	}
+	if err := checkImportedUser(&req); err != nil {
+		s.write(models.StreamImportResult{Line: line, Email: req.Email, Action: models.ImportActionFailed, Error: "Invalid user data: " + err.Error()})
+		return
+	}
+
+	hashedPassword, err := hashing.Hash(req.Password)
+	if err != nil {
+		s.write(models.StreamImportResult{Line: line, Email: req.Email, Action: models.ImportActionFailed, Error: "Error processing password"})
+		return
+	}
+
+	s.batch = append(s.batch, streamImportRow{
+		line:      line,
+		req:       req,
+		canonical: utils.CanonicalEmail(req.Email),
+		password:  hashedPassword,
+	})
+}
+
+// checkImportedUser normalizes the email of an imported user and validates
+// the record like user creation does
+func checkImportedUser(req *models.CreateUserRequest) error {
+	req.Email = utils.NormalizeEmail(req.Email)
+	err := binding.Validator.ValidateStruct(req)
+	if err == nil {
+		err = validation.CheckNewUser(req.Name, req.Age)
+	}
+	if err == nil {
+		err = validation.CheckMetadata(req.Metadata)
+	}
+	if err == nil && req.Username != nil {
+		*req.Username = validation.NormalizeUsername(*req.Username)
+		err = validation.CheckUsername(*req.Username)
+	}
+	if err == nil {
+		err = validation.CheckPassword(req.Password)
+	}
+	return err
+}
+
+// flush inserts the queued rows and reports a result for each of them
+func (s *streamImport) flush() {
+	if len(s.batch) == 0 {
+		return
+	}
+	defer func() {
+		s.batch = s.batch[:0]
+		s.c.Writer.Flush()
+	}()
+	// Each batch gets the whole timeout, however long the stream is
+	ctx, cancel := s.h.withTimeout(s.c.Request.Context())
+	defer cancel()
+
+	canonical := make([]string, len(s.batch))
+	var usernames []string
+	for i, row := range s.batch {
+		canonical[i] = row.canonical
+		if row.req.Username != nil {
+			usernames = append(usernames, *row.req.Username)
+		}
+	}
+
+	// Skip emails that already exist, either in the table or earlier in this batch
+	existing := make(map[string]bool)
+	rows, err := s.h.db.QueryContext(ctx, "SELECT email_normalized FROM users WHERE email_normalized = ANY($1)", canonical)
+	if err != nil {
+		s.failBatch("Database error")
+		return
+	}
+	for rows.Next() {
+		var email string
+		if rows.Scan(&email) == nil {
+			existing[email] = true
+		}
+	}
+	rows.Close()
+
+	// Likewise for usernames
+	takenUsernames := make(map[string]bool)
+	if len(usernames) > 0 {
+		rows, err = s.h.db.QueryContext(ctx, "SELECT username FROM users WHERE username = ANY($1)", usernames)
+		if err != nil {
+			s.failBatch("Database error")
+			return
+		}
+		for rows.Next() {
+			var username string
+			if rows.Scan(&username) == nil {
+				takenUsernames[username] = true
+			}
+		}
+		rows.Close()
+	}
+
+	var pending []streamImportRow
+	for _, row := range s.batch {
+		if existing[row.canonical] {
+			s.write(models.StreamImportResult{Line: row.line, Email: row.req.Email, Action: models.ImportActionFailed, Error: "User with email " + row.req.Email + " already exists"})
+			continue
+		}
+		if username := row.req.Username; username != nil {
+			if takenUsernames[*username] {
+				s.write(models.StreamImportResult{Line: row.line, Email: row.req.Email, Action: models.ImportActionFailed, Error: "Username " + *username + " is already taken"})
+				continue
+			}
+			takenUsernames[*username] = true
+		}
+		existing[row.canonical] = true
+		pending = append(pending, row)
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	const columns = 12
+	now := time.Now()
+	placeholders := make([]string, len(pending))
+	args := make([]interface{}, 0, len(pending)*columns)
+	for i, row := range pending {
+		p := make([]string, columns)
+		for j := range p {
+			p[j] = fmt.Sprintf("$%d", i*columns+j+1)
+		}
+		placeholders[i] = "(" + strings.Join(p, ", ") + ")"
+		req := row.req
+		args = append(args, req.Name, req.Email, row.canonical, req.Username, row.password, req.Age,
+			req.IsActive == nil || *req.IsActive, req.ShowEmail != nil && *req.ShowEmail, req.ShowAge != nil && *req.ShowAge,
+			req.Metadata, now, now)
+	}
+
+	// A user created since the checks above is left out by ON CONFLICT
+	// instead of failing the whole batch. The audit entries commit with the
+	// users.
+	audit := userAudit{cl: clientOf(s.c), action: models.AuditUserImport}
+	var users []models.User
+	err = database.Transact(ctx, s.h.db, func(tx *sql.Tx) error {
+		users = users[:0]
+		rows, err := tx.QueryContext(ctx, `
+			INSERT INTO users (name, email, email_normalized, username, password, age, is_active, show_email, show_age, metadata, created_at, updated_at)
+			VALUES `+strings.Join(placeholders, ", ")+`
+			ON CONFLICT DO NOTHING
+			RETURNING `+userColumns, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var user models.User
+			if err := scanUser(rows, &user); err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		for _, user := range users {
+			if err := audit.hook(ctx, tx, user); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.batch = pending
+		s.failBatch("Error creating users")
+		return
+	}
+
+	inserted := make(map[string]models.User, len(users))
+	for _, user := range users {
+		s.h.publishAudit(ctx, audit, user)
+		inserted[utils.CanonicalEmail(user.Email)] = user
+	}
+	for _, row := range pending {
+		user, ok := inserted[row.canonical]
+		if !ok {
+			s.write(models.StreamImportResult{Line: row.line, Email: row.req.Email, Action: models.ImportActionFailed, Error: "User with email " + row.req.Email + " or its username already exists"})
+			continue
+		}
+		s.write(models.StreamImportResult{Line: row.line, Email: row.req.Email, Action: models.ImportActionCreate, ID: user.ID})
+	}
+}
+
+// failBatch reports every queued row as failed with the same message
+func (s *streamImport) failBatch(message string) {
+	for _, row := range s.batch {
+		s.write(models.StreamImportResult{Line: row.line, Email: row.req.Email, Action: models.ImportActionFailed, Error: message})
+	}
+}
+
+// write sends one result line and updates the summary
+func (s *streamImport) write(result models.StreamImportResult) {
+	s.summary.Total++
+	switch result.Action {
+	case models.ImportActionCreate:
+		s.summary.Created++
+	case models.ImportActionFailed:
+		s.summary.Failed++
+	}
+	s.enc.Encode(result)
+}
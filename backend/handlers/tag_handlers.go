@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/database"
+	"goapi/models"
+	"goapi/validation"
+)
+
+// existingUserParam parses the :id parameter of a user that exists and isn't
+// deleted, writing 400 or 404 otherwise
+func (h *Handler) existingUserParam(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID"))
+		return 0, false
+	}
+
+	var exists bool
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err = h.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL)", id).Scan(&exists)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return 0, false
+	}
+	if !exists {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeUserNotFound, "User with ID "+strconv.Itoa(id)+" not found"))
+		return 0, false
+	}
+	return id, true
+}
+
+// tagParam normalizes and validates the :tag parameter, writing 400 when it
+// isn't a valid tag
+func tagParam(c *gin.Context) (string, bool) {
+	tag := validation.NormalizeTag(c.Param("tag"))
+	if err := validation.CheckTag(tag); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Invalid tag: "+err.Error()))
+		return "", false
+	}
+	return tag, true
+}
+
+// @Summary List tags
+// @Description Lists the tags carried by at least one user that isn't deleted, with their user counts, by name
+// @Tags Users
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=[]models.TagResponse}
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/tags [get]
+func (h *Handler) ListTagsHandler(c *gin.Context) {
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT t.name, COUNT(u.id)
+		FROM tags t
+		JOIN user_tags ut ON ut.tag_id = t.id
+		JOIN users u ON u.id = ut.user_id AND u.deleted_at IS NULL
+		GROUP BY t.id
+		ORDER BY t.name
+	`)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving tags"))
+		return
+	}
+	defer rows.Close()
+
+	tags := []models.TagResponse{}
+	for rows.Next() {
+		var tag models.TagResponse
+		if err := rows.Scan(&tag.Name, &tag.UserCount); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving tags"))
+			return
+		}
+		tags = append(tags, tag)
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    tags,
+	})
+}
+
+// @Summary Get user tags
+// @Description Lists a user's tags by name
+// @Tags Users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.APIResponse{data=[]string}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/{id}/tags [get]
+func (h *Handler) GetUserTagsHandler(c *gin.Context) {
+	id, ok := h.existingUserParam(c)
+	if !ok {
+		return
+	}
+	h.respondWithUserTags(c, id, "")
+}
+
+// respondWithUserTags writes the names of the user's tags
+func (h *Handler) respondWithUserTags(c *gin.Context, id int, message string) {
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT t.name FROM user_tags ut JOIN tags t ON t.id = ut.tag_id
+		WHERE ut.user_id = $1
+		ORDER BY t.name
+	`, id)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving tags"))
+		return
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving tags"))
+			return
+		}
+		tags = append(tags, tag)
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    tags,
+		Message: message,
+	})
+}
+
+// @Summary Tag user
+// @Description Adds a tag to a user, creating the tag on first use. Tags are case-insensitive: 1 to 50 letters, digits, _ or -. Tagging a user again changes nothing. Needs the admin scope and ADMIN_ALLOWED_CIDRS.
+// @Tags Users
+// @Produce json
+// @Param id path int true "User ID"
+// @Param tag path string true "Tag, e.g. beta"
+// @Success 200 {object} models.APIResponse{data=[]string}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/{id}/tags/{tag} [put]
+func (h *Handler) TagUserHandler(c *gin.Context) {
+	tag, ok := tagParam(c)
+	if !ok {
+		return
+	}
+	id, ok := h.existingUserParam(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	if err := h.tagUser(ctx, clientOf(c), id, tag); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error tagging user"))
+		return
+	}
+
+	h.respondWithUserTags(c, id, "User tagged successfully")
+}
+
+// tagUser adds tag to the user, creating it if needed, and records cl's
+// audit entry when the user didn't have it yet
+func (h *Handler) tagUser(ctx context.Context, cl client, id int, tag string) error {
+	return database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		// The no-op update locks an existing tag so an untag can't remove it
+		// before the user is attached
+		var tagID int
+		now := time.Now()
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO tags (name, created_at) VALUES ($1, $2)
+			ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+			RETURNING id
+		`, tag, now).Scan(&tagID)
+		if err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO user_tags (user_id, tag_id, created_at) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, tag_id) DO NOTHING
+		`, id, tagID, now)
+		if err != nil {
+			return err
+		}
+		if added, err := result.RowsAffected(); err != nil || added == 0 {
+			return err
+		}
+		return cl.auditTx(ctx, tx, models.AuditUserTag, id, nil, gin.H{"tag": tag})
+	})
+}
+
+// @Summary Untag user
+// @Description Removes a tag from a user. A tag no user carries anymore is deleted. Needs the admin scope and ADMIN_ALLOWED_CIDRS.
+// @Tags Users
+// @Produce json
+// @Param id path int true "User ID"
+// @Param tag path string true "Tag, e.g. beta"
+// @Success 200 {object} models.APIResponse{data=[]string}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/{id}/tags/{tag} [delete]
+func (h *Handler) UntagUserHandler(c *gin.Context) {
+	tag, ok := tagParam(c)
+	if !ok {
+		return
+	}
+	id, ok := h.existingUserParam(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	removed, err := h.untagUser(ctx, clientOf(c), id, tag)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error untagging user"))
+		return
+	}
+	if !removed {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "User is not tagged "+tag))
+		return
+	}
+
+	h.respondWithUserTags(c, id, "User untagged successfully")
+}
+
+// untagUser removes tag from the user, dropping the tag once unused, with
+// cl's audit entry, and reports whether the user had it
+func (h *Handler) untagUser(ctx context.Context, cl client, id int, tag string) (bool, error) {
+	removed := false
+	err := database.Transact(ctx, h.db, func(tx *sql.Tx) error {
+		var tagID int
+		err := tx.QueryRowContext(ctx, `
+			DELETE FROM user_tags ut USING tags t
+			WHERE ut.tag_id = t.id AND ut.user_id = $1 AND t.name = $2
+			RETURNING t.id
+		`, id, tag).Scan(&tagID)
+		if err == sql.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		removed = true
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM tags
+			WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM user_tags WHERE tag_id = $1)
+		`, tagID)
+		if err != nil {
+			return err
+		}
+		return cl.auditTx(ctx, tx, models.AuditUserUntag, id, gin.H{"tag": tag}, nil)
+	})
+	return removed && err == nil, err
+}
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"goapi/apperr"
+	"goapi/auth"
+	"goapi/metrics"
+	"goapi/middleware"
+	"goapi/models"
+	userv1 "goapi/proto/user/v1"
+	"goapi/repository"
+	"goapi/services"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// UserGRPCServer serves the gRPC UserService with the same UserService and
+// helpers as the REST handlers, so both record the same audit entries and
+// publish the same events. Errors are returned as *apperr.Error for
+// middleware.GRPCErrors to turn into statuses.
+type UserGRPCServer struct {
+	userv1.UnimplementedUserServiceServer
+	// h does the work, as for the REST API
+	h *Handler
+	// adminNetworks may hard-delete users, like on the REST API
+	adminNetworks []*net.IPNet
+}
+
+// NewUserGRPCServer returns a UserGRPCServer backed by h, allowing hard
+// deletes from adminNetworks
+func NewUserGRPCServer(h *Handler, adminNetworks []*net.IPNet) *UserGRPCServer {
+	return &UserGRPCServer{h: h, adminNetworks: adminNetworks}
+}
+
+// Login checks an email and password and starts a session, like LoginHandler
+func (s *UserGRPCServer) Login(ctx context.Context, in *userv1.LoginRequest) (*userv1.LoginResponse, error) {
+	req := models.LoginRequest{Email: in.Email, Password: in.Password, Scope: in.Scope, Restore: in.Restore}
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		return nil, apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error())
+	}
+
+	ctx, cancel := s.h.withTimeout(ctx)
+	defer cancel()
+	resp, appErr := s.h.passwordLogin(ctx, grpcClient(ctx), req)
+	if appErr != nil {
+		return nil, appErr
+	}
+	user, err := userMessage(resp.User)
+	if err != nil {
+		return nil, err
+	}
+	return &userv1.LoginResponse{
+		User:      user,
+		Token:     resp.Token,
+		Scopes:    resp.Scopes,
+		ExpiresAt: timestamppb.New(resp.ExpiresAt),
+	}, nil
+}
+
+// CreateUser creates a user, like Handler.CreateUser
+func (s *UserGRPCServer) CreateUser(ctx context.Context, in *userv1.CreateUserRequest) (*userv1.User, error) {
+	req := models.CreateUserRequest{
+		Name:      in.Name,
+		Email:     in.Email,
+		Password:  in.Password,
+		Username:  in.Username,
+		Age:       intPointer(in.Age),
+		IsActive:  in.IsActive,
+		ShowEmail: &in.ShowEmail,
+		ShowAge:   &in.ShowAge,
+	}
+	if in.Metadata != nil {
+		req.Metadata = in.Metadata.AsMap()
+	}
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		return nil, apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error())
+	}
+
+	audit := userAudit{cl: grpcClient(ctx), action: models.AuditUserCreate}
+	user, err := s.h.users.Create(ctx, newUserFrom(req), audit.hook)
+	if err != nil {
+		return nil, userError(err, "Error creating user")
+	}
+
+	s.h.publishAudit(ctx, audit, user)
+	return userMessage(grpcUserResponse(ctx, user))
+}
+
+// GetUser returns a user by ID
+func (s *UserGRPCServer) GetUser(ctx context.Context, in *userv1.GetUserRequest) (*userv1.User, error) {
+	user, err := s.getUser(ctx, in.Id)
+	if err != nil {
+		return nil, err
+	}
+	return userMessage(grpcUserResponse(ctx, user))
+}
+
+// ListUsers returns a page of users, newest first, like GET /api/users
+// without filters
+func (s *UserGRPCServer) ListUsers(ctx context.Context, in *userv1.ListUsersRequest) (*userv1.ListUsersResponse, error) {
+	page, pageSize := int(in.Page), int(in.PageSize)
+	if page == 0 {
+		page = 1
+	}
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	if page < 1 {
+		return nil, apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "page must be a positive number")
+	}
+	if pageSize < 1 || pageSize > maxPageSize {
+		return nil, apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "page_size must be between 1 and "+strconv.Itoa(maxPageSize))
+	}
+
+	total, err := s.h.users.Count(ctx, repository.UserFilter{})
+	if err != nil {
+		return nil, apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving users").Wrap(err)
+	}
+	users, err := s.h.users.List(ctx, repository.ListQuery{
+		Columns: strings.Split(userColumns, ", "),
+		Limit:   pageSize,
+		Offset:  (page - 1) * pageSize,
+	})
+	if err != nil {
+		return nil, apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving users").Wrap(err)
+	}
+
+	pagination := newPagination(page, pageSize, total)
+	resp := &userv1.ListUsersResponse{
+		Users:      make([]*userv1.User, len(users)),
+		Page:       int32(page),
+		PageSize:   int32(pageSize),
+		Total:      int64(total),
+		TotalPages: int32(pagination.TotalPages),
+	}
+	for i, user := range users {
+		if resp.Users[i], err = userMessage(grpcUserResponse(ctx, user)); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// UpdateUser changes the fields named in the update mask. The mask is turned
+// into the JSON Merge Patch PATCH /api/users/{id} would get, so both apply
+// the same rules. The caller must send the version it read, so it can't
+// overwrite a change it hasn't seen.
+func (s *UserGRPCServer) UpdateUser(ctx context.Context, in *userv1.UpdateUserRequest) (*userv1.User, error) {
+	if in.User == nil {
+		return nil, apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: user is required")
+	}
+	patch, appErr := maskedUserPatch(in.User, in.UpdateMask.GetPaths())
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	if in.Version == 0 {
+		return nil, apperr.New(http.StatusPreconditionRequired, apperr.CodePreconditionRequired, "version is required; send the version of the user as read")
+	}
+
+	existing, appErr := s.getUser(ctx, in.User.Id)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if int(in.Version) != existing.Version {
+		return nil, apperr.New(http.StatusPreconditionFailed, apperr.CodeVersionConflict, "User has changed since it was read; reload it and try again")
+	}
+
+	audit := userAudit{cl: grpcClient(ctx), action: models.AuditUserUpdate, before: &existing}
+	updated, err := s.h.users.Update(ctx, existing, patch.apply, audit.hook)
+	if err != nil {
+		return nil, userError(err, "Error updating user")
+	}
+
+	s.h.publishAudit(ctx, audit, updated)
+	return userMessage(grpcUserResponse(ctx, updated))
+}
+
+// DeleteUser soft-deletes a user, or removes it for good with hard, like
+// Handler.DeleteUser
+func (s *UserGRPCServer) DeleteUser(ctx context.Context, in *userv1.DeleteUserRequest) (*userv1.DeleteUserResponse, error) {
+	cl := grpcClient(ctx)
+	if in.Hard {
+		if claims, _ := auth.FromContext(ctx); !auth.HasScope(claims.Scopes, auth.ScopeAdmin) {
+			return nil, apperr.New(http.StatusForbidden, apperr.CodeInsufficientScope, "Token is missing the "+auth.ScopeAdmin+" scope")
+		}
+		if !middleware.IPAllowed(s.adminNetworks, cl.ip) {
+			return nil, apperr.New(http.StatusForbidden, apperr.CodeForbidden, "Access denied")
+		}
+	}
+
+	id := int(in.Id)
+	audit := userAudit{cl: cl, action: models.AuditUserDelete}
+	if in.Hard {
+		audit.action = models.AuditUserHardDelete
+	}
+	user, err := s.h.users.Delete(ctx, id, in.Hard, audit.hook)
+	if errors.Is(err, services.ErrNotFound) {
+		return nil, apperr.New(http.StatusNotFound, apperr.CodeUserNotFound, "User with ID "+strconv.Itoa(id)+" not found")
+	} else if err != nil {
+		return nil, apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error deleting user").Wrap(err)
+	}
+
+	s.h.publishAudit(ctx, audit, user)
+	metrics.Deletions.Inc()
+	return &userv1.DeleteUserResponse{}, nil
+}
+
+// getUser loads the user with the given ID
+func (s *UserGRPCServer) getUser(ctx context.Context, id int64) (models.User, *apperr.Error) {
+	user, err := s.h.users.Get(ctx, int(id), nil)
+	if errors.Is(err, services.ErrNotFound) {
+		return models.User{}, apperr.New(http.StatusNotFound, apperr.CodeUserNotFound, "User with ID "+strconv.FormatInt(id, 10)+" not found")
+	} else if err != nil {
+		return models.User{}, apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving user").Wrap(err)
+	}
+	return user, nil
+}
+
+// maskedUserPatch returns the merge patch setting the fields of user named
+// in paths. A named username or age that is unset becomes null, clearing it.
+// The gateway derives the mask from the PATCH body, naming each metadata key
+// as metadata.<key> and the id when the body repeats it; those are taken as
+// metadata and ignored.
+func maskedUserPatch(user *userv1.User, paths []string) (*userMergePatch, *apperr.Error) {
+	if len(paths) == 0 {
+		return nil, apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: update_mask names no fields")
+	}
+
+	members := make(map[string]interface{}, len(paths))
+	for _, path := range paths {
+		if strings.HasPrefix(path, "metadata.") {
+			path = "metadata"
+		}
+		switch path {
+		case "id":
+		case "name":
+			members[path] = user.Name
+		case "email":
+			members[path] = user.Email
+		case "username":
+			members[path] = user.Username
+		case "age":
+			members[path] = user.Age
+		case "is_active":
+			members[path] = user.IsActive
+		case "show_email":
+			members[path] = user.ShowEmail
+		case "show_age":
+			members[path] = user.ShowAge
+		case "metadata":
+			members[path] = user.Metadata.AsMap()
+		default:
+			return nil, apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: update_mask names unknown field "+strconv.Quote(path))
+		}
+	}
+
+	body, err := json.Marshal(members)
+	if err != nil {
+		return nil, apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error())
+	}
+	return parseUserMergePatch(body)
+}
+
+// grpcClient returns the client of a gRPC call
+func grpcClient(ctx context.Context) client {
+	cl := client{ip: middleware.GRPCPeerIP(ctx), country: middleware.GRPCCountry(ctx)}
+	if claims, ok := auth.FromContext(ctx); ok {
+		cl.userID = claims.UserID
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		// The gateway passes on the HTTP client's as grpcgateway-user-agent
+		if values := md.Get("grpcgateway-user-agent"); len(values) > 0 {
+			cl.userAgent = values[0]
+		} else if values := md.Get("user-agent"); len(values) > 0 {
+			cl.userAgent = values[0]
+		}
+	}
+	return cl
+}
+
+// grpcUserResponse is userResponse for gRPC calls
+func grpcUserResponse(ctx context.Context, user models.User) models.UserResponse {
+	claims, _ := auth.FromContext(ctx)
+	return userView(claims.Scopes, claims.UserID, user)
+}
+
+// userMessage converts a user to its protobuf message
+func userMessage(user models.UserResponse) (*userv1.User, error) {
+	metadata, err := structpb.NewStruct(user.Metadata)
+	if err != nil {
+		return nil, apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error encoding user metadata").Wrap(err)
+	}
+	msg := &userv1.User{
+		Id:               int64(user.ID),
+		Name:             user.Name,
+		Email:            user.Email,
+		Username:         user.Username,
+		IsActive:         user.IsActive,
+		ShowEmail:        user.ShowEmail,
+		ShowAge:          user.ShowAge,
+		FlaggedForReview: user.Flagged,
+		Metadata:         metadata,
+		Version:          int32(user.Version),
+		CreatedAt:        timestamppb.New(user.CreatedAt),
+		UpdatedAt:        timestamppb.New(user.UpdatedAt),
+	}
+	if user.Age != nil {
+		age := int32(*user.Age)
+		msg.Age = &age
+	}
+	if user.LastLoginAt != nil {
+		msg.LastLoginAt = timestamppb.New(*user.LastLoginAt)
+	}
+	if user.LastSeenAt != nil {
+		msg.LastSeenAt = timestamppb.New(*user.LastSeenAt)
+	}
+	return msg, nil
+}
+
+// intPointer converts an optional protobuf int32
+func intPointer(n *int32) *int {
+	if n == nil {
+		return nil
+	}
+	value := int(*n)
+	return &value
+}
@@ -1,17 +1,63 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
-	"goapi/database"
+	"goapi/apperr"
+	"goapi/auth"
+	"goapi/metrics"
 	"goapi/models"
+	"goapi/repository"
+	"goapi/services"
 )
 
+// userColumns lists the user columns selected by queries, in userFields order
+const userColumns = repository.UserColumns
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner = repository.RowScanner
+
+// userFields returns scan destinations matching userColumns
+func userFields(user *models.User) []interface{} {
+	return repository.UserFields(user)
+}
+
+// scanUser scans a row selected with userColumns into user
+func scanUser(row rowScanner, user *models.User) error {
+	return repository.ScanUser(row, user)
+}
+
+// Handler serves the API routes and runs the background jobs they queue.
+// Users are created and changed through a UserService, which holds the
+// rules; everything else is read and written on db.
+type Handler struct {
+	db    *sql.DB
+	users *services.UserService
+	// timeout bounds the database work of a request, 0 for no limit
+	timeout time.Duration
+}
+
+// NewHandler returns a Handler using db, with users for creating and
+// changing users. A request's own database work gives up after timeout, or
+// never when it is zero.
+func NewHandler(db *sql.DB, users *services.UserService, timeout time.Duration) *Handler {
+	return &Handler{db: db, users: users, timeout: timeout}
+}
+
+// withTimeout bounds database work under ctx by the handler timeout
+func (h *Handler) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if h.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, h.timeout)
+}
+
 // @Summary Create a new user
 // @Description Creates a new user with the provided information
 // @Tags Users
@@ -19,315 +65,488 @@ import (
 // @Produce json
 // @Param user body models.CreateUserRequest true "User data"
 // @Success 201 {object} models.APIResponse
-// @Failure 400 {object} models.APIResponse
-// @Failure 409 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Security BearerAuth
 // @Router /users [post]
-func CreateUserHandler(c *gin.Context) {
+func (h *Handler) CreateUser(c *gin.Context) {
 	var req models.CreateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Message: "Invalid request data: " + err.Error(),
-		})
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
 		return
 	}
 
-	// Check if user already exists
-	var existingID int
-	err := database.GetDB().QueryRow("SELECT id FROM users WHERE email = $1", req.Email).Scan(&existingID)
-	if err == nil {
-		c.JSON(http.StatusConflict, models.APIResponse{
-			Success: false,
-			Message: "User with email " + req.Email + " already exists",
-		})
-		return
-	} else if err != sql.ErrNoRows {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Message: "Database error",
-		})
+	audit := userAudit{cl: clientOf(c), action: models.AuditUserCreate}
+	user, err := h.users.Create(c.Request.Context(), newUserFrom(req), audit.hook)
+	if err != nil {
+		respondWithUserError(c, err, "Error creating user")
 		return
 	}
 
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Message: "Error processing password",
-		})
+	h.publishAudit(c.Request.Context(), audit, user)
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data:    userResponse(c, user),
+	})
+}
+
+// newUserFrom returns the user req asks to create, active unless it says
+// otherwise
+func newUserFrom(req models.CreateUserRequest) services.NewUser {
+	return services.NewUser{
+		Name:      req.Name,
+		Email:     req.Email,
+		Username:  req.Username,
+		Password:  req.Password,
+		Age:       req.Age,
+		IsActive:  req.IsActive == nil || *req.IsActive,
+		ShowEmail: req.ShowEmail != nil && *req.ShowEmail,
+		ShowAge:   req.ShowAge != nil && *req.ShowAge,
+		Metadata:  req.Metadata,
+	}
+}
+
+// @Summary Get all users
+// @Description Retrieves users one page at a time, newest first unless sort is given. By default pages are numbered (page, page_size) and pagination carries total, total_pages and the next/prev page numbers. Passing cursor, empty for the first page, switches to keyset paging: pagination carries page_size and next_cursor, the opaque token for the following page (null on the last), and no totals; it stays fast on large tables and doesn't skip or repeat users when rows are added between requests. When ids is given, only those users are returned, in request order, with unknown IDs listed in missing, and paging does not apply.
+// @Tags Users
+// @Produce json
+// @Param ids query string false "Comma-separated user IDs, e.g. 1,2,3"
+// @Param q query string false "Search names and emails, tolerating typos; results are ranked by similarity unless sort is given (numbered paging only)"
+// @Param is_active query bool false "Only active or only inactive users"
+// @Param age_min query int false "Minimum age, inclusive"
+// @Param age_max query int false "Maximum age, inclusive"
+// @Param created_after query string false "Created at or after, RFC 3339 or YYYY-MM-DD"
+// @Param created_before query string false "Created before, RFC 3339 or YYYY-MM-DD"
+// @Param inactive_since query string false "Not seen since, e.g. 90d, 12h, RFC 3339 or YYYY-MM-DD; users never seen count from their creation"
+// @Param tag query string false "Tag the users carry, e.g. beta; repeat to require several"
+// @Param metadata.key query string false "Metadata value, e.g. metadata.department=eng; repeat with other keys, values compare as text"
+// @Param sort query string false "Comma-separated columns, - for descending, e.g. -created_at,name; one of id, name, email, age, is_active, created_at, updated_at, last_login_at, last_seen_at (numbered paging only)" default(-created_at)
+// @Param page query int false "Page number, starting at 1 (numbered paging)" default(1)
+// @Param cursor query string false "next_cursor from the previous page, empty for the first page (keyset paging)"
+// @Param page_size query int false "Users per page, at most 100" default(20)
+// @Param fields query string false "Comma-separated fields to return, e.g. id,name,email; all by default"
+// @Success 200 {object} models.APIResponse{data=[]models.UserResponse,pagination=models.Pagination} "With cursor, pagination is {page_size, next_cursor} instead"
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /users [get]
+func (h *Handler) GetAllUsers(c *gin.Context) {
+	fields, ok := parseUserFields(c)
+	if !ok {
+		return
+	}
+	if ids := c.Query("ids"); ids != "" {
+		h.getUsersByIDs(c, ids, fields)
+		return
+	}
+	filter, ok := parseUserFilters(c)
+	if !ok {
+		return
+	}
+	if cursor, ok := c.GetQuery("cursor"); ok {
+		if c.Query("sort") != "" {
+			c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "sort cannot be combined with cursor; keyset paging is always newest first"))
+			return
+		}
+		h.listUsersByCursor(c, filter, cursor, fields)
 		return
 	}
 
-	// Set default values
-	isActive := true
-	if req.IsActive != nil {
-		isActive = *req.IsActive
+	page, pageSize, ok := parsePage(c)
+	if !ok {
+		return
+	}
+	sort, ok := parseUserSort(c)
+	if !ok {
+		return
 	}
 
-	// Insert user
-	var user models.User
-	now := time.Now()
-	err = database.GetDB().QueryRow(`
-		INSERT INTO users (name, email, password, age, is_active, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id, name, email, age, is_active, created_at, updated_at
-	`, req.Name, req.Email, string(hashedPassword), req.Age, isActive, now, now).
-		Scan(&user.ID, &user.Name, &user.Email, &user.Age, &user.IsActive, &user.CreatedAt, &user.UpdatedAt)
+	total, err := h.users.Count(c.Request.Context(), filter)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving users"))
+		return
+	}
 
+	users, err := h.users.List(c.Request.Context(), repository.ListQuery{
+		Columns: fields.columns(),
+		Filter:  filter,
+		Sort:    sort,
+		Limit:   pageSize,
+		Offset:  (page - 1) * pageSize,
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Message: "Error creating user",
-		})
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving users"))
 		return
 	}
 
-	c.JSON(http.StatusCreated, models.APIResponse{
-		Success: true,
-		Data:    user.ToUserResponse(),
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:    true,
+		Data:       fields.responses(c, users),
+		Pagination: newPagination(page, pageSize, total),
 	})
 }
 
-// @Summary Get all users
-// @Description Retrieves a list of all users
-// @Tags Users
-// @Produce json
-// @Success 200 {object} models.APIResponse
-// @Router /users [get]
-func GetAllUsersHandler(c *gin.Context) {
-	rows, err := database.GetDB().Query(`
-		SELECT id, name, email, age, is_active, created_at, updated_at
-		FROM users
-		ORDER BY created_at DESC
-	`)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Message: "Error retrieving users",
-		})
+// listUsersByCursor serves GET /users?cursor=..., the keyset-paginated
+// listing ordered by (created_at, id) descending
+func (h *Handler) listUsersByCursor(c *gin.Context, filter repository.UserFilter, cursor string, fields userFieldSet) {
+	pageSize, ok := parsePageSize(c)
+	if !ok {
 		return
 	}
-	defer rows.Close()
 
-	var users []models.UserResponse
-	for rows.Next() {
-		var user models.User
-		err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.Age, &user.IsActive, &user.CreatedAt, &user.UpdatedAt)
+	if cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, models.APIResponse{
-				Success: false,
-				Message: "Error scanning user data",
-			})
+			c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Invalid cursor"))
 			return
 		}
-		users = append(users, user.ToUserResponse())
+		filter.Before = &repository.Position{CreatedAt: createdAt, ID: id}
+	}
+
+	// One extra row tells whether another page follows; the cursor is built
+	// from the last row's created_at and id
+	users, err := h.users.List(c.Request.Context(), repository.ListQuery{
+		Columns: fields.columns("created_at", "id"),
+		Filter:  filter,
+		Sort:    []repository.SortKey{{Column: "created_at", Descending: true}},
+		Limit:   pageSize + 1,
+	})
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving users"))
+		return
+	}
+
+	pagination := &models.CursorPagination{PageSize: pageSize}
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		last := users[pageSize-1]
+		next := encodeCursor(last.CreatedAt, last.ID)
+		pagination.NextCursor = &next
 	}
 
 	c.JSON(http.StatusOK, models.APIResponse{
-		Success: true,
-		Data:    users,
+		Success:    true,
+		Data:       fields.responses(c, users),
+		Pagination: pagination,
 	})
 }
 
+// toUserResponses converts users for a listing, never returning null
+func toUserResponses(c *gin.Context, users []models.User) []models.UserResponse {
+	responses := make([]models.UserResponse, len(users))
+	for i, user := range users {
+		responses[i] = userResponse(c, user)
+	}
+	return responses
+}
+
+// userResponse converts user for output as the caller's token may see it
+func userResponse(c *gin.Context, user models.User) models.UserResponse {
+	scopes, _ := c.Get("scopes")
+	granted, _ := scopes.([]string)
+	return userView(granted, c.GetInt("userID"), user)
+}
+
+// userView converts user for a caller with scopes: admins get the admin-only
+// fields, callers who can change users and users reading themselves the
+// full user, and callers who may only read have other users' private email
+// and age masked
+func userView(scopes []string, callerID int, user models.User) models.UserResponse {
+	switch {
+	case auth.HasScope(scopes, auth.ScopeAdmin):
+		return user.ToAdminUserResponse()
+	case !readsOnly(scopes) || user.ID == callerID:
+		return user.ToUserResponse()
+	default:
+		return user.ToRestrictedUserResponse()
+	}
+}
+
+// readsOnly reports whether scopes let the caller read users but neither
+// change them nor administer
+func readsOnly(scopes []string) bool {
+	return !auth.HasScope(scopes, auth.ScopeAdmin) && !auth.HasScope(scopes, auth.ScopeUsersWrite)
+}
+
 // @Summary Get user by ID
 // @Description Retrieves a specific user by their ID
 // @Tags Users
 // @Produce json
 // @Param id path int true "User ID"
+// @Param fields query string false "Comma-separated fields to return, e.g. id,name,email; all by default"
+// @Param If-None-Match header string false "ETag from an earlier read; 304 if the user is unchanged"
+// @Param If-Modified-Since header string false "Last-Modified from an earlier read; 304 if the user is unchanged"
 // @Success 200 {object} models.APIResponse
-// @Failure 404 {object} models.APIResponse
+// @Success 304 "The user is unchanged"
+// @Header 200 {string} ETag "User version and latest activity, for If-Match and If-None-Match"
+// @Header 200 {string} Last-Modified "When the user last changed, signed in or was seen"
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
 // @Router /users/{id} [get]
-func GetUserByIDHandler(c *gin.Context) {
+func (h *Handler) GetUserByID(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Message: "Invalid user ID",
-		})
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID"))
 		return
 	}
 
-	var user models.User
-	err = database.GetDB().QueryRow(`
-		SELECT id, name, email, age, is_active, created_at, updated_at
-		FROM users WHERE id = $1
-	`, id).Scan(&user.ID, &user.Name, &user.Email, &user.Age, &user.IsActive, &user.CreatedAt, &user.UpdatedAt)
+	h.getUser(c, id)
+}
 
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, models.APIResponse{
-			Success: false,
-			Message: "User with ID " + strconv.Itoa(id) + " not found",
-		})
+// getUser writes the user with the given ID, limited to the fields selected
+// with ?fields=
+func (h *Handler) getUser(c *gin.Context, id int) {
+	fields, ok := parseUserFields(c)
+	if !ok {
+		return
+	}
+
+	// These are always needed for the ETag and Last-Modified
+	user, err := h.users.Get(c.Request.Context(), id, fields.columns("version", "updated_at", "last_login_at", "last_seen_at"))
+
+	if errors.Is(err, services.ErrNotFound) {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeUserNotFound, "User with ID "+strconv.Itoa(id)+" not found"))
 		return
 	} else if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Message: "Error retrieving user",
-		})
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving user"))
 		return
 	}
 
+	if notModifiedUser(c, user) {
+		return
+	}
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Data:    user.ToUserResponse(),
+		Data:    fields.response(c, user),
 	})
 }
 
-// @Summary Update user
-// @Description Updates an existing user's information
+// @Summary Replace user
+// @Description Replaces a user's information. name and email are required; omitted optional fields are reset to their defaults (no username or age, active, hidden email and age, empty metadata). Use PATCH to change only some fields. Send the ETag of the user as read in If-Match to be refused with 412 if someone changed it since.
 // @Tags Users
 // @Accept json
 // @Produce json
 // @Param id path int true "User ID"
-// @Param user body models.UpdateUserRequest true "User update data"
-// @Success 200 {object} models.APIResponse
-// @Failure 400 {object} models.APIResponse
-// @Failure 404 {object} models.APIResponse
-// @Failure 409 {object} models.APIResponse
+// @Param If-Match header string false "ETag from an earlier read"
+// @Param user body models.ReplaceUserRequest true "User data"
+// @Success 200 {object} models.APIResponse{data=models.UserResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Security BearerAuth
 // @Router /users/{id} [put]
-func UpdateUserHandler(c *gin.Context) {
+func (h *Handler) ReplaceUser(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Message: "Invalid user ID",
-		})
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID"))
 		return
 	}
 
-	var req models.UpdateUserRequest
+	var req models.ReplaceUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Message: "Invalid request data: " + err.Error(),
-		})
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
 		return
 	}
 
-	// Check if user exists
-	var existingUser models.User
-	err = database.GetDB().QueryRow(`
-		SELECT id, name, email, age, is_active, created_at, updated_at
-		FROM users WHERE id = $1
-	`, id).Scan(&existingUser.ID, &existingUser.Name, &existingUser.Email, &existingUser.Age, &existingUser.IsActive, &existingUser.CreatedAt, &existingUser.UpdatedAt)
-
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, models.APIResponse{
-			Success: false,
-			Message: "User with ID " + strconv.Itoa(id) + " not found",
-		})
-		return
-	} else if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Message: "Error retrieving user",
-		})
-		return
-	}
+	h.updateUser(c, id, func(user *models.User) error {
+		replaceUserFields(user, req)
+		user.IsActive = req.IsActive == nil || *req.IsActive
+		return nil
+	})
+}
 
-	// Check email uniqueness if email is being updated
-	if req.Email != nil && *req.Email != existingUser.Email {
-		var existingID int
-		err := database.GetDB().QueryRow("SELECT id FROM users WHERE email = $1", *req.Email).Scan(&existingID)
-		if err == nil {
-			c.JSON(http.StatusConflict, models.APIResponse{
-				Success: false,
-				Message: "Email " + *req.Email + " is already taken",
-			})
-			return
-		} else if err != sql.ErrNoRows {
-			c.JSON(http.StatusInternalServerError, models.APIResponse{
-				Success: false,
-				Message: "Database error",
-			})
-			return
-		}
+// replaceUserFields sets every field a replacement covers except is_active
+func replaceUserFields(user *models.User, req models.ReplaceUserRequest) {
+	user.Name = req.Name
+	user.Email = req.Email
+	user.Username = req.Username
+	user.Age = req.Age
+	user.ShowEmail = req.ShowEmail
+	user.ShowAge = req.ShowAge
+	user.Metadata = req.Metadata
+	if user.Metadata == nil {
+		user.Metadata = models.Metadata{}
 	}
+}
 
-	// Update fields
-	if req.Name != nil {
-		existingUser.Name = *req.Name
+// @Summary Update user
+// @Description Applies a JSON Merge Patch (RFC 7396) to a user: omitted fields are kept, null clears username or age, and metadata is merged key by key, with null removing a key. name, email, is_active, show_email and show_age can't be null. Send the ETag of the user as read in If-Match to be refused with 412 if someone changed it since.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param If-Match header string false "ETag from an earlier read"
+// @Param user body models.UpdateUserRequest true "Merge patch"
+// @Success 200 {object} models.APIResponse{data=models.UserResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/{id} [patch]
+func (h *Handler) UpdateUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID"))
+		return
 	}
-	if req.Email != nil {
-		existingUser.Email = *req.Email
+
+	patch, ok := bindUserMergePatch(c)
+	if !ok {
+		return
 	}
-	if req.Age != nil {
-		existingUser.Age = req.Age
+
+	h.updateUser(c, id, patch.apply)
+}
+
+// updateUser loads the user with the given ID, lets change modify it, then
+// validates and stores the result. An error from change is invalid request
+// data. The write only succeeds if nobody changed the user in between.
+func (h *Handler) updateUser(c *gin.Context, id int, change func(user *models.User) error) {
+	// Check if user exists
+	existingUser, err := h.users.Get(c.Request.Context(), id, nil)
+
+	if errors.Is(err, services.ErrNotFound) {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeUserNotFound, "User with ID "+strconv.Itoa(id)+" not found"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving user"))
+		return
 	}
-	if req.IsActive != nil {
-		existingUser.IsActive = *req.IsActive
+	if !ifMatchUser(c, existingUser) {
+		return
 	}
-	existingUser.UpdatedAt = time.Now()
-
-	// Update in database
-	_, err = database.GetDB().Exec(`
-		UPDATE users 
-		SET name = $1, email = $2, age = $3, is_active = $4, updated_at = $5
-		WHERE id = $6
-	`, existingUser.Name, existingUser.Email, existingUser.Age, existingUser.IsActive, existingUser.UpdatedAt, id)
 
+	audit := userAudit{cl: clientOf(c), action: models.AuditUserUpdate, before: &existingUser}
+	updated, err := h.users.Update(c.Request.Context(), existingUser, change, audit.hook)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Message: "Error updating user",
-		})
+		respondWithUserError(c, err, "Error updating user")
 		return
 	}
 
+	h.publishAudit(c.Request.Context(), audit, updated)
+	c.Header("ETag", userETag(updated))
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Data:    existingUser.ToUserResponse(),
+		Data:    userResponse(c, updated),
 	})
 }
 
 // @Summary Delete user
-// @Description Deletes a user by their ID
+// @Description Soft-deletes a user by their ID: the user disappears from every query and is signed out, but can be restored. With hard=true, which needs the admin scope and ADMIN_ALLOWED_CIDRS, the user is removed for good, even if already soft-deleted.
 // @Tags Users
 // @Produce json
 // @Param id path int true "User ID"
+// @Param hard query bool false "Remove the user permanently (admin only)"
 // @Success 200 {object} models.APIResponse
-// @Failure 404 {object} models.APIResponse
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
 // @Router /users/{id} [delete]
-func DeleteUserHandler(c *gin.Context) {
+func (h *Handler) DeleteUser(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Message: "Invalid user ID",
-		})
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID"))
 		return
 	}
 
-	// Check if user exists
-	var userID int
-	err = database.GetDB().QueryRow("SELECT id FROM users WHERE id = $1", id).Scan(&userID)
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, models.APIResponse{
-			Success: false,
-			Message: "User with ID " + strconv.Itoa(id) + " not found",
-		})
+	h.deleteUser(c, id, c.Query("hard") == "true")
+}
+
+// deleteUser soft-deletes the user with the given ID, signing out their
+// sessions. A hard delete removes the row, soft-deleted or not, along with
+// everything that references it.
+func (h *Handler) deleteUser(c *gin.Context, id int, hard bool) {
+	audit := userAudit{cl: clientOf(c), action: models.AuditUserDelete}
+	if hard {
+		audit.action = models.AuditUserHardDelete
+	}
+	user, err := h.users.Delete(c.Request.Context(), id, hard, audit.hook)
+	if errors.Is(err, services.ErrNotFound) {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeUserNotFound, "User with ID "+strconv.Itoa(id)+" not found"))
 		return
 	} else if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Message: "Error checking user existence",
-		})
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error deleting user"))
 		return
 	}
 
-	// Delete user
-	_, err = database.GetDB().Exec("DELETE FROM users WHERE id = $1", id)
+	h.publishAudit(c.Request.Context(), audit, user)
+	metrics.Deletions.Inc()
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "User deleted successfully",
+	})
+}
+
+// @Summary Restore deleted user
+// @Description Undoes a soft delete. The user's sessions stay signed out. Anonymized users can't be restored.
+// @Tags Users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.APIResponse{data=models.UserResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/{id}/restore [post]
+func (h *Handler) RestoreUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Message: "Error deleting user",
-		})
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid user ID"))
+		return
+	}
+
+	audit := userAudit{cl: clientOf(c), action: models.AuditUserUndelete}
+	user, err := h.users.Restore(c.Request.Context(), id, audit.hook)
+	if errors.Is(err, services.ErrNotFound) {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "No deleted user with ID "+strconv.Itoa(id)))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error restoring user"))
 		return
 	}
 
+	h.publishAudit(c.Request.Context(), audit, user)
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Message: "User deleted successfully",
+		Data:    userResponse(c, user),
+		Message: "User restored",
 	})
-} 
\ No newline at end of file
+} 
+
+// respondWithUserError writes the response for an error from UserService,
+// with message for unexpected ones
+func respondWithUserError(c *gin.Context, err error, message string) {
+	c.Error(userError(err, message))
+}
+
+// userError is the client error for an error from UserService, with message
+// for unexpected ones
+func userError(err error, message string) *apperr.Error {
+	var invalid *services.ValidationError
+	var taken *services.TakenError
+	switch {
+	case errors.As(err, &invalid):
+		return apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+invalid.Error())
+	case errors.As(err, &taken) && errors.Is(err, services.ErrUsernameTaken):
+		return apperr.New(http.StatusConflict, apperr.CodeUsernameTaken, "Username "+taken.Value+" is already taken")
+	case errors.As(err, &taken):
+		return apperr.New(http.StatusConflict, apperr.CodeEmailTaken, "Email "+taken.Value+" is already taken")
+	case errors.Is(err, services.ErrVersionConflict):
+		return apperr.New(http.StatusConflict, apperr.CodeVersionConflict, "User was changed by another request; reload it and try again")
+	default:
+		return apperr.New(http.StatusInternalServerError, apperr.CodeInternal, message)
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"goapi/apperr"
+	"goapi/models"
+	"goapi/services"
+	"goapi/utils"
+	"goapi/validation"
+)
+
+// @Summary Get user by username
+// @Description Retrieves a user by their username, ignoring case
+// @Tags Users
+// @Produce json
+// @Param username path string true "Username"
+// @Param fields query string false "Comma-separated fields to return, e.g. id,name,email; all by default"
+// @Param If-None-Match header string false "ETag from an earlier read; 304 if the user is unchanged"
+// @Param If-Modified-Since header string false "Last-Modified from an earlier read; 304 if the user is unchanged"
+// @Success 200 {object} models.APIResponse{data=models.UserResponse}
+// @Success 304 "The user is unchanged"
+// @Header 200 {string} ETag "User version and latest activity, for If-Match and If-None-Match"
+// @Header 200 {string} Last-Modified "When the user last changed, signed in or was seen"
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /users/by-username/{username} [get]
+func (h *Handler) GetUserByUsername(c *gin.Context) {
+	username := validation.NormalizeUsername(c.Param("username"))
+
+	id, err := h.users.IDByUsername(c.Request.Context(), username)
+	if errors.Is(err, services.ErrNotFound) {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeUserNotFound, "User "+username+" not found"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving user"))
+		return
+	}
+
+	h.getUser(c, id)
+}
+
+// @Summary Check username and email availability
+// @Description Reports whether a username and/or email could be used to sign up, for live validation in the signup form. Values held by deleted users stay taken.
+// @Tags Authentication
+// @Produce json
+// @Param username query string false "Username to check"
+// @Param email query string false "Email to check"
+// @Success 200 {object} models.APIResponse{data=models.AvailabilityResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 429 {object} models.Problem
+// @Router /users/check-availability [get]
+func (h *Handler) CheckAvailability(c *gin.Context) {
+	username, wantUsername := c.GetQuery("username")
+	email, wantEmail := c.GetQuery("email")
+	if !wantUsername && !wantEmail {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Give a username or email to check"))
+		return
+	}
+
+	var resp models.AvailabilityResponse
+	if wantUsername {
+		username = validation.NormalizeUsername(username)
+		result := &models.AvailabilityResult{Available: true}
+		if err := validation.CheckUsername(username); err != nil {
+			result = &models.AvailabilityResult{Reason: err.Error()}
+		} else if taken, err := h.users.UsernameTaken(c.Request.Context(), username); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+			return
+		} else if taken {
+			result = &models.AvailabilityResult{Reason: "username is already taken"}
+		}
+		resp.Username = result
+	}
+	if wantEmail {
+		email = utils.NormalizeEmail(email)
+		result := &models.AvailabilityResult{Available: true}
+		err := binding.Validator.ValidateStruct(struct {
+			Email string `binding:"email"`
+		}{email})
+		if err != nil {
+			result = &models.AvailabilityResult{Reason: "email is not a valid address"}
+		} else if taken, err := h.users.EmailTaken(c.Request.Context(), email); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+			return
+		} else if taken {
+			result = &models.AvailabilityResult{Reason: "email is already registered"}
+		}
+		resp.Email = result
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    resp,
+	})
+}
+
+// checkUsername normalizes and validates *username in place, if set, writing
+// 400 if it's invalid or 409 if a user other than exceptID has it
+func (h *Handler) checkUsername(c *gin.Context, username *string, exceptID int) bool {
+	if username == nil {
+		return true
+	}
+	*username = validation.NormalizeUsername(*username)
+	if err := validation.CheckUsername(*username); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return false
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	taken, err := h.usernameTaken(ctx, *username, exceptID)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return false
+	}
+	if taken {
+		c.Error(apperr.New(http.StatusConflict, apperr.CodeUsernameTaken, "Username "+*username+" is already taken"))
+		return false
+	}
+	return true
+}
+
+// usernameTaken reports whether a user other than exceptID, deleted or not,
+// has the normalized username
+func (h *Handler) usernameTaken(ctx context.Context, username string, exceptID int) (bool, error) {
+	var id int
+	err := h.db.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1 AND id <> $2", username, exceptID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
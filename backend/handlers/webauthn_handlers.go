@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"goapi/apperr"
+	"goapi/auth"
+	"goapi/metrics"
+	"goapi/models"
+)
+
+// webAuthnUser adapts a user and their stored credentials to the webauthn library
+type webAuthnUser struct {
+	user        models.User
+	credentials []webauthn.Credential
+}
+
+// WebAuthnID is the user handle stored on the authenticator; it is the user
+// ID so discoverable logins can find the account
+func (u webAuthnUser) WebAuthnID() []byte                         { return []byte(strconv.Itoa(u.user.ID)) }
+func (u webAuthnUser) WebAuthnName() string                       { return u.user.Email }
+func (u webAuthnUser) WebAuthnDisplayName() string                { return u.user.Name }
+func (u webAuthnUser) WebAuthnIcon() string                       { return "" }
+func (u webAuthnUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+// @Summary Start passkey registration
+// @Description Returns the options to pass to navigator.credentials.create() and the ceremony ID to send with the result.
+// @Tags Authentication
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=models.WebAuthnBeginResponse}
+// @Failure 401 {object} models.Problem
+// @Failure 503 {object} models.Problem
+// @Security BearerAuth
+// @Router /auth/webauthn/register/begin [post]
+func (h *Handler) BeginWebAuthnRegistrationHandler(c *gin.Context) {
+	wa, ok := webAuthnConfig(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	user, err := h.loadWebAuthnUser(ctx, c.GetInt("userID"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return
+	}
+
+	// Skip authenticators that already hold a credential for this user
+	exclude := make([]protocol.CredentialDescriptor, len(user.credentials))
+	for i, cred := range user.credentials {
+		exclude[i] = cred.Descriptor()
+	}
+
+	options, session, err := wa.BeginRegistration(user,
+		webauthn.WithExclusions(exclude),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error starting registration"))
+		return
+	}
+
+	h.startWebAuthnCeremony(c, auth.WebAuthnCeremony{UserID: user.user.ID, Session: *session}, options)
+}
+
+// @Summary Finish passkey registration
+// @Description Verifies the result of navigator.credentials.create() and stores the credential's public key.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param ceremony query string true "Ceremony ID from the begin step"
+// @Param name query string false "Label for the passkey"
+// @Param credential body object true "PublicKeyCredential from the browser"
+// @Success 201 {object} models.APIResponse{data=models.WebAuthnCredentialResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 503 {object} models.Problem
+// @Security BearerAuth
+// @Router /auth/webauthn/register/finish [post]
+func (h *Handler) FinishWebAuthnRegistrationHandler(c *gin.Context) {
+	wa, ok := webAuthnConfig(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	ceremony, ok, err := auth.TakeWebAuthnCeremony(ctx, h.db, c.Query("ceremony"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error finishing ceremony"))
+		return
+	}
+	if !ok || ceremony.UserID != c.GetInt("userID") {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Unknown or expired ceremony"))
+		return
+	}
+
+	user, err := h.loadWebAuthnUser(ctx, ceremony.UserID)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Database error"))
+		return
+	}
+
+	cred, err := wa.FinishRegistration(user, ceremony.Session, c.Request)
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Invalid credential: "+webAuthnError(err)))
+		return
+	}
+
+	name := strings.TrimSpace(c.Query("name"))
+	if name == "" {
+		name = describeDevice(c.Request.UserAgent())
+	}
+	if len(name) > 100 {
+		name = name[:100]
+	}
+
+	transports := make([]string, len(cred.Transport))
+	for i, t := range cred.Transport {
+		transports[i] = string(t)
+	}
+
+	resp := models.WebAuthnCredentialResponse{Name: name, CreatedAt: time.Now()}
+	err = h.db.QueryRowContext(ctx, `
+		INSERT INTO webauthn_credentials
+			(user_id, credential_id, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible, backup_state, name, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (credential_id) DO NOTHING
+		RETURNING id
+	`, user.user.ID, cred.ID, cred.PublicKey, cred.AttestationType, strings.Join(transports, ","), cred.Authenticator.AAGUID,
+		int64(cred.Authenticator.SignCount), cred.Flags.BackupEligible, cred.Flags.BackupState, name, resp.CreatedAt).Scan(&resp.ID)
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusConflict, apperr.CodeConflict, "This passkey is already registered"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error saving passkey"))
+		return
+	}
+	h.recordAudit(c, models.AuditPasskeyRegister, user.user.ID, nil, resp)
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data:    resp,
+		Message: "Passkey registered",
+	})
+}
+
+// @Summary Start passkey login
+// @Description Returns the options to pass to navigator.credentials.get() and the ceremony ID to send with the result. No email is needed; the authenticator offers the passkeys it holds.
+// @Tags Authentication
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=models.WebAuthnBeginResponse}
+// @Failure 429 {object} models.Problem
+// @Failure 503 {object} models.Problem
+// @Router /auth/webauthn/login/begin [post]
+func (h *Handler) BeginWebAuthnLoginHandler(c *gin.Context) {
+	wa, ok := webAuthnConfig(c)
+	if !ok {
+		return
+	}
+
+	options, session, err := wa.BeginDiscoverableLogin()
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error starting login"))
+		return
+	}
+
+	h.startWebAuthnCeremony(c, auth.WebAuthnCeremony{Session: *session}, options)
+}
+
+// @Summary Finish passkey login
+// @Description Verifies the result of navigator.credentials.get() and returns an access token.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param ceremony query string true "Ceremony ID from the begin step"
+// @Param credential body object true "PublicKeyCredential from the browser"
+// @Success 200 {object} models.APIResponse{data=models.AuthResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 429 {object} models.Problem
+// @Failure 503 {object} models.Problem
+// @Router /auth/webauthn/login/finish [post]
+func (h *Handler) FinishWebAuthnLoginHandler(c *gin.Context) {
+	wa, ok := webAuthnConfig(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	ceremony, ok, err := auth.TakeWebAuthnCeremony(ctx, h.db, c.Query("ceremony"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error finishing ceremony"))
+		return
+	}
+	if !ok || ceremony.UserID != 0 {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "Unknown or expired ceremony"))
+		return
+	}
+
+	var user webAuthnUser
+	cred, err := wa.FinishDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		id, err := strconv.Atoi(string(userHandle))
+		if err != nil {
+			return nil, err
+		}
+		user, err = h.loadWebAuthnUser(ctx, id)
+		return user, err
+	}, ceremony.Session, c.Request)
+	if err == nil && cred.Authenticator.CloneWarning {
+		err = errors.New("authenticator may have been cloned")
+	}
+	if err != nil {
+		metrics.FailedLogins.Inc()
+		h.recordLoginEvent(c, user.user.ID, user.user.Email, models.LoginMethodPasskey, models.LoginFailureInvalidPasskey)
+		c.Error(apperr.New(http.StatusUnauthorized, apperr.CodeInvalidCredentials, "Passkey login failed"))
+		return
+	}
+
+	h.db.ExecContext(ctx, `
+		UPDATE webauthn_credentials SET sign_count = $1, backup_state = $2, last_used_at = $3
+		WHERE credential_id = $4
+	`, int64(cred.Authenticator.SignCount), cred.Flags.BackupState, time.Now(), cred.ID)
+
+	if !user.user.IsActive {
+		h.recordLoginEvent(c, user.user.ID, user.user.Email, models.LoginMethodPasskey, models.LoginFailureAccountDisabled)
+		c.Error(disabledError())
+		return
+	}
+
+	if h.refuseOverSessionLimit(c, user.user.ID, user.user.Email, models.LoginMethodPasskey) {
+		return
+	}
+	h.recordLoginEvent(c, user.user.ID, user.user.Email, models.LoginMethodPasskey, "")
+	metrics.Logins.Inc()
+	h.respondWithToken(c, http.StatusOK, user.user)
+}
+
+// webAuthnConfig returns the relying party, writing 503 when passkeys are
+// not configured
+func webAuthnConfig(c *gin.Context) (*webauthn.WebAuthn, bool) {
+	wa, err := auth.WebAuthn()
+	if err != nil || wa == nil {
+		c.Error(apperr.New(http.StatusServiceUnavailable, apperr.CodeNotConfigured, "Passkeys are not configured"))
+		return nil, false
+	}
+	return wa, true
+}
+
+// startWebAuthnCeremony remembers the ceremony under a random ID and writes
+// the browser options with it
+func (h *Handler) startWebAuthnCeremony(c *gin.Context, ceremony auth.WebAuthnCeremony, options interface{}) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error starting ceremony"))
+		return
+	}
+	id := hex.EncodeToString(buf)
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	if err := auth.RememberWebAuthnCeremony(ctx, h.db, id, ceremony); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error starting ceremony"))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    models.WebAuthnBeginResponse{Ceremony: id, Options: options},
+	})
+}
+
+// loadWebAuthnUser reads a user with their registered credentials
+func (h *Handler) loadWebAuthnUser(ctx context.Context, userID int) (webAuthnUser, error) {
+	var u webAuthnUser
+	err := scanUser(h.db.QueryRowContext(ctx, `
+		SELECT `+userColumns+` FROM users WHERE id = $1 AND deleted_at IS NULL
+	`, userID), &u.user)
+	if err != nil {
+		return u, err
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT credential_id, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible, backup_state
+		FROM webauthn_credentials WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		return u, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cred       webauthn.Credential
+			transports string
+			signCount  int64
+		)
+		err := rows.Scan(&cred.ID, &cred.PublicKey, &cred.AttestationType, &transports, &cred.Authenticator.AAGUID,
+			&signCount, &cred.Flags.BackupEligible, &cred.Flags.BackupState)
+		if err != nil {
+			return u, err
+		}
+		for _, t := range strings.Split(transports, ",") {
+			if t != "" {
+				cred.Transport = append(cred.Transport, protocol.AuthenticatorTransport(t))
+			}
+		}
+		cred.Authenticator.SignCount = uint32(signCount)
+		u.credentials = append(u.credentials, cred)
+	}
+	return u, rows.Err()
+}
+
+// webAuthnError returns the most useful description of a verification error
+func webAuthnError(err error) string {
+	var pErr *protocol.Error
+	if errors.As(err, &pErr) && pErr.DevInfo != "" {
+		return pErr.DevInfo
+	}
+	return err.Error()
+}
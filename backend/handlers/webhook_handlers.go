@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/database"
+	"goapi/events"
+	"goapi/models"
+	"goapi/realtime"
+	"goapi/webhooks"
+)
+
+const (
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 500
+)
+
+// webhookEvents maps the audited user changes to the webhook event they
+// publish. Imports are handled in publishAuditEvent, as they create or
+// update.
+var webhookEvents = map[string]string{
+	models.AuditUserCreate:     webhooks.EventUserCreated,
+	models.AuditUserUpdate:     webhooks.EventUserUpdated,
+	models.AuditUserUndelete:   webhooks.EventUserUpdated,
+	models.AuditUserRestore:    webhooks.EventUserUpdated,
+	models.AuditUserRevert:     webhooks.EventUserUpdated,
+	models.AuditUserDeactivate: webhooks.EventUserUpdated,
+	models.AuditUserDelete:     webhooks.EventUserDeleted,
+	models.AuditUserHardDelete: webhooks.EventUserDeleted,
+	models.AuditUserAnonymize:  webhooks.EventUserDeleted,
+}
+
+// auditEvent returns the event for an audited change and its data, if it
+// has one. The data is the user as it is after the change, or as it was
+// before it for deletes; bulk jobs only give the fields they changed.
+func auditEvent(action string, userID int, before, after interface{}) (string, gin.H, bool) {
+	event, ok := webhookEvents[action]
+	if action == models.AuditUserImport {
+		event, ok = webhooks.EventUserCreated, true
+		if before != nil {
+			event = webhooks.EventUserUpdated
+		}
+	}
+	if !ok {
+		return "", nil, false
+	}
+
+	data := gin.H{"user_id": userID}
+	if event == webhooks.EventUserDeleted {
+		after = before
+	}
+	if after != nil {
+		data["user"] = after
+	}
+	return event, data, true
+}
+
+// enqueueAuditEvent writes the event for an audited change, if it has one,
+// to the event broker's outbox in tx, the transaction making the change
+func enqueueAuditEvent(ctx context.Context, tx *sql.Tx, action string, userID int, before, after interface{}) error {
+	event, data, ok := auditEvent(action, userID, before, after)
+	if !ok {
+		return nil
+	}
+	return events.Enqueue(ctx, tx, event, strconv.Itoa(userID), data)
+}
+
+// publishAuditEvent publishes the event for an audited change, if it has
+// one, to webhooks and WebSocket clients, and wakes the event relay. It
+// runs once the change has committed, so it goes ahead even if the client
+// of ctx has gone.
+func (h *Handler) publishAuditEvent(ctx context.Context, action string, userID int, before, after interface{}) {
+	event, data, ok := auditEvent(action, userID, before, after)
+	if !ok {
+		return
+	}
+	ctx, cancel := h.withTimeout(context.WithoutCancel(ctx))
+	defer cancel()
+	webhooks.Publish(ctx, h.db, event, strconv.Itoa(userID), data)
+	events.Wake()
+	if err := realtime.Publish(ctx, h.db, event, userID, data); err != nil {
+		log.Printf("Error publishing %s to WebSocket clients: %v", event, err)
+	}
+}
+
+// @Summary List webhooks
+// @Description Lists the registered webhooks, without their secrets
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=[]models.Webhook}
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/webhooks [get]
+func (h *Handler) ListWebhooksHandler(c *gin.Context) {
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := h.db.QueryContext(ctx, `SELECT id, url, events, created_at FROM webhooks ORDER BY id`)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving webhooks"))
+		return
+	}
+	defer rows.Close()
+
+	hooks := []models.Webhook{}
+	for rows.Next() {
+		var hook models.Webhook
+		if err := rows.Scan(&hook.ID, &hook.URL, database.Array(&hook.Events), &hook.CreatedAt); err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving webhooks"))
+			return
+		}
+		hooks = append(hooks, hook)
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    hooks,
+	})
+}
+
+// @Summary Register webhook
+// @Description Registers an http(s) URL to be POSTed the given user events: user.created, user.updated, user.deleted and user.login, as CloudEvents 1.0 in structured JSON. Each delivery is signed with the secret in X-Webhook-Signature; when no secret is given one is generated. URLs resolving to loopback, private or link-local addresses are refused, and deliveries never connect to such addresses. The secret is only returned here.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param webhook body models.WebhookRequest true "Webhook"
+// @Success 201 {object} models.APIResponse{data=models.Webhook}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/webhooks [post]
+func (h *Handler) CreateWebhookHandler(c *gin.Context) {
+	var req models.WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return
+	}
+	if err := webhooks.CheckURL(c.Request.Context(), req.URL); err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Invalid request data: "+err.Error()))
+		return
+	}
+	if req.Secret == "" {
+		req.Secret = webhooks.NewSecret()
+	}
+
+	hook := models.Webhook{URL: req.URL, Secret: req.Secret}
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err := h.db.QueryRowContext(ctx, `
+		INSERT INTO webhooks (url, secret, events, created_at) VALUES ($1, $2, $3, $4)
+		RETURNING id, events, created_at
+	`, req.URL, req.Secret, uniqueStrings(req.Events), time.Now()).Scan(&hook.ID, database.Array(&hook.Events), &hook.CreatedAt)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error registering webhook"))
+		return
+	}
+
+	h.recordAudit(c, models.AuditWebhookCreate, 0, nil, gin.H{"webhook_id": hook.ID, "url": hook.URL, "events": hook.Events})
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data:    hook,
+		Message: "Webhook registered successfully",
+	})
+}
+
+// @Summary Delete webhook
+// @Description Unregisters a webhook. Its pending deliveries are dropped along with its delivery log.
+// @Tags Admin
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/webhooks/{id} [delete]
+func (h *Handler) DeleteWebhookHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid webhook ID"))
+		return
+	}
+
+	var hookURL string
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	err = h.db.QueryRowContext(ctx, `DELETE FROM webhooks WHERE id = $1 RETURNING url`, id).Scan(&hookURL)
+	if err == sql.ErrNoRows {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "Webhook with ID "+strconv.Itoa(id)+" not found"))
+		return
+	} else if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error deleting webhook"))
+		return
+	}
+
+	h.recordAudit(c, models.AuditWebhookDelete, 0, gin.H{"webhook_id": id, "url": hookURL}, nil)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Webhook deleted successfully",
+	})
+}
+
+// @Summary List webhook deliveries
+// @Description Lists a webhook's deliveries, newest first, with their status, attempts and the last attempt's response status or error. Failed attempts are retried with exponential backoff until WEBHOOK_MAX_ATTEMPTS.
+// @Tags Admin
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Param status query string false "Only deliveries with this status" Enums(pending, succeeded, failed)
+// @Param limit query int false "Number of deliveries to return (max 500)" default(50)
+// @Success 200 {object} models.APIResponse{data=[]models.WebhookDelivery}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Security BearerAuth
+// @Router /admin/webhooks/{id}/deliveries [get]
+func (h *Handler) ListWebhookDeliveriesHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidID, "Invalid webhook ID"))
+		return
+	}
+	status := c.Query("status")
+	if status != "" && status != webhooks.StatusPending && status != webhooks.StatusSucceeded && status != webhooks.StatusFailed {
+		c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "status must be pending, succeeded or failed"))
+		return
+	}
+	limit := defaultDeliveriesLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxDeliveriesLimit {
+			c.Error(apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxDeliveriesLimit)))
+			return
+		}
+		limit = n
+	}
+
+	var exists bool
+	ctx, cancel := h.withTimeout(c.Request.Context())
+	defer cancel()
+	if err := h.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = $1)`, id).Scan(&exists); err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving deliveries"))
+		return
+	}
+	if !exists {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "Webhook with ID "+strconv.Itoa(id)+" not found"))
+		return
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT id, webhook_id, event_id, event, payload, status, attempts,
+			CASE WHEN status = 'pending' THEN next_attempt_at END,
+			response_status, last_error, created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1 AND ($2::text = '' OR status = $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`, id, status, limit)
+	if err != nil {
+		c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving deliveries"))
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var d models.WebhookDelivery
+		var payload []byte
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.Event, &payload, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.ResponseStatus, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+		if err != nil {
+			c.Error(apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error retrieving deliveries"))
+			return
+		}
+		d.Payload = payload
+		deliveries = append(deliveries, d)
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    deliveries,
+	})
+}
+
+// uniqueStrings returns values without repeats, in their first order
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,41 @@
+package hashing
+
+import (
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BcryptHasher hashes passwords with bcrypt at the given cost
+type BcryptHasher struct {
+	Cost int
+}
+
+// Hash returns the bcrypt hash of password
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost())
+	return string(hash), err
+}
+
+// Recognizes reports whether encoded is a bcrypt hash
+func (BcryptHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// Verify reports whether password matches the bcrypt hash
+func (BcryptHasher) Verify(encoded, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+}
+
+// NeedsRehash reports whether the hash was made with a lower cost
+func (h BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < h.cost()
+}
+
+func (h BcryptHasher) cost() int {
+	if h.Cost < bcrypt.MinCost || h.Cost > bcrypt.MaxCost {
+		return bcrypt.DefaultCost
+	}
+	return h.Cost
+}
@@ -0,0 +1,59 @@
+package hashing
+
+import "sync"
+
+// Hasher hashes and verifies passwords with one algorithm
+type Hasher interface {
+	// Hash returns the encoded hash of password
+	Hash(password string) (string, error)
+	// Recognizes reports whether encoded was produced by this algorithm
+	Recognizes(encoded string) bool
+	// Verify reports whether password matches encoded
+	Verify(encoded, password string) bool
+	// NeedsRehash reports whether encoded uses weaker parameters than the hasher
+	NeedsRehash(encoded string) bool
+}
+
+var (
+	mu      sync.RWMutex
+	current Hasher = BcryptHasher{Cost: 10}
+	// known lists every algorithm stored hashes may use, so passwords keep
+	// working after PASSWORD_HASH_ALGORITHM changes
+	known = []Hasher{BcryptHasher{}, Argon2idHasher{}}
+)
+
+// SetHasher replaces the hasher used for new hashes
+func SetHasher(h Hasher) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = h
+}
+
+func active() Hasher {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Hash hashes password with the configured algorithm
+func Hash(password string) (string, error) {
+	return active().Hash(password)
+}
+
+// Verify reports whether password matches encoded, whichever supported
+// algorithm produced it
+func Verify(encoded, password string) bool {
+	for _, h := range known {
+		if h.Recognizes(encoded) {
+			return h.Verify(encoded, password)
+		}
+	}
+	return false
+}
+
+// NeedsRehash reports whether encoded should be replaced by a fresh hash
+// because it uses another algorithm or weaker parameters than configured
+func NeedsRehash(encoded string) bool {
+	h := active()
+	return !h.Recognizes(encoded) || h.NeedsRehash(encoded)
+}
@@ -0,0 +1,107 @@
+// Package health answers liveness and readiness probes. Liveness only says
+// the process is serving requests; readiness runs checks against the
+// server's dependencies and reports each one's status and latency.
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Overall and per-check statuses
+const (
+	StatusOK = "ok"
+	// StatusDegraded means only optional checks failed
+	StatusDegraded = "degraded"
+	StatusFail     = "fail"
+)
+
+// Check is one dependency to probe
+type Check struct {
+	Name string
+	// Optional checks are reported but don't make the server unready, for
+	// dependencies it can work without for a while
+	Optional bool
+	Run      func(ctx context.Context) error
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+	Optional  bool    `json:"optional,omitempty"`
+}
+
+// Report is the outcome of all checks
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Run runs the checks concurrently, each given at most timeout
+func Run(ctx context.Context, timeout time.Duration, checks []Check) Report {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			start := time.Now()
+			err := check.Run(ctx)
+			results[i] = Result{
+				Status:    StatusOK,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+				Optional:  check.Optional,
+			}
+			if err != nil {
+				results[i].Status = StatusFail
+				results[i].Error = err.Error()
+			}
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+	for i, check := range checks {
+		report.Checks[check.Name] = results[i]
+		if results[i].Status == StatusOK {
+			continue
+		}
+		if !check.Optional {
+			report.Status = StatusFail
+		} else if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+// Liveness answers 200 as long as the process can serve requests at all.
+// Orchestrators restart the server when it stops answering.
+func Liveness() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": StatusOK})
+	}
+}
+
+// Readiness runs the checks on every request and answers 200 with the
+// report, or 503 when a required check failed so load balancers stop
+// sending traffic until it recovers
+func Readiness(timeout time.Duration, checks ...Check) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := Run(c.Request.Context(), timeout, checks)
+		status := http.StatusOK
+		if report.Status == StatusFail {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, report)
+	}
+}
@@ -0,0 +1,71 @@
+package heartbeat
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"goapi/jobs"
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// Start pings url every interval from a background goroutine. When check
+// returns an error the failure endpoint (url + "/fail") is pinged instead,
+// so the monitor alerts without waiting for the grace period.
+func Start(url string, interval time.Duration, check func() error) {
+	go func() {
+		for {
+			if err := check(); err != nil {
+				log.Println("Heartbeat check failed:", err)
+				PingFailure(url)
+			} else {
+				Ping(url)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// Ping reports a successful beat
+func Ping(url string) {
+	send(url)
+}
+
+// JobObserver returns a jobs observer that pings url after each job of
+// jobTypes succeeds, and the failure endpoint after one fails for good.
+// Pings are sent in the background so workers don't wait on the monitor.
+func JobObserver(url string, jobTypes ...string) jobs.Observer {
+	watched := make(map[string]bool, len(jobTypes))
+	for _, jobType := range jobTypes {
+		watched[jobType] = true
+	}
+	return func(job *jobs.Job, err error) {
+		if !watched[job.Type] {
+			return
+		}
+		if err != nil {
+			go PingFailure(url)
+		} else {
+			go Ping(url)
+		}
+	}
+}
+
+// PingFailure reports a failed beat
+func PingFailure(url string) {
+	send(strings.TrimRight(url, "/") + "/fail")
+}
+
+func send(url string) {
+	resp, err := client.Get(url)
+	if err != nil {
+		log.Println("Heartbeat ping failed:", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		log.Printf("Heartbeat ping to %s returned %s", url, resp.Status)
+	}
+}
@@ -0,0 +1,343 @@
+// Package jobs runs work outside of requests, such as sending emails,
+// generating exports and purging deleted accounts. Enqueue stores a job in
+// the database and background workers run it with the handler registered
+// for its type, retrying failures with exponential backoff as the type's
+// Policy allows. Jobs survive restarts and are shared out between replicas;
+// finished ones are kept for Config.Retention as their log.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Job statuses
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Policy is how the jobs of a type are tried. Zero fields take the
+// defaults: 5 attempts, 30s doubling up to 1h between them, 5m each.
+type Policy struct {
+	// MaxAttempts is how many tries a job gets before it is failed
+	MaxAttempts int
+	// Backoff is the pause after the first failure; it doubles after every
+	// further one, up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout bounds each attempt
+	Timeout time.Duration
+}
+
+// withDefaults fills in the zero fields of p
+func (p Policy) withDefaults() Policy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 5
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = 30 * time.Second
+	}
+	if p.MaxBackoff < p.Backoff {
+		p.MaxBackoff = max(time.Hour, p.Backoff)
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = 5 * time.Minute
+	}
+	return p
+}
+
+// retryDelay is the pause after the given number of failed attempts
+func (p Policy) retryDelay(attempts int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < attempts && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxBackoff)
+}
+
+// Job is a job as handed to its handler
+type Job struct {
+	ID      int64
+	Type    string
+	Payload json.RawMessage
+	// Attempt is 1 on the first try
+	Attempt int
+	// Result and ResultType may be set by the handler; they are stored with
+	// the job once it succeeds, e.g. an export for download
+	Result     []byte
+	ResultType string
+}
+
+// Handler runs a job. Returning an error fails the attempt, to be retried
+// unless the error is Permanent or the job is out of attempts.
+type Handler func(ctx context.Context, job *Job) error
+
+// Config tunes the workers
+type Config struct {
+	// DB holds the jobs table
+	DB *sql.DB
+	// Workers is how many jobs this replica runs at once
+	Workers int
+	// PollInterval is how often due jobs and retries are looked for
+	PollInterval time.Duration
+	// Retention is how long finished jobs are kept
+	Retention time.Duration
+}
+
+// Options tune an enqueued job
+type Options struct {
+	// RunAt delays the job until then; zero runs it right away
+	RunAt time.Time
+	// UniqueKey, when set, skips the job while an unfinished one has the
+	// same key, so a periodic task is never queued twice
+	UniqueKey string
+}
+
+// Querier is a *sql.DB or *sql.Tx
+type Querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type registration struct {
+	policy  Policy
+	handler Handler
+}
+
+var (
+	registry = map[string]registration{}
+	// observers are told of every job that finished
+	observers []Observer
+	// wake tells an idle worker that Enqueue queued something
+	wake = make(chan struct{}, 1)
+)
+
+// Observer is told when a job finishes: err is nil once it succeeded, or
+// the error of its last attempt once it failed for good. Retried attempts
+// aren't reported. Observers run on the worker, so they should be quick.
+type Observer func(job *Job, err error)
+
+// Observe adds an observer of finished jobs. Observers are added before
+// Start.
+func Observe(observer Observer) {
+	observers = append(observers, observer)
+}
+
+// Register sets the handler and policy of jobType. Types are registered
+// before Start; a replica only runs the types it knows.
+func Register(jobType string, policy Policy, handler Handler) {
+	registry[jobType] = registration{policy: policy.withDefaults(), handler: handler}
+}
+
+// Enqueue stores a job of the registered jobType with payload, JSON encoded,
+// and returns its ID, 0 when UniqueKey skipped it
+func Enqueue(ctx context.Context, db *sql.DB, jobType string, payload interface{}, opts Options) (int64, error) {
+	id, err := EnqueueTx(ctx, db, jobType, payload, opts)
+	if id != 0 {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	return id, err
+}
+
+// EnqueueTx is Enqueue on q, typically a transaction so the job only exists
+// if the change it follows is committed. Workers find it on their next poll.
+func EnqueueTx(ctx context.Context, q Querier, jobType string, payload interface{}, opts Options) (int64, error) {
+	reg, ok := registry[jobType]
+	if !ok {
+		return 0, fmt.Errorf("unknown job type %q", jobType)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	runAt := opts.RunAt
+	if runAt.IsZero() {
+		runAt = now
+	}
+	uniqueKey := sql.NullString{String: opts.UniqueKey, Valid: opts.UniqueKey != ""}
+
+	var id int64
+	err = q.QueryRowContext(ctx, `
+		INSERT INTO jobs (type, payload, max_attempts, run_at, unique_key, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (unique_key) WHERE status IN ('pending', 'running') DO NOTHING
+		RETURNING id
+	`, jobType, body, reg.policy.MaxAttempts, runAt, uniqueKey, now).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// Retry queues a failed job to run again with a fresh set of attempts. It
+// reports whether the job was failed.
+func Retry(ctx context.Context, db *sql.DB, id int64) (bool, error) {
+	result, err := db.ExecContext(ctx, `
+		UPDATE jobs SET status = $2, attempts = 0, run_at = $3, finished_at = NULL
+		WHERE id = $1 AND status = $4
+	`, id, StatusPending, time.Now(), StatusFailed)
+	if err != nil {
+		return false, err
+	}
+	retried, _ := result.RowsAffected()
+	if retried > 0 {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	return retried > 0, nil
+}
+
+// Permanent marks err as not worth retrying, e.g. for a payload that can't
+// be decoded; the job fails straight away
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Start runs due jobs from cfg.Workers background goroutines, as soon as
+// they are enqueued and every cfg.PollInterval for retries and delayed
+// jobs, and removes finished jobs older than cfg.Retention every hour
+func Start(cfg Config) {
+	types := make([]string, 0, len(registry))
+	var lease time.Duration
+	for jobType, reg := range registry {
+		types = append(types, jobType)
+		lease = max(lease, reg.policy.Timeout)
+	}
+	// A job whose worker died is picked up again once this is over
+	lease += time.Minute
+
+	for i := 0; i < cfg.Workers; i++ {
+		go func() {
+			ticker := time.NewTicker(cfg.PollInterval)
+			for {
+				for runNext(cfg.DB, types, lease) {
+					// Keep going while jobs are due
+				}
+				select {
+				case <-ticker.C:
+				case <-wake:
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for {
+			if removed, err := prune(cfg.DB, cfg.Retention); err != nil {
+				log.Println("Error removing finished jobs:", err)
+			} else if removed > 0 {
+				log.Printf("Removed %d finished jobs", removed)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+// runNext claims the next due job of one of types and runs it. It reports
+// whether there was one. The claim leases the job until it is recorded, so
+// other workers and replicas skip it.
+func runNext(db *sql.DB, types []string, lease time.Duration) bool {
+	now := time.Now()
+	job := &Job{}
+	err := db.QueryRow(`
+		UPDATE jobs SET status = $3, attempts = attempts + 1, run_at = $2, started_at = $1
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE status IN ('pending', 'running') AND run_at <= $1 AND type = ANY($4)
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, type, payload, attempts
+	`, now, now.Add(lease), StatusRunning, types).Scan(&job.ID, &job.Type, &job.Payload, &job.Attempt)
+	if err == sql.ErrNoRows {
+		return false
+	} else if err != nil {
+		log.Println("Error claiming job:", err)
+		return false
+	}
+
+	reg := registry[job.Type]
+	runErr := run(reg, job)
+	record(db, job, reg.policy, runErr)
+	return true
+}
+
+// run calls the job's handler within its timeout, turning a panic into an
+// error
+func run(reg registration, job *Job) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), reg.policy.Timeout)
+	defer cancel()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+	return reg.handler(ctx, job)
+}
+
+// record stores the outcome of an attempt, scheduling a retry after a
+// failure until the job is out of attempts
+func record(db *sql.DB, job *Job, policy Policy, runErr error) {
+	now := time.Now()
+	var permanent permanentError
+
+	var err error
+	finished := true
+	switch {
+	case runErr == nil:
+		_, err = db.Exec(`
+			UPDATE jobs
+			SET status = $2, last_error = NULL, result = $3, result_type = $4, finished_at = $5
+			WHERE id = $1
+		`, job.ID, StatusSucceeded, job.Result, sql.NullString{String: job.ResultType, Valid: job.ResultType != ""}, now)
+	case errors.As(runErr, &permanent) || job.Attempt >= policy.MaxAttempts:
+		log.Printf("Warning: job %d (%s) failed after %d attempts: %v", job.ID, job.Type, job.Attempt, runErr)
+		_, err = db.Exec(`
+			UPDATE jobs SET status = $2, last_error = $3, finished_at = $4 WHERE id = $1
+		`, job.ID, StatusFailed, runErr.Error(), now)
+	default:
+		finished = false
+		_, err = db.Exec(`
+			UPDATE jobs SET status = $2, last_error = $3, run_at = $4 WHERE id = $1
+		`, job.ID, StatusPending, runErr.Error(), now.Add(policy.retryDelay(job.Attempt)))
+	}
+	if err != nil {
+		log.Println("Error recording job:", err)
+	}
+
+	if finished {
+		for _, observer := range observers {
+			observer(job, runErr)
+		}
+	}
+}
+
+// prune deletes the jobs that finished more than retention ago
+func prune(db *sql.DB, retention time.Duration) (int64, error) {
+	result, err := db.Exec(`
+		DELETE FROM jobs WHERE status IN ('succeeded', 'failed') AND finished_at < $1
+	`, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,86 @@
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+// Config holds the failed-login thresholds. Accounts are tracked in the
+// database by the login handler; client IPs are tracked here in memory.
+type Config struct {
+	// MaxFailures is the number of failures within Window that locks an account
+	MaxFailures int
+	// IPMaxFailures is the number of failures within Window that locks a client IP
+	IPMaxFailures int
+	Window        time.Duration
+	// Duration is how long a lock lasts
+	Duration time.Duration
+}
+
+var (
+	config = Config{
+		MaxFailures:   5,
+		IPMaxFailures: 20,
+		Window:        15 * time.Minute,
+		Duration:      15 * time.Minute,
+	}
+	mu       sync.Mutex
+	failures = map[string][]time.Time{}
+	ipLocks  = map[string]time.Time{}
+)
+
+// SetConfig replaces the active configuration
+func SetConfig(c Config) {
+	mu.Lock()
+	defer mu.Unlock()
+	config = c
+}
+
+// Current returns the active configuration
+func Current() Config {
+	mu.Lock()
+	defer mu.Unlock()
+	return config
+}
+
+// IPLockedFor returns how much longer ip is locked out, or zero
+func IPLockedFor(ip string) time.Duration {
+	mu.Lock()
+	defer mu.Unlock()
+
+	until, ok := ipLocks[ip]
+	if !ok {
+		return 0
+	}
+	remaining := time.Until(until)
+	if remaining <= 0 {
+		delete(ipLocks, ip)
+		return 0
+	}
+	return remaining
+}
+
+// RecordIPFailure counts a failed login from ip and returns how long the IP
+// is now locked out, or zero when it is still under the limit
+func RecordIPFailure(ip string) time.Duration {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-config.Window)
+	kept := failures[ip][:0]
+	for _, t := range failures[ip] {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	kept = append(kept, now)
+
+	if len(kept) < config.IPMaxFailures {
+		failures[ip] = kept
+		return 0
+	}
+	delete(failures, ip)
+	ipLocks[ip] = now.Add(config.Duration)
+	return config.Duration
+}
@@ -0,0 +1,152 @@
+// Package locks runs singleton background work, such as the maintenance
+// schedule and the event relay, on one replica at a time. Each replica
+// tries to take a Postgres advisory lock for the work and the one holding
+// it runs the work for as long as it keeps the lock's connection. When it
+// stops or loses the connection, Postgres releases the lock and another
+// replica takes over within RetryInterval.
+package locks
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"goapi/database"
+)
+
+// namespace is the first key of the two-key advisory locks taken here. The
+// migration lock is a one-key lock, which Postgres keeps apart.
+const namespace = 4250392
+
+// RetryInterval is how often a replica without the lock tries to take it,
+// and how often the holder checks that its connection is still up
+var RetryInterval = 15 * time.Second
+
+// holder names this replica in the locks table
+var holder = hostname() + ":" + strconv.Itoa(os.Getpid())
+
+// Holder is who last took a lock
+type Holder struct {
+	Name string
+	// Holder is the replica, as host:pid
+	Holder     string
+	AcquiredAt time.Time
+	// Held is false once the holder let go or died and no replica has
+	// taken the lock since
+	Held bool
+}
+
+// Run calls fn from a background goroutine whenever this replica holds the
+// lock called name. fn's context is cancelled when the lock is lost; fn
+// should then return, and is called again if the lock comes back.
+func Run(db *sql.DB, name string, fn func(ctx context.Context)) {
+	go func() {
+		for {
+			if err := hold(db, name, fn); err != nil {
+				log.Printf("Error holding the %s lock: %v", name, err)
+			}
+			time.Sleep(RetryInterval)
+		}
+	}()
+}
+
+// hold runs fn if it can take the lock, until fn returns or the lock's
+// connection fails
+func hold(db *sql.DB, name string, fn func(ctx context.Context)) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1, $2)`, namespace, key(name)).Scan(&acquired); err != nil {
+		return err
+	}
+	if !acquired {
+		return nil
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1, $2)`, namespace, key(name))
+
+	_, err = conn.ExecContext(ctx, `
+		INSERT INTO locks (name, lock_key, holder, acquired_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (name) DO UPDATE SET lock_key = $2, holder = $3, acquired_at = $4
+	`, name, key(name), holder, time.Now())
+	if err != nil {
+		return err
+	}
+	log.Printf("Holding the %s lock", name)
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(runCtx)
+	}()
+
+	ticker := time.NewTicker(RetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+			if _, err := conn.ExecContext(ctx, `SELECT 1`); err != nil {
+				cancel()
+				<-done
+				return fmt.Errorf("lost the lock's connection: %w", err)
+			}
+		}
+	}
+}
+
+// Holders lists who last took each lock, by name
+func Holders(ctx context.Context, db database.Querier) ([]Holder, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT l.name, l.holder, l.acquired_at, EXISTS (
+			SELECT 1 FROM pg_locks p
+			WHERE p.locktype = 'advisory' AND p.granted
+				AND p.database = (SELECT oid FROM pg_database WHERE datname = current_database())
+				AND p.classid = $1::oid AND p.objid = l.lock_key::oid AND p.objsubid = 2
+		)
+		FROM locks l
+		ORDER BY l.name
+	`, namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holders := []Holder{}
+	for rows.Next() {
+		var h Holder
+		if err := rows.Scan(&h.Name, &h.Holder, &h.AcquiredAt, &h.Held); err != nil {
+			return nil, err
+		}
+		holders = append(holders, h)
+	}
+	return holders, rows.Err()
+}
+
+// key is the second advisory lock key for name. It is kept positive so it
+// reads back the same from pg_locks, where it is an unsigned oid.
+func key(name string) int32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int32(h.Sum32() & 0x7fffffff)
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
@@ -0,0 +1,54 @@
+// Package logging sets up the process-wide structured logger
+package logging
+
+import (
+	stdlog "log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Setup makes the global zerolog logger write at level and above, as one
+// JSON object per line or, with format "console", as colored text for
+// development. Messages from the standard library's log package go through
+// it too.
+func Setup(level, format string) error {
+	lvl, err := zerolog.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	logger := zerolog.New(os.Stdout)
+	if format == "console" {
+		logger = zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.TimeOnly})
+	}
+	logger = logger.Level(lvl).With().Timestamp().Logger()
+
+	zerolog.DurationFieldUnit = time.Millisecond
+	log.Logger = logger
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(stdWriter{logger})
+	return nil
+}
+
+// stdWriter logs lines from the log package, at error or warn level when
+// they start with "Error" or "Warning" as they do in this codebase
+type stdWriter struct {
+	logger zerolog.Logger
+}
+
+func (w stdWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	event := w.logger.Info()
+	switch {
+	case strings.HasPrefix(msg, "Error"):
+		event = w.logger.Error()
+	case strings.HasPrefix(msg, "Warning"):
+		event = w.logger.Warn()
+	}
+	event.Msg(msg)
+	return len(p), nil
+}
@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"goapi/jobs"
+)
+
+// JobSend is the background job type sending one email
+const JobSend = "email.send"
+
+// RegisterJobs registers the email job. Mail servers may be down for a
+// while, so emails get more attempts than most jobs.
+func RegisterJobs() {
+	jobs.Register(JobSend, jobs.Policy{MaxAttempts: 8, Backoff: time.Minute, MaxBackoff: 2 * time.Hour, Timeout: time.Minute}, sendJob)
+}
+
+// Enqueue queues msg to be sent by a background job, retried while the
+// backend fails
+func Enqueue(ctx context.Context, db *sql.DB, msg Message) error {
+	_, err := jobs.Enqueue(ctx, db, JobSend, msg, jobs.Options{})
+	return err
+}
+
+// EnqueueTx is Enqueue on q, typically a transaction, so the email is only
+// sent if the change it announces is committed
+func EnqueueTx(ctx context.Context, q jobs.Querier, msg Message) error {
+	_, err := jobs.EnqueueTx(ctx, q, JobSend, msg, jobs.Options{})
+	return err
+}
+
+// sendJob sends the message in the job's payload, failing for good when the
+// backend rejects it
+func sendJob(ctx context.Context, job *jobs.Job) error {
+	var msg Message
+	if err := json.Unmarshal(job.Payload, &msg); err != nil {
+		return jobs.Permanent(err)
+	}
+	err := Send(ctx, msg)
+	if errors.Is(err, ErrRejected) {
+		return jobs.Permanent(err)
+	}
+	return err
+}
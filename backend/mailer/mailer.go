@@ -0,0 +1,130 @@
+// Package mailer sends emails through an SMTP server or SendGrid, or writes
+// them to the log in development. Emails are rendered from the templates in
+// templates/ and usually queued with Enqueue as background jobs, so a mail
+// outage delays them instead of failing the request that sent them.
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Drivers that can be configured
+const (
+	DriverSMTP     = "smtp"
+	DriverSendGrid = "sendgrid"
+	DriverLog      = "log"
+)
+
+// ErrRejected wraps errors the mail backend won't get over by retrying,
+// such as an invalid recipient or sender
+var ErrRejected = errors.New("email rejected")
+
+// Message is an email to one recipient. HTML is optional; Text is always
+// sent, as the only body or the plain alternative.
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// Mailer delivers emails
+type Mailer interface {
+	// Send returns once the backend has accepted msg
+	Send(ctx context.Context, msg Message) error
+	// Ping checks that the backend can be reached
+	Ping(ctx context.Context) error
+}
+
+// Config is the outgoing mail backend. Without a driver, emails go through
+// SMTP when a host is set and are logged otherwise, so development setups
+// need no mail server.
+type Config struct {
+	// Driver is smtp, sendgrid or log
+	Driver   string
+	Host     string
+	Port     string
+	Username string
+	Password string
+	// SendGridAPIKey needs the mail send permission
+	SendGridAPIKey string
+	From           string
+}
+
+var (
+	config  Config
+	current Mailer = logMailer{}
+)
+
+// withDefaults fills in the driver, port and sender left empty
+func (cfg Config) withDefaults() Config {
+	if cfg.Driver == "" {
+		cfg.Driver = DriverLog
+		if cfg.Host != "" {
+			cfg.Driver = DriverSMTP
+		}
+	}
+	if cfg.Port == "" {
+		cfg.Port = "587"
+	}
+	if cfg.From == "" {
+		cfg.From = "no-reply@localhost"
+	}
+	return cfg
+}
+
+// New returns the Mailer of cfg's driver
+func New(cfg Config) (Mailer, error) {
+	cfg = cfg.withDefaults()
+	switch cfg.Driver {
+	case DriverSMTP:
+		if cfg.Host == "" {
+			return nil, errors.New("SMTP_HOST must be set for the smtp driver")
+		}
+		return smtpMailer{cfg: cfg}, nil
+	case DriverSendGrid:
+		if cfg.SendGridAPIKey == "" {
+			return nil, errors.New("SENDGRID_API_KEY must be set for the sendgrid driver")
+		}
+		return newSendGrid(cfg.SendGridAPIKey, cfg.From), nil
+	case DriverLog:
+		return logMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q, want smtp, sendgrid or log", cfg.Driver)
+	}
+}
+
+// SetConfig sets the backend used by Send and the email jobs
+func SetConfig(cfg Config) error {
+	cfg = cfg.withDefaults()
+	m, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	config, current = cfg, m
+	return nil
+}
+
+// Configured reports whether emails are sent rather than logged
+func Configured() bool {
+	return config.Driver != "" && config.Driver != DriverLog
+}
+
+// Ping checks that the configured backend can be reached
+func Ping(ctx context.Context) error {
+	return current.Ping(ctx)
+}
+
+// Send sends msg right away; Enqueue is usually better
+func Send(ctx context.Context, msg Message) error {
+	msg.To = headerValue.Replace(msg.To)
+	msg.Subject = headerValue.Replace(msg.Subject)
+	return current.Send(ctx, msg)
+}
+
+// headerValue keeps user-supplied text such as names from starting new
+// headers
+var headerValue = strings.NewReplacer("\r", "", "\n", " ")
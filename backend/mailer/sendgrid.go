@@ -0,0 +1,98 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// sendGridURL is the SendGrid v3 API
+const sendGridURL = "https://api.sendgrid.com/v3"
+
+// sendGrid sends through SendGrid's v3 mail send API
+type sendGrid struct {
+	apiKey string
+	from   string
+	client *http.Client
+}
+
+func newSendGrid(apiKey, from string) *sendGrid {
+	return &sendGrid{
+		apiKey: apiKey,
+		from:   from,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Send posts msg to /mail/send. Answers other than 429 and 5xx are
+// ErrRejected.
+func (s *sendGrid) Send(ctx context.Context, msg Message) error {
+	content := []sendGridContent{{Type: "text/plain", Value: msg.Text}}
+	if msg.HTML != "" {
+		content = append(content, sendGridContent{Type: "text/html", Value: msg.HTML})
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"personalizations": []map[string]interface{}{
+			{"to": []sendGridAddress{{Email: msg.To}}},
+		},
+		"from":    sendGridAddress{Email: s.from},
+		"subject": msg.Subject,
+		"content": content,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodPost, "/mail/send", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("SendGrid answered %s: %s", resp.Status, bytes.TrimSpace(detail))
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	return err
+}
+
+// Ping lists the API key's scopes, which checks the key as well
+func (s *sendGrid) Ping(ctx context.Context) error {
+	resp, err := s.do(ctx, http.MethodGet, "/scopes", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("SendGrid answered %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *sendGrid) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, sendGridURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return s.client.Do(req)
+}
@@ -0,0 +1,83 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+)
+
+// smtpMailer sends through an SMTP server, authenticating when a username
+// is configured
+type smtpMailer struct {
+	cfg Config
+}
+
+// Send sends msg as plain text, or as text and HTML alternatives. Permanent
+// (5xx) answers are ErrRejected.
+func (s smtpMailer) Send(ctx context.Context, msg Message) error {
+	var buf bytes.Buffer
+	buf.WriteString("From: " + s.cfg.From + "\r\n" +
+		"To: " + msg.To + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("UTF-8", msg.Subject) + "\r\n" +
+		"MIME-Version: 1.0\r\n")
+	if msg.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n" + crlf(msg.Text))
+	} else {
+		parts := multipart.NewWriter(&buf)
+		buf.WriteString("Content-Type: multipart/alternative; boundary=" + parts.Boundary() + "\r\n\r\n")
+		for _, part := range []struct{ contentType, body string }{
+			{"text/plain; charset=UTF-8", msg.Text},
+			{"text/html; charset=UTF-8", msg.HTML},
+		} {
+			w, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+			if err != nil {
+				return err
+			}
+			w.Write([]byte(crlf(part.body)))
+		}
+		if err := parts.Close(); err != nil {
+			return err
+		}
+	}
+
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+	}
+	err := smtp.SendMail(net.JoinHostPort(s.cfg.Host, s.cfg.Port), auth, s.cfg.From, []string{msg.To}, buf.Bytes())
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	return err
+}
+
+// Ping checks that the mail server answers with its greeting
+func (s smtpMailer) Ping(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.cfg.Host, s.cfg.Port))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	return client.Quit()
+}
+
+// crlf gives body the line endings SMTP expects
+func crlf(body string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n")
+}
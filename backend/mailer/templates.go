@@ -0,0 +1,125 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Templates, each a name.txt defining the "subject" and the plain "body",
+// and a name.html defining the HTML "body" within layout.html
+const (
+	// TemplateWelcome takes Name, Email and AppURL
+	TemplateWelcome = "welcome"
+	// TemplateVerification takes Name, Email, URL and ExpiresAt
+	TemplateVerification = "verification"
+	// TemplatePasswordReset takes Name, URL and ExpiresAt
+	TemplatePasswordReset = "password_reset"
+	// TemplateInvitation takes InviterName, OrgName, Role, URL and ExpiresAt
+	TemplateInvitation = "invitation"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// templates are parsed once; a broken template stops the server at startup
+var templates = map[string]emailTemplate{}
+
+func init() {
+	for _, name := range []string{TemplateWelcome, TemplateVerification, TemplatePasswordReset, TemplateInvitation} {
+		templates[name] = emailTemplate{
+			text: texttemplate.Must(texttemplate.ParseFS(templateFiles, "templates/"+name+".txt")),
+			html: htmltemplate.Must(htmltemplate.ParseFS(templateFiles, "templates/layout.html", "templates/"+name+".html")),
+		}
+	}
+}
+
+// Render fills in the named template with data, making a message to to
+func Render(name, to string, data interface{}) (Message, error) {
+	msg := Message{To: to}
+	tmpl, ok := templates[name]
+	if !ok {
+		return msg, fmt.Errorf("unknown email template %q", name)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return msg, err
+	}
+	msg.Subject = strings.TrimSpace(buf.String())
+	buf.Reset()
+	if err := tmpl.text.ExecuteTemplate(&buf, "body", data); err != nil {
+		return msg, err
+	}
+	msg.Text = buf.String()
+	buf.Reset()
+	if err := tmpl.html.ExecuteTemplate(&buf, "layout", data); err != nil {
+		return msg, err
+	}
+	msg.HTML = buf.String()
+	return msg, nil
+}
+
+// Template is an email written at run time, such as a campaign, instead of
+// one of the named templates. Its HTML body goes within layout.html like
+// theirs.
+type Template struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	// html is nil for emails in plain text only
+	html *htmltemplate.Template
+}
+
+// Parse parses the subject, plain body and optional HTML body of an email
+// written as Go templates
+func Parse(subject, text, html string) (*Template, error) {
+	t := &Template{}
+	var err error
+	if t.subject, err = texttemplate.New("subject").Parse(subject); err != nil {
+		return nil, fmt.Errorf("subject: %w", err)
+	}
+	if t.text, err = texttemplate.New("body").Parse(text); err != nil {
+		return nil, fmt.Errorf("text: %w", err)
+	}
+	if html != "" {
+		layout, err := htmltemplate.ParseFS(templateFiles, "templates/layout.html")
+		if err != nil {
+			return nil, err
+		}
+		if t.html, err = layout.New("body").Parse(html); err != nil {
+			return nil, fmt.Errorf("html: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// Render fills in t with data, making a message to to
+func (t *Template) Render(to string, data interface{}) (Message, error) {
+	msg := Message{To: to}
+	var buf bytes.Buffer
+	if err := t.subject.Execute(&buf, data); err != nil {
+		return msg, err
+	}
+	msg.Subject = strings.TrimSpace(buf.String())
+	buf.Reset()
+	if err := t.text.Execute(&buf, data); err != nil {
+		return msg, err
+	}
+	msg.Text = buf.String()
+	if t.html != nil {
+		buf.Reset()
+		if err := t.html.ExecuteTemplate(&buf, "layout", data); err != nil {
+			return msg, err
+		}
+		msg.HTML = buf.String()
+	}
+	return msg, nil
+}
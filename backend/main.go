@@ -1,20 +1,52 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/getsentry/sentry-go"
 	"github.com/gin-gonic/gin"
-	_ "github.com/lib/pq"
+	"github.com/XSAM/otelsql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"goapi/apperr"
+	"goapi/auth"
+	"goapi/cloudevents"
+	"goapi/config"
 	"goapi/database"
+	"goapi/events"
 	"goapi/handlers"
+	"goapi/health"
+	"goapi/logging"
+	"goapi/heartbeat"
+	"goapi/jobs"
+	"goapi/mailer"
+	"goapi/metrics"
+	"goapi/middleware"
+	"goapi/migrations"
+	userv1 "goapi/proto/user/v1"
+	"goapi/ratelimit"
+	"goapi/realtime"
+	"goapi/repository"
+	"goapi/scheduler"
+	"goapi/services"
+	"goapi/tracing"
+	"goapi/utils"
+	"goapi/version"
+	"goapi/webhooks"
 	_ "goapi/docs"
+	"google.golang.org/grpc"
 )
 
 // @title Go CRUD API
@@ -24,6 +56,10 @@ import (
 // @contact.email support@example.com
 // @host localhost:8080
 // @BasePath /api
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description Access token from login or signup, as "Bearer <token>"
 
 var db *sql.DB
 
@@ -43,8 +79,8 @@ func corsMiddleware() gin.HandlerFunc {
 		}
 		
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, Accept")
-		c.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Cache-Control, Content-Language, Content-Type")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, Accept, If-Match, If-None-Match, If-Modified-Since, X-Request-ID")
+		c.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Cache-Control, Content-Language, Content-Type, ETag, Last-Modified, X-Request-ID")
 		
 		// Handle preflight requests
 		if c.Request.Method == "OPTIONS" {
@@ -57,23 +93,284 @@ func corsMiddleware() gin.HandlerFunc {
 }
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "doctor":
+			os.Exit(runDoctor(os.Args[2:]))
+		case "console":
+			runConsole(os.Args[2:])
+			return
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:]))
+		case "scopes":
+			os.Exit(runScopes(os.Args[2:]))
+		}
+	}
+
+	// Settings from flags, the environment and the config file
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal("Error loading configuration: ", err)
+	}
+	if err := logging.Setup(cfg.LogLevel, cfg.LogFormat); err != nil {
+		log.Fatal("Error setting up logging: ", err)
+	}
+
+	// Optional OpenTelemetry tracing of requests and queries
+	if cfg.OTLPEndpoint != "" {
+		shutdown, err := tracing.Setup(context.Background())
+		if err != nil {
+			log.Fatal("Error setting up tracing: ", err)
+		}
+		defer shutdown(context.Background())
+		log.Printf("Exporting traces to %s", cfg.OTLPEndpoint)
+	}
+
+	// Optional error reporting of panics to Sentry
+	if cfg.SentryDSN != "" {
+		if err := sentry.Init(sentry.ClientOptions{Dsn: cfg.SentryDSN}); err != nil {
+			log.Fatal("Error setting up Sentry: ", err)
+		}
+		defer sentry.Flush(2 * time.Second)
+	}
+
 	// Initialize database connection
-	initDB()
+	initDB(cfg.Database)
 	defer db.Close()
 
-	// Set database connection for handlers
-	database.SetDB(db)
+	// Handlers and background jobs share the connection pool; users are
+	// created and changed through the UserService
+	userService := services.NewUserService(repository.NewPostgresUserRepository(db, cfg.Database.QueryTimeout))
+	h := handlers.NewHandler(db, userService, cfg.Database.QueryTimeout)
+
+	// Tokens, sign-in, passwords, signup checks, the directory import and
+	// mail are set up from the configuration
+	if err := applySettings(cfg); err != nil {
+		log.Fatal("Invalid mail configuration: ", err)
+	}
+
+	// Optionally treat plus-tags and Gmail dots as the same mailbox
+	utils.SetStripEmailAliases(cfg.EmailDedupStripAliases)
+
+	// Optional uptime heartbeat for installs without Prometheus
+	if cfg.HeartbeatURL != "" {
+		heartbeat.Start(cfg.HeartbeatURL, cfg.HeartbeatInterval, db.Ping)
+	}
+
+	// Accounts deleted by their owners are purged once the grace period is over
+	handlers.SetDeletionGracePeriod(cfg.DeletionGracePeriod, cfg.PurgeMode == "anonymize")
+
+	// Emails such as invitations link into the app
+	handlers.SetInvitationConfig(cfg.InvitationTTL, cfg.AppURL)
+
+	// Import files too large for one request are uploaded in chunks
+	handlers.SetImportUploadConfig(int64(cfg.Imports.UploadMaxSize), cfg.Imports.UploadTTL)
+
+	// Emails, exports and maintenance run as background jobs, retried with
+	// backoff when they fail
+	mailer.RegisterJobs()
+	h.RegisterJobs()
+	// Maintenance is queued on a schedule shared by all replicas
+	handlers.SetMaintenanceConfig(cfg.Maintenance.SessionRetention, cfg.Maintenance.AuditRetention, cfg.DeactivateInactiveDays)
+	maintenance := []scheduler.Task{
+		{JobType: handlers.JobPurgeAccounts, Interval: cfg.PurgeInterval},
+		{JobType: handlers.JobExpireInvitations, Interval: cfg.Maintenance.InvitationExpiryInterval},
+		{JobType: handlers.JobRefreshStats, Interval: cfg.Maintenance.StatsRefreshInterval},
+		{JobType: handlers.JobExpireUploads, Interval: cfg.Maintenance.ImportUploadExpiryInterval},
+	}
+	if cfg.Maintenance.SessionRetention > 0 {
+		maintenance = append(maintenance, scheduler.Task{JobType: handlers.JobPurgeSessions, Interval: cfg.Maintenance.SessionPurgeInterval})
+	}
+	if cfg.Maintenance.AuditRetention > 0 {
+		maintenance = append(maintenance, scheduler.Task{JobType: handlers.JobPurgeAuditLogs, Interval: cfg.Maintenance.AuditPurgeInterval})
+	}
+	// Optionally deactivate users who haven't been seen in a while
+	if cfg.DeactivateInactiveDays > 0 {
+		maintenance = append(maintenance, scheduler.Task{JobType: handlers.JobDeactivateInactive, Interval: cfg.DeactivateInactiveInterval})
+	}
+	// The heartbeat also beats after every scheduled run, or fails when a
+	// run failed for good
+	if cfg.HeartbeatURL != "" {
+		scheduled := make([]string, len(maintenance))
+		for i, task := range maintenance {
+			scheduled[i] = task.JobType
+		}
+		jobs.Observe(heartbeat.JobObserver(cfg.HeartbeatURL, scheduled...))
+	}
+	jobs.Start(jobs.Config{
+		DB:           db,
+		Workers:      cfg.Jobs.Workers,
+		PollInterval: cfg.Jobs.PollInterval,
+		Retention:    cfg.Jobs.Retention,
+	})
+
+	// Stored email keys follow EMAIL_DEDUP_STRIP_ALIASES when it changes
+	if err := h.QueueEmailRenormalization(context.Background()); err != nil {
+		log.Println("Error checking stored email keys:", err)
+	}
+
+	// Maintenance jobs are queued once their interval is up
+	scheduler.Start(db, maintenance...)
+
+	// Webhook payloads and broker messages are CloudEvents from this source
+	cloudevents.SetSource(cfg.Events.Source)
+
+	// User events are delivered to registered webhooks in the background
+	webhooks.Start(webhooks.Config{
+		DB:           db,
+		PollInterval: cfg.Webhooks.PollInterval,
+		Timeout:      cfg.Webhooks.Timeout,
+		MaxAttempts:  cfg.Webhooks.MaxAttempts,
+	})
+
+	// Optionally relay user events to NATS or Kafka through the outbox
+	var eventPublisher events.Publisher
+	if cfg.Events.Broker != "" {
+		eventPublisher, err = events.Connect(cfg.Events.Broker, cfg.Events.URL, cfg.Events.Timeout)
+		if err != nil {
+			log.Fatal("Error connecting to the event broker: ", err)
+		}
+		defer eventPublisher.Close()
+		events.Start(eventPublisher, events.Config{
+			DB:           db,
+			TopicPrefix:  cfg.Events.TopicPrefix,
+			PollInterval: cfg.Events.PollInterval,
+			Timeout:      cfg.Events.Timeout,
+		})
+		log.Printf("Publishing user events to %s", cfg.Events.Broker)
+	}
+
+	// Events reach the WebSocket clients of every replica through
+	// LISTEN/NOTIFY; connections are closed once their session ends
+	realtime.Start(db, cfg.Database.ConnString(), time.Minute)
 
 	// Set Gin mode
 	gin.SetMode(gin.ReleaseMode)
 
-	// Create router
-	r := gin.Default()
+	// Create router; requests are logged as structured lines, and panics are
+	// logged, reported and answered with a 500 inside that
+	r := gin.New()
+	// Forwarding headers are only believed from TRUSTED_PROXIES, so clients
+	// can't spoof the IP that allowlists, rate limits and lockouts go by
+	if err := middleware.TrustProxies(r, cfg.TrustedProxies); err != nil {
+		log.Fatal("Error parsing TRUSTED_PROXIES:", err)
+	}
+	r.Use(middleware.RequestID(), middleware.Tracing(), middleware.RequestLogger(), middleware.Recovery(), middleware.Errors())
+	r.NoRoute(func(c *gin.Context) {
+		c.Error(apperr.New(http.StatusNotFound, apperr.CodeNotFound, "No route for "+c.Request.Method+" "+c.Request.URL.Path))
+	})
 
 	// Add CORS middleware
 	r.Use(corsMiddleware())
 
-	// Health check endpoint
+	// Dependencies checked by /readyz. The server can't work without the
+	// database or with an outdated schema; without Redis, the mail server or
+	// the event broker it only degrades.
+	readinessChecks := []health.Check{
+		{Name: "database", Run: db.PingContext},
+		{Name: "migrations", Run: func(ctx context.Context) error {
+			pending, err := migrations.Pending(ctx, db)
+			if err == nil && pending > 0 {
+				err = fmt.Errorf("%d migration(s) pending", pending)
+			}
+			return err
+		}},
+	}
+	if mailer.Configured() {
+		readinessChecks = append(readinessChecks, health.Check{Name: "mail", Optional: true, Run: mailer.Ping})
+	}
+	if eventPublisher != nil {
+		readinessChecks = append(readinessChecks, health.Check{Name: "events", Optional: true, Run: eventPublisher.Ping})
+	}
+
+	// Per-IP rate limits, shared across replicas when RATE_LIMIT_REDIS_URL is set
+	var limiterStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimit.RedisURL != "" {
+		redisStore, err := ratelimit.NewRedisStore(cfg.RateLimit.RedisURL)
+		if err != nil {
+			log.Fatal("Error parsing RATE_LIMIT_REDIS_URL:", err)
+		}
+		if err := redisStore.Ping(context.Background()); err != nil {
+			log.Println("Warning: rate limit Redis is unreachable, requests will not be limited until it is:", err)
+		}
+		limiterStore = redisStore
+		readinessChecks = append(readinessChecks, health.Check{Name: "redis", Optional: true, Run: redisStore.Ping})
+	}
+	defaultLimit := ratelimit.Policy{
+		Rate:  cfg.RateLimit.RPS,
+		Burst: cfg.RateLimit.Burst,
+	}
+	authLimit := ratelimit.Policy{
+		Rate:  cfg.RateLimit.AuthPerMinute / 60,
+		Burst: cfg.RateLimit.AuthBurst,
+	}
+	r.Use(middleware.RateLimit(limiterStore, "default", defaultLimit))
+
+	// Restrict admin endpoints (and optionally Swagger) to trusted networks
+	adminNetworks, err := middleware.ParseCIDRs(cfg.AdminAllowedCIDRs)
+	if err != nil {
+		log.Fatal("Error parsing ADMIN_ALLOWED_CIDRS:", err)
+	}
+	adminAllowlist := middleware.IPAllowlist(adminNetworks)
+
+	// Per-route permissions carried in access tokens
+	requireAuth := middleware.RequireAuth(db, cfg.Database.QueryTimeout)
+	canReadUsers := middleware.RequireScope(auth.ScopeUsersRead)
+	canWriteUsers := middleware.RequireScope(auth.ScopeUsersWrite)
+	// Admin operations take the admin scope, from the admin allowlist
+	requireAdmin := middleware.RequireAdmin(adminNetworks)
+
+	// Hard deletes are an admin operation
+	hardDeleteAdmin := func(c *gin.Context) {
+		if c.Query("hard") == "true" {
+			requireAdmin(c)
+			return
+		}
+		c.Next()
+	}
+
+	// unlessSelf skips check when the caller is the user named by :id
+	unlessSelf := func(check gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			if c.Param("id") == strconv.Itoa(c.GetInt("userID")) {
+				c.Next()
+				return
+			}
+			check(c)
+		}
+	}
+
+	// Optional country-based blocking, applied to all routes or only to auth,
+	// and likewise to every gRPC call or only to Login
+	var geoBlock gin.HandlerFunc
+	var grpcGeoBlock grpc.UnaryServerInterceptor
+	if cfg.GeoIPDBPath != "" {
+		geoDB, err := geoip2.Open(cfg.GeoIPDBPath)
+		if err != nil {
+			log.Fatal("Error opening GeoIP database:", err)
+		}
+		defer geoDB.Close()
+
+		rules := middleware.GeoRules{
+			Allow: middleware.ParseCountries(cfg.GeoAllowCountries),
+			Deny:  middleware.ParseCountries(cfg.GeoDenyCountries),
+		}
+		geoBlock = middleware.GeoBlock(geoDB, rules)
+		grpcGeoBlock = middleware.GRPCGeoBlock(geoDB, rules, userv1.UserService_Login_FullMethodName)
+		if cfg.GeoBlockScope == "all" {
+			r.Use(geoBlock)
+			geoBlock = nil
+			grpcGeoBlock = middleware.GRPCGeoBlock(geoDB, rules)
+		}
+	}
+
+	// Liveness and readiness probes
+	r.GET("/healthz", health.Liveness())
+	r.GET("/readyz", health.Readiness(cfg.ReadinessTimeout, readinessChecks...))
+
+	// Original health check, kept for existing monitors; it doesn't check
+	// dependencies, use /readyz for that
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"status":  "ok",
@@ -82,6 +379,41 @@ func main() {
 		})
 	})
 
+	// Build and version info, with the database's schema version
+	r.GET("/version", func(c *gin.Context) {
+		info := version.Get()
+		if current, err := migrations.Current(c.Request.Context(), db); err != nil {
+			log.Println("Error reading the schema version:", err)
+		} else {
+			info.MigrationVersion = &current
+		}
+		c.JSON(http.StatusOK, info)
+	})
+
+	// Prometheus metrics
+	metrics.RegisterUserGauges(db)
+	metrics.RegisterPoolStats(db)
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// Profiles and runtime variables for diagnosing slowdowns in production
+	if cfg.DebugEndpoints {
+		if cfg.AdminAllowedCIDRs == "" {
+			log.Println("Warning: DEBUG_ENDPOINTS is on and ADMIN_ALLOWED_CIDRS is empty, so /debug is open to everyone")
+		}
+		expvar.Publish("db", expvar.Func(func() any { return db.Stats() }))
+
+		debug := r.Group("/debug")
+		debug.Use(adminAllowlist)
+		{
+			debug.GET("/pprof/*profile", handlers.PprofHandler)
+			debug.POST("/pprof/*profile", handlers.PprofHandler)
+			debug.GET("/vars", handlers.ExpvarHandler)
+		}
+	}
+
+	// Real-time user events and presence over a WebSocket
+	r.GET("/ws", middleware.RequireAuthQuery(db, cfg.Database.QueryTimeout), canReadUsers, handlers.WebSocketHandler)
+
 	// Root endpoint
 	r.GET("/", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
@@ -91,6 +423,18 @@ func main() {
 		})
 	})
 
+	// The same users over gRPC, for internal consumers
+	if cfg.GRPCPort != 0 {
+		// Login is limited like POST /api/auth/login, in the same buckets
+		checks := []grpc.UnaryServerInterceptor{
+			middleware.GRPCRateLimit(limiterStore, "login", authLimit, userv1.UserService_Login_FullMethodName),
+		}
+		if grpcGeoBlock != nil {
+			checks = append([]grpc.UnaryServerInterceptor{grpcGeoBlock}, checks...)
+		}
+		serveGRPC(cfg.GRPCPort, h, db, cfg.Database.QueryTimeout, adminNetworks, checks...)
+	}
+
 	// API routes
 	api := r.Group("/api")
 	{
@@ -103,89 +447,217 @@ func main() {
 		})
 		
 		// Swagger documentation
-		api.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+		swagger := api.Group("/swagger")
+		if cfg.SwaggerRestricted {
+			swagger.Use(adminAllowlist)
+		}
+		swagger.GET("/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+
+		// Admin routes
+		admin := api.Group("/admin")
+		admin.Use(requireAuth, requireAdmin)
+		{
+			admin.POST("/imports/google-workspace", h.GoogleWorkspaceImportHandler)
+			admin.POST("/users/:id/unlock", h.UnlockUserHandler)
+			admin.POST("/users/deactivate-inactive", h.DeactivateInactiveUsersHandler)
+			admin.GET("/audit", h.ListAuditLogsHandler)
+			admin.GET("/campaigns", h.ListCampaignsHandler)
+			admin.POST("/campaigns", h.CreateCampaignHandler)
+			admin.GET("/campaigns/:id", h.GetCampaignHandler)
+			admin.GET("/campaigns/:id/recipients", h.ListCampaignRecipientsHandler)
+			admin.POST("/exports/users", h.ExportUsersHandler)
+			admin.GET("/jobs", h.ListJobsHandler)
+			admin.GET("/jobs/:id", h.GetJobHandler)
+			admin.GET("/jobs/:id/result", h.GetJobResultHandler)
+			admin.POST("/jobs/:id/retry", h.RetryJobHandler)
+			admin.GET("/locks", h.ListLocksHandler)
+			admin.POST("/stats/refresh", h.RefreshStatsHandler)
+			admin.GET("/users/:id/notes", h.ListAdminNotesHandler)
+			admin.POST("/users/:id/notes", h.CreateAdminNoteHandler)
+			admin.DELETE("/users/:id/notes/:noteId", h.DeleteAdminNoteHandler)
+			admin.GET("/webhooks", h.ListWebhooksHandler)
+			admin.POST("/webhooks", h.CreateWebhookHandler)
+			admin.DELETE("/webhooks/:id", h.DeleteWebhookHandler)
+			admin.GET("/webhooks/:id/deliveries", h.ListWebhookDeliveriesHandler)
+		}
 
 		// Auth routes
 		auth := api.Group("/auth")
+		if geoBlock != nil {
+			auth.Use(geoBlock)
+		}
 		{
-			auth.POST("/login", handlers.LoginHandler)
-			auth.POST("/signup", handlers.SignupHandler)
+			auth.POST("/login", middleware.RateLimit(limiterStore, "login", authLimit), h.LoginHandler)
+			auth.POST("/signup", middleware.RateLimit(limiterStore, "signup", authLimit), h.Signup)
+			auth.GET("/oauth/:provider", handlers.OAuthLoginHandler)
+			auth.GET("/oauth/:provider/callback", h.OAuthCallbackHandler)
+			auth.POST("/oauth/:provider/link", requireAuth, h.OAuthLinkHandler)
+			auth.POST("/webauthn/register/begin", requireAuth, h.BeginWebAuthnRegistrationHandler)
+			auth.POST("/webauthn/register/finish", requireAuth, h.FinishWebAuthnRegistrationHandler)
+			auth.POST("/webauthn/login/begin", middleware.RateLimit(limiterStore, "login", authLimit), h.BeginWebAuthnLoginHandler)
+			auth.POST("/webauthn/login/finish", middleware.RateLimit(limiterStore, "login", authLimit), h.FinishWebAuthnLoginHandler)
+			auth.GET("/saml/login", h.SAMLLoginHandler)
+			auth.POST("/saml/acs", h.SAMLACSHandler)
+			auth.GET("/saml/metadata", handlers.SAMLMetadataHandler)
+			auth.POST("/restore", middleware.RateLimit(limiterStore, "login", authLimit), h.RestoreAccountHandler)
 		}
 
-		// User routes
+		// Public routes
+		public := api.Group("/public")
+		{
+			public.GET("/users/:id", middleware.OptionalAuth(db, cfg.Database.QueryTimeout), h.GetPublicUserHandler)
+		}
+
+		// Lets the signup form validate as the user types; rate limited like
+		// signup so it can't be used to enumerate accounts quickly
+		api.GET("/users/check-availability", middleware.RateLimit(limiterStore, "availability", authLimit), h.CheckAvailability)
+
+		// The unsubscribe link of campaign emails works without signing in
+		api.POST("/email/unsubscribe", middleware.RateLimit(limiterStore, "unsubscribe", authLimit), h.UnsubscribeHandler)
+
+		// User routes, authenticated callers only
 		users := api.Group("/users")
+		users.Use(requireAuth)
 		{
-			users.POST("", handlers.CreateUserHandler)
-			users.POST("/", handlers.CreateUserHandler)
-			users.GET("", handlers.GetAllUsersHandler)
-			users.GET("/", handlers.GetAllUsersHandler)
-			users.GET("/:id", handlers.GetUserByIDHandler)
-			users.PUT("/:id", handlers.UpdateUserHandler)
-			users.PATCH("/:id", handlers.UpdateUserHandler)
-			users.DELETE("/:id", handlers.DeleteUserHandler)
+			users.POST("", canWriteUsers, h.CreateUser)
+			users.POST("/", canWriteUsers, h.CreateUser)
+			users.GET("", canReadUsers, h.GetAllUsers)
+			users.GET("/", canReadUsers, h.GetAllUsers)
+			users.POST("/lookup", canReadUsers, h.LookupUsers)
+			users.GET("/stats", canReadUsers, h.GetUserStatsHandler)
+			users.GET("/tags", canReadUsers, h.ListTagsHandler)
+			users.GET("/online", canReadUsers, h.ListOnlineUsersHandler)
+			users.POST("/import", canWriteUsers, h.ImportUsers)
+			users.POST("/import/stream", canWriteUsers, h.StreamImportUsersHandler)
+			users.POST("/import/uploads", canWriteUsers, h.CreateImportUploadHandler)
+			users.GET("/import/uploads/:id", canWriteUsers, h.GetImportUploadHandler)
+			users.HEAD("/import/uploads/:id", canWriteUsers, h.GetImportUploadHandler)
+			users.PATCH("/import/uploads/:id", canWriteUsers, h.UploadImportChunkHandler)
+			users.DELETE("/import/uploads/:id", canWriteUsers, h.DeleteImportUploadHandler)
+			users.GET("/me", h.GetMe)
+			users.PUT("/me", h.ReplaceMe)
+			users.PATCH("/me", h.UpdateMe)
+			users.DELETE("/me", h.DeleteMe)
+			users.GET("/me/sessions", h.ListMySessionsHandler)
+			users.GET("/me/logins", h.ListMyLoginsHandler)
+			users.GET("/me/identities", h.ListMyIdentitiesHandler)
+			users.POST("/me/identities/:provider", h.LinkMyIdentityHandler)
+			users.DELETE("/me/identities/:id", h.UnlinkMyIdentityHandler)
+			users.GET("/me/email-preferences", h.GetEmailPreferencesHandler)
+			users.PUT("/me/email-preferences", h.SetEmailPreferencesHandler)
+			users.DELETE("/me/sessions/:id", h.RevokeMySessionHandler)
+			users.PUT("/me/password", h.ChangeMyPasswordHandler)
+			users.POST("/me/following/:id", h.FollowUserHandler)
+			users.DELETE("/me/following/:id", h.UnfollowUserHandler)
+			users.GET("/me/blocks", h.ListBlocksHandler)
+			users.POST("/me/blocks/:id", h.BlockUserHandler)
+			users.DELETE("/me/blocks/:id", h.UnblockUserHandler)
+			users.GET("/by-username/:username", canReadUsers, h.GetUserByUsername)
+			users.GET("/:id", canReadUsers, h.GetUserByID)
+			users.PUT("/:id", canWriteUsers, h.ReplaceUser)
+			users.PATCH("/:id", canWriteUsers, h.UpdateUser)
+			users.DELETE("/:id", canWriteUsers, hardDeleteAdmin, h.DeleteUser)
+			users.POST("/:id/restore", canWriteUsers, h.RestoreUser)
+			users.POST("/:id/anonymize", unlessSelf(canWriteUsers), unlessSelf(requireAdmin), h.AnonymizeUserHandler)
+			users.GET("/:id/versions", canReadUsers, h.GetUserVersionsHandler)
+			users.GET("/:id/history", canReadUsers, h.GetUserHistoryHandler)
+			users.POST("/:id/versions/:version/restore", canWriteUsers, h.RestoreUserVersionHandler)
+			users.POST("/:id/revert", canWriteUsers, requireAdmin, h.RevertUserHandler)
+			users.GET("/:id/tags", canReadUsers, h.GetUserTagsHandler)
+			users.GET("/:id/followers", canReadUsers, h.ListFollowersHandler)
+			users.GET("/:id/following", canReadUsers, h.ListFollowingHandler)
+			users.PUT("/:id/tags/:tag", canWriteUsers, requireAdmin, h.TagUserHandler)
+			users.DELETE("/:id/tags/:tag", canWriteUsers, requireAdmin, h.UntagUserHandler)
 		}
-	}
 
-	// Get port from environment or use default
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
+		// Organization routes; access is decided by the caller's role in
+		// each organization rather than by token scopes
+		orgs := api.Group("/orgs")
+		orgs.Use(requireAuth)
+		{
+			orgs.POST("", h.CreateOrgHandler)
+			orgs.GET("", h.ListOrgsHandler)
+			orgs.GET("/:id", h.GetOrgHandler)
+			orgs.PUT("/:id", h.UpdateOrgHandler)
+			orgs.DELETE("/:id", h.DeleteOrgHandler)
+			orgs.GET("/:id/members", h.ListOrgMembersHandler)
+			orgs.POST("/:id/members", h.AddOrgMemberHandler)
+			orgs.PATCH("/:id/members/:userId", h.UpdateOrgMemberHandler)
+			orgs.DELETE("/:id/members/:userId", h.RemoveOrgMemberHandler)
+			orgs.POST("/:id/invitations", h.CreateInvitationHandler)
+			orgs.GET("/:id/invitations", h.ListInvitationsHandler)
+			orgs.DELETE("/:id/invitations/:invitationId", h.RevokeInvitationHandler)
+		}
 
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(r.Run(":" + port))
-}
+		// Invitation links; new users accept by signing up with the token
+		invitations := api.Group("/invitations")
+		{
+			invitations.GET("/:token", h.GetInvitationHandler)
+			invitations.POST("/:token/accept", requireAuth, h.AcceptInvitationHandler)
+		}
 
-func initDB() {
-	// Get database connection details from environment variables
-	dbHost := getEnv("DATABASE_HOST", "localhost")
-	dbPort := getEnv("DATABASE_PORT", "5432")
-	dbName := getEnv("DATABASE_NAME", "test_db")
-	dbUser := getEnv("DATABASE_USER", "postgres")
-	dbPassword := getEnv("DATABASE_PASSWORD", "password")
+		// The gRPC UserService as JSON, with routes generated from its proto;
+		// it checks tokens, rate limits and geo-blocks login itself
+		if cfg.GRPCPort != 0 {
+			gateway := gatewayHandler(cfg.GRPCPort)
+			v1 := api.Group("/v1")
+			{
+				v1.POST("/auth/login", gateway)
+				v1.Any("/users", gateway)
+				v1.Any("/users/:id", gateway)
+				v1.GET("/openapi.json", gateway)
+			}
+		}
+	}
 
-	// Create connection string
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		dbHost, dbPort, dbUser, dbPassword, dbName)
+	build := version.Get()
+	log.Printf("Server starting on port %d (git %s, built %s)", cfg.Port, build.GitSHA, build.BuildTime)
+	log.Fatal(r.Run(":" + strconv.Itoa(cfg.Port)))
+}
 
+func initDB(cfg config.Database) {
+	// Queries made with a request's context are traced as its child spans
 	var err error
-	db, err = sql.Open("postgres", connStr)
+	db, err = otelsql.Open("pgx", cfg.ConnString(),
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{DisableErrSkip: true, OmitRows: true, OmitConnResetSession: true}),
+	)
 	if err != nil {
 		log.Fatal("Error opening database connection:", err)
 	}
 
-	// Test the connection
-	err = db.Ping()
+	// Connection pool limits; idle and old connections are closed so the
+	// pool shrinks after a burst and follows database failovers
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	// Wait for the database, which may still be starting
+	err = database.Wait(context.Background(), db, cfg.ConnectTimeout)
 	if err != nil {
 		log.Fatal("Error connecting to database:", err)
 	}
 
 	log.Println("Successfully connected to database")
 
-	// Create users table if it doesn't exist
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS users (
-		id SERIAL PRIMARY KEY,
-		name VARCHAR(100) NOT NULL,
-		email VARCHAR(255) NOT NULL UNIQUE,
-		password VARCHAR(255) NOT NULL,
-		age INTEGER,
-		is_active BOOLEAN DEFAULT TRUE,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	_, err = db.Exec(createTableSQL)
-	if err != nil {
-		log.Fatal("Error creating users table:", err)
+	// Bring the schema up to date unless another step of the deploy does it
+	if cfg.MigrateOnStart {
+		applied, err := migrations.Up(context.Background(), db)
+		if err != nil {
+			log.Fatal("Error migrating database:", err)
+		}
+		log.Printf("Database schema ready (%d migrations applied)", applied)
+		return
 	}
 
-	log.Println("Users table ready")
-}
-
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	// Otherwise another step migrates, and handlers would hit missing
+	// tables and columns until it has
+	pending, err := migrations.Pending(context.Background(), db)
+	if err != nil {
+		log.Fatal("Error checking database migrations:", err)
+	}
+	if pending > 0 {
+		log.Fatalf("Database schema is behind: %d migration(s) pending; run \"migrate up\" first", pending)
 	}
-	return defaultValue
-} 
\ No newline at end of file
+}
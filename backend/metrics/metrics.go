@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Domain-level counters, exported on /metrics alongside the default Go and
+// process collectors
+var (
+	Signups = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "goapi",
+		Subsystem: "users",
+		Name:      "signups_total",
+		Help:      "Number of successful user signups.",
+	})
+
+	Logins = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "goapi",
+		Subsystem: "users",
+		Name:      "logins_total",
+		Help:      "Number of successful logins.",
+	})
+
+	FailedLogins = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "goapi",
+		Subsystem: "users",
+		Name:      "failed_logins_total",
+		Help:      "Number of login attempts rejected because of invalid credentials.",
+	})
+
+	Deletions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "goapi",
+		Subsystem: "users",
+		Name:      "deletions_total",
+		Help:      "Number of deleted users.",
+	})
+
+	WebSocketConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "goapi",
+		Subsystem: "realtime",
+		Name:      "connections",
+		Help:      "Number of open WebSocket connections.",
+	})
+)
+
+// RegisterUserGauges registers gauges that are computed from the database
+// when Prometheus scrapes the endpoint
+func RegisterUserGauges(db *sql.DB) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "goapi",
+		Subsystem: "users",
+		Name:      "active",
+		Help:      "Number of active users.",
+	}, func() float64 {
+		return countUsers(db, "SELECT COUNT(*) FROM users WHERE is_active = TRUE AND deleted_at IS NULL")
+	})
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "goapi",
+		Subsystem: "users",
+		Name:      "total",
+		Help:      "Number of users.",
+	}, func() float64 {
+		return countUsers(db, "SELECT COUNT(*) FROM users WHERE deleted_at IS NULL")
+	})
+}
+
+// RegisterPoolStats exports the connection pool statistics of db as the
+// go_sql_* metrics, labelled db_name="goapi"
+func RegisterPoolStats(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "goapi"))
+}
+
+func countUsers(db *sql.DB, query string) float64 {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var count int64
+	if err := db.QueryRowContext(ctx, query).Scan(&count); err != nil {
+		log.Println("Error computing user metrics:", err)
+		return 0
+	}
+	return float64(count)
+}
@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"goapi/apperr"
+	"goapi/auth"
+)
+
+// sessionTouchInterval limits how often a session's last-seen time is written
+const sessionTouchInterval = time.Minute
+
+// RequireAuth rejects requests without a valid "Authorization: Bearer" access
+// token for an active session with 401. The caller's user and session IDs and
+// the token's scopes are stored in the context as "userID", "sessionID" and
+// "scopes". Sessions are looked up in db, giving up after timeout, or never
+// when it is zero.
+func RequireAuth(db *sql.DB, timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		token, found := strings.CutPrefix(header, "Bearer ")
+		if !found || strings.TrimSpace(token) == "" {
+			c.Error(apperr.New(http.StatusUnauthorized, apperr.CodeUnauthorized, "Authentication required"))
+			c.Abort()
+			return
+		}
+
+		claims, err := authenticate(c.Request.Context(), db, timeout, strings.TrimSpace(token), c.ClientIP())
+		if err != nil {
+			c.Error(err)
+			c.Abort()
+			return
+		}
+
+		c.Set("userID", claims.UserID)
+		c.Set("sessionID", claims.SessionID)
+		c.Set("scopes", claims.Scopes)
+		c.Next()
+	}
+}
+
+// authenticate checks an access token, that its session is still active and
+// that its user hasn't been deactivated, returning its claims. Use of the
+// session from ip is recorded, at most once every sessionTouchInterval.
+func authenticate(ctx context.Context, db *sql.DB, timeout time.Duration, token, ip string) (auth.Claims, *apperr.Error) {
+	claims, err := auth.ParseToken(token)
+	if err != nil {
+		return auth.Claims{}, apperr.New(http.StatusUnauthorized, apperr.CodeInvalidToken, "Invalid or expired token")
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var lastSeen time.Time
+	var active bool
+	err = db.QueryRowContext(ctx, `
+		SELECT s.last_seen_at, u.is_active FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.id = $1 AND s.user_id = $2 AND s.revoked_at IS NULL AND s.expires_at > $3
+	`, claims.SessionID, claims.UserID, time.Now()).Scan(&lastSeen, &active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.Claims{}, apperr.New(http.StatusUnauthorized, apperr.CodeInvalidToken, "Session has ended, please sign in again")
+	}
+	if err != nil {
+		return auth.Claims{}, apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Error checking session").Wrap(err)
+	}
+	if !active {
+		return auth.Claims{}, apperr.New(http.StatusForbidden, apperr.CodeAccountDisabled, "This account has been deactivated")
+	}
+	if time.Since(lastSeen) > sessionTouchInterval {
+		// A missed touch only leaves last-seen times stale, so the call
+		// goes ahead
+		if err := touchSession(ctx, db, claims, ip); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Int("session_id", claims.SessionID).Msg("Error recording session use")
+		}
+	}
+	return claims, nil
+}
+
+// touchSession records use of the session of claims from ip now
+func touchSession(ctx context.Context, db *sql.DB, claims auth.Claims, ip string) error {
+	now := time.Now()
+	_, err := db.ExecContext(ctx, `
+		UPDATE sessions SET last_seen_at = $1, ip = $2 WHERE id = $3
+	`, now, ip, claims.SessionID)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "UPDATE users SET last_seen_at = $1 WHERE id = $2", now, claims.UserID)
+	return err
+}
+
+// RequireScope rejects callers whose token lacks scope with 403. It runs
+// after RequireAuth.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, _ := c.Get("scopes")
+		granted, _ := scopes.([]string)
+		if !auth.HasScope(granted, scope) {
+			c.Error(apperr.New(http.StatusForbidden, apperr.CodeInsufficientScope, "Token is missing the "+scope+" scope"))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAdmin rejects callers whose token lacks the admin scope, or who
+// connect from outside networks, with 403. It runs after RequireAuth; the
+// allowlist only narrows who can use an admin token.
+func RequireAdmin(networks []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, _ := c.Get("scopes")
+		granted, _ := scopes.([]string)
+		if !auth.HasScope(granted, auth.ScopeAdmin) {
+			c.Error(apperr.New(http.StatusForbidden, apperr.CodeInsufficientScope, "Token is missing the "+auth.ScopeAdmin+" scope"))
+			c.Abort()
+			return
+		}
+		if !IPAllowed(networks, c.ClientIP()) {
+			c.Error(apperr.New(http.StatusForbidden, apperr.CodeForbidden, "Access denied"))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAuthQuery is RequireAuth for WebSocket handshakes, which browsers
+// can't add headers to: without an Authorization header, the token is taken
+// from the access_token query parameter
+func RequireAuthQuery(db *sql.DB, timeout time.Duration) gin.HandlerFunc {
+	requireAuth := RequireAuth(db, timeout)
+	return func(c *gin.Context) {
+		if token := c.Query("access_token"); token != "" && c.GetHeader("Authorization") == "" {
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+		requireAuth(c)
+	}
+}
+
+// OptionalAuth authenticates requests that carry an Authorization header like
+// RequireAuth, rejecting bad tokens, and lets requests without one through
+// anonymously with no "userID" set
+func OptionalAuth(db *sql.DB, timeout time.Duration) gin.HandlerFunc {
+	requireAuth := RequireAuth(db, timeout)
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.Next()
+			return
+		}
+		requireAuth(c)
+	}
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"goapi/apperr"
+	"goapi/models"
+)
+
+// Errors writes the last error a handler or middleware recorded with
+// c.Error as an application/problem+json response, unless a response was
+// already written. Errors other than *apperr.Error become a generic 500.
+// Causes of server errors are logged.
+func Errors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		last := c.Errors.Last()
+		if last == nil || c.Writer.Written() {
+			return
+		}
+		err := apperr.From(last.Err)
+		if err.Status >= 500 && err.Err != nil {
+			zerolog.Ctx(c.Request.Context()).Error().Err(err.Err).Str("code", string(err.Code)).Msg(err.Detail)
+		}
+		writeProblem(c, err)
+	}
+}
+
+// writeProblem writes err as an application/problem+json response
+func writeProblem(c *gin.Context, err *apperr.Error) {
+	// Set first so c.JSON keeps it
+	c.Header("Content-Type", "application/problem+json")
+	c.JSON(err.Status, newProblem(err, c.Request.URL.Path, c.GetString("requestID")))
+}
+
+// newProblem returns the problem describing err for the request to instance
+func newProblem(err *apperr.Error, instance, requestID string) models.Problem {
+	return models.Problem{
+		Type:       "about:blank",
+		Title:      http.StatusText(err.Status),
+		Status:     err.Status,
+		Detail:     err.Detail,
+		Instance:   instance,
+		Code:       string(err.Code),
+		RequestID:  requestID,
+		Extensions: err.Extensions,
+	}
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/rs/zerolog"
+	"goapi/apperr"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GatewayErrors writes the errors of the /api/v1 gateway routes as
+// application/problem+json, like Errors does for the rest of the API. The
+// status, code and extensions come from the ErrorInfo GRPCErrors attaches,
+// so a call fails the same way whichever API it goes through. Errors raised
+// by the gateway itself, e.g. for a body it can't decode, get a status and
+// code from their gRPC code. It is a runtime.ErrorHandlerFunc.
+func GatewayErrors(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	appErr := gatewayError(err)
+	if appErr.Status >= 500 && appErr.Err != nil {
+		zerolog.Ctx(r.Context()).Error().Err(appErr.Err).Str("code", string(appErr.Code)).Msg(appErr.Detail)
+	}
+
+	body, marshalErr := json.Marshal(newProblem(appErr, r.URL.Path, w.Header().Get(RequestIDHeader)))
+	if marshalErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	if retryAfter, ok := appErr.Extensions["retry_after"].(int); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+	w.WriteHeader(appErr.Status)
+	w.Write(body)
+}
+
+// gatewayError converts an error from the gateway or the gRPC server back to
+// the *apperr.Error it started as
+func gatewayError(err error) *apperr.Error {
+	st := status.Convert(err)
+	for _, detail := range st.Details() {
+		info, ok := detail.(*errdetails.ErrorInfo)
+		if !ok || info.Domain != errorDomain {
+			continue
+		}
+		appErr := apperr.New(runtime.HTTPStatusFromCode(st.Code()), apperr.Code(info.Reason), st.Message())
+		for key, value := range info.Metadata {
+			n, convErr := strconv.Atoi(value)
+			switch {
+			case key == httpStatusKey && convErr == nil:
+				appErr.Status = n
+			case convErr == nil:
+				appErr.With(key, n)
+			default:
+				appErr.With(key, value)
+			}
+		}
+		return appErr
+	}
+
+	switch st.Code() {
+	case codes.InvalidArgument:
+		return apperr.New(http.StatusBadRequest, apperr.CodeInvalidRequest, st.Message())
+	case codes.NotFound:
+		return apperr.New(http.StatusNotFound, apperr.CodeNotFound, st.Message())
+	case codes.Unimplemented:
+		// The gateway's answer to a method the route doesn't have
+		return apperr.New(http.StatusMethodNotAllowed, apperr.CodeInvalidRequest, st.Message())
+	case codes.Unavailable:
+		return apperr.New(http.StatusServiceUnavailable, apperr.CodeUpstream, "The gRPC server is unavailable").Wrap(err)
+	}
+	return apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Internal server error").Wrap(err)
+}
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/geoip2-golang"
+	"goapi/apperr"
+	"google.golang.org/grpc"
+)
+
+// GeoRules holds country allow/deny lists keyed by ISO 3166-1 alpha-2 code
+type GeoRules struct {
+	Allow map[string]bool
+	Deny  map[string]bool
+}
+
+// ParseCountries parses a comma-separated list of ISO country codes
+func ParseCountries(list string) map[string]bool {
+	countries := map[string]bool{}
+	for _, code := range strings.Split(list, ",") {
+		if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+			countries[code] = true
+		}
+	}
+	return countries
+}
+
+// Permits reports whether a country passes the rules. The deny list wins over
+// the allow list, and a non-empty allow list rejects everything not on it.
+// Clients whose country can't be resolved (e.g. private addresses) pass.
+func (r GeoRules) Permits(country string) bool {
+	if country == "" {
+		return true
+	}
+	if r.Deny[country] {
+		return false
+	}
+	return len(r.Allow) == 0 || r.Allow[country]
+}
+
+// GeoBlock rejects requests from countries not permitted by rules with 451,
+// resolving the client IP through a MaxMind GeoIP2/GeoLite2 country database.
+// The resolved country code is stored in the context under "country".
+func GeoBlock(db *geoip2.Reader, rules GeoRules) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		country := lookupCountry(db, c.ClientIP())
+		c.Set("country", country)
+
+		if !rules.Permits(country) {
+			c.Error(regionBlocked())
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// GRPCGeoBlock is GeoBlock for gRPC calls, resolving GRPCPeerIP. It checks
+// the given methods, or every one when there are none, failing blocked calls
+// with PERMISSION_DENIED. The country is available from GRPCCountry.
+func GRPCGeoBlock(db *geoip2.Reader, rules GeoRules, methods ...string) grpc.UnaryServerInterceptor {
+	checked := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		checked[method] = true
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if len(checked) > 0 && !checked[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		country := lookupCountry(db, GRPCPeerIP(ctx))
+		if !rules.Permits(country) {
+			return nil, regionBlocked()
+		}
+		return handler(context.WithValue(ctx, countryKey{}, country), req)
+	}
+}
+
+// countryKey is the context key of the country GRPCGeoBlock resolved
+type countryKey struct{}
+
+// GRPCCountry returns the country GRPCGeoBlock resolved for a call, empty
+// when it didn't run or the country is unknown
+func GRPCCountry(ctx context.Context) string {
+	country, _ := ctx.Value(countryKey{}).(string)
+	return country
+}
+
+// lookupCountry returns the ISO code of the country of ip, empty if unknown
+func lookupCountry(db *geoip2.Reader, ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		if record, err := db.Country(parsed); err == nil {
+			return record.Country.IsoCode
+		}
+	}
+	return ""
+}
+
+func regionBlocked() *apperr.Error {
+	return apperr.New(http.StatusUnavailableForLegalReasons, apperr.CodeRegionBlocked, "Service is not available in your region")
+}
@@ -0,0 +1,285 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"goapi/apperr"
+	"goapi/auth"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// errorDomain is the domain of the ErrorInfo of statuses from this API
+	errorDomain = "goapi"
+	// httpStatusKey is the ErrorInfo metadata key holding the HTTP status
+	httpStatusKey = "http_status"
+)
+
+// GRPCLogger logs one line per gRPC call once it is handled, like
+// RequestLogger: with the method, status code, latency and peer IP. Server
+// errors are logged at error level and client errors at warn.
+func GRPCLogger() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		code := status.Code(err)
+		var event *zerolog.Event
+		switch code {
+		case codes.OK:
+			event = log.Info()
+		case codes.Internal, codes.Unknown, codes.Unavailable, codes.DataLoss, codes.Unimplemented:
+			event = log.Error()
+		default:
+			event = log.Warn()
+		}
+
+		event = event.
+			Str("method", info.FullMethod).
+			Str("code", code.String()).
+			Dur("latency_ms", time.Since(start)).
+			Str("ip", GRPCPeerIP(ctx))
+		if err != nil {
+			event = event.Str("error", status.Convert(err).Message())
+		}
+		event.Msg("rpc")
+		return resp, err
+	}
+}
+
+// GRPCRecovery turns a panic in a gRPC method into an INTERNAL error, logging
+// it with its stack trace and reporting it to Sentry when that is set up.
+// The Sentry event ID, if any, is returned as the reference.
+func GRPCRecovery() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			hub := sentry.CurrentHub().Clone()
+			hub.Scope().SetTag("rpc_method", info.FullMethod)
+			var reference string
+			if eventID := hub.RecoverWithContext(ctx, recovered); eventID != nil {
+				reference = string(*eventID)
+			}
+
+			log.Error().
+				Str("panic", fmt.Sprint(recovered)).
+				Str("stack", string(debug.Stack())).
+				Str("method", info.FullMethod).
+				Str("reference", reference).
+				Msg("Recovered from panic")
+
+			appErr := apperr.New(http.StatusInternalServerError, apperr.CodeInternal, "Something went wrong on our side")
+			if reference != "" {
+				appErr.Detail += "; quote the reference when reporting it"
+				appErr.With("reference", reference)
+			}
+			err = grpcStatus(appErr)
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// GRPCErrors turns the *apperr.Error returned by a gRPC method or a later
+// interceptor into a gRPC status, the counterpart of Errors for REST. The
+// status carries a google.rpc.ErrorInfo whose reason is the error's code and
+// whose metadata holds its extensions and HTTP status. Errors that already are statuses are
+// passed on; any other error becomes a generic INTERNAL. Causes of server
+// errors are logged.
+func GRPCErrors() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		if _, ok := status.FromError(err); ok {
+			return resp, err
+		}
+
+		appErr := apperr.From(err)
+		if appErr.Status >= 500 && appErr.Err != nil {
+			log.Error().Err(appErr.Err).Str("method", info.FullMethod).Str("code", string(appErr.Code)).Msg(appErr.Detail)
+		}
+		return resp, grpcStatus(appErr)
+	}
+}
+
+// GRPCAuth checks the access token of calls to the methods in scopes, which
+// maps full method names to the scope they need, "" for none. The token is
+// read from the "authorization" metadata as "Bearer <token>" and checked
+// like RequireAuth does; the caller's claims are then available from
+// auth.FromContext. Other methods, such as login or the health service, are
+// left open.
+func GRPCAuth(db *sql.DB, timeout time.Duration, scopes map[string]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		scope, ok := scopes[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		var header string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get("authorization"); len(values) > 0 {
+				header = values[0]
+			}
+		}
+		token, found := strings.CutPrefix(header, "Bearer ")
+		if !found || strings.TrimSpace(token) == "" {
+			return nil, apperr.New(http.StatusUnauthorized, apperr.CodeUnauthorized, "Authentication required")
+		}
+
+		claims, appErr := authenticate(ctx, db, timeout, strings.TrimSpace(token), GRPCPeerIP(ctx))
+		if appErr != nil {
+			return nil, appErr
+		}
+		if scope != "" && !auth.HasScope(claims.Scopes, scope) {
+			return nil, apperr.New(http.StatusForbidden, apperr.CodeInsufficientScope, "Token is missing the "+scope+" scope")
+		}
+		return handler(auth.NewContext(ctx, claims), req)
+	}
+}
+
+// gatewayKeyHeader is the metadata key carrying gatewayKey
+const gatewayKeyHeader = "x-goapi-gateway-key"
+
+// gatewayKey marks the calls of this process's /api/v1 gateway. It is random
+// per process, so no other client can pass its calls off as the gateway's.
+var gatewayKey = func() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic("generating the gateway key: " + err.Error())
+	}
+	return hex.EncodeToString(buf)
+}()
+
+// GatewayClientInterceptor adds the gateway key to the calls the /api/v1
+// gateway makes, so GRPCPeerIP trusts the client address they forward
+func GatewayClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, gatewayKeyHeader, gatewayKey)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// fromGateway reports whether a call was made by this process's gateway: it
+// comes from the loopback interface and carries the gateway key
+func fromGateway(ctx context.Context, host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return false
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+	keys := md.Get(gatewayKeyHeader)
+	return len(keys) == 1 && subtle.ConstantTimeCompare([]byte(keys[0]), []byte(gatewayKey)) == 1
+}
+
+// GRPCPeerIP returns the IP address a gRPC call came from, empty if unknown.
+// Calls of the /api/v1 gateway are taken to come from the last address in
+// their x-forwarded-for metadata; other callers can't set it.
+func GRPCPeerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	if fromGateway(ctx, host) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if values := md.Get("x-forwarded-for"); len(values) > 0 {
+			forwarded := strings.Split(values[len(values)-1], ",")
+			if client := strings.TrimSpace(forwarded[len(forwarded)-1]); net.ParseIP(client) != nil {
+				return client
+			}
+		}
+	}
+	return host
+}
+
+// grpcStatus converts err to a gRPC status error. Its HTTP status is kept in
+// the ErrorInfo metadata as http_status, so the gateway answers with the
+// same status as the REST API.
+func grpcStatus(err *apperr.Error) error {
+	st := status.New(grpcCode(err), err.Detail)
+	info := &errdetails.ErrorInfo{
+		Reason:   string(err.Code),
+		Domain:   errorDomain,
+		Metadata: map[string]string{httpStatusKey: strconv.Itoa(err.Status)},
+	}
+	if len(err.Extensions) > 0 {
+		for key, value := range err.Extensions {
+			if t, ok := value.(time.Time); ok {
+				info.Metadata[key] = t.Format(time.RFC3339)
+			} else {
+				info.Metadata[key] = fmt.Sprint(value)
+			}
+		}
+	}
+	if detailed, detailErr := st.WithDetails(info); detailErr == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// grpcCode is the gRPC status code closest to err's HTTP status
+func grpcCode(err *apperr.Error) codes.Code {
+	switch err.Code {
+	case apperr.CodeVersionConflict:
+		return codes.Aborted
+	case apperr.CodePendingDeletion:
+		return codes.FailedPrecondition
+	case apperr.CodeSessionLimit:
+		return codes.ResourceExhausted
+	case apperr.CodeRegionBlocked:
+		return codes.PermissionDenied
+	}
+	switch err.Status {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusPreconditionFailed, http.StatusPreconditionRequired:
+		return codes.FailedPrecondition
+	case http.StatusLocked, http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	}
+	if err.Status >= 500 {
+		return codes.Internal
+	}
+	return codes.Unknown
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+)
+
+// ParseCIDRs parses a comma-separated list of CIDR ranges. Bare IP addresses
+// are accepted and treated as single-host ranges.
+func ParseCIDRs(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// IPAllowed reports whether ip is inside one of the networks; an empty list
+// allows every address
+func IPAllowed(networks []*net.IPNet, ip string) bool {
+	if len(networks) == 0 {
+		return true
+	}
+	parsed := net.ParseIP(ip)
+	for _, network := range networks {
+		if parsed != nil && network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustProxies makes r take the client IP from X-Forwarded-For and
+// X-Real-IP only on requests coming from proxies, a comma-separated list of
+// CIDR ranges or IPs. With none, the client IP is the connection's remote
+// address and those headers are ignored, so clients can't pick their IP.
+func TrustProxies(r *gin.Engine, proxies string) error {
+	networks, err := ParseCIDRs(proxies)
+	if err != nil {
+		return err
+	}
+	var trusted []string
+	for _, network := range networks {
+		trusted = append(trusted, network.String())
+	}
+	return r.SetTrustedProxies(trusted)
+}
+
+// IPAllowlist rejects requests whose client IP is outside the given networks
+// with 403. An empty list allows every client. The client IP is only taken
+// from forwarding headers set by TrustProxies' proxies.
+func IPAllowlist(networks []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if IPAllowed(networks, c.ClientIP()) {
+			c.Next()
+			return
+		}
+
+		c.Error(apperr.New(http.StatusForbidden, apperr.CodeForbidden, "Access denied"))
+		c.Abort()
+	}
+}
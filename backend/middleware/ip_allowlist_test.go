@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIPAllowlistIgnoresSpoofedForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	admins, err := ParseCIDRs("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		proxies    string
+		remoteAddr string
+		forwarded  string
+		want       int
+	}{
+		{"no proxies, spoofed header", "", "203.0.113.5:4000", "10.1.2.3", http.StatusForbidden},
+		{"no proxies, allowed address", "", "10.9.9.9:4000", "", http.StatusOK},
+		{"untrusted proxy, spoofed header", "192.0.2.1", "203.0.113.5:4000", "10.1.2.3", http.StatusForbidden},
+		{"trusted proxy, allowed client", "192.0.2.1", "192.0.2.1:4000", "10.1.2.3", http.StatusOK},
+		{"trusted proxy, other client", "192.0.2.1", "192.0.2.1:4000", "203.0.113.5", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			if err := TrustProxies(r, tt.proxies); err != nil {
+				t.Fatal(err)
+			}
+			r.Use(Errors())
+			r.GET("/admin", IPAllowlist(admins), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("got %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
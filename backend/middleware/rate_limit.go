@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"goapi/apperr"
+	"goapi/ratelimit"
+	"google.golang.org/grpc"
+)
+
+// RateLimit limits each client IP to the policy within scope, so the same IP
+// gets separate buckets for separately limited routes. Over the limit it
+// responds 429 with Retry-After. X-RateLimit-* headers are set on every
+// response. If the store fails, requests are let through.
+func RateLimit(store ratelimit.Store, scope string, policy ratelimit.Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !policy.Enabled() {
+			c.Next()
+			return
+		}
+
+		res, err := store.Take(c.Request.Context(), scope+":"+c.ClientIP(), policy)
+		if err != nil {
+			log.Println("Rate limiter unavailable:", err)
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(policy.Burst))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+		if !res.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			c.Error(apperr.New(http.StatusTooManyRequests, apperr.CodeRateLimited, "Too many requests, slow down"))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// GRPCRateLimit is RateLimit for the given gRPC methods, keyed by
+// GRPCPeerIP, so a client shares its buckets between the REST API and gRPC.
+// Over the limit calls fail with RESOURCE_EXHAUSTED and a retry_after in
+// seconds. Other methods are not limited.
+func GRPCRateLimit(store ratelimit.Store, scope string, policy ratelimit.Policy, methods ...string) grpc.UnaryServerInterceptor {
+	limited := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		limited[method] = true
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !policy.Enabled() || !limited[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		res, err := store.Take(ctx, scope+":"+GRPCPeerIP(ctx), policy)
+		if err != nil {
+			log.Println("Rate limiter unavailable:", err)
+			return handler(ctx, req)
+		}
+		if !res.Allowed {
+			return nil, apperr.New(http.StatusTooManyRequests, apperr.CodeRateLimited, "Too many requests, slow down").
+				With("retry_after", ceilSeconds(res.RetryAfter))
+		}
+		return handler(ctx, req)
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"goapi/apperr"
+)
+
+// Recovery turns a panic in a later handler into a problem+json 500 with
+// a "reference" member, logging the panic and its stack trace with the
+// request's logger. When Sentry is set up with sentry.Init the panic is
+// reported there too, and the reference is the Sentry event ID; otherwise
+// it is the request ID, so the log line can be found either way.
+//
+// A panic caused by the client hanging up is only logged, as there is no
+// one left to answer.
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			logger := zerolog.Ctx(c.Request.Context())
+
+			if brokenPipe(recovered) {
+				logger.Warn().Str("path", c.Request.URL.Path).Msgf("Client went away: %v", recovered)
+				c.Abort()
+				return
+			}
+
+			hub := sentry.CurrentHub().Clone()
+			hub.Scope().SetRequest(c.Request)
+			hub.Scope().SetTag("request_id", c.GetString("requestID"))
+			if route := c.FullPath(); route != "" {
+				hub.Scope().SetTag("route", route)
+			}
+			if userID := c.GetInt("userID"); userID != 0 {
+				hub.Scope().SetUser(sentry.User{ID: strconv.Itoa(userID)})
+			}
+			reference := c.GetString("requestID")
+			if eventID := hub.RecoverWithContext(c.Request.Context(), recovered); eventID != nil {
+				reference = string(*eventID)
+			}
+
+			logger.Error().
+				Str("panic", fmt.Sprint(recovered)).
+				Str("stack", string(debug.Stack())).
+				Str("reference", reference).
+				Msg("Recovered from panic")
+
+			err := apperr.New(http.StatusInternalServerError, apperr.CodeInternal,
+				"Something went wrong on our side; quote the reference when reporting it").
+				With("reference", reference).
+				Wrap(fmt.Errorf("panic: %v", recovered))
+			// Recorded so the request log and the trace show the failure
+			c.Error(err)
+			c.Abort()
+			if !c.Writer.Written() {
+				writeProblem(c, err)
+			}
+		}()
+		c.Next()
+	}
+}
+
+// brokenPipe reports whether a panic came from writing to a connection the
+// client closed
+func brokenPipe(recovered interface{}) bool {
+	err, ok := recovered.(error)
+	if !ok {
+		return false
+	}
+	var opErr *net.OpError
+	var syscallErr *os.SyscallError
+	if !errors.As(err, &opErr) || !errors.As(opErr, &syscallErr) {
+		return false
+	}
+	message := strings.ToLower(syscallErr.Error())
+	return strings.Contains(message, "broken pipe") || strings.Contains(message, "connection reset by peer")
+}
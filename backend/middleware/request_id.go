@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID tags each request with an ID, the caller's X-Request-ID when it
+// sends a sane one or a random one otherwise. The ID is stored in the
+// context as "requestID", returned in the X-Request-ID header and in the
+// request_id field of every APIResponse, and attached to the logger in the
+// request's context, so a bug report can be matched to the server's logs.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		c.Set("requestID", id)
+		c.Header(RequestIDHeader, id)
+		logger := log.With().Str("request_id", id).Logger()
+		c.Request = c.Request.WithContext(logger.WithContext(c.Request.Context()))
+
+		field, _ := json.Marshal(id)
+		c.Writer = &requestIDWriter{ResponseWriter: c.Writer, field: append([]byte(`,"request_id":`), field...)}
+		c.Next()
+	}
+}
+
+// validRequestID accepts IDs from other services' tracing, e.g. UUIDs, but
+// nothing that could garble a log line
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.:", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// requestIDWriter adds the request ID to APIResponse bodies, which every
+// handler writes in a single call starting with {"success":
+type requestIDWriter struct {
+	gin.ResponseWriter
+	field   []byte
+	written bool
+}
+
+func (w *requestIDWriter) Write(b []byte) (int, error) {
+	first := !w.written
+	w.written = true
+	if !first || !bytes.HasPrefix(b, []byte(`{"success":`)) || !bytes.HasSuffix(b, []byte("}")) ||
+		!strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		return w.ResponseWriter.Write(b)
+	}
+
+	body := make([]byte, 0, len(b)+len(w.field))
+	body = append(body, b[:len(b)-1]...)
+	body = append(body, w.field...)
+	body = append(body, '}')
+	if _, err := w.ResponseWriter.Write(body); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
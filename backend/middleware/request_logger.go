@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestLogger logs one line per request once it is handled, with the
+// method, path, status, latency and, when known, the request ID, the trace
+// ID and the caller's user ID. Server errors are logged at error level and client
+// errors at warn.
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		var event *zerolog.Event
+		switch {
+		case status >= 500:
+			event = log.Error()
+		case status >= 400:
+			event = log.Warn()
+		default:
+			event = log.Info()
+		}
+
+		event = event.
+			Str("method", c.Request.Method).
+			Str("path", c.Request.URL.Path).
+			Str("route", c.FullPath()).
+			Int("status", status).
+			Dur("latency_ms", time.Since(start)).
+			Int("size", c.Writer.Size()).
+			Str("ip", c.ClientIP())
+		if requestID := c.GetString("requestID"); requestID != "" {
+			event = event.Str("request_id", requestID)
+		}
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.HasTraceID() {
+			event = event.Str("trace_id", sc.TraceID().String())
+		}
+		if userID := c.GetInt("userID"); userID != 0 {
+			event = event.Int("user_id", userID)
+		}
+		if len(c.Errors) > 0 {
+			event = event.Str("error", c.Errors.String())
+		}
+		event.Msg("request")
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing starts a server span for each request, continuing the trace from
+// the caller's traceparent header when it sends one. The span is named
+// after the route, e.g. "GET /api/users/:id", and stored in the request's
+// context so database queries made with it become child spans. The trace
+// ID is attached to the logger in the request's context.
+//
+// Without tracing.Setup the global tracer is a no-op and so is this.
+func Tracing() gin.HandlerFunc {
+	tracer := otel.Tracer("goapi/middleware")
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		name := c.Request.Method + " " + route
+		if route == "" {
+			name = c.Request.Method
+		}
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(c.Request.URL.Path),
+				semconv.ClientAddress(c.ClientIP()),
+				semconv.UserAgentOriginal(c.Request.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		if sc := span.SpanContext(); sc.HasTraceID() {
+			logger := zerolog.Ctx(ctx).With().Str("trace_id", sc.TraceID().String()).Logger()
+			ctx = logger.WithContext(ctx)
+		}
+		if requestID := c.GetString("requestID"); requestID != "" {
+			span.SetAttributes(attribute.String("http.request.header.x_request_id", requestID))
+		}
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if userID := c.GetInt("userID"); userID != 0 {
+			span.SetAttributes(semconv.EnduserID(strconv.Itoa(userID)))
+		}
+		if status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(status))
+			if len(c.Errors) > 0 {
+				span.RecordError(c.Errors.Last())
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	"goapi/config"
+	"goapi/migrations"
+)
+
+// runMigrate applies, rolls back or lists schema migrations. It returns the
+// process exit code.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	steps := fs.Int("steps", 1, "migrations to roll back with down")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: migrate up | down [-steps n] | status")
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		return 2
+	}
+	command := args[0]
+	fs.Parse(args[1:])
+
+	cfg, err := config.Load(nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading configuration:", err)
+		return 1
+	}
+	conn, err := sql.Open("pgx", cfg.Database.ConnString())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening database connection:", err)
+		return 1
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	switch command {
+	case "up":
+		applied, err := migrations.Up(ctx, conn)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error migrating database:", err)
+			return 1
+		}
+		fmt.Printf("Applied %d migration(s)\n", applied)
+	case "down":
+		rolledBack, err := migrations.Down(ctx, conn, *steps)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error rolling back database:", err)
+			return 1
+		}
+		fmt.Printf("Rolled back %d migration(s)\n", rolledBack)
+	case "status":
+		statuses, err := migrations.List(ctx, conn)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading migrations:", err)
+			return 1
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d %-24s %s\n", s.Version, s.Name, applied)
+		}
+	default:
+		fs.Usage()
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,50 @@
+-- Baseline: the users table as it stood before migrations were versioned.
+-- Every statement tolerates a database the server already set up.
+CREATE TABLE IF NOT EXISTS users (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(100) NOT NULL,
+	email VARCHAR(255) NOT NULL UNIQUE,
+	password VARCHAR(255) NOT NULL,
+	age INTEGER,
+	is_active BOOLEAN DEFAULT TRUE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS show_email BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS show_age BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_normalized VARCHAR(255);
+UPDATE users SET email_normalized = LOWER(TRIM(email)) WHERE email_normalized IS NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS flagged_for_review BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS username VARCHAR(30);
+-- Usernames are stored lowercased, so this also ignores case
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_key ON users (username);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS anonymized_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS purge_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_login_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP;
+
+-- Emails are unique whatever their case. Existing rows may already hold the
+-- same mailbox twice; the migration then fails, naming them, rather than
+-- leaving emails unchecked. The second index is a case-insensitive backstop
+-- for rows written without email_normalized.
+DO $$
+DECLARE
+	duplicates TEXT;
+BEGIN
+	SELECT string_agg(mailbox, ', ') INTO duplicates FROM (
+		SELECT email_normalized AS mailbox FROM users GROUP BY email_normalized HAVING COUNT(*) > 1
+		UNION
+		SELECT LOWER(email) FROM users GROUP BY LOWER(email) HAVING COUNT(*) > 1
+	) d;
+	IF duplicates IS NOT NULL THEN
+		RAISE EXCEPTION 'users share an email address (%), so emails cannot be made unique', duplicates
+			USING HINT = 'Merge or rename the duplicate accounts, then run the migrations again.';
+	END IF;
+END
+$$;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_normalized_key ON users (email_normalized);
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_key ON users (LOWER(email));
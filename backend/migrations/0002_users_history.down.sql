@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS users_history_trigger ON users;
+DROP FUNCTION IF EXISTS record_user_history();
+DROP TABLE IF EXISTS users_history;
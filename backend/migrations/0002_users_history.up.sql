@@ -0,0 +1,33 @@
+-- Keep every prior state of a user so admin edits can be rolled back
+CREATE TABLE IF NOT EXISTS users_history (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	version INTEGER NOT NULL,
+	operation VARCHAR(10) NOT NULL,
+	data JSONB NOT NULL,
+	changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (user_id, version)
+);
+
+CREATE OR REPLACE FUNCTION record_user_history() RETURNS TRIGGER AS $$
+BEGIN
+	-- Password re-hashes and activity tracking on login change
+	-- nothing worth a version
+	IF TG_OP = 'UPDATE' AND (to_jsonb(NEW) - 'password' - 'last_login_at' - 'last_seen_at') = (to_jsonb(OLD) - 'password' - 'last_login_at' - 'last_seen_at') THEN
+		RETURN NULL;
+	END IF;
+	INSERT INTO users_history (user_id, version, operation, data)
+	VALUES (
+		OLD.id,
+		COALESCE((SELECT MAX(version) FROM users_history WHERE user_id = OLD.id), 0) + 1,
+		TG_OP,
+		to_jsonb(OLD) - 'password'
+	);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_history_trigger ON users;
+CREATE TRIGGER users_history_trigger
+	AFTER UPDATE OR DELETE ON users
+	FOR EACH ROW EXECUTE FUNCTION record_user_history();
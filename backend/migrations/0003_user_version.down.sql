@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS users_version_trigger ON users;
+DROP FUNCTION IF EXISTS bump_user_version();
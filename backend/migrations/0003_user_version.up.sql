@@ -0,0 +1,15 @@
+-- Bump a user's version on every change clients can see, so updates based
+-- on a stale read can be refused; sign-in activity doesn't count
+CREATE OR REPLACE FUNCTION bump_user_version() RETURNS TRIGGER AS $$
+BEGIN
+	IF (to_jsonb(NEW) - 'password' - 'updated_at' - 'version' - 'last_login_at' - 'last_seen_at') <> (to_jsonb(OLD) - 'password' - 'updated_at' - 'version' - 'last_login_at' - 'last_seen_at') THEN
+		NEW.version := OLD.version + 1;
+	END IF;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_version_trigger ON users;
+CREATE TRIGGER users_version_trigger
+	BEFORE UPDATE ON users
+	FOR EACH ROW EXECUTE FUNCTION bump_user_version();
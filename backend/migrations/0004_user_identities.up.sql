@@ -0,0 +1,10 @@
+-- Social sign-in identities, several per user
+CREATE TABLE IF NOT EXISTS user_identities (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	provider VARCHAR(32) NOT NULL,
+	subject VARCHAR(255) NOT NULL,
+	email VARCHAR(255),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (provider, subject)
+);
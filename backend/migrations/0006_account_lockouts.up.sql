@@ -0,0 +1,8 @@
+-- Failed login counters; kept out of users so they don't show up in
+-- users_history
+CREATE TABLE IF NOT EXISTS account_lockouts (
+	user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	failed_count INTEGER NOT NULL DEFAULT 0,
+	window_started_at TIMESTAMP NOT NULL,
+	locked_until TIMESTAMP
+);
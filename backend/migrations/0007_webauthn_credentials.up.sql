@@ -0,0 +1,16 @@
+-- Passkeys; the public key is COSE-encoded as returned by the authenticator
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	credential_id BYTEA NOT NULL UNIQUE,
+	public_key BYTEA NOT NULL,
+	attestation_type VARCHAR(32) NOT NULL DEFAULT '',
+	transports VARCHAR(100) NOT NULL DEFAULT '',
+	aaguid BYTEA,
+	sign_count BIGINT NOT NULL DEFAULT 0,
+	backup_eligible BOOLEAN NOT NULL DEFAULT false,
+	backup_state BOOLEAN NOT NULL DEFAULT false,
+	name VARCHAR(100),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	last_used_at TIMESTAMP
+);
@@ -0,0 +1,15 @@
+-- Sign-in attempts; user_id is NULL when the email matched no account
+CREATE TABLE IF NOT EXISTS login_events (
+	id BIGSERIAL PRIMARY KEY,
+	user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+	email VARCHAR(255) NOT NULL DEFAULT '',
+	success BOOLEAN NOT NULL,
+	method VARCHAR(40) NOT NULL,
+	failure_reason VARCHAR(40) NOT NULL DEFAULT '',
+	ip VARCHAR(45) NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	device VARCHAR(100) NOT NULL DEFAULT '',
+	country VARCHAR(2) NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS login_events_user_id_idx ON login_events (user_id, created_at DESC);
@@ -0,0 +1,14 @@
+-- Who changed what; no foreign keys so entries outlive deleted users
+CREATE TABLE IF NOT EXISTS audit_logs (
+	id BIGSERIAL PRIMARY KEY,
+	actor_id INTEGER,
+	actor_ip VARCHAR(45) NOT NULL DEFAULT '',
+	action VARCHAR(40) NOT NULL,
+	user_id INTEGER,
+	before JSONB,
+	after JSONB,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS audit_logs_created_at_idx ON audit_logs (created_at);
+CREATE INDEX IF NOT EXISTS audit_logs_user_id_idx ON audit_logs (user_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_logs_actor_id_idx ON audit_logs (actor_id, created_at);
@@ -0,0 +1,3 @@
+-- pg_trgm is left installed; other database objects may use it
+DROP INDEX IF EXISTS users_email_trgm_idx;
+DROP INDEX IF EXISTS users_name_trgm_idx;
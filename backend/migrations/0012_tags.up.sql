@@ -0,0 +1,13 @@
+-- Tags segment users; a tag is removed when its last user is untagged
+CREATE TABLE IF NOT EXISTS tags (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(50) NOT NULL UNIQUE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS user_tags (
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, tag_id)
+);
+CREATE INDEX IF NOT EXISTS user_tags_tag_id_idx ON user_tags (tag_id);
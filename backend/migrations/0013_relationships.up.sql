@@ -0,0 +1,18 @@
+-- Who follows and who blocks whom
+CREATE TABLE IF NOT EXISTS user_relationships (
+	follower_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	followee_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (follower_id, followee_id),
+	CHECK (follower_id <> followee_id)
+);
+CREATE INDEX IF NOT EXISTS user_relationships_followee_id_idx ON user_relationships (followee_id);
+
+CREATE TABLE IF NOT EXISTS user_blocks (
+	blocker_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	blocked_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (blocker_id, blocked_id),
+	CHECK (blocker_id <> blocked_id)
+);
+CREATE INDEX IF NOT EXISTS user_blocks_blocked_id_idx ON user_blocks (blocked_id);
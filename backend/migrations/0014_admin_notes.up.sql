@@ -0,0 +1,9 @@
+-- Support notes admins keep on user records
+CREATE TABLE IF NOT EXISTS admin_notes (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	author_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	body TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS admin_notes_user_id_idx ON admin_notes (user_id);
@@ -0,0 +1,28 @@
+-- Endpoints notified of user lifecycle events, and the queue and log of
+-- their deliveries
+CREATE TABLE IF NOT EXISTS webhooks (
+	id SERIAL PRIMARY KEY,
+	url TEXT NOT NULL,
+	secret VARCHAR(100) NOT NULL,
+	events TEXT[] NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id BIGSERIAL PRIMARY KEY,
+	webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+	-- event_id is shared by the deliveries of one event to different webhooks
+	event_id CHAR(32) NOT NULL,
+	event VARCHAR(50) NOT NULL,
+	payload JSONB NOT NULL,
+	-- pending, succeeded or failed
+	status VARCHAR(20) NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL,
+	response_status INTEGER,
+	last_error TEXT,
+	created_at TIMESTAMP NOT NULL,
+	delivered_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, created_at);
@@ -0,0 +1,13 @@
+-- User events waiting to be relayed to the message broker. Rows are deleted
+-- once the broker has accepted them.
+CREATE TABLE IF NOT EXISTS event_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	event_id CHAR(32) NOT NULL,
+	event VARCHAR(50) NOT NULL,
+	-- event_key keeps a user's events in order on brokers that partition by key
+	event_key VARCHAR(100) NOT NULL,
+	payload JSONB NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	created_at TIMESTAMP NOT NULL
+);
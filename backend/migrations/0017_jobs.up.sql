@@ -0,0 +1,26 @@
+-- Background jobs and their log. Finished jobs are removed after
+-- JOB_RETENTION.
+CREATE TABLE IF NOT EXISTS jobs (
+	id BIGSERIAL PRIMARY KEY,
+	type VARCHAR(50) NOT NULL,
+	payload JSONB NOT NULL,
+	-- pending, running, succeeded or failed
+	status VARCHAR(20) NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	-- run_at is when a pending job is due, and when a running job's lease
+	-- runs out
+	run_at TIMESTAMP NOT NULL,
+	-- unique_key allows only one unfinished job with the same key
+	unique_key VARCHAR(100),
+	last_error TEXT,
+	-- result is what the job produced, e.g. an export file
+	result BYTEA,
+	result_type VARCHAR(100),
+	created_at TIMESTAMP NOT NULL,
+	started_at TIMESTAMP,
+	finished_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS jobs_due_idx ON jobs (run_at) WHERE status IN ('pending', 'running');
+CREATE UNIQUE INDEX IF NOT EXISTS jobs_unique_key_idx ON jobs (unique_key) WHERE status IN ('pending', 'running');
+CREATE INDEX IF NOT EXISTS jobs_created_at_idx ON jobs (created_at);
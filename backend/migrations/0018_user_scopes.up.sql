@@ -0,0 +1,3 @@
+-- Scopes granted to an account on top of JWT_DEFAULT_SCOPES, managed with
+-- the scopes subcommand
+ALTER TABLE users ADD COLUMN IF NOT EXISTS scopes TEXT[] NOT NULL DEFAULT '{}';
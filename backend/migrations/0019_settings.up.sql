@@ -0,0 +1,8 @@
+-- Settings the application records about its own data, e.g. how email
+-- deduplication keys were computed, so a configuration change can be
+-- applied to the rows written before it
+CREATE TABLE IF NOT EXISTS settings (
+	key VARCHAR(100) PRIMARY KEY,
+	value TEXT NOT NULL,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
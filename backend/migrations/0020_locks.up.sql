@@ -0,0 +1,9 @@
+-- Who last took each singleton lock, for GET /api/admin/locks. The locks
+-- themselves are Postgres advisory locks; a row only says who holds one
+-- while the advisory lock with its key is granted.
+CREATE TABLE IF NOT EXISTS locks (
+	name VARCHAR(100) PRIMARY KEY,
+	lock_key INTEGER NOT NULL,
+	holder VARCHAR(255) NOT NULL,
+	acquired_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,12 @@
+-- State of sign-in flows that span two requests (SAML RelayState, OAuth
+-- account links, WebAuthn ceremonies), so the second request can be served
+-- by any replica. Keys are stored hashed; rows are taken once and expire.
+CREATE TABLE IF NOT EXISTS pending_flows (
+	kind VARCHAR(50) NOT NULL,
+	key_hash VARCHAR(64) NOT NULL,
+	value JSONB NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (kind, key_hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_pending_flows_expires_at ON pending_flows(kind, expires_at);
@@ -0,0 +1,2 @@
+-- The indexes belong to 0001; there is nothing to undo
+SELECT 1;
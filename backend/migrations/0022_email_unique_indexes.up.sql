@@ -0,0 +1,20 @@
+-- Earlier builds of 0001 skipped the email unique indexes with a warning
+-- when existing rows collided, and still recorded 0001 as applied. Build
+-- them now, failing with the colliding addresses until they are resolved.
+DO $$
+DECLARE
+	duplicates TEXT;
+BEGIN
+	SELECT string_agg(mailbox, ', ') INTO duplicates FROM (
+		SELECT email_normalized AS mailbox FROM users GROUP BY email_normalized HAVING COUNT(*) > 1
+		UNION
+		SELECT LOWER(email) FROM users GROUP BY LOWER(email) HAVING COUNT(*) > 1
+	) d;
+	IF duplicates IS NOT NULL THEN
+		RAISE EXCEPTION 'users share an email address (%), so emails cannot be made unique', duplicates
+			USING HINT = 'Merge or rename the duplicate accounts, then run the migrations again.';
+	END IF;
+END
+$$;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_normalized_key ON users (email_normalized);
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_key ON users (LOWER(email));
@@ -0,0 +1 @@
+ALTER TABLE pending_flows DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1,4 @@
+-- The user a pending flow belongs to, if any, so erasing or deleting the
+-- user drops their flows with them
+ALTER TABLE pending_flows ADD COLUMN IF NOT EXISTS user_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_pending_flows_user_id ON pending_flows(user_id) WHERE user_id IS NOT NULL;
@@ -0,0 +1,13 @@
+-- Open WebSocket connections of every replica, for GET /api/users/online.
+-- Replicas refresh seen_at while a connection stays open; the rows of a
+-- replica that stopped without removing them stop counting once it is old.
+CREATE TABLE IF NOT EXISTS realtime_connections (
+	id BIGSERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	session_id INTEGER NOT NULL,
+	connected_at TIMESTAMP NOT NULL,
+	seen_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_realtime_connections_user_id ON realtime_connections(user_id, seen_at);
+CREATE INDEX IF NOT EXISTS idx_realtime_connections_seen_at ON realtime_connections(seen_at);
@@ -0,0 +1 @@
+DROP MATERIALIZED VIEW IF EXISTS user_stats_daily;
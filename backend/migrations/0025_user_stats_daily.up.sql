@@ -0,0 +1,15 @@
+-- Users that aren't deleted, summed per signup day, for GET /api/users/stats.
+-- The stats.refresh job refreshes it; refreshed_at is when it last ran.
+CREATE MATERIALIZED VIEW IF NOT EXISTS user_stats_daily AS
+	SELECT created_at::date AS day,
+		COUNT(*) AS signups,
+		COUNT(*) FILTER (WHERE is_active) AS active,
+		COALESCE(SUM(age), 0) AS age_sum,
+		COUNT(age) AS age_count,
+		now()::timestamp AS refreshed_at
+	FROM users
+	WHERE deleted_at IS NULL
+	GROUP BY created_at::date;
+
+-- Refreshing concurrently, so reads aren't blocked, needs a unique index
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_stats_daily_day ON user_stats_daily(day);
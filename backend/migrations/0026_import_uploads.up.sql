@@ -0,0 +1,27 @@
+-- Import files uploaded in chunks through /api/users/import/uploads. The
+-- chunks are kept until the file's import job has read them, so any replica
+-- can take the next chunk or run the job.
+CREATE TABLE IF NOT EXISTS import_uploads (
+	id BIGSERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	filename VARCHAR(255) NOT NULL,
+	format VARCHAR(10) NOT NULL,
+	size BIGINT NOT NULL,
+	received BIGINT NOT NULL DEFAULT 0,
+	checksum VARCHAR(64) NOT NULL DEFAULT '',
+	on_duplicate VARCHAR(10) NOT NULL,
+	dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+	job_id BIGINT,
+	error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_uploads_updated_at ON import_uploads(updated_at);
+
+CREATE TABLE IF NOT EXISTS import_upload_chunks (
+	upload_id BIGINT NOT NULL REFERENCES import_uploads(id) ON DELETE CASCADE,
+	position BIGINT NOT NULL,
+	data BYTEA NOT NULL,
+	PRIMARY KEY (upload_id, position)
+);
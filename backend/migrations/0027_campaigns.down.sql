@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS campaign_recipients;
+DROP TABLE IF EXISTS campaigns;
+DROP TABLE IF EXISTS email_opt_outs;
@@ -0,0 +1,38 @@
+-- Users who don't want campaign emails, by their own choice or through a
+-- campaign's unsubscribe link
+CREATE TABLE IF NOT EXISTS email_opt_outs (
+	user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Emails to a segment of users, sent by a campaigns.send job. filter is the
+-- segment as a repository.UserFilter; resolved_at is set once every user in
+-- it has a recipient row.
+CREATE TABLE IF NOT EXISTS campaigns (
+	id BIGSERIAL PRIMARY KEY,
+	subject TEXT NOT NULL,
+	text_body TEXT NOT NULL,
+	html_body TEXT NOT NULL DEFAULT '',
+	filter JSONB NOT NULL DEFAULT '{}',
+	created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	job_id BIGINT,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	resolved_at TIMESTAMP
+);
+
+-- One row per user in a campaign's segment: pending until the email is
+-- sent or fails, or skipped when the user opted out
+CREATE TABLE IF NOT EXISTS campaign_recipients (
+	campaign_id BIGINT NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	email VARCHAR(255) NOT NULL,
+	status VARCHAR(10) NOT NULL DEFAULT 'pending',
+	error TEXT NOT NULL DEFAULT '',
+	token_hash VARCHAR(64),
+	sent_at TIMESTAMP,
+	PRIMARY KEY (campaign_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_campaign_recipients_status ON campaign_recipients(campaign_id, status);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_campaign_recipients_token_hash ON campaign_recipients(token_hash);
+CREATE INDEX IF NOT EXISTS idx_campaign_recipients_user_id ON campaign_recipients(user_id);
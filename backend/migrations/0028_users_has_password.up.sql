@@ -0,0 +1,34 @@
+-- Whether the user can sign in with a password. Users created by a social or
+-- SAML sign-in get an unusable random one; they were created in the same
+-- statement time as their first identity.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS has_password BOOLEAN NOT NULL DEFAULT TRUE;
+UPDATE users u SET has_password = FALSE
+WHERE EXISTS (SELECT 1 FROM user_identities i WHERE i.user_id = u.id AND i.created_at = u.created_at);
+
+-- Like the password itself, linking or unlinking it isn't a version
+CREATE OR REPLACE FUNCTION bump_user_version() RETURNS TRIGGER AS $$
+BEGIN
+	IF (to_jsonb(NEW) - 'password' - 'has_password' - 'updated_at' - 'version' - 'last_login_at' - 'last_seen_at') <> (to_jsonb(OLD) - 'password' - 'has_password' - 'updated_at' - 'version' - 'last_login_at' - 'last_seen_at') THEN
+		NEW.version := OLD.version + 1;
+	END IF;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION record_user_history() RETURNS TRIGGER AS $$
+BEGIN
+	-- Password changes and activity tracking on login change nothing
+	-- worth a version
+	IF TG_OP = 'UPDATE' AND (to_jsonb(NEW) - 'password' - 'has_password' - 'last_login_at' - 'last_seen_at') = (to_jsonb(OLD) - 'password' - 'has_password' - 'last_login_at' - 'last_seen_at') THEN
+		RETURN NULL;
+	END IF;
+	INSERT INTO users_history (user_id, version, operation, data)
+	VALUES (
+		OLD.id,
+		COALESCE((SELECT MAX(version) FROM users_history WHERE user_id = OLD.id), 0) + 1,
+		TG_OP,
+		to_jsonb(OLD) - 'password' - 'has_password'
+	);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,39 @@
+-- The address of each user's last successful sign-in, only shown to admins.
+-- Existing users take it from their login events.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_login_ip VARCHAR(45);
+UPDATE users u SET last_login_ip = e.ip
+FROM (
+	SELECT DISTINCT ON (user_id) user_id, ip FROM login_events
+	WHERE success AND user_id IS NOT NULL
+	ORDER BY user_id, created_at DESC
+) e
+WHERE e.user_id = u.id AND e.ip <> '' AND u.last_login_ip IS NULL;
+
+-- The address is sign-in activity like last_login_at: no version, and not
+-- kept in the history
+CREATE OR REPLACE FUNCTION bump_user_version() RETURNS TRIGGER AS $$
+BEGIN
+	IF (to_jsonb(NEW) - 'password' - 'has_password' - 'updated_at' - 'version' - 'last_login_at' - 'last_login_ip' - 'last_seen_at') <> (to_jsonb(OLD) - 'password' - 'has_password' - 'updated_at' - 'version' - 'last_login_at' - 'last_login_ip' - 'last_seen_at') THEN
+		NEW.version := OLD.version + 1;
+	END IF;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION record_user_history() RETURNS TRIGGER AS $$
+BEGIN
+	-- Password changes and activity tracking on login change nothing
+	-- worth a version
+	IF TG_OP = 'UPDATE' AND (to_jsonb(NEW) - 'password' - 'has_password' - 'last_login_at' - 'last_login_ip' - 'last_seen_at') = (to_jsonb(OLD) - 'password' - 'has_password' - 'last_login_at' - 'last_login_ip' - 'last_seen_at') THEN
+		RETURN NULL;
+	END IF;
+	INSERT INTO users_history (user_id, version, operation, data)
+	VALUES (
+		OLD.id,
+		COALESCE((SELECT MAX(version) FROM users_history WHERE user_id = OLD.id), 0) + 1,
+		TG_OP,
+		to_jsonb(OLD) - 'password' - 'has_password' - 'last_login_ip'
+	);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
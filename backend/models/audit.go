@@ -0,0 +1,75 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Audited actions
+const (
+	AuditUserCreate         = "user.create"
+	AuditUserUpdate         = "user.update"
+	AuditUserDelete         = "user.delete"
+	AuditUserHardDelete     = "user.hard_delete"
+	AuditUserUndelete       = "user.undelete"
+	AuditUserAnonymize      = "user.anonymize"
+	AuditUserPurge          = "user.purge"
+	AuditUserDeactivate     = "user.deactivate"
+	AuditUserRestore        = "user.restore"
+	AuditUserRevert         = "user.revert"
+	AuditUserImport         = "user.import"
+	AuditUserUnlock         = "user.unlock"
+	AuditUserTag            = "user.tag"
+	AuditUserUntag          = "user.untag"
+	AuditUserNoteAdd        = "user.note_add"
+	AuditUserNoteDelete     = "user.note_delete"
+	AuditUserPasswordChange = "user.password_change"
+	AuditUserExport         = "user.export"
+	AuditSessionRevoke      = "session.revoke"
+	AuditPasskeyRegister    = "passkey.register"
+	AuditIdentityLink       = "identity.link"
+	AuditIdentityUnlink     = "identity.unlink"
+	AuditOrgCreate          = "org.create"
+	AuditOrgUpdate          = "org.update"
+	AuditOrgDelete          = "org.delete"
+	AuditOrgMemberAdd       = "org.member_add"
+	AuditOrgMemberUpdate    = "org.member_update"
+	AuditOrgMemberRemove    = "org.member_remove"
+	AuditOrgInvite          = "org.invite"
+	AuditOrgInviteRevoke    = "org.invite_revoke"
+	AuditWebhookCreate      = "webhook.create"
+	AuditWebhookDelete      = "webhook.delete"
+	AuditCampaignCreate     = "campaign.create"
+)
+
+// FieldChange is one field's value before and after an audited change. From
+// is null for fields a create set, To for fields a delete removed.
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// UserHistoryEntry is one audited change in a user's timeline
+type UserHistoryEntry struct {
+	ID      int64  `json:"id"`
+	Action  string `json:"action"`
+	ActorID *int   `json:"actor_id"`
+	// ActorName is null for jobs and unknown or purged actors
+	ActorName *string       `json:"actor_name"`
+	Changes   []FieldChange `json:"changes"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// AuditLogResponse represents one audited change in API responses. For
+// updates Before and After only hold the fields that changed.
+type AuditLogResponse struct {
+	ID        int64           `json:"id"`
+	ActorID   *int            `json:"actor_id"`
+	ActorIP   string          `json:"actor_ip"`
+	Action    string          `json:"action"`
+	UserID    *int            `json:"user_id"`
+	Before    json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	After     json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+	CreatedAt time.Time       `json:"created_at"`
+}
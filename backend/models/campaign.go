@@ -0,0 +1,70 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Campaign recipient statuses
+const (
+	CampaignRecipientPending = "pending"
+	CampaignRecipientSent    = "sent"
+	CampaignRecipientFailed  = "failed"
+	// CampaignRecipientSkipped means the user opted out of campaign emails
+	CampaignRecipientSkipped = "skipped"
+)
+
+// CampaignRequest is an email to send to a segment of users. The fields are
+// Go templates taking the recipient's Name, Email and Username, and AppURL;
+// an unsubscribe link is added to every email.
+type CampaignRequest struct {
+	Subject string `json:"subject" binding:"required,max=200" example:"What's new in {{.AppURL}}"`
+	Text    string `json:"text" binding:"required,max=50000" example:"Hi {{.Name}}, ..."`
+	// HTML is optional; without it the email is plain text only
+	HTML string `json:"html" binding:"max=100000"`
+}
+
+// Campaign is an email sent to a segment of users, with its progress
+type Campaign struct {
+	ID      int64  `json:"id"`
+	Subject string `json:"subject"`
+	// Segment is the user filter the recipients were chosen by
+	Segment json.RawMessage `json:"segment" swaggertype:"object"`
+	// Status is the campaigns.send job's: pending, running, succeeded or
+	// failed
+	Status string `json:"status"`
+	JobID  *int64 `json:"job_id,omitempty"`
+	// Error is why the job failed
+	Error string `json:"error,omitempty"`
+	// Total counts the users in the segment, once they have all been found
+	Total     int       `json:"total"`
+	Pending   int       `json:"pending"`
+	Sent      int       `json:"sent"`
+	Failed    int       `json:"failed"`
+	Skipped   int       `json:"skipped"`
+	CreatedBy *int      `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// ResolvedAt is when every user in the segment had been found
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// CampaignRecipient is one user's email of a campaign
+type CampaignRecipient struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+	// Status is pending, sent, failed, or skipped for users who opted out
+	Status string     `json:"status"`
+	Error  string     `json:"error,omitempty"`
+	SentAt *time.Time `json:"sent_at,omitempty"`
+}
+
+// EmailPreferences are the emails a user agrees to get; account emails such
+// as password resets are always sent
+type EmailPreferences struct {
+	Campaigns bool `json:"campaigns"`
+}
+
+// UnsubscribeRequest carries the token of a campaign email's unsubscribe link
+type UnsubscribeRequest struct {
+	Token string `json:"token" binding:"required"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Identity is a social or SAML account linked to a user, by which they can
+// sign in
+type Identity struct {
+	ID int `json:"id"`
+	// Provider is google, github or saml
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginMethods are the ways a user can sign in
+type LoginMethods struct {
+	Password   bool       `json:"password"`
+	Identities []Identity `json:"identities"`
+	Passkeys   int        `json:"passkeys"`
+}
+
+// SetPasswordRequest sets a password on an account that has none
+type SetPasswordRequest struct {
+	Password string `json:"password" binding:"required"`
+}
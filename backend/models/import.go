@@ -0,0 +1,112 @@
+package models
+
+import "time"
+
+// Import item actions
+const (
+	ImportActionCreate    = "create"
+	ImportActionUpdate    = "update"
+	ImportActionUnchanged = "unchanged"
+	ImportActionSkipped   = "skipped"
+	ImportActionFailed    = "failed"
+)
+
+// ImportItem represents the outcome for a single imported user
+type ImportItem struct {
+	Line   int    `json:"line,omitempty"`
+	Email  string `json:"email"`
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportReport summarizes an import run
+type ImportReport struct {
+	DryRun    bool         `json:"dry_run"`
+	Total     int          `json:"total"`
+	Created   int          `json:"created"`
+	Updated   int          `json:"updated"`
+	Unchanged int          `json:"unchanged"`
+	Skipped   int          `json:"skipped"`
+	Failed    int          `json:"failed"`
+	Items     []ImportItem `json:"items"`
+}
+
+// Add records an item and updates the counters
+func (r *ImportReport) Add(item ImportItem) {
+	r.Total++
+	switch item.Action {
+	case ImportActionCreate:
+		r.Created++
+	case ImportActionUpdate:
+		r.Updated++
+	case ImportActionUnchanged:
+		r.Unchanged++
+	case ImportActionSkipped:
+		r.Skipped++
+	case ImportActionFailed:
+		r.Failed++
+	}
+	r.Items = append(r.Items, item)
+}
+
+// Import upload statuses. A complete upload that could be imported has its
+// import job's status instead: pending, running, succeeded or failed.
+const (
+	ImportUploadReceiving = "receiving"
+	ImportUploadInvalid   = "invalid"
+	// ImportUploadExpired means the job finished so long ago it is gone
+	ImportUploadExpired = "expired"
+)
+
+// CreateImportUploadRequest starts an import file uploaded in chunks
+type CreateImportUploadRequest struct {
+	Filename string `json:"filename" binding:"required,max=255" example:"users.csv"`
+	// Size is the whole file's length in bytes
+	Size int64 `json:"size" binding:"required,gt=0" example:"209715200"`
+	// Format is csv or jsonl; without it the filename's extension decides
+	Format      string `json:"format" binding:"omitempty,oneof=csv jsonl"`
+	OnDuplicate string `json:"on_duplicate" binding:"omitempty,oneof=skip update fail"`
+	DryRun      bool   `json:"dry_run"`
+	// Checksum is the hex SHA-256 of the whole file, checked once it is
+	// complete
+	Checksum string `json:"checksum" binding:"omitempty,len=64,hexadecimal"`
+}
+
+// ImportUpload is an import file uploaded in chunks and, once complete, its
+// import
+type ImportUpload struct {
+	ID       int64  `json:"id"`
+	Filename string `json:"filename"`
+	Format   string `json:"format"`
+	Size     int64  `json:"size"`
+	// Offset is how many bytes have arrived; the next chunk starts there
+	Offset      int64  `json:"offset"`
+	OnDuplicate string `json:"on_duplicate"`
+	DryRun      bool   `json:"dry_run"`
+	// Status is receiving until the last chunk arrives, then invalid when
+	// the file can't be imported, else its import job's status
+	Status string `json:"status"`
+	JobID  *int64 `json:"job_id,omitempty"`
+	// Error says why the file can't be imported, or why its import failed
+	Error string `json:"error,omitempty"`
+	// Report is the outcome per record once the import has succeeded
+	Report    *ImportReport `json:"report,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// StreamImportResult reports the outcome for one line of an NDJSON import
+type StreamImportResult struct {
+	Line   int    `json:"line"`
+	Email  string `json:"email,omitempty"`
+	Action string `json:"action"`
+	ID     int    `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// StreamImportSummary is written as the last line of an NDJSON import
+type StreamImportSummary struct {
+	Total   int `json:"total"`
+	Created int `json:"created"`
+	Failed  int `json:"failed"`
+}
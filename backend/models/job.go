@@ -0,0 +1,25 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Job is a background job, as listed on /api/admin/jobs
+type Job struct {
+	ID      int64           `json:"id"`
+	Type    string          `json:"type" example:"users.export"`
+	Payload json.RawMessage `json:"payload" swaggertype:"object"`
+	// Status is pending, running, succeeded or failed
+	Status      string `json:"status"`
+	Attempts    int    `json:"attempts"`
+	MaxAttempts int    `json:"max_attempts"`
+	// RunAt is when a pending job is next tried; only set while pending
+	RunAt     *time.Time `json:"run_at,omitempty"`
+	LastError *string    `json:"last_error,omitempty"`
+	// HasResult tells whether GET /admin/jobs/{id}/result has a file
+	HasResult  bool       `json:"has_result"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Lock is a singleton background task's lock and the replica that last took
+// it, as listed on /api/admin/locks
+type Lock struct {
+	Name string `json:"name" example:"scheduler"`
+	// Holder is the replica as host:pid
+	Holder     string    `json:"holder" example:"api-7d9f8b-x2lkq:1"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	// Held is false when the holder let go or died and no replica has taken
+	// the lock since
+	Held bool `json:"held"`
+}
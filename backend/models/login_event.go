@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// Login methods
+const (
+	LoginMethodPassword = "password"
+	LoginMethodPasskey  = "passkey"
+	LoginMethodSAML     = "saml"
+	// Social sign-ins are recorded as "oauth:<provider>"
+	LoginMethodOAuth = "oauth:"
+	// Sessions evicted to make room for a new sign-in are recorded under
+	// this method, with the evicted session's device
+	LoginMethodSession = "session"
+)
+
+// Login failure reasons
+const (
+	LoginFailureUnknownEmail     = "unknown_email"
+	LoginFailureInvalidPassword  = "invalid_password"
+	LoginFailureAccountLocked    = "account_locked"
+	LoginFailureIPLocked         = "ip_locked"
+	LoginFailureInvalidPasskey   = "invalid_passkey"
+	LoginFailureInvalidAssertion = "invalid_assertion"
+	LoginFailurePendingDeletion  = "pending_deletion"
+	LoginFailureAccountDisabled  = "account_disabled"
+	LoginFailureSessionLimit     = "session_limit"
+	LoginFailureSessionEvicted   = "session_evicted"
+)
+
+// LoginEventResponse represents one sign-in attempt in API responses
+type LoginEventResponse struct {
+	ID            int64     `json:"id"`
+	Success       bool      `json:"success"`
+	Method        string    `json:"method"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	IP            string    `json:"ip"`
+	UserAgent     string    `json:"user_agent"`
+	Device        string    `json:"device"`
+	Country       string    `json:"country,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Metadata holds arbitrary key-value profile data, stored as a JSONB object
+type Metadata map[string]interface{}
+
+// Value encodes the metadata for a JSONB column; nil is stored as {}
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(m)
+}
+
+// Scan decodes a JSONB column, reading NULL as an empty object
+func (m *Metadata) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*m = Metadata{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Metadata", src)
+	}
+	decoded := Metadata{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*m = decoded
+	return nil
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// AdminNoteRequest is the body for adding an admin note to a user
+type AdminNoteRequest struct {
+	Body string `json:"body" binding:"required,max=5000"`
+}
+
+// AdminNote is a note admins keep on a user record for support; users never
+// see them
+type AdminNote struct {
+	ID     int `json:"id"`
+	UserID int `json:"user_id"`
+	// AuthorID and AuthorName are unset once the author is deleted
+	AuthorID   *int      `json:"author_id,omitempty"`
+	AuthorName *string   `json:"author_name,omitempty"`
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+}
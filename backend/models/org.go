@@ -0,0 +1,85 @@
+package models
+
+import "time"
+
+// Organization roles, from most to least privileged. Owners can do anything,
+// including deleting the organization and granting admin or owner; admins
+// rename it and add or remove members; members can only see it.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// OrgRoleRank orders roles so permission checks can compare them
+var OrgRoleRank = map[string]int{
+	OrgRoleMember: 1,
+	OrgRoleAdmin:  2,
+	OrgRoleOwner:  3,
+}
+
+// OrgRequest is the body for creating or renaming an organization
+type OrgRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+// OrgResponse represents an organization in API responses
+type OrgResponse struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	MemberCount int    `json:"member_count"`
+	// Role is the caller's role in the organization
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AddMemberRequest adds a user to an organization
+type AddMemberRequest struct {
+	UserID int    `json:"user_id" binding:"required"`
+	Role   string `json:"role" binding:"required,oneof=owner admin member"`
+}
+
+// UpdateMemberRequest changes a member's role
+type UpdateMemberRequest struct {
+	Role string `json:"role" binding:"required,oneof=owner admin member"`
+}
+
+// MemberResponse represents a member of an organization
+type MemberResponse struct {
+	UserID   int       `json:"user_id"`
+	Name     string    `json:"name"`
+	Email    string    `json:"email"`
+	Username *string   `json:"username,omitempty"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// InvitationRequest invites an email address to an organization
+type InvitationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"required,oneof=owner admin member"`
+}
+
+// InvitationResponse represents a pending invitation. The token is only ever
+// sent to the invited address.
+type InvitationResponse struct {
+	ID        int       `json:"id"`
+	OrgID     int       `json:"org_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	InvitedBy *int      `json:"invited_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// InvitationPreview describes an invitation to whoever holds its token
+type InvitationPreview struct {
+	OrgID     int       `json:"org_id"`
+	OrgName   string    `json:"org_name"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// ExistingAccount tells whether to sign in and accept or to sign up
+	ExistingAccount bool `json:"existing_account"`
+}
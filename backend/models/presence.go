@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// OnlineUser is a user with open WebSocket connections
+type OnlineUser struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Username    *string   `json:"username,omitempty"`
+	Connections int       `json:"connections"`
+	OnlineSince time.Time `json:"online_since"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import "encoding/json"
+
+// Problem is an RFC 7807 error response, sent as application/problem+json
+type Problem struct {
+	// Type is always about:blank; Code tells problems apart
+	Type   string `json:"type" example:"about:blank"`
+	Title  string `json:"title" example:"Not Found"`
+	Status int    `json:"status" example:"404"`
+	Detail string `json:"detail,omitempty" example:"User with ID 7 not found"`
+	// Instance is the request path
+	Instance  string `json:"instance,omitempty" example:"/api/users/7"`
+	Code      string `json:"code" example:"user_not_found"`
+	RequestID string `json:"request_id,omitempty"`
+	// Extensions are extra top-level members, e.g. retry_after
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON adds the extension members next to the standard ones
+func (p Problem) MarshalJSON() ([]byte, error) {
+	type problem Problem
+	body, err := json.Marshal(problem(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return body, err
+	}
+	extensions, err := json.Marshal(p.Extensions)
+	if err != nil {
+		return nil, err
+	}
+	body = append(body[:len(body)-1], ',')
+	return append(body, extensions[1:]...), nil
+}
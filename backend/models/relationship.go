@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// RelatedUser is a follower or followed user in relationship listings; only
+// the public profile is shown
+type RelatedUser struct {
+	PublicUserResponse
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+// BlockedUser is a user the caller has blocked
+type BlockedUser struct {
+	PublicUserResponse
+	BlockedAt time.Time `json:"blocked_at"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// UserStats summarizes the users that aren't deleted
+type UserStats struct {
+	Total    int `json:"total"`
+	Active   int `json:"active"`
+	Inactive int `json:"inactive"`
+	// AverageAge is null when no user has an age
+	AverageAge *float64 `json:"average_age"`
+	// SignupsPerDay covers the last StatsSignupDays days, oldest first,
+	// including days without signups
+	SignupsPerDay []DailySignups `json:"signups_per_day"`
+	// RefreshedAt is when the figures were computed, null before there
+	// were any users to count
+	RefreshedAt *time.Time `json:"refreshed_at"`
+}
+
+// DailySignups is the number of users created on one day
+type DailySignups struct {
+	// Date is the day, YYYY-MM-DD in the database's time zone
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+// StatsSignupDays is how many days of signups UserStats covers
+const StatsSignupDays = 30
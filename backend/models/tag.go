@@ -0,0 +1,7 @@
+package models
+
+// TagResponse is a tag with the number of users carrying it
+type TagResponse struct {
+	Name      string `json:"name"`
+	UserCount int    `json:"user_count"`
+}
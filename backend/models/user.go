@@ -1,57 +1,154 @@
 package models
 
 import (
+	"strings"
 	"time"
 )
 
 // User represents the user entity
 type User struct {
 	ID        int       `json:"id" db:"id"`
-	Name      string    `json:"name" db:"name" binding:"required,min=2,max=100"`
+	Name      string    `json:"name" db:"name" binding:"required"`
 	Email     string    `json:"email" db:"email" binding:"required,email"`
-	Password  string    `json:"-" db:"password" binding:"required,min=6"`
+	Username  *string   `json:"username,omitempty" db:"username"`
+	Password  string    `json:"-" db:"password" binding:"required"`
 	Age       *int      `json:"age,omitempty" db:"age"`
 	IsActive  bool      `json:"is_active" db:"is_active"`
+	ShowEmail bool      `json:"show_email" db:"show_email"`
+	ShowAge   bool      `json:"show_age" db:"show_age"`
+	Flagged   bool      `json:"flagged_for_review" db:"flagged_for_review"`
+	Metadata  Metadata  `json:"metadata" db:"metadata"`
+	Version   int       `json:"version" db:"version"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty" db:"last_login_at"`
+	LastLoginIP *string    `json:"last_login_ip,omitempty" db:"last_login_ip"`
+	LastSeenAt  *time.Time `json:"last_seen_at,omitempty" db:"last_seen_at"`
 }
 
 // CreateUserRequest represents the request for creating a user
 type CreateUserRequest struct {
-	Name     string `json:"name" binding:"required,min=2,max=100"`
+	Name     string `json:"name" binding:"required"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
-	Age      *int   `json:"age,omitempty"`
-	IsActive *bool  `json:"is_active,omitempty"`
+	Password string `json:"password" binding:"required"`
+	Username  *string `json:"username,omitempty"`
+	Age       *int   `json:"age,omitempty"`
+	IsActive  *bool  `json:"is_active,omitempty"`
+	ShowEmail *bool  `json:"show_email,omitempty"`
+	ShowAge   *bool  `json:"show_age,omitempty"`
+	Metadata  Metadata `json:"metadata,omitempty" swaggertype:"object"`
+}
+
+// ReplaceUserRequest represents the request for replacing a user; omitted
+// optional fields are reset to their defaults
+type ReplaceUserRequest struct {
+	Name      string   `json:"name" binding:"required"`
+	Email     string   `json:"email" binding:"required,email"`
+	Username  *string  `json:"username,omitempty"`
+	Age       *int     `json:"age,omitempty"`
+	IsActive  *bool    `json:"is_active,omitempty"`
+	ShowEmail bool     `json:"show_email"`
+	ShowAge   bool     `json:"show_age"`
+	Metadata  Metadata `json:"metadata,omitempty" swaggertype:"object"`
 }
 
-// UpdateUserRequest represents the request for updating a user
+// UpdateUserRequest represents a JSON Merge Patch (RFC 7396) of a user:
+// omitted fields are kept and null clears username, age or a metadata key
 type UpdateUserRequest struct {
-	Name     *string `json:"name,omitempty" binding:"omitempty,min=2,max=100"`
+	Name     *string `json:"name,omitempty"`
 	Email    *string `json:"email,omitempty" binding:"omitempty,email"`
-	Age      *int    `json:"age,omitempty"`
-	IsActive *bool   `json:"is_active,omitempty"`
+	Username  *string `json:"username,omitempty"`
+	Age       *int    `json:"age,omitempty"`
+	IsActive  *bool   `json:"is_active,omitempty"`
+	ShowEmail *bool   `json:"show_email,omitempty"`
+	ShowAge   *bool   `json:"show_age,omitempty"`
+	// Metadata is merged into the existing object
+	Metadata Metadata `json:"metadata,omitempty" swaggertype:"object"`
 }
 
 // LoginRequest represents the login request
 type LoginRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
+	// Scope optionally limits the token, e.g. "users:read"
+	Scope string `json:"scope,omitempty"`
+	// Restore brings back an account that is scheduled for deletion
+	Restore bool `json:"restore,omitempty"`
+}
+
+// RestoreAccountRequest confirms a social or SAML sign-in to an account
+// scheduled for deletion, keeping the account
+type RestoreAccountRequest struct {
+	RestoreToken string `json:"restore_token" binding:"required"`
 }
 
 // SignupRequest represents the signup request
 type SignupRequest struct {
-	Name     string `json:"name" binding:"required,min=2,max=100"`
+	Name     string `json:"name" binding:"required"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"`
+	Username *string `json:"username,omitempty"`
 	Age      *int   `json:"age,omitempty"`
+	// Website is a honeypot field rendered hidden by the signup form; humans leave it empty
+	Website      string `json:"website,omitempty"`
+	CaptchaToken string `json:"captcha_token,omitempty"`
+	// InvitationToken signs up through an organization invitation, joining
+	// the organization; it works even when signup is disabled
+	InvitationToken string `json:"invitation_token,omitempty"`
+}
+
+// ChangePasswordRequest represents a request to change the caller's password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// LookupUsersRequest represents a request for several users by ID
+type LookupUsersRequest struct {
+	IDs []int `json:"ids" binding:"required,min=1"`
+}
+
+// BatchUsersResponse represents the result of looking up users by ID
+type BatchUsersResponse struct {
+	// Users holds UserResponse objects, limited to the fields selected with ?fields=
+	Users   interface{} `json:"users" swaggertype:"array,object"`
+	Missing []int       `json:"missing"`
+}
+
+// DeactivationReport lists the users deactivated for not being seen in Days
+// days, or the ones that would be on a dry run
+type DeactivationReport struct {
+	DryRun bool  `json:"dry_run"`
+	Days   int   `json:"days"`
+	IDs    []int `json:"ids"`
 }
 
 // APIResponse represents a standard API response
 type APIResponse struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data,omitempty"`
-	Message string      `json:"message,omitempty"`
+	Success    bool        `json:"success"`
+	Data       interface{} `json:"data,omitempty"`
+	Message    string      `json:"message,omitempty"`
+	Pagination interface{} `json:"pagination,omitempty"`
+	// RequestID is filled in by middleware.RequestID
+	RequestID  string      `json:"request_id,omitempty"`
+}
+
+// Pagination describes the page of a paginated listing. Next and Prev are
+// page numbers, null on the last and first page.
+type Pagination struct {
+	Page       int  `json:"page"`
+	PageSize   int  `json:"page_size"`
+	Total      int  `json:"total"`
+	TotalPages int  `json:"total_pages"`
+	Next       *int `json:"next"`
+	Prev       *int `json:"prev"`
+}
+
+// CursorPagination describes a page of a keyset-paginated listing. NextCursor
+// is null on the last page.
+type CursorPagination struct {
+	PageSize   int     `json:"page_size"`
+	NextCursor *string `json:"next_cursor"`
 }
 
 // UserResponse represents the user data in API responses
@@ -59,10 +156,70 @@ type UserResponse struct {
 	ID        int        `json:"id"`
 	Name      string     `json:"name"`
 	Email     string     `json:"email"`
+	Username  *string    `json:"username,omitempty"`
 	Age       *int       `json:"age,omitempty"`
 	IsActive  bool       `json:"is_active"`
+	ShowEmail bool       `json:"show_email"`
+	ShowAge   bool       `json:"show_age"`
+	// Flagged is only returned to callers with the admin scope
+	Flagged   bool       `json:"flagged_for_review,omitempty"`
+	Metadata  Metadata   `json:"metadata" swaggertype:"object"`
+	// Version goes up on every change; it is also sent as the ETag
+	Version   int        `json:"version"`
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
+	// LastLoginAt is the last successful sign-in, by any method
+	LastLoginAt *time.Time `json:"last_login_at"`
+	// LastLoginIP is where it came from, only returned to callers with the
+	// admin scope
+	LastLoginIP *string `json:"last_login_ip,omitempty"`
+	// LastSeenAt is the last authenticated request, updated at most once a minute
+	LastSeenAt *time.Time `json:"last_seen_at"`
+}
+
+// AuthResponse is returned by login and signup
+type AuthResponse struct {
+	User      UserResponse `json:"user"`
+	Token     string       `json:"token"`
+	Scopes    []string     `json:"scopes"`
+	ExpiresAt time.Time    `json:"expires_at"`
+}
+
+// AccountDeletionResponse tells when an account deleted by its owner is
+// purged for good
+type AccountDeletionResponse struct {
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// SessionResponse represents a signed-in device in API responses
+type SessionResponse struct {
+	ID         int       `json:"id"`
+	Device     string    `json:"device"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Current    bool      `json:"current"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// OAuthLinkResponse holds the provider URL that completes an identity link
+type OAuthLinkResponse struct {
+	URL string `json:"url"`
+}
+
+// WebAuthnBeginResponse starts a passkey registration or login. Options is
+// passed to the browser's WebAuthn API; Ceremony is sent back with its result.
+type WebAuthnBeginResponse struct {
+	Ceremony string      `json:"ceremony"`
+	Options  interface{} `json:"options"`
+}
+
+// WebAuthnCredentialResponse represents a registered passkey in API responses
+type WebAuthnCredentialResponse struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // ToUserResponse converts a User to UserResponse
@@ -71,9 +228,109 @@ func (u *User) ToUserResponse() UserResponse {
 		ID:        u.ID,
 		Name:      u.Name,
 		Email:     u.Email,
+		Username:  u.Username,
 		Age:       u.Age,
 		IsActive:  u.IsActive,
+		ShowEmail: u.ShowEmail,
+		ShowAge:   u.ShowAge,
+		Metadata:  u.Metadata,
+		Version:   u.Version,
 		CreatedAt: u.CreatedAt,
 		UpdatedAt: u.UpdatedAt,
+		LastLoginAt: u.LastLoginAt,
+		LastSeenAt:  u.LastSeenAt,
+	}
+}
+
+// ToAdminUserResponse is ToUserResponse with the fields only admins see,
+// such as whether signup abuse scoring flagged the account
+func (u *User) ToAdminUserResponse() UserResponse {
+	resp := u.ToUserResponse()
+	resp.Flagged = u.Flagged
+	resp.LastLoginIP = u.LastLoginIP
+	return resp
+}
+
+// ToRestrictedUserResponse is ToUserResponse for callers who may only read
+// other users: the email is masked and the age left out, unless the user
+// made them public
+func (u *User) ToRestrictedUserResponse() UserResponse {
+	resp := u.ToUserResponse()
+	if !u.ShowEmail {
+		resp.Email = MaskEmail(u.Email)
 	}
-} 
\ No newline at end of file
+	if !u.ShowAge {
+		resp.Age = nil
+	}
+	return resp
+}
+
+// MaskEmail hides an address but for the first letter of the mailbox and
+// the domain, e.g. j***@example.com
+func MaskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return "***"
+	}
+	return email[:1] + "***" + email[at:]
+}
+
+// UserSnapshot represents a prior state of a user as stored in users_history
+type UserSnapshot struct {
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	Username  *string `json:"username"`
+	Age       *int   `json:"age"`
+	IsActive  bool   `json:"is_active"`
+	ShowEmail bool   `json:"show_email"`
+	ShowAge   bool   `json:"show_age"`
+	Metadata  Metadata `json:"metadata" swaggertype:"object"`
+}
+
+// UserVersion represents one entry of a user's history
+type UserVersion struct {
+	Version   int          `json:"version"`
+	Operation string       `json:"operation"`
+	ChangedAt time.Time    `json:"changed_at"`
+	Data      UserSnapshot `json:"data"`
+}
+
+// PublicUserResponse represents the publicly visible part of a user profile
+type PublicUserResponse struct {
+	ID       int     `json:"id"`
+	Name     string  `json:"name"`
+	Username *string `json:"username,omitempty"`
+	Email    *string `json:"email,omitempty"`
+	Age      *int    `json:"age,omitempty"`
+}
+
+// ToPublicUserResponse converts a User to PublicUserResponse, keeping only
+// the fields the user has made public
+func (u *User) ToPublicUserResponse() PublicUserResponse {
+	resp := PublicUserResponse{
+		ID:       u.ID,
+		Name:     u.Name,
+		Username: u.Username,
+	}
+	if u.ShowEmail {
+		email := u.Email
+		resp.Email = &email
+	}
+	if u.ShowAge {
+		resp.Age = u.Age
+	}
+	return resp
+}
+
+// AvailabilityResult says whether a username or email can still be taken
+type AvailabilityResult struct {
+	Available bool `json:"available"`
+	// Reason explains why the value isn't available
+	Reason string `json:"reason,omitempty"`
+}
+
+// AvailabilityResponse holds a result for each value that was checked
+type AvailabilityResponse struct {
+	Username *AvailabilityResult `json:"username,omitempty"`
+	Email    *AvailabilityResult `json:"email,omitempty"`
+}
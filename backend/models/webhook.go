@@ -0,0 +1,44 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WebhookRequest is the body for registering a webhook
+type WebhookRequest struct {
+	URL    string   `json:"url" binding:"required,url,max=2000" example:"https://example.com/hooks/users"`
+	Events []string `json:"events" binding:"required,min=1,dive,oneof=user.created user.updated user.deleted user.login" example:"user.created,user.deleted"`
+	// Secret signs the deliveries; a random one is generated when omitted
+	Secret string `json:"secret,omitempty" binding:"omitempty,min=16,max=100"`
+}
+
+// Webhook is an endpoint notified of user events
+type Webhook struct {
+	ID     int      `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret is only returned when the webhook is registered
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one event sent, or to be sent, to a webhook
+type WebhookDelivery struct {
+	ID        int64 `json:"id"`
+	WebhookID int   `json:"webhook_id"`
+	// EventID is the same in every webhook's delivery of an event, and across retries
+	EventID string          `json:"event_id"`
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload" swaggertype:"object"`
+	// Status is pending, succeeded or failed
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	// NextAttemptAt is only set while pending
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	// ResponseStatus is the HTTP status of the last attempt, unset if it got no response
+	ResponseStatus *int       `json:"response_status,omitempty"`
+	LastError      *string    `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+}
@@ -0,0 +1,9 @@
+package userv1
+
+import _ "embed"
+
+// OpenAPI is the OpenAPI v2 document of the /api/v1 gateway routes,
+// generated from user.proto along with them
+//
+//go:embed user.swagger.json
+var OpenAPI []byte
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Policy is a token bucket: Burst requests at once, refilled at Rate per second
+type Policy struct {
+	Rate  float64
+	Burst int
+}
+
+// Enabled reports whether the policy limits anything
+func (p Policy) Enabled() bool {
+	return p.Rate > 0 && p.Burst > 0
+}
+
+// Result is the outcome of taking a token
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until a token is available when not allowed
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// Store keeps token buckets
+type Store interface {
+	Take(ctx context.Context, key string, p Policy) (Result, error)
+}
+
+// resultFor builds a Result from the tokens left after a take
+func resultFor(p Policy, allowed bool, tokens float64) Result {
+	res := Result{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(p.Burst) - tokens) / p.Rate * float64(time.Second)),
+	}
+	if !allowed {
+		res.RetryAfter = time.Duration((1 - tokens) / p.Rate * float64(time.Second))
+	}
+	return res
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	policy Policy
+}
+
+// MemoryStore keeps buckets in process memory, so each replica limits separately
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+// maxIdleBuckets is the map size above which full buckets are dropped
+const maxIdleBuckets = 10000
+
+// Take removes a token from the bucket for key if one is available
+func (s *MemoryStore) Take(_ context.Context, key string, p Policy) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if len(s.buckets) > maxIdleBuckets {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(p.Burst), last: now, policy: p}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(p.Burst), b.tokens+now.Sub(b.last).Seconds()*p.Rate)
+	b.last = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return resultFor(p, allowed, b.tokens), nil
+}
+
+// sweep drops buckets that have refilled completely, which behave the same as
+// missing ones. Callers must hold mu.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.policy.Rate >= float64(b.policy.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package realtime
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"goapi/database"
+	"goapi/metrics"
+)
+
+// presenceLock is the first key of the advisory locks serializing a user's
+// connects and disconnects, apart from the singleton locks
+const presenceLock = 4250393
+
+// staleAfter is how long a connection counts as open without its replica
+// checking it; it is set by Start
+var staleAfter = 3 * time.Minute
+
+// Presence is an online user
+type Presence struct {
+	UserID      int
+	Connections int
+	// Since is when the user's oldest open connection was made
+	Since time.Time
+}
+
+// Online returns the users with open connections to any replica, by user ID
+func Online(ctx context.Context, db database.Querier) ([]Presence, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT user_id, COUNT(*), MIN(connected_at) FROM realtime_connections
+		WHERE seen_at > $1
+		GROUP BY user_id ORDER BY user_id
+	`, time.Now().Add(-staleAfter))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	online := []Presence{}
+	for rows.Next() {
+		var p Presence
+		if err := rows.Scan(&p.UserID, &p.Connections, &p.Since); err != nil {
+			return nil, err
+		}
+		online = append(online, p)
+	}
+	return online, rows.Err()
+}
+
+// register records c and adds it to the clients, announcing the user if it
+// is their first connection to any replica
+func register(c *client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	err := database.Transact(ctx, pool, func(tx *sql.Tx) error {
+		if err := lockPresence(ctx, tx, c.userID); err != nil {
+			return err
+		}
+		online, err := connected(ctx, tx, c.userID)
+		if err != nil {
+			return err
+		}
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO realtime_connections (user_id, session_id, connected_at, seen_at)
+			VALUES ($1, $2, $3, $3) RETURNING id
+		`, c.userID, c.sessionID, c.since).Scan(&c.id)
+		if err != nil || online {
+			return err
+		}
+		return Publish(ctx, tx, EventUserOnline, c.userID, map[string]int{"user_id": c.userID})
+	})
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	clients[c] = true
+	mu.Unlock()
+	metrics.WebSocketConnections.Inc()
+	return nil
+}
+
+// unregister removes c, announcing the user if it was their last
+// connection to any replica
+func unregister(c *client) {
+	mu.Lock()
+	delete(clients, c)
+	mu.Unlock()
+	metrics.WebSocketConnections.Dec()
+
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	err := database.Transact(ctx, pool, func(tx *sql.Tx) error {
+		if err := lockPresence(ctx, tx, c.userID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM realtime_connections WHERE id = $1`, c.id); err != nil {
+			return err
+		}
+		online, err := connected(ctx, tx, c.userID)
+		if err != nil || online {
+			return err
+		}
+		return Publish(ctx, tx, EventUserOffline, c.userID, map[string]int{"user_id": c.userID})
+	})
+	if err != nil {
+		log.Println("Error removing realtime connection:", err)
+	}
+}
+
+// lockPresence keeps other connects and disconnects of the user waiting
+// until tx ends, so exactly one of them sees the user come or go
+func lockPresence(ctx context.Context, tx *sql.Tx, userID int) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, $2)`, presenceLock, userID)
+	return err
+}
+
+// connected reports whether the user has a connection to any replica
+func connected(ctx context.Context, tx *sql.Tx, userID int) (bool, error) {
+	var online bool
+	err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM realtime_connections WHERE user_id = $1 AND seen_at > $2)
+	`, userID, time.Now().Add(-staleAfter)).Scan(&online)
+	return online, err
+}
+
+// touchConnections keeps the connections with ids open
+func touchConnections(db *sql.DB, ids []int64, now time.Time) {
+	if _, err := db.Exec(`UPDATE realtime_connections SET seen_at = $2 WHERE id = ANY($1)`, ids, now); err != nil {
+		log.Println("Error refreshing realtime connections:", err)
+	}
+}
+
+// pruneConnections drops the connections no replica has checked lately
+func pruneConnections(db *sql.DB) {
+	if _, err := db.Exec(`DELETE FROM realtime_connections WHERE seen_at <= $1`, time.Now().Add(-staleAfter)); err != nil {
+		log.Println("Error pruning realtime connections:", err)
+	}
+}
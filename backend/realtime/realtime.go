@@ -0,0 +1,372 @@
+// Package realtime pushes user events to clients connected over WebSockets
+// and tracks which users are online. Events are published with Postgres
+// NOTIFY, and every replica LISTENs and passes them on to the clients
+// connected to it, so clients hear of changes made through any replica.
+// Connections are recorded in Postgres too, so presence covers them all.
+package realtime
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5"
+	"goapi/database"
+	"goapi/models"
+)
+
+// Presence events, sent when a user's first connection opens and their last
+// one closes
+const (
+	EventUserOnline  = "user.online"
+	EventUserOffline = "user.offline"
+)
+
+const (
+	// writeTimeout bounds writing one message to a client
+	writeTimeout = 10 * time.Second
+	// pingInterval is how often clients are pinged; one that hasn't answered
+	// within pongTimeout is dropped
+	pingInterval = 30 * time.Second
+	pongTimeout  = pingInterval + writeTimeout
+	// sendBuffer is how many messages may wait for a client before it is
+	// dropped as too slow
+	sendBuffer = 64
+	// maxMessageSize limits what clients send, which is only control frames
+	maxMessageSize = 512
+
+	// channel is the NOTIFY channel events are published on
+	channel = "realtime"
+	// maxPayload is the longest NOTIFY payload Postgres accepts, in bytes
+	maxPayload = 7999
+	// listenRetry is how long to wait before listening again after the
+	// listening connection failed
+	listenRetry = 5 * time.Second
+)
+
+// Message is the JSON of every message sent to clients, shaped like webhook
+// payloads
+type Message struct {
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// client is one open connection
+type client struct {
+	// id is the connection's realtime_connections row
+	id        int64
+	conn      *websocket.Conn
+	userID    int
+	sessionID int
+	// restricted clients may only read users; other users in their events
+	// have their private email and age masked, as GET /api/users does
+	restricted bool
+	since      time.Time
+	send       chan []byte
+
+	closeOnce sync.Once
+	done      chan struct{}
+	// closeMsg is the close frame sent when the server ends the connection
+	closeMsg []byte
+}
+
+var (
+	mu      sync.RWMutex
+	clients = make(map[*client]bool)
+
+	// pool records the connections; it is set by Start
+	pool *sql.DB
+
+	upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		// Connections are authenticated with an access token rather than
+		// cookies, so a page on another origin can't open one as the user
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+)
+
+// Serve upgrades the request to a WebSocket for the user's session and
+// streams events to it until either side closes it. A restricted caller
+// gets events about other users masked. When the upgrade fails
+// the upgrader has already answered the request; when the connection can't
+// be recorded it is closed with an error.
+func Serve(w http.ResponseWriter, r *http.Request, userID, sessionID int, restricted bool) error {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return err
+	}
+	c := &client{
+		conn:       conn,
+		userID:     userID,
+		sessionID:  sessionID,
+		restricted: restricted,
+		since:      time.Now(),
+		send:       make(chan []byte, sendBuffer),
+		done:       make(chan struct{}),
+	}
+	if err := register(c); err != nil {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "try again later"),
+			time.Now().Add(writeTimeout))
+		conn.Close()
+		return err
+	}
+
+	go c.writeLoop()
+	c.readLoop()
+	unregister(c)
+	return nil
+}
+
+// Publish sends event with data about a user to the clients of every
+// replica. With a transaction for db, it is sent when the transaction
+// commits. Data too large for a notification is cut down to the user ID,
+// for clients to fetch the user themselves.
+func Publish(ctx context.Context, db database.Querier, event string, userID int, data interface{}) error {
+	msg, err := json.Marshal(Message{Event: event, CreatedAt: time.Now(), Data: data})
+	if err != nil {
+		return err
+	}
+	if len(msg) > maxPayload {
+		msg, err = json.Marshal(Message{Event: event, CreatedAt: time.Now(), Data: map[string]int{"user_id": userID}})
+		if err != nil {
+			return err
+		}
+	}
+	_, err = db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, channel, string(msg))
+	return err
+}
+
+// broadcast sends msg to every client connected to this replica. It never
+// blocks: clients too slow to keep up are disconnected.
+func broadcast(msg []byte) {
+	mu.RLock()
+	defer mu.RUnlock()
+	var masked []byte
+	var subject int
+	for c := range clients {
+		out := msg
+		if c.restricted {
+			if masked == nil {
+				masked, subject = restrict(msg)
+			}
+			if subject != c.userID {
+				out = masked
+			}
+		}
+		select {
+		case c.send <- out:
+		default:
+			c.close(websocket.ClosePolicyViolation, "too slow to keep up")
+		}
+	}
+}
+
+// restrict returns msg for restricted clients, with the user it carries
+// masked like models.User.ToRestrictedUserResponse does, and the ID of the
+// user the event is about
+func restrict(msg []byte) ([]byte, int) {
+	var m struct {
+		Event     string                     `json:"event"`
+		CreatedAt time.Time                  `json:"created_at"`
+		Data      map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return msg, 0
+	}
+	var subject int
+	json.Unmarshal(m.Data["user_id"], &subject)
+	raw, ok := m.Data["user"]
+	if !ok {
+		return msg, subject
+	}
+
+	var user map[string]interface{}
+	if err := json.Unmarshal(raw, &user); err != nil || user == nil {
+		return msg, subject
+	}
+	if email, ok := user["email"].(string); ok && user["show_email"] != true {
+		user["email"] = models.MaskEmail(email)
+	}
+	if user["show_age"] != true {
+		delete(user, "age")
+	}
+	delete(user, "flagged_for_review")
+	delete(user, "last_login_ip")
+	raw, err := json.Marshal(user)
+	if err != nil {
+		return msg, subject
+	}
+	m.Data["user"] = raw
+	masked, err := json.Marshal(m)
+	if err != nil {
+		return msg, subject
+	}
+	return masked, subject
+}
+
+// Start listens for published events on a connection of its own to the
+// database at connString, and checks the connected sessions every interval,
+// from background goroutines. Connections of sessions that were revoked or
+// expired are closed; the others count as activity, like requests do, and
+// stay online. Connections a replica hasn't checked for three intervals,
+// because it stopped without closing them, are dropped.
+//
+// Events published while the listening connection is down, until it is
+// back, are missed by this replica's clients.
+func Start(db *sql.DB, connString string, interval time.Duration) {
+	pool = db
+	staleAfter = 3 * interval
+	go func() {
+		for {
+			if err := listen(context.Background(), connString); err != nil {
+				log.Println("Error listening for realtime events:", err)
+			}
+			time.Sleep(listenRetry)
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			checkSessions(db)
+			pruneConnections(db)
+		}
+	}()
+}
+
+// listen passes the events published on channel to this replica's clients
+// until the connection fails
+func listen(ctx context.Context, connString string) error {
+	conn, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+channel); err != nil {
+		return err
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		broadcast([]byte(n.Payload))
+	}
+}
+
+func checkSessions(db *sql.DB) {
+	mu.RLock()
+	var sessionIDs []int64
+	seen := make(map[int]bool)
+	for c := range clients {
+		if !seen[c.sessionID] {
+			seen[c.sessionID] = true
+			sessionIDs = append(sessionIDs, int64(c.sessionID))
+		}
+	}
+	mu.RUnlock()
+	if len(sessionIDs) == 0 {
+		return
+	}
+
+	now := time.Now()
+	rows, err := db.Query(`
+		UPDATE sessions SET last_seen_at = $2
+		WHERE id = ANY($1) AND revoked_at IS NULL AND expires_at > $2
+		RETURNING id
+	`, sessionIDs, now)
+	if err != nil {
+		log.Println("Error checking realtime sessions:", err)
+		return
+	}
+	live := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			log.Println("Error checking realtime sessions:", err)
+			return
+		}
+		live[id] = true
+	}
+	rows.Close()
+
+	var userIDs, connectionIDs []int64
+	mu.RLock()
+	for c := range clients {
+		if live[c.sessionID] {
+			userIDs = append(userIDs, int64(c.userID))
+			connectionIDs = append(connectionIDs, c.id)
+		} else if seen[c.sessionID] {
+			c.close(websocket.ClosePolicyViolation, "session has ended")
+		}
+	}
+	mu.RUnlock()
+	if len(userIDs) > 0 {
+		db.Exec(`UPDATE users SET last_seen_at = $2 WHERE id = ANY($1)`, userIDs, now)
+		touchConnections(db, connectionIDs, now)
+	}
+}
+
+// close ends the connection with a close frame carrying code and reason
+func (c *client) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeMsg = websocket.FormatCloseMessage(code, reason)
+		close(c.done)
+	})
+}
+
+// readLoop reads until the connection fails or is closed. Clients only
+// listen, so anything but control frames is discarded; reading is what
+// handles pongs and the client's close frame.
+func (c *client) readLoop() {
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+	for {
+		if _, _, err := c.conn.NextReader(); err != nil {
+			c.close(websocket.CloseNormalClosure, "")
+			return
+		}
+	}
+}
+
+// writeLoop writes queued messages and pings until the client is closed,
+// then sends the close frame. Closing the connection on the way out ends
+// readLoop too.
+func (c *client) writeLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-c.done:
+			c.conn.WriteControl(websocket.CloseMessage, c.closeMsg, time.Now().Add(writeTimeout))
+			return
+		}
+	}
+}
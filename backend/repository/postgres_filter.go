@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// conditions collects WHERE conditions and their numbered parameters
+type conditions struct {
+	list []string
+	args []interface{}
+}
+
+// add adds a condition whose $%d verbs are numbered after the parameters
+// already collected, one per arg
+func (w *conditions) add(condition string, args ...interface{}) {
+	verbs := make([]interface{}, len(args))
+	for i, arg := range args {
+		w.args = append(w.args, arg)
+		verbs[i] = len(w.args)
+	}
+	w.list = append(w.list, fmt.Sprintf(condition, verbs...))
+}
+
+// param adds a parameter used outside the WHERE clause and returns its
+// placeholder
+func (w *conditions) param(arg interface{}) string {
+	w.args = append(w.args, arg)
+	return "$" + strconv.Itoa(len(w.args))
+}
+
+// clause returns the WHERE clause, or nothing without conditions
+func (w *conditions) clause() string {
+	if len(w.list) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.list, " AND ")
+}
+
+// likeEscaper escapes LIKE wildcards so a search term matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// filterConditions turns f into WHERE conditions. rank is the ORDER BY key
+// putting closer search matches first, or "" without a search.
+func filterConditions(f UserFilter) (w *conditions, rank string) {
+	w = &conditions{}
+	w.add("deleted_at IS NULL")
+	if f.Active != nil {
+		w.add("is_active = $%d", *f.Active)
+	}
+	if f.MinAge != nil {
+		w.add("age >= $%d", *f.MinAge)
+	}
+	if f.MaxAge != nil {
+		w.add("age <= $%d", *f.MaxAge)
+	}
+	if f.CreatedAfter != nil {
+		w.add("created_at >= $%d", *f.CreatedAfter)
+	}
+	if f.CreatedBefore != nil {
+		w.add("created_at < $%d", *f.CreatedBefore)
+	}
+	if f.InactiveSince != nil {
+		w.add(LastActivity+" < $%d", *f.InactiveSince)
+	}
+	for _, tag := range f.Tags {
+		w.add("id IN (SELECT ut.user_id FROM user_tags ut JOIN tags t ON t.id = ut.tag_id WHERE t.name = $%d)", tag)
+	}
+	// Keys in order, so the same filter always makes the same statement
+	keys := make([]string, 0, len(f.Metadata))
+	for key := range f.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range f.Metadata[key] {
+			w.add("metadata ->> $%d = $%d", key, value)
+		}
+	}
+	if f.Before != nil {
+		w.add("(created_at, id) < ($%d, $%d)", f.Before.CreatedAt, f.Before.ID)
+	}
+	if f.Search != "" {
+		pattern := "%" + likeEscaper.Replace(f.Search) + "%"
+		w.add("(name ILIKE $%[1]d OR email ILIKE $%[1]d OR name %% $%[2]d OR email %% $%[2]d)", pattern, f.Search)
+		n := len(w.args)
+		rank = fmt.Sprintf("GREATEST(similarity(name, $%d), similarity(email, $%d)) DESC", n, n)
+	}
+	return w, rank
+}
+
+// orderBy returns the ORDER BY list for keys, or for rank and then newest
+// first without any. Columns sort with nulls last, and the ID is always the
+// last key, in the direction of the first, so pages are stable.
+func orderBy(keys []SortKey, rank string) (string, error) {
+	if len(keys) == 0 {
+		if rank != "" {
+			return rank + ", created_at DESC, id DESC", nil
+		}
+		return "created_at DESC, id DESC", nil
+	}
+
+	var list []string
+	seen := map[string]bool{}
+	tiebreak := ""
+	for _, key := range keys {
+		if !SortColumns[key.Column] {
+			return "", fmt.Errorf("cannot sort users by %q", key.Column)
+		}
+		direction := "ASC"
+		if key.Descending {
+			direction = "DESC"
+		}
+		if tiebreak == "" {
+			tiebreak = "id " + direction
+		}
+		if seen[key.Column] {
+			continue
+		}
+		seen[key.Column] = true
+		list = append(list, key.Column+" "+direction+" NULLS LAST")
+	}
+	if !seen["id"] {
+		list = append(list, tiebreak)
+	}
+	return strings.Join(list, ", "), nil
+}
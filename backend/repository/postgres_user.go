@@ -0,0 +1,256 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"goapi/database"
+	"goapi/models"
+	"goapi/utils"
+)
+
+// usernameIndex is the unique index that keeps usernames distinct; any other
+// unique violation on users is a duplicate email
+const usernameIndex = "users_username_key"
+
+// PostgresUserRepository is the UserRepository backed by the users table
+type PostgresUserRepository struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+var _ UserRepository = (*PostgresUserRepository)(nil)
+
+// NewPostgresUserRepository returns a UserRepository using db. Each call
+// gives up after timeout, or never when it is zero.
+func NewPostgresUserRepository(db *sql.DB, timeout time.Duration) *PostgresUserRepository {
+	return &PostgresUserRepository{db: db, timeout: timeout}
+}
+
+// withTimeout bounds a call's database work by the repository timeout
+func (r *PostgresUserRepository) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.timeout)
+}
+
+// duplicateError translates a unique violation into ErrEmailTaken or
+// ErrUsernameTaken, returning other errors unchanged
+func duplicateError(err error) error {
+	constraint, ok := database.UniqueViolation(err)
+	if !ok {
+		return err
+	}
+	if constraint == usernameIndex {
+		return ErrUsernameTaken
+	}
+	return ErrEmailTaken
+}
+
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r *PostgresUserRepository) Get(ctx context.Context, id int, columns []string) (models.User, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	if columns == nil {
+		columns = strings.Split(UserColumns, ", ")
+	}
+	var user models.User
+	err := ScanUserColumns(r.db.QueryRowContext(ctx, `
+		SELECT `+strings.Join(columns, ", ")+`
+		FROM users WHERE id = $1 AND deleted_at IS NULL
+	`, id), &user, columns)
+	return user, notFound(err)
+}
+
+func (r *PostgresUserRepository) GetIncludingDeleted(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	var user models.User
+	err := ScanUser(r.db.QueryRowContext(ctx, `SELECT `+UserColumns+` FROM users WHERE id = $1`, id), &user)
+	return user, notFound(err)
+}
+
+func (r *PostgresUserRepository) IDByUsername(ctx context.Context, username string) (int, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	var id int
+	err := r.db.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1 AND deleted_at IS NULL", username).Scan(&id)
+	return id, notFound(err)
+}
+
+func (r *PostgresUserRepository) GetMany(ctx context.Context, ids []int, columns []string) ([]models.User, error) {
+	return r.query(ctx, columns, `
+		SELECT `+strings.Join(columns, ", ")+`
+		FROM users WHERE id = ANY($1) AND deleted_at IS NULL
+	`, ids)
+}
+
+func (r *PostgresUserRepository) List(ctx context.Context, q ListQuery) ([]models.User, error) {
+	where, rank := filterConditions(q.Filter)
+	order, err := orderBy(q.Sort, rank)
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + strings.Join(q.Columns, ", ") + ` FROM users` + where.clause() +
+		` ORDER BY ` + order + ` LIMIT ` + where.param(q.Limit) + ` OFFSET ` + where.param(q.Offset)
+	return r.query(ctx, q.Columns, query, where.args...)
+}
+
+func (r *PostgresUserRepository) Count(ctx context.Context, filter UserFilter) (int, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	where, _ := filterConditions(filter)
+	var total int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users"+where.clause(), where.args...).Scan(&total)
+	return total, err
+}
+
+// query runs a query selecting columns, a subset of UserColumns, and scans
+// every row
+func (r *PostgresUserRepository) query(ctx context.Context, columns []string, query string, args ...interface{}) ([]models.User, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := ScanUserColumns(rows, &user, columns); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (r *PostgresUserRepository) EmailTaken(ctx context.Context, email string, exceptID int) (bool, error) {
+	return r.exists(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE email_normalized = $1 AND id <> $2)", utils.CanonicalEmail(email), exceptID)
+}
+
+func (r *PostgresUserRepository) UsernameTaken(ctx context.Context, username string, exceptID int) (bool, error) {
+	return r.exists(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE username = $1 AND id <> $2)", username, exceptID)
+}
+
+func (r *PostgresUserRepository) exists(ctx context.Context, query string, args ...interface{}) (bool, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+	err := r.db.QueryRowContext(ctx, query, args...).Scan(&exists)
+	return exists, err
+}
+
+func (r *PostgresUserRepository) Create(ctx context.Context, user *models.User, passwordHash string, hook Hook) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now()
+	err := database.Transact(ctx, r.db, func(tx *sql.Tx) error {
+		err := ScanUser(tx.QueryRowContext(ctx, `
+			INSERT INTO users (name, email, email_normalized, username, password, age, is_active, show_email, show_age, flagged_for_review, metadata, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			RETURNING `+UserColumns,
+			user.Name, user.Email, utils.CanonicalEmail(user.Email), user.Username, passwordHash, user.Age, user.IsActive, user.ShowEmail, user.ShowAge, user.Flagged, user.Metadata, now, now), user)
+		if err != nil {
+			return err
+		}
+		return hook.run(ctx, tx, *user)
+	})
+	return duplicateError(err)
+}
+
+func (r *PostgresUserRepository) Update(ctx context.Context, user *models.User, version int, hook Hook) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	err := database.Transact(ctx, r.db, func(tx *sql.Tx) error {
+		err := ScanUser(tx.QueryRowContext(ctx, `
+			UPDATE users
+			SET name = $1, email = $2, email_normalized = $3, username = $4, age = $5, is_active = $6, show_email = $7, show_age = $8, metadata = $9, updated_at = $10
+			WHERE id = $11 AND deleted_at IS NULL AND version = $12
+			RETURNING `+UserColumns,
+			user.Name, user.Email, utils.CanonicalEmail(user.Email), user.Username, user.Age, user.IsActive, user.ShowEmail, user.ShowAge, user.Metadata, time.Now(), user.ID, version), user)
+		if err != nil {
+			return err
+		}
+		return hook.run(ctx, tx, *user)
+	})
+	if err == sql.ErrNoRows {
+		return ErrVersionConflict
+	}
+	return duplicateError(err)
+}
+
+func (r *PostgresUserRepository) SoftDelete(ctx context.Context, id int, purgeAt *time.Time, hook Hook) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	return database.Transact(ctx, r.db, func(tx *sql.Tx) error {
+		now := time.Now()
+		var user models.User
+		err := ScanUser(tx.QueryRowContext(ctx, `
+			UPDATE users SET deleted_at = $1, purge_at = $2 WHERE id = $3
+			RETURNING `+UserColumns, now, purgeAt, id), &user)
+		if err != nil {
+			return notFound(err)
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE sessions SET revoked_at = $1
+			WHERE user_id = $2 AND revoked_at IS NULL
+		`, now, id)
+		if err != nil {
+			return err
+		}
+		return hook.run(ctx, tx, user)
+	})
+}
+
+func (r *PostgresUserRepository) HardDelete(ctx context.Context, id int, hook Hook) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	return database.Transact(ctx, r.db, func(tx *sql.Tx) error {
+		var user models.User
+		err := ScanUser(tx.QueryRowContext(ctx, "DELETE FROM users WHERE id = $1 RETURNING "+UserColumns, id), &user)
+		if err != nil {
+			return notFound(err)
+		}
+		return hook.run(ctx, tx, user)
+	})
+}
+
+func (r *PostgresUserRepository) Restore(ctx context.Context, id int, hook Hook) (models.User, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	var user models.User
+	err := database.Transact(ctx, r.db, func(tx *sql.Tx) error {
+		err := ScanUser(tx.QueryRowContext(ctx, `
+			UPDATE users SET deleted_at = NULL, purge_at = NULL
+			WHERE id = $1 AND deleted_at IS NOT NULL AND anonymized_at IS NULL
+			RETURNING `+UserColumns, id), &user)
+		if err != nil {
+			return notFound(err)
+		}
+		return hook.run(ctx, tx, user)
+	})
+	return user, err
+}
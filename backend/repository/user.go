@@ -0,0 +1,181 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"goapi/models"
+)
+
+// Errors returned by UserRepository
+var (
+	ErrNotFound        = errors.New("user not found")
+	ErrEmailTaken      = errors.New("email is already taken")
+	ErrUsernameTaken   = errors.New("username is already taken")
+	ErrVersionConflict = errors.New("user was changed by another request")
+)
+
+// Hook runs inside the transaction of a change to user, as stored or, for
+// deletes, as they were, to write what must commit with it, like the audit
+// entry. ctx is the change's, bounded by the repository timeout. An error
+// undoes the change. A nil Hook does nothing.
+type Hook func(ctx context.Context, tx *sql.Tx, user models.User) error
+
+// run calls h unless it is nil
+func (h Hook) run(ctx context.Context, tx *sql.Tx, user models.User) error {
+	if h == nil {
+		return nil
+	}
+	return h(ctx, tx, user)
+}
+
+// UserRepository stores users. Unless noted otherwise, methods ignore
+// soft-deleted users. Every method stops its database work when ctx is
+// done.
+type UserRepository interface {
+	// Get loads a user, selecting only columns, a subset of UserColumns, or
+	// all of them when columns is nil
+	Get(ctx context.Context, id int, columns []string) (models.User, error)
+	// GetIncludingDeleted loads a user even if soft-deleted
+	GetIncludingDeleted(ctx context.Context, id int) (models.User, error)
+	// IDByUsername finds a user by normalized username
+	IDByUsername(ctx context.Context, username string) (int, error)
+	// GetMany loads the users with the given IDs, in no particular order
+	GetMany(ctx context.Context, ids []int, columns []string) ([]models.User, error)
+	// List returns a page of the users matching a listing and Count how
+	// many there are in all
+	List(ctx context.Context, query ListQuery) ([]models.User, error)
+	Count(ctx context.Context, filter UserFilter) (int, error)
+
+	// EmailTaken and UsernameTaken report whether a user other than
+	// exceptID, deleted or not, holds the email or normalized username
+	EmailTaken(ctx context.Context, email string, exceptID int) (bool, error)
+	UsernameTaken(ctx context.Context, username string, exceptID int) (bool, error)
+
+	// Create inserts user with the password hash and fills in the stored
+	// row. It returns ErrEmailTaken or ErrUsernameTaken on duplicates.
+	Create(ctx context.Context, user *models.User, passwordHash string, hook Hook) error
+	// Update stores the profile fields of user if its version is still
+	// version, filling in the stored row; otherwise ErrVersionConflict
+	Update(ctx context.Context, user *models.User, version int, hook Hook) error
+	// SoftDelete hides a user and revokes their sessions; with purgeAt the
+	// account is purged for good at that time unless restored
+	SoftDelete(ctx context.Context, id int, purgeAt *time.Time, hook Hook) error
+	// HardDelete removes a user, deleted or not, and everything that
+	// references them
+	HardDelete(ctx context.Context, id int, hook Hook) error
+	// Restore undoes a soft delete unless the user was anonymized
+	Restore(ctx context.Context, id int, hook Hook) (models.User, error)
+}
+
+// UserFilter narrows a listing of users; zero fields match everyone.
+// Soft-deleted users are never listed.
+type UserFilter struct {
+	Active *bool
+	// MinAge and MaxAge are inclusive
+	MinAge *int
+	MaxAge *int
+	// CreatedAfter is inclusive and CreatedBefore exclusive
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// InactiveSince keeps users whose last activity, or creation if never
+	// seen, is before it
+	InactiveSince *time.Time
+	// Tags are normalized tag names the users must all carry
+	Tags []string
+	// Metadata keys map to values the user's metadata must equal, as text
+	Metadata map[string][]string
+	// Search keeps users whose name or email contains it or is similar
+	// enough under pg_trgm, so typos still find them
+	Search string
+	// Before keeps the users after this position in newest-first order, for
+	// keyset paging
+	Before *Position
+}
+
+// Position is a user's place in newest-first order
+type Position struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+// SortKey orders a listing by one of SortColumns
+type SortKey struct {
+	Column     string
+	Descending bool
+}
+
+// ListQuery selects a page of users. Without Sort, search results come
+// closest match first, then the newest users first; with it, the ID breaks
+// ties in the direction of the first key so pages are stable.
+type ListQuery struct {
+	Columns []string
+	Filter  UserFilter
+	Sort    []SortKey
+	Limit   int
+	Offset  int
+}
+
+// SortColumns are the columns a listing can be sorted by
+var SortColumns = map[string]bool{
+	"id":            true,
+	"name":          true,
+	"email":         true,
+	"age":           true,
+	"is_active":     true,
+	"created_at":    true,
+	"updated_at":    true,
+	"last_login_at": true,
+	"last_seen_at":  true,
+}
+
+// LastActivity is when a user last signed in or made an authenticated
+// request; users never seen count from when they were created
+const LastActivity = "COALESCE(GREATEST(last_seen_at, last_login_at), created_at)"
+
+// UserColumns lists the user columns selected by queries, in UserFields order
+const UserColumns = "id, name, email, username, age, is_active, show_email, show_age, flagged_for_review, metadata, version, created_at, updated_at, last_login_at, last_login_ip, last_seen_at"
+
+// userColumnIndex maps each of UserColumns to its position in UserFields
+var userColumnIndex = func() map[string]int {
+	index := make(map[string]int)
+	for i, column := range strings.Split(UserColumns, ", ") {
+		index[column] = i
+	}
+	return index
+}()
+
+// IsUserColumn reports whether column is one of UserColumns
+func IsUserColumn(column string) bool {
+	_, ok := userColumnIndex[column]
+	return ok
+}
+
+// RowScanner is implemented by both *sql.Row and *sql.Rows
+type RowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// UserFields returns scan destinations matching UserColumns
+func UserFields(user *models.User) []interface{} {
+	return []interface{}{&user.ID, &user.Name, &user.Email, &user.Username, &user.Age, &user.IsActive, &user.ShowEmail, &user.ShowAge, &user.Flagged, &user.Metadata, &user.Version, &user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt, &user.LastLoginIP, &user.LastSeenAt}
+}
+
+// ScanUser scans a row selected with UserColumns into user
+func ScanUser(row RowScanner, user *models.User) error {
+	return row.Scan(UserFields(user)...)
+}
+
+// ScanUserColumns scans a row selected with columns, a subset of
+// UserColumns, into user
+func ScanUserColumns(row RowScanner, user *models.User, columns []string) error {
+	fields := UserFields(user)
+	dest := make([]interface{}, len(columns))
+	for i, column := range columns {
+		dest[i] = fields[userColumnIndex[column]]
+	}
+	return row.Scan(dest...)
+}
@@ -0,0 +1,81 @@
+// Package scheduler queues periodic maintenance, such as purging expired
+// rows, as background jobs. Only the replica holding the scheduler lock runs
+// the schedule, and a task is only queued once its last job on record is an
+// interval old, so it runs about once per interval however many replicas
+// there are and however often they restart or hand the lock over.
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"goapi/jobs"
+	"goapi/locks"
+)
+
+// LockName is the lock held by the replica running the schedule
+const LockName = "scheduler"
+
+// Task queues a job of JobType, which must be registered, every Interval
+type Task struct {
+	JobType string
+	// Interval is 0 to turn the task off
+	Interval time.Duration
+}
+
+// Start runs each task with an interval from a background goroutine while
+// this replica holds the scheduler lock
+func Start(db *sql.DB, tasks ...Task) {
+	locks.Run(db, LockName, func(ctx context.Context) {
+		done := make(chan struct{})
+		running := 0
+		for _, task := range tasks {
+			if task.Interval > 0 {
+				running++
+				go func(task Task) {
+					schedule(ctx, db, task)
+					done <- struct{}{}
+				}(task)
+			}
+		}
+		for ; running > 0; running-- {
+			<-done
+		}
+	})
+}
+
+// schedule queues task's job whenever the last one is an interval old,
+// until ctx is done
+func schedule(ctx context.Context, db *sql.DB, task Task) {
+	for {
+		wait := task.Interval
+		last, err := lastQueued(ctx, db, task.JobType)
+		if err != nil {
+			log.Printf("Error looking up the last %s job: %v", task.JobType, err)
+		} else if until := time.Until(last.Add(task.Interval)); until > 0 {
+			// Queued recently, by this replica or the lock's last holder
+			wait = until
+		} else {
+			_, err = jobs.Enqueue(ctx, db, task.JobType, struct{}{}, jobs.Options{UniqueKey: task.JobType})
+			if err != nil {
+				log.Printf("Error queueing %s job: %v", task.JobType, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// lastQueued is when the last job of jobType was queued, zero when there is
+// none on record
+func lastQueued(ctx context.Context, db *sql.DB, jobType string) (time.Time, error) {
+	var last sql.NullTime
+	err := db.QueryRowContext(ctx, `SELECT MAX(created_at) FROM jobs WHERE type = $1`, jobType).Scan(&last)
+	return last.Time, err
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"goapi/auth"
+	"goapi/config"
+	"goapi/database"
+)
+
+// runScopes lists, grants or revokes the scopes an account holds on top of
+// JWT_DEFAULT_SCOPES. It returns the process exit code.
+func runScopes(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: scopes list <email> | grant <email> <scope>... | revoke <email> <scope>...")
+		fmt.Fprintln(os.Stderr, "scopes:", strings.Join(auth.Scopes, " "))
+	}
+	if len(args) < 2 || (args[0] != "list" && len(args) < 3) {
+		usage()
+		return 2
+	}
+	command, email, scopes := args[0], args[1], args[2:]
+	for _, scope := range scopes {
+		if !auth.HasScope(auth.Scopes, scope) {
+			fmt.Fprintf(os.Stderr, "Unknown scope %q\n", scope)
+			usage()
+			return 2
+		}
+	}
+
+	cfg, err := config.Load(nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading configuration:", err)
+		return 1
+	}
+	conn, err := sql.Open("pgx", cfg.Database.ConnString())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening database connection:", err)
+		return 1
+	}
+	defer conn.Close()
+
+	var query string
+	switch command {
+	case "list":
+		query = `SELECT scopes FROM users WHERE LOWER(email) = LOWER($1) AND deleted_at IS NULL`
+	case "grant":
+		query = `
+			UPDATE users SET scopes = ARRAY(SELECT DISTINCT unnest(scopes || $2::text[]) ORDER BY 1)
+			WHERE LOWER(email) = LOWER($1) AND deleted_at IS NULL
+			RETURNING scopes`
+	case "revoke":
+		query = `
+			UPDATE users SET scopes = ARRAY(SELECT unnest(scopes) EXCEPT SELECT unnest($2::text[]) ORDER BY 1)
+			WHERE LOWER(email) = LOWER($1) AND deleted_at IS NULL
+			RETURNING scopes`
+	default:
+		usage()
+		return 2
+	}
+	queryArgs := []interface{}{email}
+	if command != "list" {
+		queryArgs = append(queryArgs, scopes)
+	}
+
+	var held []string
+	err = conn.QueryRow(query, queryArgs...).Scan(database.Array(&held))
+	if err == sql.ErrNoRows {
+		fmt.Fprintln(os.Stderr, "No user with email", email)
+		return 1
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "Error updating scopes:", err)
+		return 1
+	}
+	fmt.Printf("%s: %s (granted to everyone: %s)\n", email, strings.Join(held, " "), strings.Join(auth.ParseScopes(cfg.Auth.DefaultScopes), " "))
+	if command == "revoke" {
+		fmt.Println("Tokens already issued keep their scopes until they expire or their session is revoked")
+	}
+	return 0
+}
@@ -15,6 +15,9 @@ NC='\033[0m' # No Color
 # API base URL
 API_BASE="http://localhost:8080/api"
 
+# Access token for /users routes, obtained in get_token
+AUTH_TOKEN=""
+
 # Function to print colored output
 print_status() {
     echo -e "${GREEN}[INFO]${NC} $1"
@@ -42,7 +45,7 @@ print_header() {
 check_api() {
     print_status "Checking if API is running..."
     
-    if curl -s -f "$API_BASE/users" > /dev/null 2>&1; then
+    if curl -s -f "http://localhost:8080/health" > /dev/null 2>&1; then
         print_success "API is running and accessible"
         return 0
     else
@@ -67,11 +70,13 @@ make_request() {
     
     if [ -n "$data" ]; then
         response=$(curl -s -w "\n%{http_code}" -X "$method" \
+            -H "Authorization: Bearer $AUTH_TOKEN" \
             -H "Content-Type: application/json" \
             -d "$data" \
             "$API_BASE$endpoint")
     else
         response=$(curl -s -w "\n%{http_code}" -X "$method" \
+            -H "Authorization: Bearer $AUTH_TOKEN" \
             "$API_BASE$endpoint")
     fi
     
@@ -91,12 +96,45 @@ make_request() {
     echo ""
 }
 
+# Function to sign up a test admin and keep its access token
+get_token() {
+    print_status "Obtaining access token..."
+
+    local credentials='{
+        "name": "API Tester",
+        "email": "api.tester@example.com",
+        "password": "api-tester-horse"
+    }'
+
+    AUTH_TOKEN=$(curl -s -X POST -H "Content-Type: application/json" \
+        -d "$credentials" "$API_BASE/auth/signup" | jq -r '.data.token // empty')
+    if [ -z "$AUTH_TOKEN" ]; then
+        AUTH_TOKEN=$(curl -s -X POST -H "Content-Type: application/json" \
+            -d "$credentials" "$API_BASE/auth/login" | jq -r '.data.token // empty')
+    fi
+
+    if [ -n "$AUTH_TOKEN" ]; then
+        print_success "Access token obtained"
+    else
+        print_error "Could not obtain an access token"
+    fi
+    echo ""
+}
+
+# Function to test that user routes require a token
+test_requires_auth() {
+    local token="$AUTH_TOKEN"
+    AUTH_TOKEN=""
+    make_request "GET" "/users" "" 401 "Get all users without a token"
+    AUTH_TOKEN="$token"
+}
+
 # Function to test user creation
 test_create_user() {
     local test_data='{
         "name": "John Doe",
         "email": "john.doe@example.com",
-        "password": "password123",
+        "password": "correct-horse-1",
         "age": 30,
         "is_active": true
     }'
@@ -109,7 +147,7 @@ test_create_duplicate_user() {
     local test_data='{
         "name": "Jane Doe",
         "email": "john.doe@example.com",
-        "password": "password456",
+        "password": "correct-horse-2",
         "age": 25,
         "is_active": true
     }'
@@ -157,12 +195,13 @@ test_update_duplicate_email() {
     local user2_data='{
         "name": "Jane Smith",
         "email": "jane.smith@example.com",
-        "password": "password789",
+        "password": "correct-horse-3",
         "age": 28,
         "is_active": true
     }'
     
     curl -s -X POST -H "Content-Type: application/json" \
+        -H "Authorization: Bearer $AUTH_TOKEN" \
         -d "$user2_data" "$API_BASE/users" > /dev/null
     
     # Now try to update first user with second user's email
@@ -192,7 +231,7 @@ test_auth() {
     local signup_data='{
         "name": "Test User",
         "email": "test.user@example.com",
-        "password": "testpass123",
+        "password": "tester-horse-1",
         "age": 25
     }'
     
@@ -201,7 +240,7 @@ test_auth() {
     # Test login with correct credentials
     local login_data='{
         "email": "test.user@example.com",
-        "password": "testpass123"
+        "password": "tester-horse-1"
     }'
     
     make_request "POST" "/auth/login" "$login_data" 200 "User login with correct credentials"
@@ -217,7 +256,7 @@ test_auth() {
     # Test login with non-existent user
     local nonexistent_login_data='{
         "email": "nonexistent@example.com",
-        "password": "password123"
+        "password": "correct-horse-1"
     }'
     
     make_request "POST" "/auth/login" "$nonexistent_login_data" 401 "User login with non-existent user"
@@ -232,7 +271,7 @@ test_validation() {
     local invalid_email_data='{
         "name": "Test User",
         "email": "invalid-email",
-        "password": "password123",
+        "password": "correct-horse-1",
         "age": 25
     }'
     
@@ -252,7 +291,7 @@ test_validation() {
     local short_name_data='{
         "name": "A",
         "email": "test@example.com",
-        "password": "password123",
+        "password": "correct-horse-1",
         "age": 25
     }'
     
@@ -262,7 +301,7 @@ test_validation() {
     local invalid_age_data='{
         "name": "Test User",
         "email": "test@example.com",
-        "password": "password123",
+        "password": "correct-horse-1",
         "age": 200
     }'
     
@@ -304,10 +343,13 @@ main() {
     # Test health endpoint
     test_health
     
+    get_token
+    
     # Test user CRUD operations
     print_status "Testing User CRUD Operations"
     echo ""
     
+    test_requires_auth
     test_create_user
     test_create_duplicate_user
     test_get_all_users
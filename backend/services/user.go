@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"goapi/hashing"
+	"goapi/models"
+	"goapi/repository"
+	"goapi/utils"
+	"goapi/validation"
+)
+
+// Errors returned by UserService. They are the repository's, so callers
+// need not import it to tell them apart.
+var (
+	ErrNotFound        = repository.ErrNotFound
+	ErrEmailTaken      = repository.ErrEmailTaken
+	ErrUsernameTaken   = repository.ErrUsernameTaken
+	ErrVersionConflict = repository.ErrVersionConflict
+)
+
+// Hook runs inside the transaction of a change, to write what must commit
+// with it, like the audit entry. It may be nil.
+type Hook = repository.Hook
+
+// ValidationError is returned for input that breaks a validation rule
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string { return e.Err.Error() }
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// TakenError is returned when another user, deleted or not, holds an email
+// or username. It matches ErrEmailTaken or ErrUsernameTaken.
+type TakenError struct {
+	Err   error
+	Value string
+}
+
+func (e *TakenError) Error() string { return e.Err.Error() + ": " + e.Value }
+func (e *TakenError) Unwrap() error { return e.Err }
+
+// invalid wraps a validation failure, keeping nil as nil
+func invalid(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ValidationError{Err: err}
+}
+
+// UserService holds the rules for creating and changing users, independent
+// of how requests arrive. Its methods stop their database work when ctx is
+// done.
+type UserService struct {
+	users repository.UserRepository
+}
+
+// NewUserService returns a UserService storing users in users
+func NewUserService(users repository.UserRepository) *UserService {
+	return &UserService{users: users}
+}
+
+// NewUser is a user to create, with the plain-text password
+type NewUser struct {
+	Name      string
+	Email     string
+	Username  *string
+	Password  string
+	Age       *int
+	IsActive  bool
+	ShowEmail bool
+	ShowAge   bool
+	Metadata  models.Metadata
+	// Flagged holds the account for review, e.g. after a suspicious signup
+	Flagged bool
+}
+
+// Validate normalizes u and checks everything that doesn't need a lookup,
+// returning a ValidationError if something is wrong
+func (u *NewUser) Validate() error {
+	if err := validation.CheckNewUser(u.Name, u.Age); err != nil {
+		return invalid(err)
+	}
+	if err := validation.CheckMetadata(u.Metadata); err != nil {
+		return invalid(err)
+	}
+	if err := validation.CheckPassword(u.Password); err != nil {
+		return invalid(err)
+	}
+	u.Email = utils.NormalizeEmail(u.Email)
+	if u.Username != nil {
+		username := validation.NormalizeUsername(*u.Username)
+		u.Username = &username
+		if err := validation.CheckUsername(username); err != nil {
+			return invalid(err)
+		}
+	}
+	return nil
+}
+
+// Create validates u, hashes the password and stores the user, running hook
+// in the same transaction. The unique indexes on email and username decide
+// whether they are free, so two requests racing for the same one can't both
+// succeed.
+func (s *UserService) Create(ctx context.Context, u NewUser, hook Hook) (models.User, error) {
+	if err := u.Validate(); err != nil {
+		return models.User{}, err
+	}
+
+	hashedPassword, err := hashing.Hash(u.Password)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	user := models.User{
+		Name:      u.Name,
+		Email:     u.Email,
+		Username:  u.Username,
+		Age:       u.Age,
+		IsActive:  u.IsActive,
+		ShowEmail: u.ShowEmail,
+		ShowAge:   u.ShowAge,
+		Metadata:  u.Metadata,
+		Flagged:   u.Flagged,
+	}
+	if err := s.users.Create(ctx, &user, hashedPassword, hook); err != nil {
+		return models.User{}, takenError(err, user)
+	}
+	return user, nil
+}
+
+// Update lets change modify a copy of existing, validates the fields that
+// changed and stores the result with hook, unless someone changed the user
+// since existing was loaded. An error from change is a ValidationError.
+func (s *UserService) Update(ctx context.Context, existing models.User, change func(user *models.User) error, hook Hook) (models.User, error) {
+	updated := existing
+	err := change(&updated)
+	if err == nil && updated.Name != existing.Name {
+		err = validation.CheckName(updated.Name)
+	}
+	if err == nil && !reflect.DeepEqual(updated.Age, existing.Age) {
+		err = validation.CheckUserAge(updated.Age)
+	}
+	if err == nil {
+		err = validation.CheckMetadata(updated.Metadata)
+	}
+	if err != nil {
+		return models.User{}, invalid(err)
+	}
+
+	if updated.Username != nil && !reflect.DeepEqual(updated.Username, existing.Username) {
+		username := validation.NormalizeUsername(*updated.Username)
+		updated.Username = &username
+		if err := validation.CheckUsername(username); err != nil {
+			return models.User{}, invalid(err)
+		}
+	}
+	updated.Email = utils.NormalizeEmail(updated.Email)
+
+	if err := s.users.Update(ctx, &updated, existing.Version, hook); err != nil {
+		return models.User{}, takenError(err, updated)
+	}
+	return updated, nil
+}
+
+// takenError turns a duplicate reported by the repository, when another
+// user holds the email or username, into a TakenError for user
+func takenError(err error, user models.User) error {
+	switch {
+	case errors.Is(err, ErrUsernameTaken) && user.Username != nil:
+		return &TakenError{Err: ErrUsernameTaken, Value: *user.Username}
+	case errors.Is(err, ErrEmailTaken):
+		return &TakenError{Err: ErrEmailTaken, Value: user.Email}
+	}
+	return err
+}
+
+// EmailTaken reports whether any user, deleted or not, has email
+func (s *UserService) EmailTaken(ctx context.Context, email string) (bool, error) {
+	return s.users.EmailTaken(ctx, email, 0)
+}
+
+// UsernameTaken reports whether any user, deleted or not, has the
+// normalized username
+func (s *UserService) UsernameTaken(ctx context.Context, username string) (bool, error) {
+	return s.users.UsernameTaken(ctx, username, 0)
+}
+
+// Get loads a user, selecting only columns, or all of them when nil
+func (s *UserService) Get(ctx context.Context, id int, columns []string) (models.User, error) {
+	return s.users.Get(ctx, id, columns)
+}
+
+// IDByUsername finds a user by username, normalizing it first
+func (s *UserService) IDByUsername(ctx context.Context, username string) (int, error) {
+	return s.users.IDByUsername(ctx, validation.NormalizeUsername(username))
+}
+
+// GetMany loads the users with the given IDs, in no particular order
+func (s *UserService) GetMany(ctx context.Context, ids []int, columns []string) ([]models.User, error) {
+	return s.users.GetMany(ctx, ids, columns)
+}
+
+// List returns a page of users and Count how many match a listing
+func (s *UserService) List(ctx context.Context, query repository.ListQuery) ([]models.User, error) {
+	return s.users.List(ctx, query)
+}
+
+func (s *UserService) Count(ctx context.Context, filter repository.UserFilter) (int, error) {
+	return s.users.Count(ctx, filter)
+}
+
+// Delete soft-deletes a user, signing them out, and returns them as they
+// were. A hard delete removes the user for good, even if already
+// soft-deleted. hook runs in the same transaction.
+func (s *UserService) Delete(ctx context.Context, id int, hard bool, hook Hook) (models.User, error) {
+	if hard {
+		user, err := s.users.GetIncludingDeleted(ctx, id)
+		if err != nil {
+			return models.User{}, err
+		}
+		return user, s.users.HardDelete(ctx, id, hook)
+	}
+	return s.softDelete(ctx, id, nil, hook)
+}
+
+// ScheduleDeletion soft-deletes a user at their own request, to be purged
+// at purgeAt unless they come back first
+func (s *UserService) ScheduleDeletion(ctx context.Context, id int, purgeAt time.Time, hook Hook) (models.User, error) {
+	return s.softDelete(ctx, id, &purgeAt, hook)
+}
+
+func (s *UserService) softDelete(ctx context.Context, id int, purgeAt *time.Time, hook Hook) (models.User, error) {
+	user, err := s.users.Get(ctx, id, nil)
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, s.users.SoftDelete(ctx, id, purgeAt, hook)
+}
+
+// Restore undoes a soft delete unless the user was anonymized, running hook
+// in the same transaction
+func (s *UserService) Restore(ctx context.Context, id int, hook Hook) (models.User, error) {
+	return s.users.Restore(ctx, id, hook)
+}
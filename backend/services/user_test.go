@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"goapi/models"
+	"goapi/repository"
+)
+
+// fakeUserRepository keeps users in memory, enforcing the unique email and
+// the version check the way the Postgres repository does. Methods the tests
+// don't use panic through the nil embedded interface.
+type fakeUserRepository struct {
+	repository.UserRepository
+	users  map[int]models.User
+	nextID int
+}
+
+func newFakeUserRepository() *fakeUserRepository {
+	return &fakeUserRepository{users: map[int]models.User{}, nextID: 1}
+}
+
+func (r *fakeUserRepository) Get(ctx context.Context, id int, columns []string) (models.User, error) {
+	user, ok := r.users[id]
+	if !ok {
+		return models.User{}, repository.ErrNotFound
+	}
+	return user, nil
+}
+
+func (r *fakeUserRepository) emailHolder(email string, exceptID int) bool {
+	for id, user := range r.users {
+		if id != exceptID && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *fakeUserRepository) Create(ctx context.Context, user *models.User, passwordHash string, hook repository.Hook) error {
+	if r.emailHolder(user.Email, 0) {
+		return repository.ErrEmailTaken
+	}
+	user.ID, user.Version = r.nextID, 1
+	r.nextID++
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *fakeUserRepository) Update(ctx context.Context, user *models.User, version int, hook repository.Hook) error {
+	stored, ok := r.users[user.ID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	if stored.Version != version {
+		return repository.ErrVersionConflict
+	}
+	if r.emailHolder(user.Email, user.ID) {
+		return repository.ErrEmailTaken
+	}
+	user.Version = version + 1
+	r.users[user.ID] = *user
+	return nil
+}
+
+func newTestUser(email string) NewUser {
+	return NewUser{Name: "Ada Lovelace", Email: email, Password: "Correct-Horse-9", IsActive: true}
+}
+
+func TestCreateReportsTakenEmail(t *testing.T) {
+	users := NewUserService(newFakeUserRepository())
+	ctx := context.Background()
+
+	created, err := users.Create(ctx, newTestUser("ada@Example.COM"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Email != "ada@example.com" {
+		t.Errorf("stored email %q, want the domain lowercased", created.Email)
+	}
+
+	_, err = users.Create(ctx, newTestUser("ADA@example.com"), nil)
+	var taken *TakenError
+	if !errors.As(err, &taken) || !errors.Is(err, ErrEmailTaken) {
+		t.Fatalf("second create: got %v, want a TakenError for the email", err)
+	}
+	if taken.Value != "ADA@example.com" {
+		t.Errorf("TakenError value %q, want the email as given", taken.Value)
+	}
+}
+
+func TestCreateRejectsInvalidInputBeforeStoring(t *testing.T) {
+	repo := newFakeUserRepository()
+	users := NewUserService(repo)
+
+	u := newTestUser("ada@example.com")
+	u.Name = ""
+	_, err := users.Create(context.Background(), u, nil)
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("got %v, want a ValidationError", err)
+	}
+	if len(repo.users) != 0 {
+		t.Errorf("stored %d users, want none", len(repo.users))
+	}
+}
+
+func TestUpdateRejectsStaleVersion(t *testing.T) {
+	users := NewUserService(newFakeUserRepository())
+	ctx := context.Background()
+
+	loaded, err := users.Create(ctx, newTestUser("ada@example.com"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rename := func(name string) func(*models.User) error {
+		return func(u *models.User) error {
+			u.Name = name
+			return nil
+		}
+	}
+
+	updated, err := users.Update(ctx, loaded, rename("Ada King"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Version != loaded.Version+1 {
+		t.Errorf("version %d after update, want %d", updated.Version, loaded.Version+1)
+	}
+
+	// A second writer still holding the first read loses
+	if _, err := users.Update(ctx, loaded, rename("Countess of Lovelace"), nil); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("stale update: got %v, want ErrVersionConflict", err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log"
+
+	"goapi/abuse"
+	"goapi/auth"
+	"goapi/config"
+	"goapi/directory"
+	"goapi/handlers"
+	"goapi/hashing"
+	"goapi/lockout"
+	"goapi/mailer"
+	"goapi/validation"
+)
+
+// applySettings hands the packages with settings of their own their part of
+// cfg; none of them read the environment. It fails only when the mail
+// backend can't be set up.
+func applySettings(cfg *config.Config) error {
+	// Access tokens are signed with JWT_SECRET, which config.Load requires
+	auth.SetConfig(auth.Config{
+		Secret: []byte(cfg.JWTSecret),
+		TTL:    cfg.Auth.TokenTTL,
+		Scopes: auth.ParseScopes(cfg.Auth.DefaultScopes),
+	})
+	auth.SetOAuthClients(auth.OAuthClient(cfg.Auth.Google), auth.OAuthClient(cfg.Auth.GitHub))
+	auth.SetWebAuthnConfig(auth.WebAuthnConfig{
+		RPID:    cfg.Auth.WebAuthn.RPID,
+		RPName:  cfg.Auth.WebAuthn.RPName,
+		Origins: cfg.Auth.WebAuthn.RPOrigins,
+	})
+	auth.SetSAMLConfig(auth.SAMLConfig(cfg.Auth.SAML))
+
+	hashing.SetHasher(passwordHasher(cfg.Password))
+	denylist, err := validation.Denylist(cfg.Password.DenyCommon, cfg.Password.DenylistFile)
+	if err != nil {
+		log.Println("Warning: could not read PASSWORD_DENYLIST_FILE:", err)
+	}
+	validation.SetPasswordPolicy(validation.PasswordPolicy{
+		MinLength:     cfg.Password.MinLength,
+		RequireUpper:  cfg.Password.RequireUpper,
+		RequireLower:  cfg.Password.RequireLower,
+		RequireDigit:  cfg.Password.RequireDigit,
+		RequireSymbol: cfg.Password.RequireSymbol,
+		Denylist:      denylist,
+	})
+	validation.SetRules(validation.Rules{
+		NameMin:       cfg.Validation.NameMin,
+		NameMax:       cfg.Validation.NameMax,
+		MinAge:        cfg.Validation.MinAge,
+		MaxAge:        cfg.Validation.MaxAge,
+		RequireAge:    cfg.Validation.RequireAge,
+		SignupEnabled: cfg.Validation.SignupEnabled,
+	})
+	reserved, profanity := cfg.Validation.UsernameReservedFile, cfg.Validation.UsernameProfanityFile
+	if lists, err := validation.LoadUsernameLists(reserved, profanity); err != nil {
+		log.Println("Warning: could not read the username lists:", err)
+	} else {
+		validation.SetUsernameLists(lists)
+	}
+	validation.WatchUsernameLists(reserved, profanity, cfg.Validation.UsernameListsReloadInterval)
+
+	lockout.SetConfig(lockout.Config(cfg.Lockout))
+	handlers.SetSessionLimit(cfg.Sessions.MaxPerUser, cfg.Sessions.Policy == "evict_oldest")
+
+	// The scores stay at their defaults
+	abuseConfig := abuse.Current()
+	abuseConfig.ReviewThreshold = cfg.Abuse.ReviewThreshold
+	abuseConfig.CaptchaThreshold = cfg.Abuse.CaptchaThreshold
+	abuseConfig.BlockThreshold = cfg.Abuse.BlockThreshold
+	abuseConfig.Window = cfg.Abuse.VelocityWindow
+	abuseConfig.IPLimit = cfg.Abuse.IPSignupLimit
+	abuseConfig.SubnetLimit = cfg.Abuse.SubnetSignupLimit
+	abuseConfig.DisposableDomains = abuse.DomainSet(cfg.Abuse.DisposableDomains)
+	abuseConfig.BlockedDomains = abuse.DomainSet(cfg.Abuse.BlockedDomains)
+	abuseConfig.CaptchaSecret = cfg.Abuse.CaptchaSecret
+	abuseConfig.CaptchaVerifyURL = cfg.Abuse.CaptchaVerifyURL
+	abuse.SetConfig(abuseConfig)
+
+	handlers.SetGoogleWorkspaceConfig(directory.GoogleConfig(cfg.GoogleWorkspace))
+
+	// Emails go out through SMTP or SendGrid, or the log in development
+	return mailer.SetConfig(mailer.Config{
+		Driver:         cfg.Mail.Driver,
+		Host:           cfg.Mail.SMTPHost,
+		Port:           cfg.Mail.SMTPPort,
+		Username:       cfg.Mail.SMTPUsername,
+		Password:       cfg.Mail.SMTPPassword,
+		SendGridAPIKey: cfg.Mail.SendGridAPIKey,
+		From:           cfg.Mail.From,
+	})
+}
+
+// passwordHasher returns the hasher of PASSWORD_HASH_ALGORITHM
+func passwordHasher(p config.Password) hashing.Hasher {
+	if p.HashAlgorithm == "argon2id" {
+		return hashing.Argon2idHasher{
+			Memory:      uint32(p.Argon2MemoryKB),
+			Iterations:  uint32(p.Argon2Iterations),
+			Parallelism: uint8(p.Argon2Parallelism),
+		}
+	}
+	return hashing.BcryptHasher{Cost: p.BcryptCost}
+}
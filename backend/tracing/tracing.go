@@ -0,0 +1,61 @@
+// Package tracing sets up OpenTelemetry tracing. Spans are exported over
+// OTLP/HTTP to the collector named by the standard OTEL_EXPORTER_OTLP_*
+// variables, and trace context is read from and written to W3C traceparent
+// and baggage headers.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+
+	"goapi/version"
+)
+
+// ServiceName names this service in traces unless OTEL_SERVICE_NAME is set
+const ServiceName = "goapi"
+
+// Setup installs the global tracer provider and propagator. It returns a
+// function that flushes the spans still buffered and stops the exporter.
+func Setup(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Later sources win, so OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES
+	// override the defaults
+	attrs := []attribute.KeyValue{semconv.ServiceName(ServiceName)}
+	if sha := version.Get().GitSHA; sha != "" {
+		attrs = append(attrs, semconv.ServiceVersion(sha))
+	}
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(attrs...),
+		resource.WithHost(),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sampling follows OTEL_TRACES_SAMPLER, by default the caller's decision
+	// or every trace started here
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	return provider.Shutdown, nil
+}
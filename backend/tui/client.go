@@ -0,0 +1,106 @@
+package tui
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// User is the subset of the user resource shown in the console
+type User struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Age      *int   `json:"age,omitempty"`
+	IsActive bool   `json:"is_active"`
+}
+
+// client talks to a running instance's REST API
+type client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func newClient(baseURL, token string) *client {
+	return &client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// apiResponse mirrors models.APIResponse with a typed payload
+type apiResponse[T any] struct {
+	Success    bool   `json:"success"`
+	Data       T      `json:"data"`
+	Message    string `json:"message"`
+	Pagination struct {
+		Next *int `json:"next"`
+	} `json:"pagination"`
+}
+
+// listUsers fetches every page of the user listing
+func (c *client) listUsers() ([]User, error) {
+	var users []User
+	for page := 1; ; {
+		var resp apiResponse[[]User]
+		if err := c.do(http.MethodGet, fmt.Sprintf("/api/users?page=%d&page_size=100", page), nil, &resp); err != nil {
+			return nil, err
+		}
+		users = append(users, resp.Data...)
+		if resp.Pagination.Next == nil {
+			return users, nil
+		}
+		page = *resp.Pagination.Next
+	}
+}
+
+func (c *client) setActive(id int, active bool) (User, error) {
+	var resp apiResponse[User]
+	err := c.do(http.MethodPatch, fmt.Sprintf("/api/users/%d", id), map[string]bool{"is_active": active}, &resp)
+	return resp.Data, err
+}
+
+func (c *client) do(method, path string, body interface{}, out interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		// Errors are application/problem+json
+		var failure struct {
+			Detail string `json:"detail"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&failure) == nil && failure.Detail != "" {
+			return fmt.Errorf("%s: %s", resp.Status, failure.Detail)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,233 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Run starts the interactive admin console against the API at baseURL,
+// authenticating with the given access token
+func Run(baseURL, token string) error {
+	_, err := tea.NewProgram(newModel(newClient(baseURL, token)), tea.WithAltScreen()).Run()
+	return err
+}
+
+type usersLoadedMsg struct{ users []User }
+
+type userUpdatedMsg struct{ user User }
+
+type errMsg struct{ err error }
+
+type model struct {
+	client *client
+
+	users   []User
+	visible []User
+	cursor  int
+	offset  int
+	height  int
+	query   string
+	editing bool
+	loading bool
+	status  string
+	lastErr error
+}
+
+func newModel(c *client) model {
+	return model{client: c, loading: true, height: 20}
+}
+
+func (m model) Init() tea.Cmd {
+	return m.loadUsers()
+}
+
+func (m model) loadUsers() tea.Cmd {
+	return func() tea.Msg {
+		users, err := m.client.listUsers()
+		if err != nil {
+			return errMsg{err}
+		}
+		return usersLoadedMsg{users}
+	}
+}
+
+func (m model) toggleActive(u User) tea.Cmd {
+	return func() tea.Msg {
+		updated, err := m.client.setActive(u.ID, !u.IsActive)
+		if err != nil {
+			return errMsg{err}
+		}
+		return userUpdatedMsg{updated}
+	}
+}
+
+func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		// Leave room for the header, status and help lines
+		m.height = msg.Height - 6
+		if m.height < 1 {
+			m.height = 1
+		}
+		m.clampCursor()
+
+	case usersLoadedMsg:
+		m.loading = false
+		m.lastErr = nil
+		m.users = msg.users
+		m.applyFilter()
+		m.status = fmt.Sprintf("Loaded %d users", len(m.users))
+
+	case userUpdatedMsg:
+		m.lastErr = nil
+		for i := range m.users {
+			if m.users[i].ID == msg.user.ID {
+				m.users[i] = msg.user
+			}
+		}
+		m.applyFilter()
+		state := "deactivated"
+		if msg.user.IsActive {
+			state = "activated"
+		}
+		m.status = fmt.Sprintf("User %d %s", msg.user.ID, state)
+
+	case errMsg:
+		m.loading = false
+		m.lastErr = msg.err
+
+	case tea.KeyMsg:
+		if m.editing {
+			return m.updateSearch(msg)
+		}
+		switch msg.String() {
+		case "q", "ctrl+c":
+			return m, tea.Quit
+		case "up", "k":
+			m.cursor--
+			m.clampCursor()
+		case "down", "j":
+			m.cursor++
+			m.clampCursor()
+		case "/":
+			m.editing = true
+		case "esc":
+			m.query = ""
+			m.applyFilter()
+		case "r":
+			m.loading = true
+			return m, m.loadUsers()
+		case "a", " ":
+			if len(m.visible) > 0 {
+				return m, m.toggleActive(m.visible[m.cursor])
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m model) updateSearch(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEnter:
+		m.editing = false
+	case tea.KeyEsc:
+		m.editing = false
+		m.query = ""
+	case tea.KeyBackspace:
+		if m.query != "" {
+			runes := []rune(m.query)
+			m.query = string(runes[:len(runes)-1])
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.query += string(msg.Runes)
+	}
+	m.applyFilter()
+	return m, nil
+}
+
+// applyFilter narrows the user list to those matching the search query
+func (m *model) applyFilter() {
+	query := strings.ToLower(m.query)
+	m.visible = m.visible[:0]
+	for _, u := range m.users {
+		if query == "" || strings.Contains(strings.ToLower(u.Name), query) || strings.Contains(strings.ToLower(u.Email), query) {
+			m.visible = append(m.visible, u)
+		}
+	}
+	m.clampCursor()
+}
+
+func (m *model) clampCursor() {
+	if m.cursor >= len(m.visible) {
+		m.cursor = len(m.visible) - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+m.height {
+		m.offset = m.cursor - m.height + 1
+	}
+}
+
+func (m model) View() string {
+	var b strings.Builder
+
+	b.WriteString("User admin console\n")
+	if m.editing || m.query != "" {
+		cursor := ""
+		if m.editing {
+			cursor = "_"
+		}
+		fmt.Fprintf(&b, "Search: %s%s\n", m.query, cursor)
+	} else {
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "  %-6s %-24s %-32s %s\n", "ID", "NAME", "EMAIL", "ACTIVE")
+
+	end := m.offset + m.height
+	if end > len(m.visible) {
+		end = len(m.visible)
+	}
+	for i := m.offset; i < end; i++ {
+		u := m.visible[i]
+		pointer := " "
+		if i == m.cursor {
+			pointer = ">"
+		}
+		active := "no"
+		if u.IsActive {
+			active = "yes"
+		}
+		fmt.Fprintf(&b, "%s %-6d %-24s %-32s %s\n", pointer, u.ID, truncate(u.Name, 24), truncate(u.Email, 32), active)
+	}
+	if len(m.visible) == 0 && !m.loading {
+		b.WriteString("  no users\n")
+	}
+
+	b.WriteString("\n")
+	switch {
+	case m.loading:
+		b.WriteString("Loading...\n")
+	case m.lastErr != nil:
+		fmt.Fprintf(&b, "Error: %v\n", m.lastErr)
+	default:
+		b.WriteString(m.status + "\n")
+	}
+	b.WriteString("↑/↓ move  / search  esc clear  a toggle active  r refresh  q quit\n")
+	return b.String()
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
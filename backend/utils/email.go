@@ -0,0 +1,49 @@
+package utils
+
+import "strings"
+
+var stripEmailAliases bool
+
+// SetStripEmailAliases enables stripping of plus-tags (and Gmail dots) when
+// computing the canonical form of an email address
+func SetStripEmailAliases(enabled bool) {
+	stripEmailAliases = enabled
+}
+
+// StripEmailAliases reports whether CanonicalEmail strips aliases
+func StripEmailAliases() bool {
+	return stripEmailAliases
+}
+
+// NormalizeEmail trims surrounding whitespace and lowercases the domain part,
+// keeping the local part as entered so the address stays deliverable
+func NormalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	return email[:at+1] + strings.ToLower(email[at+1:])
+}
+
+// CanonicalEmail returns the deduplication key for an email address. The
+// address is lowercased and, when alias stripping is enabled, plus-tags are
+// removed and Gmail addresses lose their dots, so that trivially different
+// spellings of the same mailbox map to the same key.
+func CanonicalEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 || !stripEmailAliases {
+		return email
+	}
+
+	local, domain := email[:at], email[at+1:]
+	if plus := strings.Index(local, "+"); plus >= 0 {
+		local = local[:plus]
+	}
+	if domain == "gmail.com" || domain == "googlemail.com" {
+		local = strings.ReplaceAll(local, ".", "")
+		domain = "gmail.com"
+	}
+	return local + "@" + domain
+}
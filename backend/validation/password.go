@@ -0,0 +1,134 @@
+package validation
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxPasswordBytes is the longest password bcrypt can hash
+const maxPasswordBytes = 72
+
+// commonPasswords are rejected unless PASSWORD_DENY_COMMON is off
+var commonPasswords = []string{
+	"123456", "123456789", "12345678", "1234567", "1234567890", "111111", "000000", "123123",
+	"password", "password1", "password123", "passw0rd", "qwerty", "qwerty123", "qwertyuiop",
+	"abc123", "iloveyou", "admin", "admin123", "welcome", "letmein", "monkey", "dragon",
+	"football", "baseball", "sunshine", "princess", "master", "shadow", "superman", "trustno1",
+}
+
+// PasswordPolicy holds the rules new passwords must satisfy
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// Denylist holds lowercased passwords that are always rejected
+	Denylist map[string]bool
+}
+
+var passwordPolicy = PasswordPolicy{MinLength: 6, Denylist: commonDenylist()}
+
+func commonDenylist() map[string]bool {
+	denylist := make(map[string]bool, len(commonPasswords))
+	for _, pw := range commonPasswords {
+		denylist[pw] = true
+	}
+	return denylist
+}
+
+// Denylist builds a PasswordPolicy denylist of the common passwords, when
+// common is set, and the passwords in the file at path, one per line. When
+// the file can't be read, the rest of the list is returned with the error.
+func Denylist(common bool, path string) (map[string]bool, error) {
+	denylist := map[string]bool{}
+	if common {
+		denylist = commonDenylist()
+	}
+	if path == "" {
+		return denylist, nil
+	}
+	return denylist, loadDenylist(path, denylist)
+}
+
+func loadDenylist(path string, denylist map[string]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if pw := strings.ToLower(strings.TrimSpace(scanner.Text())); pw != "" {
+			denylist[pw] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// SetPasswordPolicy replaces the active password policy
+func SetPasswordPolicy(p PasswordPolicy) {
+	mu.Lock()
+	defer mu.Unlock()
+	passwordPolicy = p
+}
+
+// CurrentPasswordPolicy returns the active password policy
+func CurrentPasswordPolicy() PasswordPolicy {
+	mu.RLock()
+	defer mu.RUnlock()
+	return passwordPolicy
+}
+
+// CheckPassword validates a new password against the password policy
+func CheckPassword(password string) error {
+	p := CurrentPasswordPolicy()
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("password must be at most %d bytes", maxPasswordBytes)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	var missing []string
+	if p.RequireUpper && !upper {
+		missing = append(missing, "an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		missing = append(missing, "a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		missing = append(missing, "a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		missing = append(missing, "a symbol")
+	}
+	if len(missing) > 0 {
+		return errors.New("password must contain " + strings.Join(missing, ", "))
+	}
+
+	if p.Denylist[strings.ToLower(password)] {
+		return errors.New("password is too common")
+	}
+	return nil
+}
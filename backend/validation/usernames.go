@@ -0,0 +1,128 @@
+package validation
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultReservedUsernames can't be registered, so they can't impersonate
+// the service
+var defaultReservedUsernames = []string{
+	"abuse", "admin", "administrator", "api", "billing", "help", "hostmaster",
+	"info", "mail", "moderator", "noreply", "no_reply", "null", "postmaster",
+	"root", "security", "staff", "support", "system", "undefined", "webmaster", "www",
+}
+
+// UsernameLists hold the usernames that can't be registered
+type UsernameLists struct {
+	// Reserved usernames are refused as they are
+	Reserved map[string]bool
+	// Profane words are refused anywhere in a username
+	Profane []string
+}
+
+var usernameLists = UsernameLists{Reserved: wordSet(defaultReservedUsernames)}
+
+// SetUsernameLists replaces the active username lists
+func SetUsernameLists(l UsernameLists) {
+	mu.Lock()
+	defer mu.Unlock()
+	usernameLists = l
+}
+
+// CurrentUsernameLists returns the active username lists
+func CurrentUsernameLists() UsernameLists {
+	mu.RLock()
+	defer mu.RUnlock()
+	return usernameLists
+}
+
+// LoadUsernameLists builds UsernameLists of the built-in reserved usernames
+// plus those in reservedFile, and the words in profanityFile, each one per
+// line. Either file may be empty to go without it.
+func LoadUsernameLists(reservedFile, profanityFile string) (UsernameLists, error) {
+	reserved, err := readWords(reservedFile)
+	if err != nil {
+		return UsernameLists{}, err
+	}
+	profane, err := readWords(profanityFile)
+	if err != nil {
+		return UsernameLists{}, err
+	}
+	return UsernameLists{
+		Reserved: wordSet(append(reserved, defaultReservedUsernames...)),
+		Profane:  profane,
+	}, nil
+}
+
+// WatchUsernameLists reloads the username lists from a background goroutine
+// whenever either file has changed, checking every interval. A list that
+// can't be read keeps the lists in use.
+func WatchUsernameLists(reservedFile, profanityFile string, interval time.Duration) {
+	if reservedFile == "" && profanityFile == "" {
+		return
+	}
+	loaded := modTimes(reservedFile, profanityFile)
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			current := modTimes(reservedFile, profanityFile)
+			if current == loaded {
+				continue
+			}
+			lists, err := LoadUsernameLists(reservedFile, profanityFile)
+			if err != nil {
+				log.Println("Error reloading the username lists:", err)
+				continue
+			}
+			SetUsernameLists(lists)
+			loaded = current
+			log.Printf("Reloaded the username lists: %d reserved, %d profane words", len(lists.Reserved), len(lists.Profane))
+		}
+	}()
+}
+
+// modTimes returns when the files were last changed, zero for the missing
+func modTimes(reservedFile, profanityFile string) [2]time.Time {
+	var times [2]time.Time
+	for i, path := range []string{reservedFile, profanityFile} {
+		if info, err := os.Stat(path); err == nil {
+			times[i] = info.ModTime()
+		}
+	}
+	return times
+}
+
+// readWords returns the lowercased words in the file at path, one per line,
+// skipping blanks and # comments, or none without a path
+func readWords(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if word != "" && !strings.HasPrefix(word, "#") {
+			words = append(words, word)
+		}
+	}
+	return words, scanner.Err()
+}
+
+func wordSet(words []string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, word := range words {
+		set[word] = true
+	}
+	return set
+}
@@ -0,0 +1,181 @@
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// maxNameColumn is the width of users.name; longer names can't be stored
+const maxNameColumn = 100
+
+// Rules holds the user field constraints applied by the handlers
+type Rules struct {
+	NameMin int
+	NameMax int
+
+	MinAge     int
+	MaxAge     int
+	RequireAge bool
+
+	SignupEnabled bool
+}
+
+var (
+	mu    sync.RWMutex
+	rules = Rules{
+		NameMin:       2,
+		NameMax:       maxNameColumn,
+		MaxAge:        150,
+		SignupEnabled: true,
+	}
+)
+
+// SetRules replaces the active rules. Names longer than the column can
+// hold are never accepted.
+func SetRules(r Rules) {
+	if r.NameMax <= 0 || r.NameMax > maxNameColumn {
+		r.NameMax = maxNameColumn
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	rules = r
+}
+
+// Current returns the active rules
+func Current() Rules {
+	mu.RLock()
+	defer mu.RUnlock()
+	return rules
+}
+
+// SignupEnabled reports whether self-service signup is allowed
+func SignupEnabled() bool {
+	return Current().SignupEnabled
+}
+
+// CheckName validates a user name against the configured length bounds
+func CheckName(name string) error {
+	r := Current()
+	n := utf8.RuneCountInString(strings.TrimSpace(name))
+	if n < r.NameMin || n > r.NameMax {
+		return fmt.Errorf("name must be between %d and %d characters", r.NameMin, r.NameMax)
+	}
+	return nil
+}
+
+// CheckAge validates an age against the configured bounds; a nil age is
+// accepted here and enforced by CheckNewUser when age is required
+func CheckAge(age *int) error {
+	if age == nil {
+		return nil
+	}
+	r := Current()
+	if *age < r.MinAge || *age > r.MaxAge {
+		return fmt.Errorf("age must be between %d and %d", r.MinAge, r.MaxAge)
+	}
+	return nil
+}
+
+// CheckNewUser validates the fields of a user being created
+func CheckNewUser(name string, age *int) error {
+	if err := CheckName(name); err != nil {
+		return err
+	}
+	return CheckUserAge(age)
+}
+
+// CheckUserAge is CheckAge that also enforces a required age
+func CheckUserAge(age *int) error {
+	if age == nil && Current().RequireAge {
+		return fmt.Errorf("age is required")
+	}
+	return CheckAge(age)
+}
+
+// Limits on user metadata
+const (
+	maxMetadataKeys  = 50
+	maxMetadataBytes = 8 << 10
+)
+
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// CheckMetadata validates user metadata: at most 50 keys of letters, digits,
+// "_" or "-" (so they can be used in metadata.<key> filters), and 8 KB of JSON
+func CheckMetadata(metadata map[string]interface{}) error {
+	if len(metadata) > maxMetadataKeys {
+		return fmt.Errorf("metadata can have at most %d keys", maxMetadataKeys)
+	}
+	for key := range metadata {
+		if err := CheckMetadataKey(key); err != nil {
+			return err
+		}
+	}
+	if data, err := json.Marshal(metadata); err != nil || len(data) > maxMetadataBytes {
+		return fmt.Errorf("metadata must be at most %d bytes of JSON", maxMetadataBytes)
+	}
+	return nil
+}
+
+// CheckMetadataKey validates a single metadata key
+func CheckMetadataKey(key string) error {
+	if !metadataKeyPattern.MatchString(key) {
+		return fmt.Errorf("metadata key %q must be 1 to 64 letters, digits, _ or -", key)
+	}
+	return nil
+}
+
+var usernamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{2,29}$`)
+
+// NormalizeUsername returns the stored form of a username: trimmed and
+// lowercased, so usernames are unique regardless of case
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// CheckUsername validates a normalized username: 3 to 30 lowercase letters,
+// digits or "_", starting with a letter, neither reserved nor containing a
+// profane word
+func CheckUsername(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return fmt.Errorf("username must be 3 to 30 letters, digits or _, starting with a letter")
+	}
+	lists := CurrentUsernameLists()
+	if lists.Reserved[username] {
+		return fmt.Errorf("username %q is reserved", username)
+	}
+	// Underscores and digits don't hide a word
+	letters := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r
+		}
+		return -1
+	}, username)
+	for _, word := range lists.Profane {
+		if strings.Contains(letters, word) {
+			return fmt.Errorf("username is not allowed")
+		}
+	}
+	return nil
+}
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,49}$`)
+
+// NormalizeTag returns the stored form of a tag: trimmed and lowercased, so
+// "VIP" and "vip" are the same tag
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// CheckTag validates a normalized tag: 1 to 50 lowercase letters, digits,
+// "_" or "-", starting with a letter or digit
+func CheckTag(tag string) error {
+	if !tagPattern.MatchString(tag) {
+		return fmt.Errorf("tag %q must be 1 to 50 letters, digits, _ or -", tag)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, injected at build time with
+//
+//	go build -ldflags "-X goapi/version.GitSHA=$(git rev-parse HEAD) -X goapi/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	GitSHA    = ""
+	BuildTime = ""
+)
+
+// Info describes the running build
+type Info struct {
+	GitSHA    string `json:"git_sha"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+	// MigrationVersion is the latest schema migration applied to the
+	// database, 0 before the first; nil when it wasn't looked up or the
+	// database couldn't be read
+	MigrationVersion *int `json:"migration_version"`
+}
+
+// Get returns the build metadata, falling back to the VCS information the Go
+// toolchain embeds when ldflags weren't set
+func Get() Info {
+	info := Info{
+		GitSHA:    GitSHA,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.GitSHA == "":
+				info.GitSHA = setting.Value
+			case setting.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = setting.Value
+			}
+		}
+	}
+
+	if info.GitSHA == "" {
+		info.GitSHA = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+	return info
+}
@@ -0,0 +1,59 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"syscall"
+)
+
+// ErrPrivateTarget is returned for webhook URLs that resolve to loopback,
+// private, link-local or other non-public addresses, which would let
+// webhooks probe the network the server runs in
+var ErrPrivateTarget = errors.New("webhook URL must resolve to a public address")
+
+// CheckURL checks that rawURL is an http(s) URL whose host resolves only to
+// public addresses
+func CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("url must be an http or https URL")
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return errors.New("url host does not resolve")
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return ErrPrivateTarget
+		}
+	}
+	return nil
+}
+
+// publicIP reports whether ip is a unicast address outside the loopback,
+// private, link-local and shared (carrier-grade NAT) ranges
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || sharedRange.Contains(ip))
+}
+
+// sharedRange is 100.64.0.0/10, used behind carrier-grade NAT and by some
+// cloud providers for internal services
+var sharedRange = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// dialPublic refuses connections to non-public addresses. Checked at dial
+// time, after DNS resolution, it also covers hosts that resolved to a
+// public address when the webhook was registered and no longer do.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return ErrPrivateTarget
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+// Package webhooks notifies registered endpoints of user events. Publish
+// queues a delivery per subscribed webhook in the database, and a background
+// worker POSTs them, signed with each webhook's secret, retrying failures
+// with exponential backoff. The queue doubles as the delivery log.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"goapi/cloudevents"
+)
+
+// Events webhooks can subscribe to
+const (
+	EventUserCreated = "user.created"
+	EventUserUpdated = "user.updated"
+	EventUserDeleted = "user.deleted"
+	EventUserLogin   = "user.login"
+)
+
+// Delivery headers
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderEventID   = "X-Webhook-ID"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+const (
+	// batchSize is how many due deliveries a worker claims at once
+	batchSize = 20
+	// firstRetryDelay doubles after every failed attempt, up to maxRetryDelay
+	firstRetryDelay = 30 * time.Second
+	maxRetryDelay   = 6 * time.Hour
+	// maxResponseBody is how much of an endpoint's answer is read
+	maxResponseBody = 64 << 10
+)
+
+// Config tunes the delivery worker
+type Config struct {
+	// DB holds the webhooks and their deliveries
+	DB *sql.DB
+	// PollInterval is how often due retries are looked for
+	PollInterval time.Duration
+	// Timeout bounds each attempt
+	Timeout time.Duration
+	// MaxAttempts is how many attempts a delivery gets before it is failed
+	MaxAttempts int
+}
+
+// wake tells the worker that Publish queued something
+var wake = make(chan struct{}, 1)
+
+// Publish queues event about subject with data, in the CloudEvents
+// envelope, for every webhook subscribed to it. Errors are logged rather
+// than returned so a failing webhook queue never undoes a change that
+// already happened.
+func Publish(ctx context.Context, db *sql.DB, event, subject string, data interface{}) {
+	now := time.Now()
+	eventID := newEventID()
+	payload, err := json.Marshal(cloudevents.New(eventID, event, subject, now, data))
+	if err != nil {
+		log.Println("Error encoding webhook payload:", err)
+		return
+	}
+
+	result, err := db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event, payload, next_attempt_at, created_at)
+		SELECT id, $1, $2::text, $3::jsonb, $4::timestamp, $4 FROM webhooks WHERE $2 = ANY(events)
+	`, eventID, event, payload, now)
+	if err != nil {
+		log.Println("Error queueing webhook deliveries:", err)
+		return
+	}
+	if queued, _ := result.RowsAffected(); queued > 0 {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Sign returns the X-Webhook-Signature for body sent at timestamp (Unix
+// seconds): "sha256=" and the hex HMAC-SHA256 of "<timestamp>.<body>" keyed
+// with the webhook's secret. Receivers recompute it to check that the
+// delivery is authentic, and reject old timestamps to stop replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Start delivers queued events from a background goroutine, as soon as
+// they are published and every cfg.PollInterval for retries
+func Start(cfg Config) {
+	// Deliveries connect straight to their target, and only to public
+	// addresses, so webhooks can't reach the internal network
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{Timeout: cfg.Timeout, Control: dialPublic}).DialContext
+	client := &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: transport,
+		// A redirect counts as a failed attempt, so deliveries only go
+		// where they were registered to
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	ticker := time.NewTicker(cfg.PollInterval)
+
+	go func() {
+		for {
+			for deliverDue(client, cfg) == batchSize {
+				// A full batch may have left more due deliveries behind
+			}
+			select {
+			case <-ticker.C:
+			case <-wake:
+			}
+		}
+	}()
+}
+
+// delivery is a queued delivery with where to send it
+type delivery struct {
+	id       int64
+	eventID  string
+	event    string
+	payload  []byte
+	attempts int
+	url      string
+	secret   string
+}
+
+// deliverDue sends a batch of due deliveries concurrently and returns how
+// many it claimed. Claimed deliveries are leased until the attempts are
+// over, so other replicas skip them.
+func deliverDue(client *http.Client, cfg Config) int {
+	now := time.Now()
+	rows, err := cfg.DB.Query(`
+		UPDATE webhook_deliveries d SET next_attempt_at = $2
+		FROM webhooks w
+		WHERE w.id = d.webhook_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= $1
+			ORDER BY next_attempt_at, id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.id, d.event_id, d.event, d.payload, d.attempts, w.url, w.secret
+	`, now, now.Add(cfg.Timeout+time.Minute), batchSize)
+	if err != nil {
+		log.Println("Error claiming webhook deliveries:", err)
+		return 0
+	}
+	var due []delivery
+	for rows.Next() {
+		var d delivery
+		if err := rows.Scan(&d.id, &d.eventID, &d.event, &d.payload, &d.attempts, &d.url, &d.secret); err != nil {
+			log.Println("Error claiming webhook deliveries:", err)
+			break
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+
+	var wg sync.WaitGroup
+	for _, d := range due {
+		wg.Add(1)
+		go func(d delivery) {
+			defer wg.Done()
+			status, err := send(client, d)
+			record(cfg.DB, d, status, err, cfg.MaxAttempts)
+		}(d)
+	}
+	wg.Wait()
+	return len(due)
+}
+
+// send POSTs a delivery and returns the endpoint's HTTP status, 0 when it
+// didn't answer. Any status but 2xx is an error.
+func send(client *http.Client, d delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(d.payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", cloudevents.ContentType+"; charset=utf-8")
+	req.Header.Set("User-Agent", "goapi-webhooks")
+	req.Header.Set(HeaderEvent, d.event)
+	req.Header.Set(HeaderEventID, d.eventID)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(d.id, 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(d.secret, timestamp, d.payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drained so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBody))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// record stores the outcome of an attempt, scheduling a retry after a
+// failure until the delivery is out of attempts
+func record(db *sql.DB, d delivery, status int, sendErr error, maxAttempts int) {
+	now := time.Now()
+	attempts := d.attempts + 1
+	responseStatus := sql.NullInt64{Int64: int64(status), Valid: status != 0}
+
+	var err error
+	switch {
+	case sendErr == nil:
+		_, err = db.Exec(`
+			UPDATE webhook_deliveries
+			SET status = $2, attempts = $3, response_status = $4, last_error = NULL, delivered_at = $5
+			WHERE id = $1
+		`, d.id, StatusSucceeded, attempts, responseStatus, now)
+	case attempts >= maxAttempts:
+		log.Printf("Warning: giving up on webhook delivery %d to %s after %d attempts: %v", d.id, d.url, attempts, sendErr)
+		_, err = db.Exec(`
+			UPDATE webhook_deliveries
+			SET status = $2, attempts = $3, response_status = $4, last_error = $5
+			WHERE id = $1
+		`, d.id, StatusFailed, attempts, responseStatus, sendErr.Error())
+	default:
+		_, err = db.Exec(`
+			UPDATE webhook_deliveries
+			SET attempts = $2, response_status = $3, last_error = $4, next_attempt_at = $5
+			WHERE id = $1
+		`, d.id, attempts, responseStatus, sendErr.Error(), now.Add(retryDelay(attempts)))
+	}
+	if err != nil {
+		log.Println("Error recording webhook delivery:", err)
+	}
+}
+
+// retryDelay is the pause after the given number of failed attempts: 30s,
+// 1m, 2m, ... up to 6h
+func retryDelay(attempts int) time.Duration {
+	delay := firstRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// NewSecret returns a random signing secret
+func NewSecret() string {
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func newEventID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -1,4 +1,4 @@
-import React from 'react';
+import React, { useState } from 'react';
 import { useForm } from 'react-hook-form';
 import { zodResolver } from '@hookform/resolvers/zod';
 import { z } from 'zod';
@@ -14,6 +14,7 @@ import {
   CardTitle,
 } from '@/components/ui/card';
 import { useAuth } from '@/contexts/AuthContext';
+import { ApiError } from '@/services/api';
 import type { LoginCredentials } from '@/types/auth';
 
 const loginSchema = z.object({
@@ -29,6 +30,8 @@ interface LoginFormProps {
 
 export const LoginForm: React.FC<LoginFormProps> = ({ onSwitchToSignup }) => {
   const { login, isLoading } = useAuth();
+  // Set when the account was deleted by its owner and can still be restored
+  const [pendingDeletion, setPendingDeletion] = useState<LoginFormData | null>(null);
   const {
     register,
     handleSubmit,
@@ -37,16 +40,28 @@ export const LoginForm: React.FC<LoginFormProps> = ({ onSwitchToSignup }) => {
     resolver: zodResolver(loginSchema),
   });
 
-  const onSubmit = async (data: LoginFormData): Promise<void> => {
+  const onSubmit = async (data: LoginFormData, restore = false): Promise<void> => {
     try {
-      await login(data as LoginCredentials);
-      toast.success('Login successful!');
+      await login({ ...data, restore } as LoginCredentials);
+      setPendingDeletion(null);
+      toast.success(restore ? 'Account restored!' : 'Login successful!');
     } catch (error) {
+      if (error instanceof ApiError && error.status === 409) {
+        setPendingDeletion(data);
+        return;
+      }
       const errorMessage = error instanceof Error ? error.message : 'Login failed';
       toast.error(errorMessage);
     }
   };
 
+  const handleRestore = (): void => {
+    if (pendingDeletion === null) return;
+    onSubmit(pendingDeletion, true).catch(() => {
+      // Error is handled in onSubmit
+    });
+  };
+
   const handleFormSubmit = (data: LoginFormData): void => {
     onSubmit(data).catch(() => {
       // Error is handled in onSubmit
@@ -97,6 +112,23 @@ export const LoginForm: React.FC<LoginFormProps> = ({ onSwitchToSignup }) => {
           </Button>
         </form>
 
+        {pendingDeletion && (
+          <div className='mt-4 space-y-2 rounded-md border p-3'>
+            <p className='text-sm text-muted-foreground'>
+              This account is scheduled for deletion. Restore it to keep using it.
+            </p>
+            <Button
+              type='button'
+              variant='outline'
+              className='w-full'
+              disabled={isLoading}
+              onClick={handleRestore}
+            >
+              Restore account
+            </Button>
+          </div>
+        )}
+
         <div className='mt-4 text-center'>
           <p className='text-sm text-muted-foreground'>
             Don't have an account?{' '}
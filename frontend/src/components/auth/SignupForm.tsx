@@ -1,4 +1,4 @@
-import React from 'react';
+import React, { useEffect, useState } from 'react';
 import { useForm } from 'react-hook-form';
 import { zodResolver } from '@hookform/resolvers/zod';
 import { z } from 'zod';
@@ -14,12 +14,22 @@ import {
   CardTitle,
 } from '@/components/ui/card';
 import { useAuth } from '@/contexts/AuthContext';
-import type { SignupCredentials } from '@/types/auth';
+import { authService } from '@/services/authService';
+import type { Availability, SignupCredentials } from '@/types/auth';
+
+const emailSchema = z.email('Please enter a valid email address');
+const usernameSchema = z
+  .string()
+  .regex(
+    /^[A-Za-z][A-Za-z0-9_]{2,29}$/,
+    'Username must be 3 to 30 letters, digits or _, starting with a letter',
+  );
 
 const signupSchema = z
   .object({
     name: z.string().min(2, 'Name must be at least 2 characters'),
-    email: z.email('Please enter a valid email address'),
+    email: emailSchema,
+    username: usernameSchema.optional().or(z.literal('')),
     password: z.string().min(6, 'Password must be at least 6 characters'),
     confirmPassword: z.string(),
     age: z.number().min(0).max(150).optional(),
@@ -40,16 +50,45 @@ export const SignupForm: React.FC<SignupFormProps> = ({ onSwitchToLogin }) => {
   const {
     register,
     handleSubmit,
+    watch,
     formState: { errors },
   } = useForm<SignupFormData>({
     resolver: zodResolver(signupSchema),
   });
+  const [availability, setAvailability] = useState<Availability>({});
+
+  const username = watch('username') ?? '';
+  const email = watch('email') ?? '';
+
+  // Check username and email availability shortly after the user stops typing
+  useEffect(() => {
+    const check = {
+      ...(usernameSchema.safeParse(username).success && { username }),
+      ...(emailSchema.safeParse(email).success && { email }),
+    };
+    if (check.username === undefined && check.email === undefined) {
+      setAvailability({});
+      return;
+    }
+    const timer = setTimeout(() => {
+      authService
+        .checkAvailability(check)
+        .then(setAvailability)
+        .catch(() => {
+          // Signup itself reports taken values
+        });
+    }, 400);
+    return () => {
+      clearTimeout(timer);
+    };
+  }, [username, email]);
 
   const onSubmit = async (data: SignupFormData): Promise<void> => {
     try {
       const signupData = {
         name: data.name,
         email: data.email,
+        ...(data.username && { username: data.username }),
         password: data.password,
         age: data.age,
       };
@@ -104,6 +143,33 @@ export const SignupForm: React.FC<SignupFormProps> = ({ onSwitchToLogin }) => {
             {errors.email && (
               <p className='text-sm text-red-500'>{errors.email.message}</p>
             )}
+            {!errors.email && availability.email?.available === false && (
+              <p className='text-sm text-red-500'>
+                {availability.email.reason}
+              </p>
+            )}
+          </div>
+
+          <div className='space-y-2'>
+            <Label htmlFor='username'>Username (optional)</Label>
+            <Input
+              id='username'
+              type='text'
+              placeholder='Choose a username'
+              {...register('username')}
+            />
+            {errors.username && (
+              <p className='text-sm text-red-500'>{errors.username.message}</p>
+            )}
+            {!errors.username && availability.username && (
+              <p
+                className={`text-sm ${availability.username.available ? 'text-green-600' : 'text-red-500'}`}
+              >
+                {availability.username.available
+                  ? 'Username is available'
+                  : availability.username.reason}
+              </p>
+            )}
           </div>
 
           <div className='space-y-2'>
@@ -1,6 +1,7 @@
 import React, { useState, useEffect } from 'react';
 import { toast } from 'sonner';
 import { Button } from '@/components/ui/button';
+import { Input } from '@/components/ui/input';
 import { ThemeToggle } from '@/components/ui/theme-toggle';
 import {
   Card,
@@ -19,12 +20,30 @@ import {
 } from '@/components/ui/table';
 import { useAuth } from '@/contexts/AuthContext';
 import { userService } from '@/services/userService';
-import type { User } from '@/types/user';
+import { ApiError } from '@/services/api';
+import type { Pagination, User, UserStats } from '@/types/user';
 import { UserForm } from './UserForm';
 
+// Columns the API can sort by, keyed by table header
+const sortColumns: Record<string, string> = {
+  Name: 'name',
+  Email: 'email',
+  Age: 'age',
+  Status: 'is_active',
+  Created: 'created_at',
+  Updated: 'updated_at',
+};
+
 export const Dashboard: React.FC = () => {
   const { user, logout } = useAuth();
   const [users, setUsers] = useState<User[]>([]);
+  const [page, setPage] = useState(1);
+  // Empty means the server's order: best matches when searching, else newest
+  const [sort, setSort] = useState('');
+  const [search, setSearch] = useState('');
+  const [query, setQuery] = useState('');
+  const [pagination, setPagination] = useState<Pagination | null>(null);
+  const [stats, setStats] = useState<UserStats | null>(null);
   const [isLoading, setIsLoading] = useState(true);
   const [showUserForm, setShowUserForm] = useState(false);
   const [editingUser, setEditingUser] = useState<User | null>(null);
@@ -36,13 +55,31 @@ export const Dashboard: React.FC = () => {
     loadUsers().catch(() => {
       // Handle error silently
     });
-  }, []);
+  }, [page, sort, query]);
+
+  // Search once typing pauses rather than on every keystroke
+  useEffect(() => {
+    const timer = setTimeout(() => {
+      setQuery(search.trim());
+      setPage(1);
+    }, 300);
+    return () => {
+      clearTimeout(timer);
+    };
+  }, [search]);
 
   const loadUsers = async (): Promise<void> => {
     try {
       setIsLoading(true);
-      const userList = await userService.getUsers();
-      setUsers(userList);
+      const result = await userService.getUsers(page, sort, query);
+      setUsers(result.users);
+      setPagination(result.pagination);
+      // Counts cover every user, not just this page
+      setStats(await userService.getStats().catch(() => null));
+      // Deleting the last user on the last page leaves the page empty
+      if (result.pagination.prev !== null && result.users.length === 0) {
+        setPage(result.pagination.prev);
+      }
     } catch {
       toast.error('Failed to load users');
     } finally {
@@ -76,12 +113,19 @@ export const Dashboard: React.FC = () => {
     if (!editingUser) return;
 
     try {
-      await userService.updateUser(editingUser.id, userData);
+      await userService.updateUser(editingUser.id, userData, editingUser.version);
       await loadUsers();
       setEditingUser(null);
       setShowUserForm(false);
       toast.success('User updated successfully');
-    } catch {
+    } catch (error) {
+      if (error instanceof ApiError && (error.status === 409 || error.status === 412)) {
+        toast.error('This user was changed elsewhere; reloaded the latest version');
+        await loadUsers();
+        setEditingUser(null);
+        setShowUserForm(false);
+        return;
+      }
       toast.error('Failed to update user');
     }
   };
@@ -97,6 +141,18 @@ export const Dashboard: React.FC = () => {
     }
   };
 
+  // Clicking a header sorts by it ascending, clicking again flips direction
+  const handleSort = (column: string): void => {
+    setSort(sort === column ? `-${column}` : column);
+    setPage(1);
+  };
+
+  const sortIndicator = (column: string): string => {
+    if (sort === column) return ' ▲';
+    if (sort === `-${column}`) return ' ▼';
+    return '';
+  };
+
   const handleEditUser = (user: User): void => {
     setEditingUser(user);
     setShowUserForm(true);
@@ -106,7 +162,8 @@ export const Dashboard: React.FC = () => {
     return new Date(dateString).toLocaleDateString();
   };
 
-  if (isLoading) {
+  // Only the first load blanks the page, so the search box keeps focus
+  if (isLoading && pagination === null) {
     return (
       <div className='min-h-screen flex items-center justify-center'>
         <div className='text-lg'>Loading...</div>
@@ -149,42 +206,55 @@ export const Dashboard: React.FC = () => {
               </CardDescription>
             </CardHeader>
             <CardContent>
-              <div className='grid grid-cols-1 md:grid-cols-3 gap-4'>
+              <div className='grid grid-cols-2 md:grid-cols-4 gap-4'>
                 <div className='text-center'>
-                  <div className='text-2xl font-bold'>{users.length}</div>
+                  <div className='text-2xl font-bold'>
+                    {stats?.total ?? pagination?.total ?? users.length}
+                  </div>
                   <div className='text-sm text-muted-foreground'>
                     Total Users
                   </div>
                 </div>
                 <div className='text-center'>
-                  <div className='text-2xl font-bold'>
-                    {
-                      users.filter(
-                        user =>
-                          new Date(user.createdAt) >
-                          new Date(Date.now() - 7 * 24 * 60 * 60 * 1000),
-                      ).length
-                    }
-                  </div>
-                  <div className='text-sm text-muted-foreground'>
-                    New This Week
-                  </div>
+                  <div className='text-2xl font-bold'>{stats?.active ?? '-'}</div>
+                  <div className='text-sm text-muted-foreground'>Active</div>
+                </div>
+                <div className='text-center'>
+                  <div className='text-2xl font-bold'>{stats?.inactive ?? '-'}</div>
+                  <div className='text-sm text-muted-foreground'>Inactive</div>
                 </div>
                 <div className='text-center'>
                   <div className='text-2xl font-bold'>
-                    {
-                      users.filter(
-                        user =>
-                          new Date(user.updatedAt) >
-                          new Date(Date.now() - 24 * 60 * 60 * 1000),
-                      ).length
-                    }
+                    {stats?.averageAge ?? '-'}
                   </div>
                   <div className='text-sm text-muted-foreground'>
-                    Updated Today
+                    Average Age
                   </div>
                 </div>
               </div>
+              {stats && (
+                <div className='mt-6'>
+                  <div className='text-sm text-muted-foreground mb-2'>
+                    Signups, last 30 days
+                  </div>
+                  <div className='flex items-end gap-1 h-24'>
+                    {stats.signupsPerDay.map(day => (
+                      <div
+                        key={day.date}
+                        title={`${day.date}: ${String(day.count)}`}
+                        className='flex-1 bg-primary rounded-sm min-h-px'
+                        style={{
+                          height: `${String(
+                            (day.count /
+                              Math.max(1, ...stats.signupsPerDay.map(d => d.count))) *
+                              100,
+                          )}%`,
+                        }}
+                      />
+                    ))}
+                  </div>
+                </div>
+              )}
             </CardContent>
           </Card>
 
@@ -197,24 +267,41 @@ export const Dashboard: React.FC = () => {
                   A list of all registered users in the system
                 </CardDescription>
               </div>
-              <Button
-                onClick={() => {
-                  setShowUserForm(true);
-                }}
-              >
-                Add User
-              </Button>
+              <div className='flex items-center space-x-2'>
+                <Input
+                  type='search'
+                  placeholder='Search name or email'
+                  value={search}
+                  onChange={e => {
+                    setSearch(e.target.value);
+                  }}
+                  className='w-64'
+                />
+                <Button
+                  onClick={() => {
+                    setShowUserForm(true);
+                  }}
+                >
+                  Add User
+                </Button>
+              </div>
             </CardHeader>
             <CardContent>
               <Table>
                 <TableHeader>
                   <TableRow>
-                    <TableHead>Name</TableHead>
-                    <TableHead>Email</TableHead>
-                    <TableHead>Age</TableHead>
-                    <TableHead>Status</TableHead>
-                    <TableHead>Created</TableHead>
-                    <TableHead>Updated</TableHead>
+                    {Object.entries(sortColumns).map(([label, column]) => (
+                      <TableHead
+                        key={column}
+                        className='cursor-pointer select-none'
+                        onClick={() => {
+                          handleSort(column);
+                        }}
+                      >
+                        {label}
+                        {sortIndicator(column)}
+                      </TableHead>
+                    ))}
                     <TableHead className='text-right'>Actions</TableHead>
                   </TableRow>
                 </TableHeader>
@@ -261,6 +348,35 @@ export const Dashboard: React.FC = () => {
                   ))}
                 </TableBody>
               </Table>
+              {pagination !== null && pagination.totalPages > 1 && (
+                <div className='flex items-center justify-between pt-4'>
+                  <span className='text-sm text-muted-foreground'>
+                    Page {pagination.page} of {pagination.totalPages}
+                  </span>
+                  <div className='flex space-x-2'>
+                    <Button
+                      variant='outline'
+                      size='sm'
+                      disabled={pagination.prev === null}
+                      onClick={() => {
+                        if (pagination.prev !== null) setPage(pagination.prev);
+                      }}
+                    >
+                      Previous
+                    </Button>
+                    <Button
+                      variant='outline'
+                      size='sm'
+                      disabled={pagination.next === null}
+                      onClick={() => {
+                        if (pagination.next !== null) setPage(pagination.next);
+                      }}
+                    >
+                      Next
+                    </Button>
+                  </div>
+                </div>
+              )}
             </CardContent>
           </Card>
         </div>
@@ -285,8 +401,8 @@ export const Dashboard: React.FC = () => {
             <CardHeader>
               <CardTitle>Confirm Delete</CardTitle>
               <CardDescription>
-                Are you sure you want to delete this user? This action cannot be
-                undone.
+                Are you sure you want to delete this user? They will be signed
+                out and hidden until restored.
               </CardDescription>
             </CardHeader>
             <CardContent>
@@ -1,5 +1,6 @@
 import React, { createContext, useContext, useState, useEffect } from 'react';
 import { authService } from '@/services/authService';
+import { ApiError } from '@/services/api';
 import type {
   User,
   LoginCredentials,
@@ -46,6 +47,10 @@ export const AuthProvider: React.FC<AuthProviderProps> = ({ children }) => {
       const user = await authService.login(credentials);
       setUser(user);
     } catch (error) {
+      // Keep the status so the form can offer to restore a deleted account
+      if (error instanceof ApiError) {
+        throw error;
+      }
       // Provide more specific error messages
       if (error instanceof Error) {
         throw new Error(error.message || 'Invalid email or password');
@@ -73,6 +78,7 @@ export const AuthProvider: React.FC<AuthProviderProps> = ({ children }) => {
   };
 
   const logout = (): void => {
+    authService.logout();
     setUser(null);
   };
 
@@ -4,7 +4,10 @@ class ApiError extends Error {
   constructor(
     message: string,
     public status: number,
-    public details?: string,
+    // Stable machine-readable error code, e.g. email_taken
+    public code?: string,
+    // Quote in bug reports to find the request in the server logs
+    public requestId?: string,
   ) {
     super(message);
     this.name = 'ApiError';
@@ -13,19 +16,28 @@ class ApiError extends Error {
 
 const handleResponse = async <T>(response: Response): Promise<T> => {
   if (!response.ok) {
-    const errorData = (await response.json().catch(() => ({}))) as {
-      error?: string;
-      details?: string;
+    // Errors are RFC 7807 application/problem+json
+    const problem = (await response.json().catch(() => ({}))) as {
+      detail?: string;
+      code?: string;
     };
     throw new ApiError(
-      errorData.error ?? `HTTP error! status: ${String(response.status)}`,
+      problem.detail ?? `HTTP error! status: ${String(response.status)}`,
       response.status,
-      errorData.details,
+      problem.code,
+      response.headers.get('X-Request-ID') ?? undefined,
     );
   }
   return response.json() as Promise<T>;
 };
 
+// Access token from login/signup, sent with every request while set
+let authToken: string | null = null;
+
+export const setAuthToken = (token: string | null): void => {
+  authToken = token;
+};
+
 export const api = {
   // Generic request method
   request: async <T>(
@@ -34,11 +46,12 @@ export const api = {
   ): Promise<T> => {
     const url = `${API_BASE_URL}${endpoint}`;
     const config: RequestInit = {
+      ...options,
       headers: {
         'Content-Type': 'application/json',
+        ...(authToken !== null && { Authorization: `Bearer ${authToken}` }),
         ...(options.headers as Record<string, string>),
       },
-      ...options,
     };
 
     const response = await fetch(url, config);
@@ -67,10 +80,15 @@ export const api = {
   },
 
    // PATCH request
-  patch: async <T>(endpoint: string, data: unknown): Promise<T> => {
+  patch: async <T>(
+    endpoint: string,
+    data: unknown,
+    headers: Record<string, string> = {},
+  ): Promise<T> => {
     return await api.request<T>(endpoint, {
       method: 'PATCH',
       body: JSON.stringify(data),
+      headers,
     });
   },
 
@@ -1,5 +1,10 @@
-import { api } from './api';
-import type { LoginCredentials, SignupCredentials, User } from '@/types/auth';
+import { api, setAuthToken } from './api';
+import type {
+  Availability,
+  LoginCredentials,
+  SignupCredentials,
+  User,
+} from '@/types/auth';
 
 interface ApiUser {
   id: number;
@@ -10,6 +15,13 @@ interface ApiUser {
   updated_at: string;
 }
 
+interface AuthData {
+  user: ApiUser;
+  token: string;
+  scopes: string[];
+  expires_at: string;
+}
+
 interface ApiResponse<T> {
   success: boolean;
   data?: T;
@@ -29,7 +41,7 @@ const convertApiUser = (apiUser: ApiUser): User => ({
 export const authService = {
   login: async (credentials: LoginCredentials): Promise<User> => {
     try {
-      const response = await api.post<ApiResponse<ApiUser>>('/api/auth/login', credentials);
+      const response = await api.post<ApiResponse<AuthData>>('/api/auth/login', credentials);
       
       if (!response.success) {
         throw new Error(response.message ?? 'Invalid email or password');
@@ -39,7 +51,8 @@ export const authService = {
         throw new Error('Invalid response from server');
       }
       
-      return convertApiUser(response.data);
+      setAuthToken(response.data.token);
+      return convertApiUser(response.data.user);
     } catch (error) {
       if (error instanceof Error) {
         throw error;
@@ -50,9 +63,10 @@ export const authService = {
 
   signup: async (credentials: SignupCredentials): Promise<User> => {
     try {
-      const response = await api.post<ApiResponse<ApiUser>>('/api/auth/signup', {
+      const response = await api.post<ApiResponse<AuthData>>('/api/auth/signup', {
         name: credentials.name,
         email: credentials.email,
+        username: credentials.username,
         password: credentials.password,
         age: credentials.age,
       });
@@ -65,7 +79,8 @@ export const authService = {
         throw new Error('Invalid response from server');
       }
       
-      return convertApiUser(response.data);
+      setAuthToken(response.data.token);
+      return convertApiUser(response.data.user);
     } catch (error) {
       if (error instanceof Error) {
         throw error;
@@ -73,4 +88,23 @@ export const authService = {
       throw new Error('Signup failed. Please try again.');
     }
   },
+
+  // Checks whether a username and/or email can still be used to sign up
+  checkAvailability: async (check: {
+    username?: string;
+    email?: string;
+  }): Promise<Availability> => {
+    const params = new URLSearchParams();
+    if (check.username !== undefined) params.set('username', check.username);
+    if (check.email !== undefined) params.set('email', check.email);
+
+    const response = await api.get<ApiResponse<Availability>>(
+      `/api/users/check-availability?${params.toString()}`,
+    );
+    return response.data ?? {};
+  },
+
+  logout: (): void => {
+    setAuthToken(null);
+  },
 }; 
\ No newline at end of file
@@ -1,5 +1,11 @@
 import { api } from './api';
-import type { User, CreateUserData, UpdateUserData } from '@/types/user';
+import type {
+  User,
+  UserPage,
+  UserStats,
+  CreateUserData,
+  UpdateUserData,
+} from '@/types/user';
 
 interface ApiUser {
   id: number;
@@ -7,15 +13,34 @@ interface ApiUser {
   email: string;
   age?: number;
   is_active: boolean;
+  version: number;
   created_at: string;
   updated_at: string;
 }
 
+interface ApiUserStats {
+  total: number;
+  active: number;
+  inactive: number;
+  average_age: number | null;
+  signups_per_day: { date: string; count: number }[];
+}
+
+interface ApiPagination {
+  page: number;
+  page_size: number;
+  total: number;
+  total_pages: number;
+  next: number | null;
+  prev: number | null;
+}
+
 interface ApiResponse<T> {
   success: boolean;
   data?: T;
   message?: string;
   source?: string;
+  pagination?: ApiPagination;
 }
 
 // Convert API user format to frontend user format
@@ -24,24 +49,49 @@ const convertApiUser = (apiUser: ApiUser): User => ({
   name: apiUser.name,
   email: apiUser.email,
   isActive: apiUser.is_active,
+  version: apiUser.version,
   ...(apiUser.age !== undefined && { age: apiUser.age }),
   createdAt: apiUser.created_at,
   updatedAt: apiUser.created_at,
 });
 
-const getUsers = async (): Promise<User[]> => {
+const getUsers = async (
+  page = 1,
+  sort = '',
+  query = '',
+): Promise<UserPage> => {
   try {
-    const response = await api.get<ApiResponse<ApiUser[]>>('/api/users');
+    const params = new URLSearchParams({ page: String(page) });
+    if (sort !== '') {
+      params.set('sort', sort);
+    }
+    if (query !== '') {
+      params.set('q', query);
+    }
+    const response = await api.get<ApiResponse<ApiUser[]>>(
+      `/api/users?${params.toString()}`,
+    );
     
     if (!response.success) {
       throw new Error(response.message ?? 'Failed to fetch users');
     }
     
-    if (!response.data) {
+    if (!response.data || !response.pagination) {
       throw new Error('Invalid response from server');
     }
     
-    return response.data.map(convertApiUser);
+    const { pagination } = response;
+    return {
+      users: response.data.map(convertApiUser),
+      pagination: {
+        page: pagination.page,
+        pageSize: pagination.page_size,
+        total: pagination.total,
+        totalPages: pagination.total_pages,
+        next: pagination.next,
+        prev: pagination.prev,
+      },
+    };
   } catch (error) {
     if (error instanceof Error) {
       throw error;
@@ -50,6 +100,26 @@ const getUsers = async (): Promise<User[]> => {
   }
 };
 
+const getStats = async (): Promise<UserStats> => {
+  const response = await api.get<ApiResponse<ApiUserStats>>('/api/users/stats');
+
+  if (!response.success) {
+    throw new Error(response.message ?? 'Failed to fetch user statistics');
+  }
+
+  if (!response.data) {
+    throw new Error('Invalid response from server');
+  }
+
+  return {
+    total: response.data.total,
+    active: response.data.active,
+    inactive: response.data.inactive,
+    averageAge: response.data.average_age,
+    signupsPerDay: response.data.signups_per_day,
+  };
+};
+
 const createUser = async (userData: CreateUserData): Promise<User> => {
   try {
     const response = await api.post<ApiResponse<ApiUser>>('/api/users', userData);
@@ -71,12 +141,19 @@ const createUser = async (userData: CreateUserData): Promise<User> => {
   }
 };
 
+// Passing the version the edit started from makes the server refuse it if
+// the user changed in the meantime
 const updateUser = async (
   id: string,
   userData: UpdateUserData,
+  version?: number,
 ): Promise<User> => {
   try {
-    const response = await api.patch<ApiResponse<ApiUser>>(`/api/users/${id}`, userData);
+    const response = await api.patch<ApiResponse<ApiUser>>(
+      `/api/users/${id}`,
+      userData,
+      version !== undefined ? { 'If-Match': `"${String(version)}"` } : {},
+    );
     
     if (!response.success) {
       throw new Error(response.message ?? 'Failed to update user');
@@ -112,6 +189,7 @@ const deleteUser = async (id: string): Promise<void> => {
 
 export const userService = {
   getUsers,
+  getStats,
   createUser,
   updateUser,
   deleteUser,
@@ -10,16 +10,29 @@ export interface User {
 export interface LoginCredentials {
   email: string;
   password: string;
+  // Brings back an account that is scheduled for deletion
+  restore?: boolean;
 }
 
 export interface SignupCredentials {
   name: string;
   email: string;
+  username?: string;
   password: string;
   confirmPassword: string;
   age?: number;
 }
 
+export interface AvailabilityResult {
+  available: boolean;
+  reason?: string;
+}
+
+export interface Availability {
+  username?: AvailabilityResult;
+  email?: AvailabilityResult;
+}
+
 export interface AuthContextType {
   user: User | null;
   login: (credentials: LoginCredentials) => Promise<void>;
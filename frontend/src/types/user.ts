@@ -5,10 +5,39 @@ export interface User {
   email: string;
   age?: number;
   isActive?: boolean;
+  version?: number;
   createdAt: string;
   updatedAt: string;
 }
 
+export interface Pagination {
+  page: number;
+  pageSize: number;
+  total: number;
+  totalPages: number;
+  next: number | null;
+  prev: number | null;
+}
+
+export interface DailySignups {
+  date: string;
+  count: number;
+}
+
+export interface UserStats {
+  total: number;
+  active: number;
+  inactive: number;
+  averageAge: number | null;
+  // Last 30 days, oldest first
+  signupsPerDay: DailySignups[];
+}
+
+export interface UserPage {
+  users: User[];
+  pagination: Pagination;
+}
+
 export interface CreateUserData {
   name: string;
   email: string;
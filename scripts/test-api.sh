@@ -30,6 +30,8 @@ echo
 TEST_COUNT=$(jq '.tests | length' "$TEST_FILE")
 PASSED=0
 FAILED=0
+# Access token captured from the first response that returns one (signup/login)
+TOKEN=""
 
 # Run each test
 for ((i=0; i<TEST_COUNT; i++)); do
@@ -53,10 +55,17 @@ for ((i=0; i<TEST_COUNT; i++)); do
         timestamp=$(date +%s)
         body="${body//TIMESTAMP/$timestamp}"
         http_code=$(curl -sS -o "$tmp" -w "%{http_code}" -X "$method" "$url" \
+            ${TOKEN:+-H "Authorization: Bearer $TOKEN"} \
             -H "Content-Type: application/json" \
             -d "$body")
     else
-        http_code=$(curl -sS -o "$tmp" -w "%{http_code}" -X "$method" "$url")
+        http_code=$(curl -sS -o "$tmp" -w "%{http_code}" -X "$method" "$url" \
+            ${TOKEN:+-H "Authorization: Bearer $TOKEN"})
+    fi
+    
+    # Keep the access token for authenticated routes
+    if [[ -z "$TOKEN" ]]; then
+        TOKEN=$(jq -r '.data.token // empty' "$tmp" 2>/dev/null || true)
     fi
     
     echo "   📡 $method $path → HTTP $http_code"